@@ -1,27 +1,115 @@
+// aux prints the auxilliary vector of its own process, as parsed by the auxv
+// package. The string values are read from the memory of the process, so
+// only its own vector can be resolved.
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 
 	"github.com/elwinar/rcoredump/pkg/auxv"
 )
 
+// decimal are the types whose values are numbers instead of addresses or
+// flags.
+var decimal = map[auxv.Type]bool{
+	auxv.TypeExecFD:          true,
+	auxv.TypePHEnt:           true,
+	auxv.TypePHNum:           true,
+	auxv.TypePageSize:        true,
+	auxv.TypeUID:             true,
+	auxv.TypeEUID:            true,
+	auxv.TypeGID:             true,
+	auxv.TypeEGID:            true,
+	auxv.TypeClockTick:       true,
+	auxv.TypeSecure:          true,
+	auxv.TypeRSeqFeatureSize: true,
+	auxv.TypeRSeqAlign:       true,
+	auxv.TypeMinSigStkSz:     true,
+}
+
+// entry of the vector, as printed in JSON.
+type entry struct {
+	Type   auxv.Type `json:"type"`
+	Name   string    `json:"name"`
+	Value  auxv.Word `json:"value"`
+	String string    `json:"string,omitempty"`
+}
+
 func main() {
+	jsonOutput := flag.Bool("json", false, "print the vector as a JSON list of entries")
+	flag.Parse()
+
 	f, err := os.Open("/proc/self/auxv")
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
+	defer f.Close()
 
 	v := auxv.New()
 	err = v.ReadFrom(f)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
+	}
+
+	err = write(os.Stdout, entries(v), *jsonOutput)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// entries of the vector, ordered by type, with the string values resolved.
+// The AT_NULL entry terminating the vector is left out.
+func entries(v auxv.Vector) []entry {
+	var entries []entry
+	for t, w := range v {
+		if t == auxv.TypeNull {
+			continue
+		}
+		e := entry{Type: t, Name: t.String(), Value: w}
+		if t.IsString() && w != 0 {
+			e.String = w.ReadString()
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Type < entries[j].Type
+	})
+	return entries
+}
+
+// write the entries, in the format of LD_SHOW_AUXV, or in JSON.
+func write(w io.Writer, entries []entry, jsonOutput bool) error {
+	if jsonOutput {
+		if entries == nil {
+			entries = []entry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(entries)
 	}
 
-	if val, ok := v[auxv.TypePlatform]; ok {
-		fmt.Println(val.ReadString())
+	for _, e := range entries {
+		var value string
+		switch {
+		case e.Type.IsString():
+			value = e.String
+		case decimal[e.Type]:
+			value = fmt.Sprintf("%d", e.Value)
+		default:
+			value = fmt.Sprintf("0x%x", e.Value)
+		}
+		_, err := fmt.Fprintf(w, "%-22s%s\n", e.Name+":", value)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -1,14 +1,27 @@
+// ldd prints the shared libraries an executable depends on, as resolved by the
+// forwarder, in the format of the ldd command. The load addresses are left
+// out, as they are only known once the executable is loaded.
 package main
 
 import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/elwinar/rcoredump/pkg/elfx"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 )
 
 func main() {
+	jsonOutput := flag.Bool("json", false, "print the libraries as a JSON list of links, as sent by the forwarder")
+	unused := flag.Bool("u", false, "print the unused direct dependencies")
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -17,31 +30,193 @@ func main() {
 		os.Exit(1)
 	}
 
-	executable := flag.Arg(0)
-	file, err := elfx.Open(executable)
+	os.Exit(run(os.Stdout, os.Stderr, flag.Arg(0), *jsonOutput, *unused))
+}
+
+// run prints the libraries of the executable, and returns the exit code: 1 if
+// the executable isn't dynamic or has unused dependencies, as ldd does.
+func run(stdout, stderr io.Writer, path string, jsonOutput, unused bool) int {
+	file, err := elfx.Open(path)
+	var formatErr *elf.FormatError
+	if errors.As(err, &formatErr) {
+		fmt.Fprintln(stderr, "\tnot a dynamic executable")
+		return 1
+	}
 	if err != nil {
-		fmt.Println("opening binary file:", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "ldd: %s\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	static, pie := file.Type()
+	if static && !pie {
+		fmt.Fprintln(stderr, "\tnot a dynamic executable")
+		return 1
+	}
+	if static {
+		fmt.Fprintln(stdout, "\tstatically linked")
+		return 0
+	}
+
+	if unused {
+		paths, err := unusedDependencies(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "ldd: %s\n", err)
+			return 1
+		}
+		if len(paths) == 0 {
+			return 0
+		}
+		fmt.Fprintln(stdout, "Unused direct dependencies:")
+		for _, path := range paths {
+			fmt.Fprintf(stdout, "\t%s\n", path)
+		}
+		return 1
+	}
+
+	links, err := resolve(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "ldd: %s\n", err)
+		return 1
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "\t")
+		if links == nil {
+			links = []Link{}
+		}
+		enc.Encode(links)
+		return 0
+	}
+
+	// The interpreter is printed last, by its path, instead of being
+	// listed as a dependency of the libc.
+	interp := interpreter(file)
+	for _, link := range links {
+		switch {
+		case len(interp) != 0 && link.Name == filepath.Base(interp):
+			continue
+		case len(link.Error) != 0:
+			fmt.Fprintf(stdout, "\t%s => error: %s\n", link.Name, link.Error)
+		case !link.Found:
+			fmt.Fprintf(stdout, "\t%s => not found\n", link.Name)
+		default:
+			fmt.Fprintf(stdout, "\t%s => %s\n", link.Name, link.Path)
+		}
+	}
+	if len(interp) != 0 {
+		fmt.Fprintf(stdout, "\t%s\n", interp)
+	}
+	return 0
+}
+
+// resolve the libraries the file depends on, directly or transitively, in the
+// order the dynamic linker loads them. The paths are the ones the libraries
+// are found at, as ldd prints them.
+func resolve(root elfx.File) ([]Link, error) {
+	var links []Link
+	known := make(map[string]bool)
+	queue := []elfx.File{root}
+	for len(queue) != 0 {
+		file := queue[0]
+		queue = queue[1:]
+
+		libraries, err := file.ImportedLibraries()
+		if err != nil {
+			return nil, fmt.Errorf("listing libraries of %s: %w", file.Path, err)
+		}
+
+		for _, library := range libraries {
+			if known[library] {
+				continue
+			}
+			known[library] = true
+
+			link := Link{Name: library}
+			link.Path, link.Found, err = file.ResolveImportedLibrary(library)
+			if err != nil {
+				link.Error = err.Error()
+			}
+			if link.Found && err == nil {
+				dependency, err := elfx.Open(link.Path)
+				if err != nil {
+					link.Found = false
+					link.Error = fmt.Sprintf("opening library: %s", err)
+				} else {
+					defer dependency.Close()
+					dependency.Platform = file.Platform
+					queue = append(queue, dependency)
+				}
+			}
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// unusedDependencies returns the paths of the direct dependencies of the file
+// that define none of the symbols it imports.
+func unusedDependencies(file elfx.File) ([]string, error) {
+	symbols, err := file.DynamicSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("reading symbols: %w", err)
+	}
+	imported := make(map[string]bool)
+	for _, s := range symbols {
+		if s.Section == elf.SHN_UNDEF && len(s.Name) != 0 {
+			imported[s.Name] = true
+		}
 	}
 
 	libraries, err := file.ImportedLibraries()
 	if err != nil {
-		fmt.Println("parsing imported libraries:", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("listing libraries: %w", err)
 	}
 
+	var unused []string
 	for _, library := range libraries {
-		path, ok, err := file.ResolveImportedLibrary(library)
-		if err != nil {
-			fmt.Printf("%s: error while resolving: %s\n", library, err)
+		path, found, err := file.ResolveImportedLibrary(library)
+		if err != nil || !found {
 			continue
 		}
 
-		if !ok {
-			fmt.Printf("%s: not found\n", library)
-			continue
+		dependency, err := elfx.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening library %s: %w", path, err)
+		}
+		exported, err := dependency.ExportedSymbols()
+		dependency.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading symbols of %s: %w", path, err)
 		}
 
-		fmt.Printf("%s => %s\n", library, path)
+		used := false
+		for _, name := range exported {
+			if imported[name] {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, path)
+		}
+	}
+	return unused, nil
+}
+
+// interpreter returns the path of the program interpreter of the file, if
+// any.
+func interpreter(file elfx.File) string {
+	for _, p := range file.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		raw, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return ""
+		}
+		return strings.TrimRight(string(raw), "\x00")
 	}
+	return ""
 }
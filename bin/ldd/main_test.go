@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/elfx/elfxtest"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// writeExecutable generates an executable depending on two libraries, one of
+// them depending on a third one, and on a missing one. It returns the
+// directory of the files, and the path of the executable.
+func writeExecutable(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "ldd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	elfxtest.Write(t, dir, "lib/liba.so", elfxtest.File{Needed: []string{"libc.so"}, RunPath: []string{"$ORIGIN"}})
+	elfxtest.Write(t, dir, "lib/libb.so", elfxtest.File{})
+	elfxtest.Write(t, dir, "lib/libc.so", elfxtest.File{})
+	executable := elfxtest.Write(t, dir, "bin/executable", elfxtest.File{
+		Interp:  "/lib64/ld-linux-x86-64.so.2",
+		Needed:  []string{"liba.so", "libmissing.so", "libb.so"},
+		RunPath: []string{"$ORIGIN/../lib"},
+	})
+	return dir, executable
+}
+
+func TestRun(t *testing.T) {
+	dir, executable := writeExecutable(t)
+
+	for n, c := range map[string]struct {
+		path       string
+		unused     bool
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		"dynamic": {
+			path: executable,
+			wantStdout: "\tliba.so => $ROOT/lib/liba.so\n" +
+				"\tlibmissing.so => not found\n" +
+				"\tlibb.so => $ROOT/lib/libb.so\n" +
+				"\tlibc.so => $ROOT/lib/libc.so\n" +
+				"\t/lib64/ld-linux-x86-64.so.2\n",
+		},
+		"unused": {
+			path:       executable,
+			unused:     true,
+			wantCode:   1,
+			wantStdout: "Unused direct dependencies:\n\t$ROOT/lib/liba.so\n\t$ROOT/lib/libb.so\n",
+		},
+		"static": {
+			path:       "../../pkg/elfx/testdata/executable_static",
+			wantCode:   1,
+			wantStderr: "\tnot a dynamic executable\n",
+		},
+		"static pie": {
+			path:       "../../pkg/elfx/testdata/executable_static_pie",
+			wantStdout: "\tstatically linked\n",
+		},
+		"not an ELF file": {
+			path:       "main.go",
+			wantCode:   1,
+			wantStderr: "\tnot a dynamic executable\n",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(&stdout, &stderr, c.path, false, c.unused)
+			if code != c.wantCode {
+				t.Errorf(`run(): wanted exit code %d, got %d`, c.wantCode, code)
+			}
+
+			wantStdout := strings.Replace(c.wantStdout, "$ROOT", dir, -1)
+			if stdout.String() != wantStdout {
+				t.Errorf(`run(): wanted stdout %q, got %q`, wantStdout, stdout.String())
+			}
+			if stderr.String() != c.wantStderr {
+				t.Errorf(`run(): wanted stderr %q, got %q`, c.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_JSON(t *testing.T) {
+	dir, executable := writeExecutable(t)
+
+	var stdout, stderr bytes.Buffer
+	code := run(&stdout, &stderr, executable, true, false)
+	if code != 0 {
+		t.Fatalf(`run(): unexpected exit code %d: %s`, code, stderr.String())
+	}
+
+	var got []Link
+	err := json.Unmarshal(stdout.Bytes(), &got)
+	if err != nil {
+		t.Fatalf(`run(): invalid JSON output: %s`, err)
+	}
+
+	want := []Link{
+		{Name: "liba.so", Path: dir + "/lib/liba.so", Found: true},
+		{Name: "libmissing.so", Path: "libmissing.so"},
+		{Name: "libb.so", Path: dir + "/lib/libb.so", Found: true},
+		{Name: "libc.so", Path: dir + "/lib/libc.so", Found: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`run(): wanted %#v, got %#v`, want, got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/elwinar/rcoredump/pkg/client"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+	"github.com/elwinar/rcoredump/pkg/semver"
+)
+
+// cachedCapabilities is the content of the file the capabilities of the
+// destination host are cached in between two runs of the forwarder.
+type cachedCapabilities struct {
+	Dest         string       `json:"dest"`
+	FetchedAt    time.Time    `json:"fetched_at"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// capabilities returns the capabilities of the destination host, read from
+// the cache if it is recent enough, with the overrides given on the
+// command-line applied. The boolean reports whether they were actually
+// advertised by the host, failing to get them meaning that the forwarder
+// should send everything as it doesn't know better.
+func (s *service) capabilities() (Capabilities, bool) {
+	caps, fetched := s.readCapabilities()
+	if !fetched {
+		s.logger.Debug("fetching capabilities")
+		var err error
+		caps, err = s.client.Capabilities()
+		var statusErr client.StatusError
+		if errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound {
+			s.logger.Debug("capabilities not advertised by the destination host")
+		} else if err != nil {
+			s.logger.Warn("fetching capabilities", "err", err)
+		} else {
+			fetched = true
+			s.writeCapabilities(caps)
+		}
+	}
+
+	// The overrides are validated when the service is initialized.
+	_ = overrideCapabilities(&caps, s.capabilitiesOverrides)
+	return caps, fetched
+}
+
+// readCapabilities reads the cached capabilities, if they are recent enough
+// and were fetched from the same destination host.
+func (s *service) readCapabilities() (Capabilities, bool) {
+	if len(s.capabilitiesCache) == 0 || s.capabilitiesTTL == 0 {
+		return Capabilities{}, false
+	}
+
+	raw, err := ioutil.ReadFile(s.capabilitiesCache)
+	if errors.Is(err, os.ErrNotExist) {
+		return Capabilities{}, false
+	}
+	if err != nil {
+		s.logger.Warn("reading cached capabilities", "err", err)
+		return Capabilities{}, false
+	}
+
+	var cached cachedCapabilities
+	err = json.Unmarshal(raw, &cached)
+	if err != nil {
+		s.logger.Warn("reading cached capabilities", "err", err)
+		return Capabilities{}, false
+	}
+
+	if cached.Dest != s.dest || time.Since(cached.FetchedAt) > s.capabilitiesTTL {
+		return Capabilities{}, false
+	}
+	return cached.Capabilities, true
+}
+
+// writeCapabilities caches the capabilities for the next runs. The file is
+// replaced atomically, as several forwarders may run at once.
+func (s *service) writeCapabilities(caps Capabilities) {
+	if len(s.capabilitiesCache) == 0 || s.capabilitiesTTL == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(cachedCapabilities{
+		Dest:         s.dest,
+		FetchedAt:    time.Now(),
+		Capabilities: caps,
+	})
+	if err != nil {
+		s.logger.Warn("caching capabilities", "err", err)
+		return
+	}
+
+	err = writeFileAtomic(s.capabilitiesCache, raw)
+	if err != nil {
+		s.logger.Warn("caching capabilities", "err", err)
+	}
+}
+
+// writeFileAtomic writes the file by renaming a temporary one over it.
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return wrap(err, "creating cache directory")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return wrap(err, "creating cache file")
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return wrap(err, "writing cache file")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return wrap(err, "writing cache file")
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// overrideCapabilities sets the capabilities given as key=value pairs, the
+// keys being the JSON names of the fields.
+func overrideCapabilities(caps *Capabilities, overrides map[string]string) error {
+	for key, value := range overrides {
+		var err error
+		switch key {
+		case "compression":
+			caps.Compression = strings.Split(value, ",")
+		case "debuginfod":
+			caps.Debuginfod, err = strconv.ParseBool(value)
+		case "read_only":
+			caps.ReadOnly, err = strconv.ParseBool(value)
+		case "max_core_size":
+			caps.MaxCoreSize, err = parseSize(value)
+		case "max_executable_size":
+			caps.MaxExecutableSize, err = parseSize(value)
+		case "sanitizer_reports":
+			caps.SanitizerReports, err = strconv.ParseBool(value)
+		case "min_forwarder_version":
+			caps.MinForwarderVersion = value
+			if len(value) != 0 {
+				_, err = semver.Parse(value)
+			}
+		default:
+			err = errors.New("unknown capability")
+		}
+		if err != nil {
+			return wrap(err, "invalid capability %s", key)
+		}
+	}
+	return nil
+}
+
+// outdated reports whether the version of the forwarder is older than the
+// minimum one accepted by the server. As for the server, the versions of the
+// development builds can't be compared, and are accepted.
+func outdated(version string, caps Capabilities) bool {
+	if len(caps.MinForwarderVersion) == 0 {
+		return false
+	}
+	min, err := semver.Parse(caps.MinForwarderVersion)
+	if err != nil {
+		return false
+	}
+	v, err := semver.Parse(version)
+	if err != nil {
+		return false
+	}
+	return v.Less(min)
+}
+
+// parseSize parses a human-readable size (e.g: "10GB").
+func parseSize(raw string) (int64, error) {
+	var size datasize.ByteSize
+	err := size.UnmarshalText([]byte(raw))
+	if err != nil {
+		return 0, err
+	}
+	return int64(size.Bytes()), nil
+}
+
+// fileSize returns the size of the file at path, "-" being stdin whose size
+// is unknown.
+func fileSize(path string) (int64, bool) {
+	if path == "-" {
+		return 0, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Suffixes the kernel appends to the executable path (%E in the core_pattern)
+// when the executable was removed after being started, or when its path is
+// unknown, in which case the name of the process is given instead.
+const (
+	deletedSuffix     = " (deleted)"
+	pathUnknownSuffix = " (path unknown)"
+)
+
+// translateExecutablePath returns the executable path encoded by the kernel
+// for the %E specifier of the core_pattern, which replaces the slashes by
+// bangs, and nothing else. The bangs of the original path are thus ambiguous,
+// and resolved by looking for the executable on the filesystem. The arguments
+// that aren't encoded (e.g: given by hand) are returned as is.
+func translateExecutablePath(arg string, exists func(string) bool) string {
+	arg = strings.TrimSuffix(arg, deletedSuffix)
+	if strings.HasSuffix(arg, pathUnknownSuffix) || !strings.HasPrefix(arg, "!") {
+		return arg
+	}
+
+	naive := strings.Replace(arg, "!", "/", -1)
+	if !strings.Contains(arg[1:], "!") || exists(naive) {
+		return naive
+	}
+
+	// Each bang can be either a slash or an actual bang, so the
+	// components are rebuilt one after the other, keeping the ones that
+	// exist.
+	path, ok := resolveBangs("/", strings.Split(arg[1:], "!"), exists)
+	if !ok {
+		return naive
+	}
+	return path
+}
+
+// resolveBangs returns the first existing path made by appending to dir the
+// parts joined by either slashes or bangs.
+func resolveBangs(dir string, parts []string, exists func(string) bool) (string, bool) {
+	for i := 1; i <= len(parts); i++ {
+		path := filepath.Join(dir, strings.Join(parts[:i], "!"))
+		if !exists(path) {
+			continue
+		}
+		if i == len(parts) {
+			return path, true
+		}
+		if resolved, ok := resolveBangs(path, parts[i:], exists); ok {
+			return resolved, true
+		}
+	}
+	return "", false
+}
+
+// fileExists reports whether there is a file at the given path.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
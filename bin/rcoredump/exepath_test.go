@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTranslateExecutablePath(t *testing.T) {
+	files := map[string]bool{
+		"/usr":                  true,
+		"/usr/bin":              true,
+		"/usr/bin/crash":        true,
+		"/opt":                  true,
+		"/opt/a!b":              true,
+		"/opt/a!b/bang!crash":   true,
+		"/opt/a":                true,
+		"/srv":                  true,
+		"/srv/space dir":        true,
+		"/srv/space dir/crash%": true,
+	}
+	exists := func(path string) bool { return files[path] }
+
+	for n, c := range map[string]struct {
+		arg  string
+		want string
+	}{
+		"simple":        {arg: "!usr!bin!crash", want: "/usr/bin/crash"},
+		"bangs":         {arg: "!opt!a!b!bang!crash", want: "/opt/a!b/bang!crash"},
+		"special":       {arg: "!srv!space dir!crash%", want: "/srv/space dir/crash%"},
+		"missing":       {arg: "!usr!bin!missing", want: "/usr/bin/missing"},
+		"missing bangs": {arg: "!opt!a!b!missing", want: "/opt/a/b/missing"},
+		"deleted":       {arg: "!usr!bin!crash (deleted)", want: "/usr/bin/crash"},
+		"path unknown":  {arg: "crash (path unknown)", want: "crash (path unknown)"},
+		"not encoded":   {arg: "/usr/bin/crash", want: "/usr/bin/crash"},
+		"name only":     {arg: "crash!", want: "crash!"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := translateExecutablePath(c.arg, exists)
+			if got != c.want {
+				t.Errorf(`translateExecutablePath(%q): wanted %q, got %q`, c.arg, c.want, got)
+			}
+		})
+	}
+}
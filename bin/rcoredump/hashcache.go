@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxCachedHashes is the number of hashes kept in the cache, the oldest ones
+// being evicted first.
+const maxCachedHashes = 1000
+
+// cachedHash is the hash of an executable, along with the size, modification
+// time, device, inode and change time of the file when it was computed. The
+// hash is stale once any of them changed, the change time catching the files
+// rewritten with the same size and modification time, and the device and inode
+// the files replaced by another.
+type cachedHash struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Device     uint64    `json:"device"`
+	Inode      uint64    `json:"inode"`
+	ChangeTime time.Time `json:"change_time"`
+	Hash       string    `json:"hash"`
+	CachedAt   time.Time `json:"cached_at"`
+}
+
+// hashCache is the content of the file the hashes of the executables are
+// cached in between two runs of the forwarder, indexed by path.
+type hashCache map[string]cachedHash
+
+// readHashCache reads the cache at path, a missing file being an empty cache.
+func readHashCache(path string) (hashCache, error) {
+	raw, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hashCache{}, nil
+	}
+	if err != nil {
+		return hashCache{}, wrap(err, "reading cache file")
+	}
+
+	cache := hashCache{}
+	err = json.Unmarshal(raw, &cache)
+	if err != nil {
+		return hashCache{}, wrap(err, "parsing cache file")
+	}
+	return cache, nil
+}
+
+// lookup returns the cached hash of the file at path, if the file didn't
+// change since it was computed.
+func (c hashCache) lookup(path string, info os.FileInfo) (string, bool) {
+	cached, ok := c[path]
+	if !ok || cached.Size != info.Size() || !cached.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	device, inode, changeTime := fileIdentity(info)
+	if cached.Device != device || cached.Inode != inode || !cached.ChangeTime.Equal(changeTime) {
+		return "", false
+	}
+	return cached.Hash, true
+}
+
+// add the hash of the file at path to the cache, evicting the oldest hashes
+// if needed.
+func (c hashCache) add(path string, info os.FileInfo, hash string) {
+	device, inode, changeTime := fileIdentity(info)
+	c[path] = cachedHash{
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		Device:     device,
+		Inode:      inode,
+		ChangeTime: changeTime,
+		Hash:       hash,
+		CachedAt:   time.Now(),
+	}
+	if len(c) <= maxCachedHashes {
+		return
+	}
+
+	paths := make([]string, 0, len(c))
+	for p := range c {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return c[paths[i]].CachedAt.Before(c[paths[j]].CachedAt)
+	})
+	for _, p := range paths[:len(c)-maxCachedHashes] {
+		delete(c, p)
+	}
+}
+
+// write the cache at path. The file is replaced atomically, as several
+// forwarders may run at once, the last one winning.
+func (c hashCache) write(path string) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return wrap(err, "encoding cache")
+	}
+	return writeFileAtomic(path, raw)
+}
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileIdentity returns the device and inode of the file, and the time its
+// content or attributes last changed, which unlike the modification time
+// can't be set by the users.
+func fileIdentity(info os.FileInfo) (device, inode uint64, changeTime time.Time) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, time.Time{}
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+}
@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCache_Lookup_Identity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	executable := filepath.Join(dir, "executable")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// write the file with the given content, keeping the same modification
+	// time, and returns its info.
+	write := func(path, content string) os.FileInfo {
+		err := ioutil.WriteFile(path, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf(`writing executable: %s`, err)
+		}
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatalf(`changing modification time: %s`, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf(`stat: %s`, err)
+		}
+		return info
+	}
+
+	cache := hashCache{}
+	info := write(executable, "first")
+	cache.add(executable, info, "first")
+	if _, ok := cache.lookup(executable, info); !ok {
+		t.Errorf(`lookup(unchanged): wanted the cached hash`)
+	}
+
+	// The file is rewritten with the same size and modification time, but
+	// its change time can't be set back.
+	time.Sleep(10 * time.Millisecond)
+	info = write(executable, "other")
+	if _, ok := cache.lookup(executable, info); ok {
+		t.Errorf(`lookup(rewritten): wanted no cached hash`)
+	}
+
+	// The file is replaced by another, of the same size and modification
+	// time.
+	cache.add(executable, info, "other")
+	write(filepath.Join(dir, "replacement"), "third")
+	err = os.Rename(filepath.Join(dir, "replacement"), executable)
+	if err != nil {
+		t.Fatalf(`replacing executable: %s`, err)
+	}
+	info, err = os.Stat(executable)
+	if err != nil {
+		t.Fatalf(`stat: %s`, err)
+	}
+	if _, ok := cache.lookup(executable, info); ok {
+		t.Errorf(`lookup(replaced): wanted no cached hash`)
+	}
+}
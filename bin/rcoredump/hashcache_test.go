@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+func TestService_HashExecutable_Cache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	executable := filepath.Join(dir, "executable")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// write the executable with the given content and modification time.
+	write := func(content string, modTime time.Time) {
+		err := ioutil.WriteFile(executable, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf(`writing executable: %s`, err)
+		}
+		err = os.Chtimes(executable, modTime, modTime)
+		if err != nil {
+			t.Fatalf(`changing modification time: %s`, err)
+		}
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{logger: logger, hashCache: filepath.Join(dir, "cache", "hashes.json")}
+	hash := func() string {
+		hash, err := s.hashExecutable(executable)
+		if err != nil {
+			t.Fatalf(`hashExecutable(): unexpected error: %s`, err)
+		}
+		return hash
+	}
+
+	write("first", modTime)
+	first := hash()
+
+	// The cached hash is changed, so only a cache hit returns it.
+	cache, err := readHashCache(s.hashCache)
+	if err != nil {
+		t.Fatalf(`readHashCache(): unexpected error: %s`, err)
+	}
+	cached := cache[executable]
+	cached.Hash = "cached"
+	cache[executable] = cached
+	err = cache.write(s.hashCache)
+	if err != nil {
+		t.Fatalf(`write(): unexpected error: %s`, err)
+	}
+	if got := hash(); got != "cached" {
+		t.Errorf(`hashExecutable(unchanged): wanted cached hash, got %s`, got)
+	}
+
+	write("other", modTime.Add(time.Second))
+	other := hash()
+	if other == first {
+		t.Errorf(`hashExecutable(modification time changed): wanted a new hash, got the cached one`)
+	}
+
+	write("longer", modTime.Add(time.Second))
+	if got := hash(); got == other {
+		t.Errorf(`hashExecutable(size changed): wanted a new hash, got the cached one`)
+	}
+
+	// Without cache, the executable is always read.
+	s.hashCache = ""
+	write("first", modTime)
+	if got := hash(); got != first {
+		t.Errorf(`hashExecutable(no cache): wanted %s, got %s`, first, got)
+	}
+}
+
+func TestHashCache_Add(t *testing.T) {
+	info, err := os.Stat(".")
+	if err != nil {
+		t.Fatalf(`stat: %s`, err)
+	}
+
+	cache := hashCache{}
+	for i := 0; i < maxCachedHashes+10; i++ {
+		cache.add(fmt.Sprintf("/bin/%d", i), info, "hash")
+	}
+	if len(cache) != maxCachedHashes {
+		t.Errorf(`wanted %d cached hashes, got %d`, maxCachedHashes, len(cache))
+	}
+	if _, ok := cache["/bin/0"]; ok {
+		t.Errorf(`wanted the oldest hash evicted`)
+	}
+	if _, ok := cache.lookup(fmt.Sprintf("/bin/%d", maxCachedHashes+9), info); !ok {
+		t.Errorf(`wanted the newest hash kept`)
+	}
+}
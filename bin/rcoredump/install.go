@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxCorePattern is the maximum length of the core_pattern, above which the
+// kernel truncates it.
+const maxCorePattern = 127
+
+// installer configures the kernel to pipe the coredumps to the forwarder,
+// with the arguments readArgs expects.
+type installer struct {
+	executable string
+	options    string
+	pipeLimit  int
+	procDir    string
+	dryRun     bool
+	out        io.Writer
+}
+
+// install parses the arguments of the install subcommand and runs it,
+// printing what it does to out.
+func install(args []string, out io.Writer) error {
+	var i installer
+	i.out = out
+	fs := flag.NewFlagSet("rcoredump-install-"+Version, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage of rcoredump install: rcoredump install [options]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&i.executable, "executable", "", "absolute path of the forwarder to invoke from the core_pattern, empty for the running one")
+	fs.StringVar(&i.options, "options", "", "options to invoke the forwarder with, separated by spaces (e.g: \"-conf=/etc/rcoredump/rcoredump.conf -k8s -pid=%P\")")
+	fs.IntVar(&i.pipeLimit, "core-pipe-limit", 16, "number of coredumps piped concurrently for which the kernel waits for the forwarder, keeping the crashed process readable, 0 to leave it unchanged")
+	fs.StringVar(&i.procDir, "proc-dir", "/proc/sys/kernel", "directory of the kernel tunables")
+	fs.BoolVar(&i.dryRun, "dry-run", false, "print what would be written without writing it")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if len(i.executable) == 0 {
+		i.executable, err = os.Executable()
+		if err != nil {
+			return wrap(err, "finding forwarder path")
+		}
+	}
+
+	return i.run()
+}
+
+// corePattern returns the core_pattern piping the coredumps to the forwarder.
+// The kernel doesn't use a shell to invoke it: the path must be absolute, and
+// the arguments are split on spaces.
+func (i installer) corePattern() (string, error) {
+	if !filepath.IsAbs(i.executable) {
+		return "", fmt.Errorf("forwarder path %q isn't absolute", i.executable)
+	}
+	if strings.ContainsAny(i.executable, " \t") {
+		return "", fmt.Errorf("forwarder path %q contains spaces", i.executable)
+	}
+
+	// The executable path is given with its slashes replaced by bangs
+	// (%E), which readArgs translates back.
+	args := append([]string{"|" + i.executable}, strings.Fields(i.options)...)
+	args = append(args, "%E", "%t")
+	pattern := strings.Join(args, " ")
+	if len(pattern) > maxCorePattern {
+		return "", fmt.Errorf("core_pattern %q is longer than %d characters", pattern, maxCorePattern)
+	}
+	return pattern, nil
+}
+
+func (i installer) run() error {
+	if i.pipeLimit < 0 {
+		return errors.New("invalid value for core-pipe-limit option: must be positive")
+	}
+
+	pattern, err := i.corePattern()
+	if err != nil {
+		return err
+	}
+
+	err = i.write("core_pattern", pattern)
+	if err != nil {
+		return err
+	}
+
+	// Unless the pipe limit is set, the kernel doesn't wait for the
+	// forwarder, and the crashed process may be gone before it is read.
+	if i.pipeLimit != 0 {
+		err = i.write("core_pipe_limit", strconv.Itoa(i.pipeLimit))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write the value of a kernel tunable, or only print it in dry-run mode.
+func (i installer) write(name, value string) error {
+	path := filepath.Join(i.procDir, name)
+	if i.dryRun {
+		fmt.Fprintf(i.out, "would write %s: %s\n", path, value)
+		return nil
+	}
+
+	err := ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+	if err != nil {
+		return wrap(err, "writing %s", name)
+	}
+	fmt.Fprintf(i.out, "wrote %s: %s\n", path, value)
+	return nil
+}
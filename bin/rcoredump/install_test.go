@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstaller_CorePattern(t *testing.T) {
+	for n, c := range map[string]struct {
+		executable string
+		options    string
+		want       string
+		fails      bool
+	}{
+		"bare":     {executable: "/usr/bin/rcoredump", want: "|/usr/bin/rcoredump %E %t"},
+		"options":  {executable: "/usr/bin/rcoredump", options: " -k8s  -pid=%P ", want: "|/usr/bin/rcoredump -k8s -pid=%P %E %t"},
+		"relative": {executable: "rcoredump", fails: true},
+		"spaces":   {executable: "/opt/rcore dump/rcoredump", fails: true},
+		"too long": {executable: "/usr/bin/rcoredump", options: strings.Repeat("-k8s ", 30), fails: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := installer{executable: c.executable, options: c.options}.corePattern()
+			if c.fails != (err != nil) {
+				t.Fatalf(`corePattern(): wanted failure %t, got %v`, c.fails, err)
+			}
+			if got != c.want {
+				t.Errorf(`corePattern(): wanted %q, got %q`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// A dry run doesn't write anything.
+	var out bytes.Buffer
+	err = install([]string{"-proc-dir", dir, "-executable", "/usr/bin/rcoredump", "-dry-run"}, &out)
+	if err != nil {
+		t.Fatalf(`install(dry-run): unexpected error: %s`, err)
+	}
+	if !strings.Contains(out.String(), "would write") {
+		t.Errorf(`install(dry-run): wanted the tunables to be printed, got %q`, out.String())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf(`install(dry-run): wanted no file written, got %d`, len(files))
+	}
+
+	out.Reset()
+	err = install([]string{"-proc-dir", dir, "-executable", "/usr/bin/rcoredump", "-core-pipe-limit", "8"}, &out)
+	if err != nil {
+		t.Fatalf(`install(): unexpected error: %s`, err)
+	}
+	for name, want := range map[string]string{
+		"core_pattern":    "|/usr/bin/rcoredump %E %t\n",
+		"core_pipe_limit": "8\n",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf(`reading %s: %s`, name, err)
+		}
+		if string(got) != want {
+			t.Errorf(`%s: wanted %q, got %q`, name, want, got)
+		}
+	}
+}
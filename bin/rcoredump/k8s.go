@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/k8s"
+)
+
+// kubeletTimeout bounds the lookup of the pod, so a busy kubelet doesn't
+// delay the upload of the coredump.
+const kubeletTimeout = 5 * time.Second
+
+// enrichK8s adds the kubernetes namespace, pod, container and node of the
+// crashed process to the metadata, without overriding the ones given on the
+// command-line. It is best-effort: a lookup failure is only logged.
+func (s *service) enrichK8s() {
+	container, err := k8s.ReadCgroup(s.pid)
+	if err != nil {
+		s.logger.Warn("reading cgroup", "pid", s.pid, "err", err)
+		return
+	}
+
+	// The identifiers are always known, even if the kubelet can't be
+	// queried.
+	metadata := map[string]string{
+		"k8s.pod_uid":      container.PodUID,
+		"k8s.container_id": container.ID,
+	}
+	defer func() {
+		if s.metadata == nil {
+			s.metadata = make(map[string]string)
+		}
+		for key, value := range metadata {
+			if _, ok := s.metadata[key]; !ok && len(value) != 0 {
+				s.metadata[key] = value
+			}
+		}
+	}()
+
+	kubelet := k8s.Kubelet{
+		URL: s.k8sKubeletURL,
+		HTTP: &http.Client{
+			Timeout: kubeletTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: s.k8sKubeletInsecure},
+			},
+		},
+	}
+	if len(s.k8sTokenFile) != 0 {
+		raw, err := ioutil.ReadFile(s.k8sTokenFile)
+		if err != nil {
+			s.logger.Warn("reading kubelet token", "err", err)
+			return
+		}
+		kubelet.Token = strings.TrimSpace(string(raw))
+	}
+
+	info, err := kubelet.Lookup(container)
+	if err != nil {
+		s.logger.Warn("looking up pod", "pod_uid", container.PodUID, "err", err)
+		return
+	}
+
+	metadata["k8s.namespace"] = info.Namespace
+	metadata["k8s.pod"] = info.Pod
+	metadata["k8s.container"] = info.Container
+	metadata["k8s.node"] = info.Node
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/elfx"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// maxSymlinks is the maximum number of symbolic links followed when resolving
+// a library path, like the kernel does to avoid loops.
+const maxSymlinks = 40
+
+// resolveLinks returns the shared libraries the executable depends on,
+// directly or transitively, by walking the dependency tree breadth-first.
+// Libraries are deduplicated by their real path, so a file reachable through
+// multiple names is only listed (and sent) once. The platform is used to
+// expand $PLATFORM in the library paths, and guessed if empty.
+//
+// A library that can't be read is reported in its link, and doesn't stop the
+// resolution of the others.
+//
+// The libraries of a level of the tree are resolved concurrently by
+// resolve-workers routines, then merged in order, so the result is the same
+// as a serial walk.
+//
+// The walk stops once max-links libraries are listed or resolve-timeout is
+// elapsed, so a huge dependency tree doesn't delay the handling of the crash,
+// in which case the libraries listed so far are returned along with true.
+func (s *service) resolveLinks(executable, platform string) ([]Link, bool, error) {
+	var deadline time.Time
+	if s.resolveTimeout != 0 {
+		deadline = time.Now().Add(s.resolveTimeout)
+	}
+	expired := func() bool {
+		return !deadline.IsZero() && time.Now().After(deadline)
+	}
+
+	root, err := elfx.Open(executable)
+	if err != nil {
+		return nil, false, wrap(err, "opening executable")
+	}
+	root.Platform = platform
+
+	// Keep track of every opened file to close them all at once.
+	opened := []elfx.File{root}
+	defer func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}()
+
+	// Statically linked executables don't load anything.
+	if static, _ := root.Type(); static {
+		return nil, false, nil
+	}
+
+	var links []Link
+	known := make(map[string]bool)
+	byPath := make(map[string]int)
+	level := []levelFile{{file: root, link: -1}}
+	for len(level) != 0 {
+		// List the libraries of the level that weren't seen yet, in
+		// the order a serial walk would meet them.
+		var tasks []resolveTask
+		for _, l := range level {
+			file := l.file
+			libraries, err := file.ImportedLibraries()
+			if err != nil && l.link < 0 {
+				return nil, false, wrap(err, "listing libraries of %s", file.Path)
+			}
+			if err != nil {
+				links[l.link].Found = false
+				links[l.link].Error = wrap(err, "listing libraries").Error()
+				continue
+			}
+
+			for _, library := range libraries {
+				if known[library] {
+					continue
+				}
+				known[library] = true
+				tasks = append(tasks, resolveTask{file: file, library: library})
+			}
+		}
+
+		s.runResolveTasks(tasks, platform, expired)
+
+		level = nil
+		for i, task := range tasks {
+			if (s.maxLinks != 0 && len(links) >= s.maxLinks) || !task.done {
+				// The files opened for the remaining tasks
+				// must still be closed.
+				for _, task := range tasks[i:] {
+					if task.opened != nil {
+						task.opened.Close()
+					}
+				}
+				return links, true, nil
+			}
+
+			link := task.link
+
+			// The same file was already reached under another
+			// name, we only need to remember the new way to reach
+			// it.
+			if j, ok := byPath[link.Path]; ok && link.Found {
+				links[j].Symlinks = appendUnique(links[j].Symlinks, link.Symlinks...)
+				if task.opened != nil {
+					task.opened.Close()
+				}
+				continue
+			}
+
+			if task.opened != nil {
+				opened = append(opened, *task.opened)
+				level = append(level, levelFile{file: *task.opened, link: len(links)})
+				byPath[link.Path] = len(links)
+			}
+			links = append(links, link)
+		}
+	}
+
+	return links, false, nil
+}
+
+// levelFile is a file of a level of the dependency tree, along with the index
+// of its link, -1 for the executable.
+type levelFile struct {
+	file elfx.File
+	link int
+}
+
+// resolveTask is the resolution of a library required by a file.
+type resolveTask struct {
+	file    elfx.File
+	library string
+
+	// done is set once the task is executed, tasks being skipped once
+	// the resolution is expired.
+	done bool
+	link Link
+	// opened is the library, opened to list its own libraries, if it
+	// was found and could be read.
+	opened *elfx.File
+}
+
+// runResolveTasks executes the tasks with at most resolve-workers of them at
+// once.
+func (s *service) runResolveTasks(tasks []resolveTask, platform string, expired func() bool) {
+	workers := s.resolveWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if expired() {
+					continue
+				}
+				tasks[i].run(platform)
+				tasks[i].done = true
+			}
+		}()
+	}
+	for i := range tasks {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// run resolves the library, follows its symbolic links, and opens it.
+func (t *resolveTask) run(platform string) {
+	t.link = Link{Name: t.library}
+	path, found, err := t.file.ResolveImportedLibrary(t.library)
+	if err != nil {
+		t.link.Path = path
+		t.link.Error = err.Error()
+		return
+	}
+	if !found {
+		t.link.Path = path
+		return
+	}
+
+	t.link.Path, t.link.Symlinks, err = followSymlinks(path)
+	if err != nil {
+		t.link.Path = path
+		t.link.Error = err.Error()
+		return
+	}
+	t.link.Found = true
+
+	library, err := elfx.Open(t.link.Path)
+	if err != nil {
+		t.link.Found = false
+		t.link.Error = wrap(err, "opening library").Error()
+		return
+	}
+	library.Platform = platform
+	t.opened = &library
+}
+
+// writeLinksManifest writes the links as JSON to the links-manifest file, so
+// the operators can check which libraries are sent and why the others aren't.
+func (s *service) writeLinksManifest(links []Link) error {
+	raw, err := json.MarshalIndent(links, "", "\t")
+	if err != nil {
+		return wrap(err, "encoding links")
+	}
+	raw = append(raw, '\n')
+
+	if s.linksManifest == "-" {
+		_, err = os.Stderr.Write(raw)
+		return err
+	}
+	return ioutil.WriteFile(s.linksManifest, raw, 0644)
+}
+
+// followSymlinks returns the real path of the given file, and the list of
+// paths followed to get there, including the original one if it differs from
+// the real path.
+func followSymlinks(path string) (string, []string, error) {
+	var chain []string
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+
+		chain = append(chain, path)
+		path = target
+	}
+
+	// Symbolic links in the parent directories (e.g: /lib pointing to
+	// /usr/lib) aren't followed by the loop above.
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if real != path {
+		chain = append(chain, path)
+	}
+
+	return real, chain, nil
+}
+
+// appendUnique appends the values that aren't already in the slice.
+func appendUnique(slice []string, values ...string) []string {
+	for _, v := range values {
+		var found bool
+		for _, s := range slice {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
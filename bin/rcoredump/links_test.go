@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/elfx/elfxtest"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// writeTree generates an executable depending on a tree of libraries, each of
+// them depending on the next ones, some through symbolic links, and a missing
+// one. It returns the path of the executable and the names of the libraries.
+func writeTree(t testing.TB, size int) (string, []string) {
+	dir, err := ioutil.TempDir("", "links")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	names := []string{"libmissing.so"}
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("libtree%d.so", i)
+		names = append(names, name)
+
+		var needed []string
+		for _, j := range []int{2*i + 1, 2*i + 2, i + 3} {
+			if j < size {
+				needed = append(needed, fmt.Sprintf("libtree%d.so", j))
+			}
+		}
+
+		// Every third library is reached through a symbolic link.
+		if i%3 != 0 {
+			elfxtest.Write(t, dir, filepath.Join("lib", name), elfxtest.File{Needed: needed, RunPath: []string{"$ORIGIN"}})
+			continue
+		}
+		elfxtest.Write(t, dir, filepath.Join("lib", name+".1"), elfxtest.File{Needed: needed, RunPath: []string{"$ORIGIN"}})
+		err := os.Symlink(name+".1", filepath.Join(dir, "lib", name))
+		if err != nil {
+			t.Fatalf(`creating symlink: %s`, err)
+		}
+	}
+
+	executable := elfxtest.Write(t, dir, "bin/executable", elfxtest.File{
+		Needed:  []string{"libtree0.so", "libmissing.so", "libtree1.so"},
+		RunPath: []string{"$ORIGIN/../lib"},
+	})
+	return executable, names
+}
+
+func TestResolveLinks(t *testing.T) {
+	executable, names := writeTree(t, 30)
+
+	serial, truncated, err := (&service{resolveWorkers: 1}).resolveLinks(executable, "")
+	if err != nil {
+		t.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+	}
+	if truncated {
+		t.Errorf(`resolveLinks(): unexpected truncation`)
+	}
+
+	var got []string
+	for _, link := range serial {
+		got = append(got, link.Name)
+		if link.Found != (link.Name != "libmissing.so") {
+			t.Errorf(`resolveLinks(): unexpected found %t for %s`, link.Found, link.Name)
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(names)
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf(`resolveLinks(): wanted %v, got %v`, names, got)
+	}
+
+	for _, workers := range []int{2, 4, 16} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			links, truncated, err := (&service{resolveWorkers: workers}).resolveLinks(executable, "")
+			if err != nil {
+				t.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+			}
+			if truncated {
+				t.Errorf(`resolveLinks(): unexpected truncation`)
+			}
+			if !reflect.DeepEqual(links, serial) {
+				t.Errorf(`resolveLinks(): wanted the serial result %#v, got %#v`, serial, links)
+			}
+		})
+	}
+}
+
+func TestResolveLinks_MaxLinks(t *testing.T) {
+	executable, _ := writeTree(t, 30)
+
+	serial, _, err := (&service{resolveWorkers: 1}).resolveLinks(executable, "")
+	if err != nil {
+		t.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+	}
+
+	links, truncated, err := (&service{resolveWorkers: 4, maxLinks: 10}).resolveLinks(executable, "")
+	if err != nil {
+		t.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+	}
+	if !truncated {
+		t.Errorf(`resolveLinks(): wanted truncation`)
+	}
+	if !reflect.DeepEqual(links, serial[:10]) {
+		t.Errorf(`resolveLinks(): wanted the first links %#v, got %#v`, serial[:10], links)
+	}
+}
+
+func TestResolveLinks_Unreadable(t *testing.T) {
+	executable, _ := writeTree(t, 30)
+
+	// The libraries only required by the unreadable one can't be
+	// resolved, but every other one must be.
+	unreadable := filepath.Join(filepath.Dir(executable), "../lib/libtree2.so")
+	err := ioutil.WriteFile(unreadable, []byte("not an ELF file"), 0644)
+	if err != nil {
+		t.Fatalf(`writing unreadable library: %s`, err)
+	}
+
+	links, _, err := (&service{resolveWorkers: 4}).resolveLinks(executable, "")
+	if err != nil {
+		t.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+	}
+
+	got := make(map[string]Link)
+	for _, link := range links {
+		got[link.Name] = link
+	}
+
+	if link := got["libtree2.so"]; link.Found || len(link.Error) == 0 {
+		t.Errorf(`resolveLinks(): wanted an error for the unreadable library, got %#v`, link)
+	}
+	for _, name := range []string{"libtree1.so", "libtree3.so", "libtree4.so", "libtree29.so"} {
+		if link := got[name]; !link.Sent() {
+			t.Errorf(`resolveLinks(): wanted %s to be resolved, got %#v`, name, link)
+		}
+	}
+	if _, ok := got["libtree5.so"]; ok {
+		t.Errorf(`resolveLinks(): unexpected libtree5.so, only required by the unreadable library`)
+	}
+}
+
+func BenchmarkResolveLinks(b *testing.B) {
+	executable, _ := writeTree(b, 200)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			s := &service{resolveWorkers: workers}
+			for i := 0; i < b.N; i++ {
+				_, _, err := s.resolveLinks(executable, "")
+				if err != nil {
+					b.Fatalf(`resolveLinks(): unexpected error: %s`, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// errLockTimeout is returned when a lock isn't acquired in time.
+var errLockTimeout = errors.New("timeout acquiring lock")
+
+// lockPollInterval is the interval between two attempts to acquire a lock.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockFile acquires an exclusive lock on the file at path, created if needed,
+// waiting up to timeout for the other holders to release it. It returns the
+// function releasing it.
+func lockFile(path string, timeout time.Duration) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return nil, wrap(err, "creating lock directory")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, wrap(err, "opening lock file")
+	}
+
+	// The lock is polled rather than waited for, so the wait can be
+	// bounded.
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, wrap(err, "locking file")
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, errLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// Closing the file releases the lock. The file itself is kept, as
+	// removing it would let another forwarder lock a new file while one
+	// is waiting on the old one.
+	return func() { f.Close() }, nil
+}
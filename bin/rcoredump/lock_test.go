@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "locks", "hash.lock")
+
+	unlock, err := lockFile(path, 0)
+	if err != nil {
+		t.Fatalf(`lockFile(): unexpected error: %s`, err)
+	}
+
+	// The lock is held until released.
+	_, err = lockFile(path, 2*lockPollInterval)
+	if err != errLockTimeout {
+		t.Fatalf(`lockFile(locked): wanted errLockTimeout, got %v`, err)
+	}
+
+	// The waiters get the lock once released.
+	release := unlock
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		release()
+	}()
+	relock, err := lockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf(`lockFile(released): unexpected error: %s`, err)
+	}
+	relock()
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
 	"crypto/sha1"
+	"debug/elf"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,22 +12,42 @@ import (
 	"io/ioutil"
 	"log"
 	"log/syslog"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/elwinar/rcoredump/pkg/apport"
+	"github.com/elwinar/rcoredump/pkg/client"
 	"github.com/elwinar/rcoredump/pkg/conf"
+	"github.com/elwinar/rcoredump/pkg/corenote"
+	"github.com/elwinar/rcoredump/pkg/elfx"
+	"github.com/elwinar/rcoredump/pkg/protocol"
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 	"github.com/inconshreveable/log15"
 )
 
 var Version = "N/C"
 
+// hostnameEnv is the environment variable overriding the hostname of the
+// system, see service.hostname.
+const hostnameEnv = "RCOREDUMP_HOSTNAME"
+
 func main() {
+	// The install subcommand configures the kernel instead of forwarding a
+	// coredump.
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		err := install(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var s service
 	s.configure()
 
@@ -57,7 +76,41 @@ type service struct {
 	printVersion bool
 	args         []string
 	metadata     map[string]string
-
+	apport       string
+	reportFile   string
+	ldSoConf     string
+	ldSoCache    string
+	checkVersion bool
+	debuginfod   bool
+	hostnameFlag string
+	hostnameFile string
+	pid          int
+
+	maxLinks       int
+	resolveWorkers int
+	resolveTimeout time.Duration
+	linksManifest  string
+
+	caCert             string
+	clientCert         string
+	clientKey          string
+	insecureSkipVerify bool
+	authToken          string
+
+	lockDir     string
+	lockTimeout time.Duration
+	hashCache   string
+
+	k8s                bool
+	k8sKubeletURL      string
+	k8sKubeletInsecure bool
+	k8sTokenFile       string
+
+	capabilitiesCache     string
+	capabilitiesTTL       time.Duration
+	capabilitiesOverrides map[string]string
+
+	client client.Client
 	logger log15.Logger
 }
 
@@ -65,13 +118,44 @@ func (s *service) configure() {
 	fs := flag.NewFlagSet("rcoredump-"+Version, flag.ExitOnError)
 	fs.Usage = func() {
 		fmt.Fprintln(fs.Output(), "Usage of rcoredump: rcoredump [options] <executable path> <timestamp of dump>")
+		fmt.Fprintln(fs.Output(), "       rcoredump [options] -apport <report path>")
+		fmt.Fprintln(fs.Output(), "       rcoredump [options] -report-file <report path> <executable path>")
+		fmt.Fprintln(fs.Output(), "       rcoredump install [options]")
 		fs.PrintDefaults()
 	}
 	fs.StringVar(&s.dest, "dest", "http://localhost:1105", "address of the destination host")
+	fs.StringVar(&s.caCert, "ca-cert", "", "path of the PEM file of the CA certificates to verify the destination host with, empty to use the ones of the system")
+	fs.StringVar(&s.clientCert, "client-cert", "", "path of the PEM file of the certificate to authenticate to the destination host with (requires client-key), empty to disable")
+	fs.StringVar(&s.clientKey, "client-key", "", "path of the PEM file of the key of the client certificate")
+	fs.BoolVar(&s.insecureSkipVerify, "insecure-skip-verify", false, "skip the verification of the certificate of the destination host, for debugging only")
+	fs.StringVar(&s.authToken, "auth-token", "", "bearer token to authenticate to the destination host with, empty to disable")
 	fs.StringVar(&s.src, "src", "-", "path of the coredump to send to the host (\"-\" for stdin)")
 	fs.BoolVar(&s.syslog, "syslog", false, "output logs to syslog")
 	fs.StringVar(&s.filelog, "filelog", "-", "path of the file to log into (\"-\" for stdout)")
 	fs.BoolVar(&s.printVersion, "version", false, "print the version of rcoredump")
+	fs.StringVar(&s.reportFile, "report-file", "", "path of a sanitizer report (e.g: AddressSanitizer) of the executable given as argument to send to the host instead of a coredump (\"-\" for stdin), empty to disable")
+	fs.StringVar(&s.apport, "apport", "", "path of an apport crash report to send to the host instead of a coredump")
+	fs.StringVar(&s.ldSoCache, "ld-so-cache", "", "path of the dynamic linker cache to look up the libraries in first (e.g: /etc/ld.so.cache), empty to disable")
+	fs.StringVar(&s.ldSoConf, "ld-so-conf", "", "path of the dynamic linker configuration to read the library directories from (e.g: /etc/ld.so.conf), empty to use the defaults")
+	fs.StringVar(&s.hostnameFlag, "hostname", "", "hostname to send alongside the coredump instead of the one of the system (also read from the "+hostnameEnv+" environment variable)")
+	fs.StringVar(&s.hostnameFile, "hostname-file", "", "path of a file to read the hostname to send alongside the coredump from (e.g: /etc/hostname, or a file of the kubernetes downward API), empty to disable")
+	fs.IntVar(&s.pid, "pid", 0, "pid of the crashed process, as seen in the initial pid namespace (%P in the core_pattern)")
+	fs.BoolVar(&s.k8s, "k8s", false, "add the kubernetes namespace, pod, container and node of the crashed process to the metadata, from its cgroup and the kubelet (requires pid)")
+	fs.StringVar(&s.k8sKubeletURL, "k8s-kubelet-url", "https://localhost:10250", "address of the kubelet to look up the pods in")
+	fs.BoolVar(&s.k8sKubeletInsecure, "k8s-kubelet-insecure", false, "skip the verification of the certificate of the kubelet, which is usually self-signed")
+	fs.StringVar(&s.k8sTokenFile, "k8s-token-file", "", "path of the file containing the bearer token to authenticate to the kubelet with, empty to disable")
+	fs.IntVar(&s.maxLinks, "max-links", 0, "maximum number of libraries to resolve and send, the others being left out, 0 to disable")
+	fs.IntVar(&s.resolveWorkers, "resolve-workers", 4, "number of libraries to resolve concurrently")
+	fs.DurationVar(&s.resolveTimeout, "resolve-timeout", 0, "maximum duration of the resolution of the libraries (e.g: \"5s\"), the libraries resolved so far being sent, 0 to disable")
+	fs.StringVar(&s.linksManifest, "links-manifest", "", "path of the file to write the resolved libraries into as JSON before sending them (\"-\" for stderr), empty to disable")
+	fs.StringVar(&s.lockDir, "lock-dir", "", "directory of the lock files ensuring a single forwarder of the host sends a given executable at once, the others waiting to skip it, empty to disable")
+	fs.DurationVar(&s.lockTimeout, "lock-timeout", time.Minute, "maximum duration to wait for another forwarder sending the same executable, before sending it anyway")
+	fs.StringVar(&s.hashCache, "hash-cache", "", "path of the file to cache the hashes of the executables in between two runs, by path, size, modification time, device, inode and change time, so an unchanged executable isn't read again (e.g: /var/cache/rcoredump/hashes.json), empty to disable")
+	fs.BoolVar(&s.checkVersion, "check-version", false, "check the destination host can read the coredump before sending it")
+	fs.BoolVar(&s.debuginfod, "debuginfod", false, "don't send the executable and its libraries if the destination host can fetch them from debuginfod by build-id")
+	fs.StringVar(&s.capabilitiesCache, "capabilities-cache", "/var/cache/rcoredump/capabilities.json", "path of the file to cache the capabilities of the destination host in between two runs")
+	fs.DurationVar(&s.capabilitiesTTL, "capabilities-ttl", time.Hour, "duration to cache the capabilities of the destination host for, 0 to fetch them on every run")
+	fs.Var(conf.MapFlag(&s.capabilitiesOverrides), "capabilities", "capabilities of the destination host to assume instead of the advertised ones (e.g: \"debuginfod=true;max_core_size=10GB\", keys: compression, debuginfod, read_only, max_core_size, max_executable_size, min_forwarder_version, sanitizer_reports)")
 	fs.Var(conf.MapFlag(&s.metadata), "metadata", "list of metadata to send alongside the coredump (key=value, can be specified multiple times or separated by ';')")
 	fs.String("conf", "/etc/rcoredump/rcoredump.conf", "configuration file to load")
 	conf.Parse(fs, "conf")
@@ -102,25 +186,131 @@ func (s *service) init() error {
 	}
 	s.logger.SetHandler(handler)
 
+	httpClient, err := s.httpClient()
+	if err != nil {
+		return wrap(err, "configuring TLS")
+	}
+	s.client = client.Client{Dest: s.dest, HTTP: httpClient, Token: s.authToken}
+
+	if s.maxLinks < 0 {
+		return errors.New("invalid value for max-links option: must be positive")
+	}
+	if s.resolveWorkers < 1 {
+		return errors.New("invalid value for resolve-workers option: must be at least 1")
+	}
+	if s.resolveTimeout < 0 {
+		return errors.New("invalid value for resolve-timeout option: must be positive")
+	}
+	if s.lockTimeout < 0 {
+		return errors.New("invalid value for lock-timeout option: must be positive")
+	}
+
+	if s.k8s && s.pid <= 0 {
+		return errors.New("invalid value for pid option: required by the k8s option")
+	}
+
+	err = overrideCapabilities(&Capabilities{}, s.capabilitiesOverrides)
+	if err != nil {
+		return wrap(err, "invalid value for capabilities option")
+	}
+
+	// Failing to read the configuration only means that some libraries
+	// may not be found, which isn't a reason to lose the coredump.
+	if len(s.ldSoConf) != 0 {
+		err = elfx.LoadLdSoConf(s.ldSoConf)
+		if err != nil {
+			s.logger.Warn("loading dynamic linker configuration", "err", err)
+		}
+	}
+
+	if len(s.ldSoCache) != 0 {
+		err = elfx.LoadLdSoCache(s.ldSoCache)
+		if err != nil {
+			s.logger.Warn("loading dynamic linker cache", "err", err)
+		}
+	}
+
 	return nil
 }
 
 func (s *service) run(ctx context.Context) {
 	s.logger.Debug("starting")
 
-	if len(s.args) != 2 {
-		s.logger.Error("unexpected number of arguments on command-line", "want", 2, "got", len(s.args))
-		return
+	var executable string
+	var dumpedAt time.Time
+	var core io.Reader
+	var err error
+	coreFormat := CoreFormatCoredump
+	if len(s.apport) != 0 {
+		executable, dumpedAt, core, err = s.readApport()
+		if err != nil {
+			s.logger.Error("reading apport report", "err", err)
+			return
+		}
+	} else if len(s.reportFile) != 0 {
+		coreFormat = CoreFormatSanitizer
+		executable, dumpedAt, core, err = s.readReport()
+		if err != nil {
+			s.logger.Error("reading sanitizer report", "err", err)
+			return
+		}
+	} else {
+		executable, dumpedAt, err = s.readArgs()
+		if err != nil {
+			s.logger.Error("reading arguments", "err", err)
+			return
+		}
 	}
+	hostname := s.hostname()
 
-	// Pathname of the executable comes up with ! instead of /.
-	executable := strings.Replace(s.args[0], "!", "/", -1)
-	timestamp, err := strconv.ParseInt(s.args[1], 10, 64)
-	if err != nil {
-		s.logger.Error("invalid timestamp format", "err", err)
+	if s.k8s {
+		s.logger.Debug("reading kubernetes metadata")
+		s.enrichK8s()
+	}
+
+	// The capabilities of the server tell what it can do with the coredump,
+	// so the forwarder doesn't send what would be refused or unused.
+	// Failing to get them isn't a reason to lose the dump, as older
+	// servers can't tell.
+	caps, fetched := s.capabilities()
+
+	// The server would refuse the coredump anyway if it can't read it, but
+	// checking first makes the mismatch obvious.
+	if s.checkVersion {
+		s.logger.Debug("checking server version")
+		info := caps.VersionInfo
+		var err error
+		if !fetched {
+			info, err = s.client.Version()
+		}
+		if err != nil {
+			s.logger.Warn("checking server version", "err", err)
+		} else if !client.Compatible(info) {
+			s.logger.Error("incompatible server", "version", info.Version, "min_protocol_version", info.MinProtocolVersion, "max_protocol_version", info.MaxProtocolVersion, "protocol_version", protocol.Version)
+			return
+		}
+	}
+
+	if caps.ReadOnly {
+		s.logger.Error("read-only server")
+		return
+	}
+	if len(caps.Compression) != 0 && !contains(caps.Compression, protocol.Compression) {
+		s.logger.Error("unsupported compression", "compression", protocol.Compression, "supported", strings.Join(caps.Compression, ","))
+		return
+	}
+	if coreFormat == CoreFormatSanitizer && fetched && !caps.SanitizerReports {
+		s.logger.Error("sanitizer reports not supported by the server")
+		return
+	}
+	if outdated(Version, caps) {
+		s.logger.Error("forwarder too old for the server, upgrade required", "version", Version, "min_version", caps.MinForwarderVersion)
+		return
+	}
+	if size, ok := fileSize(s.src); ok && core == nil && caps.MaxCoreSize != 0 && size > caps.MaxCoreSize {
+		s.logger.Error("core too large", "size", size, "max_size", caps.MaxCoreSize)
 		return
 	}
-	hostname, _ := os.Hostname()
 
 	// Look up the executable in the server by using its sha1 hash. The
 	// operation can fail in which case we will continue and consider that
@@ -131,111 +321,267 @@ func (s *service) run(ctx context.Context) {
 	if err != nil {
 		s.logger.Error("hashing executable", "err", err)
 	} else {
-		found, err := s.lookupExecutable(hash)
+		// When a burst of processes of the same executable crash, only
+		// the first forwarder sends the executable, the others waiting
+		// for it to find it on the server.
+		unlock := s.lockExecutable(hash)
+		defer unlock()
+
+		found, err := s.client.LookupExecutable(hash)
 		if err != nil {
 			s.logger.Error("looking up executable", "err", err)
 		}
 		sendExecutable = !found
 	}
 
-	// We will use chunked transfer encoding to avoid keeping the whole
-	// dump in memory more than necessary. We will do this by giving the
-	// request a pipe as body, so it will read from it and send the content
-	// in multiple packets. This is a necessity given that a dump can
-	// measure in GB.
-	pr, pw := io.Pipe()
+	// Only ELF executables can be analyzed by the server, and have
+	// libraries to resolve. Other executables are sent anyway so the dump
+	// isn't lost.
+	s.logger.Debug("detecting executable format")
+	format, err := s.detectFormat(executable)
+	if err != nil {
+		s.logger.Error("detecting executable format", "err", err)
+	} else if format != FormatELF {
+		s.logger.Warn("unsupported executable format", "format", format)
+	}
 
-	// Fill up the pipe in a routine so the sending happens in parallel and
-	// the memory consumption is kept in check.
-	go func() {
-		defer pw.Close()
+	// The build-id allows the server to fetch the executable from
+	// debuginfod, in which case it doesn't need to be sent if the server
+	// supports it.
+	var buildID string
+	if format == FormatELF {
+		s.logger.Debug("reading build-id")
+		buildID, err = s.readBuildID(executable)
+		if err != nil {
+			s.logger.Warn("reading build-id", "err", err)
+		}
+	}
+	if sendExecutable && s.debuginfod && caps.Debuginfod && len(buildID) != 0 {
+		s.logger.Debug("skipping executable available from debuginfod", "build_id", buildID)
+		sendExecutable = false
+	}
 
-		// Send the header.
-		w := gzip.NewWriter(pw)
-		defer w.Close()
+	if size, ok := fileSize(executable); ok && sendExecutable && caps.MaxExecutableSize != 0 && size > caps.MaxExecutableSize {
+		s.logger.Error("executable too large", "size", size, "max_size", caps.MaxExecutableSize)
+		return
+	}
+
+	// The libraries are only needed alongside the executable. As for the
+	// executable, failing to resolve them isn't a reason to lose the
+	// dump.
+	var links []Link
+	var linksTruncated bool
+	if sendExecutable && format == FormatELF {
+		// The platform of the process is read from the core to expand
+		// $PLATFORM in the library paths. It can't be read from a core
+		// that is streamed.
+		var platform string
+		if core == nil && s.src != "-" {
+			s.logger.Debug("reading platform")
+			platform, err = s.readPlatform()
+			if err != nil {
+				s.logger.Warn("reading platform", "err", err)
+			}
+		}
 
-		s.logger.Debug("sending header")
-		err := json.NewEncoder(w).Encode(IndexRequest{
-			DumpedAt:          time.Unix(timestamp, 0),
+		s.logger.Debug("resolving links")
+		links, linksTruncated, err = s.resolveLinks(executable, platform)
+		if err != nil {
+			s.logger.Error("resolving links", "err", err)
+		}
+		if linksTruncated {
+			s.logger.Warn("resolution of links truncated", "links", len(links))
+		}
+
+		if len(s.linksManifest) != 0 {
+			s.logger.Debug("writing links manifest")
+			err = s.writeLinksManifest(links)
+			if err != nil {
+				s.logger.Warn("writing links manifest", "err", err)
+			}
+		}
+	}
+
+	// The size of the core is sent along when known, so the server can
+	// detect a truncated upload. A core being streamed has no size yet.
+	var expectedCoreSize int64
+	if size, ok := fileSize(s.src); ok && core == nil {
+		expectedCoreSize = size
+	}
+
+	s.logger.Debug("sending request")
+	err = s.client.Send(client.Upload{
+		Header: IndexRequest{
+			DumpedAt:          dumpedAt,
+			ExpectedCoreSize:  expectedCoreSize,
+			ExecutableBuildID: buildID,
+			ExecutableFormat:  format,
 			ExecutableHash:    hash,
 			ExecutablePath:    executable,
+			Format:            coreFormat,
 			ForwarderVersion:  Version,
 			Hostname:          hostname,
 			IncludeExecutable: sendExecutable,
+			Links:             links,
+			LinksTruncated:    linksTruncated,
 			Metadata:          s.metadata,
-		})
-		if err != nil {
-			s.logger.Error("sending header", "err", err)
-			return
-		}
+		},
+		Size: s.computeSize(core, executable, sendExecutable, links),
+		OpenCore: func() (io.ReadCloser, error) {
+			if core != nil {
+				return ioutil.NopCloser(core), nil
+			}
+			return openFile(s.src)
+		},
+		OpenExecutable: func() (io.ReadCloser, error) {
+			return openFile(executable)
+		},
+		OpenLink: func(link Link) (io.ReadCloser, error) {
+			return openFile(link.Path)
+		},
+	})
+	var statusErr client.StatusError
+	if errors.As(err, &statusErr) && statusErr.Err.Code == ErrCodeOutdatedForwarder {
+		s.logger.Error("forwarder too old for the server, upgrade required", "version", Version, "err", statusErr.Err.Err)
+		return
+	}
+	if errors.As(err, &statusErr) {
+		s.logger.Error("unexpected status", "status", statusErr.Status, "code", statusErr.Err.Code, "err", statusErr.Err.Err)
+		return
+	}
+	if err != nil {
+		s.logger.Error("sending core", "err", err)
+		return
+	}
 
-		err = w.Close()
-		if err != nil {
-			s.logger.Error("closing header stream", "err", err)
-			return
-		}
+	s.logger.Debug("done")
+}
 
-		// Send the core.
-		w.Reset(pw)
+// lockExecutable acquires the lock of the executable on the host, held until
+// the coredump is sent, and returns the function releasing it. Failing to
+// acquire it isn't a reason to lose the dump, the executable being sent
+// anyway.
+func (s *service) lockExecutable(hash string) func() {
+	if len(s.lockDir) == 0 {
+		return func() {}
+	}
 
-		s.logger.Debug("sending core")
-		err = s.sendFile(w, s.src)
-		if err != nil {
-			s.logger.Error("sending core", "err", err)
-			return
-		}
+	s.logger.Debug("locking executable")
+	unlock, err := lockFile(filepath.Join(s.lockDir, hash+".lock"), s.lockTimeout)
+	if err != nil {
+		s.logger.Warn("locking executable", "err", err)
+		return func() {}
+	}
+	return unlock
+}
 
-		err = w.Close()
+// hostname returns the name of the host the coredump comes from. In
+// containers, the hostname of the system is a meaningless identifier, so it
+// can be overridden, in order of priority, by the hostname option, the
+// RCOREDUMP_HOSTNAME environment variable, and the content of the file of the
+// hostname-file option.
+func (s *service) hostname() string {
+	if len(s.hostnameFlag) != 0 {
+		return s.hostnameFlag
+	}
+
+	if hostname := os.Getenv(hostnameEnv); len(hostname) != 0 {
+		return hostname
+	}
+
+	if len(s.hostnameFile) != 0 {
+		raw, err := ioutil.ReadFile(s.hostnameFile)
 		if err != nil {
-			s.logger.Error("closing header stream", "err", err)
-			return
+			s.logger.Warn("reading hostname file", "err", err)
+		} else if hostname := strings.TrimSpace(string(raw)); len(hostname) != 0 {
+			return hostname
 		}
+	}
 
-		// Check if we want to send the executable.
-		if !sendExecutable {
-			return
-		}
+	hostname, err := os.Hostname()
+	if err != nil {
+		s.logger.Warn("reading hostname", "err", err)
+	}
+	return hostname
+}
 
-		// Send the executable.
-		w.Reset(pw)
+// readArgs parse the command-line arguments, as given by the kernel when
+// invoked via the core_pattern.
+func (s *service) readArgs() (executable string, dumpedAt time.Time, err error) {
+	if len(s.args) != 2 {
+		return "", time.Time{}, fmt.Errorf("unexpected number of arguments on command-line: want 2, got %d", len(s.args))
+	}
 
-		s.logger.Debug("sending executable")
-		err = s.sendFile(w, executable)
-		if err != nil {
-			s.logger.Error("sending executable", "err", err)
-			return
-		}
+	// Pathname of the executable comes up with ! instead of /.
+	executable = translateExecutablePath(s.args[0], fileExists)
+	if strings.HasSuffix(executable, pathUnknownSuffix) {
+		s.logger.Warn("unknown executable path", "executable", executable)
+	}
+	timestamp, err := strconv.ParseInt(s.args[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, wrap(err, "invalid timestamp format")
+	}
 
-		err = w.Close()
-		if err != nil {
-			s.logger.Error("closing executable stream", "err", err)
-			return
-		}
-	}()
+	return executable, time.Unix(timestamp, 0), nil
+}
 
-	// Send the request by giving it the reader end of the pipe.
-	s.logger.Debug("sending request")
-	res, err := http.Post(fmt.Sprintf("%s/cores", s.dest), "application/octet-stream", pr)
+// readApport parse the apport report given on the command-line and returns the
+// informations needed to send it. The report's fields are added to the
+// metadata of the coredump.
+func (s *service) readApport() (executable string, dumpedAt time.Time, core io.Reader, err error) {
+	f, err := os.Open(s.apport)
 	if err != nil {
-		s.logger.Error("sending core", "err", err)
-		return
+		return "", time.Time{}, nil, wrap(err, "opening report")
 	}
-	defer func() {
-		_, _ = io.Copy(ioutil.Discard, res.Body)
-		res.Body.Close()
-	}()
+	defer f.Close()
 
-	s.logger.Debug("received response")
-	if res.StatusCode != http.StatusOK {
-		var err Error
-		_ = json.NewDecoder(res.Body).Decode(&err)
-		s.logger.Error("unexpected status", "err", err.Err)
-		return
+	report, err := apport.Parse(f)
+	if err != nil {
+		return "", time.Time{}, nil, wrap(err, "parsing report")
 	}
 
-	s.logger.Debug("done")
+	executable = report["ExecutablePath"]
+	if len(executable) == 0 {
+		return "", time.Time{}, nil, errors.New("missing executable path in report")
+	}
+
+	dumpedAt, err = report.Date()
+	if err != nil {
+		return "", time.Time{}, nil, wrap(err, "parsing report date")
+	}
+
+	core, err = report.Open("CoreDump")
+	if err != nil {
+		return "", time.Time{}, nil, wrap(err, "opening report coredump")
+	}
+
+	// Only the simple fields are interesting as metadata, the multi-line
+	// ones (like the memory maps or the stack traces computed by apport)
+	// would only clutter the index. The metadata given on the
+	// command-line take precedence.
+	for key, value := range map[string]string{
+		"apport.package":      report["Package"],
+		"apport.problem_type": report["ProblemType"],
+		"apport.signal":       report["Signal"],
+		"apport.cmdline":      report["ProcCmdline"],
+		"apport.release":      report["DistroRelease"],
+		"apport.uname":        report["Uname"],
+	} {
+		if len(value) == 0 || strings.Contains(value, "\n") {
+			continue
+		}
+		if _, ok := s.metadata[key]; ok {
+			continue
+		}
+		s.metadata[key] = value
+	}
+
+	return executable, dumpedAt, core, nil
 }
 
+// hashExecutable returns the sha1 hash of the executable. The hashes are
+// cached by path, so the repeated crashes of an unchanged executable don't
+// read it again. Failing to use the cache only means the executable is read.
 func (s *service) hashExecutable(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -243,62 +589,128 @@ func (s *service) hashExecutable(path string) (string, error) {
 	}
 	defer f.Close()
 
+	// The file opened is checked, as the path could be replaced in the
+	// meantime.
+	info, err := f.Stat()
+	if err != nil {
+		return "", wrap(err, "reading executable info")
+	}
+
+	var cache hashCache
+	if len(s.hashCache) != 0 {
+		cache, err = readHashCache(s.hashCache)
+		if err != nil {
+			s.logger.Warn("reading cached hashes", "err", err)
+		}
+		if hash, ok := cache.lookup(path, info); ok {
+			s.logger.Debug("using cached hash", "hash", hash)
+			return hash, nil
+		}
+	}
+
 	h := sha1.New()
 
 	_, err = io.Copy(h, f)
 	if err != nil {
 		return "", wrap(err, "hashing executable")
 	}
+	hash := hex.EncodeToString(h.Sum(nil))
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	if len(s.hashCache) != 0 {
+		cache.add(path, info, hash)
+		err = cache.write(s.hashCache)
+		if err != nil {
+			s.logger.Warn("caching hash", "err", err)
+		}
+	}
+
+	return hash, nil
 }
 
-func (s *service) lookupExecutable(hash string) (bool, error) {
-	res, err := http.Head(fmt.Sprintf("%s/executables/%s", s.dest, hash))
+// readBuildID returns the build-id of the executable, or an empty string if
+// it doesn't have one.
+func (s *service) readBuildID(path string) (string, error) {
+	file, err := elf.Open(path)
 	if err != nil {
-		return false, wrap(err, "executing request")
+		return "", wrap(err, "opening executable")
 	}
-	defer res.Body.Close()
+	defer file.Close()
+
+	return elfx.File{Path: path, File: file}.BuildID()
+}
 
-	raw, err := ioutil.ReadAll(res.Body)
+func (s *service) detectFormat(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return false, wrap(err, "reading response")
-	}
-
-	switch res.StatusCode {
-	case http.StatusOK:
-		return true, nil
-	case http.StatusNotFound:
-		return false, nil
-	default:
-		var err Error
-		jsonErr := json.Unmarshal(raw, &err)
-		if jsonErr != nil {
-			return false, wrap(jsonErr, "reading unexpected response")
-		}
-		return false, wrap(errors.New(err.Err), "unexpected response")
+		return "", wrap(err, "opening executable")
+	}
+	defer f.Close()
+
+	return DetectFormat(f)
+}
+
+// readPlatform returns the platform string the kernel gave to the process,
+// read from the core.
+func (s *service) readPlatform() (string, error) {
+	f, err := elf.Open(s.src)
+	if err != nil {
+		return "", wrap(err, "opening core")
+	}
+	defer f.Close()
+
+	notes, err := corenote.Read(f)
+	if err != nil {
+		return "", wrap(err, "reading notes")
 	}
+
+	return corenote.Platform(f, notes)
 }
 
-func (s *service) sendFile(w io.Writer, path string) error {
-	var err error
-	var f io.ReadCloser
-	if path == "-" {
-		f = os.Stdin
-	} else {
-		f, err = os.Open(path)
+// computeSize returns the total size of the files to send, or 0 if it isn't
+// known. The size of a core read from stdin or from an apport report can't be
+// known in advance.
+func (s *service) computeSize(core io.Reader, executable string, sendExecutable bool, links []Link) int64 {
+	if core != nil || s.src == "-" {
+		return 0
+	}
+
+	paths := []string{s.src}
+	if sendExecutable {
+		paths = append(paths, executable)
+		for _, link := range links {
+			if link.Sent() {
+				paths = append(paths, link.Path)
+			}
+		}
+	}
+
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
 		if err != nil {
-			return wrap(err, "opening file")
+			return 0
 		}
-		defer f.Close()
+		size += info.Size()
 	}
+	return size
+}
 
-	_, err = io.Copy(w, f)
-	if err != nil {
-		return wrap(err, "writing file")
+// contains reports whether the value is in the list.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// openFile opens the file at path, "-" being stdin.
+func openFile(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
 }
 
 // wrap an error using the provided message and arguments.
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/sanitizer"
+)
+
+// readReport reads the sanitizer report given on the command-line, along with
+// the executable it comes from, and returns the informations needed to send it.
+// The report is read at once, as it is small and may come from stdin, and is
+// sent in place of the coredump. It is dumped when it was written, or now if
+// it is read from stdin.
+func (s *service) readReport() (executable string, dumpedAt time.Time, core io.Reader, err error) {
+	if len(s.args) != 1 {
+		return "", time.Time{}, nil, fmt.Errorf("unexpected number of arguments on command-line: want 1, got %d", len(s.args))
+	}
+	executable = s.args[0]
+
+	dumpedAt = time.Now()
+	var r io.Reader = os.Stdin
+	if s.reportFile != "-" {
+		f, err := os.Open(s.reportFile)
+		if err != nil {
+			return "", time.Time{}, nil, wrap(err, "opening report")
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return "", time.Time{}, nil, wrap(err, "reading report info")
+		}
+		dumpedAt = info.ModTime()
+		r = f
+	}
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", time.Time{}, nil, wrap(err, "reading report")
+	}
+
+	// The server reads the report anyway, so it is only checked to warn
+	// about the files that don't look like one.
+	_, err = sanitizer.Parse(bytes.NewReader(raw))
+	if err != nil {
+		s.logger.Warn("parsing sanitizer report", "err", err)
+	}
+
+	return executable, dumpedAt, bytes.NewReader(raw), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+func TestService_ReadReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	report := filepath.Join(dir, "asan.log")
+	content := "==1234==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000014\n"
+	err = ioutil.WriteFile(report, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf(`writing report: %s`, err)
+	}
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = os.Chtimes(report, modTime, modTime)
+	if err != nil {
+		t.Fatalf(`changing modification time: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	for n, c := range map[string]struct {
+		service service
+		wantErr bool
+	}{
+		"report":         {service: service{reportFile: report, args: []string{"/usr/bin/a.out"}}},
+		"missing report": {service: service{reportFile: filepath.Join(dir, "missing.log"), args: []string{"/usr/bin/a.out"}}, wantErr: true},
+		"no executable":  {service: service{reportFile: report}, wantErr: true},
+		"too many args":  {service: service{reportFile: report, args: []string{"/usr/bin/a.out", "1600000000"}}, wantErr: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			c.service.logger = logger
+			executable, dumpedAt, core, err := c.service.readReport()
+			if (err != nil) != c.wantErr {
+				t.Fatalf(`wanted error %t, got %v`, c.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if executable != "/usr/bin/a.out" {
+				t.Errorf(`wanted executable /usr/bin/a.out, got %s`, executable)
+			}
+			if !dumpedAt.Equal(modTime) {
+				t.Errorf(`wanted dumped at %s, got %s`, modTime, dumpedAt)
+			}
+			raw, err := ioutil.ReadAll(core)
+			if err != nil {
+				t.Fatalf(`reading core: %s`, err)
+			}
+			if string(raw) != content {
+				t.Errorf(`wanted the report as core, got %q`, raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// tlsConfig returns the TLS configuration of the requests to the destination
+// host. The certificate of the host is verified against the CA certificates
+// of the caCert file if given, the ones of the system otherwise. The client
+// certificate is presented if given, and must come along its key.
+func tlsConfig(caCert, clientCert, clientKey string, insecure bool) (*tls.Config, error) {
+	if (len(clientCert) == 0) != (len(clientKey) == 0) {
+		return nil, errors.New("client-cert and client-key options must be given together")
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if len(caCert) != 0 {
+		raw, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, wrap(err, "reading CA certificate")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(raw) {
+			return nil, errors.New("reading CA certificate: no PEM certificate found")
+		}
+	}
+
+	if len(clientCert) != 0 {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, wrap(err, "loading client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// httpClient returns the HTTP client of the requests to the destination host,
+// nil for the default one if there is no TLS option.
+func (s *service) httpClient() (*http.Client, error) {
+	if len(s.caCert) == 0 && len(s.clientCert) == 0 && len(s.clientKey) == 0 && !s.insecureSkipVerify {
+		return nil, nil
+	}
+
+	config, err := tlsConfig(s.caCert, s.clientCert, s.clientKey, s.insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed client certificate and its key in
+// dir, and returns their paths.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`generating key: %s`, err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rcoredump"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(`creating certificate: %s`, err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf(`encoding key: %s`, err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	if err != nil {
+		t.Fatalf(`writing certificate: %s`, err)
+	}
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	if err != nil {
+		t.Fatalf(`writing key: %s`, err)
+	}
+	return certPath, keyPath
+}
+
+func TestService_HTTPClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredump")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// The server requires a client certificate, which isn't verified.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caCert := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatalf(`writing CA certificate: %s`, err)
+	}
+	clientCert, clientKey := writeCertificate(t, dir)
+
+	for n, c := range map[string]struct {
+		service service
+		wantErr bool
+	}{
+		"system CA":           {service: service{clientCert: clientCert, clientKey: clientKey}, wantErr: true},
+		"no client cert":      {service: service{caCert: caCert}, wantErr: true},
+		"CA and client cert":  {service: service{caCert: caCert, clientCert: clientCert, clientKey: clientKey}},
+		"insecure skip":       {service: service{clientCert: clientCert, clientKey: clientKey, insecureSkipVerify: true}},
+		"client cert only":    {service: service{caCert: caCert, clientCert: clientCert}, wantErr: true},
+		"CA without PEM data": {service: service{caCert: clientKey}, wantErr: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			client, err := c.service.httpClient()
+			if err == nil {
+				var res *http.Response
+				res, err = client.Get(srv.URL)
+				if err == nil {
+					res.Body.Close()
+				}
+			}
+			if (err != nil) != c.wantErr {
+				t.Errorf(`wanted error %t, got %v`, c.wantErr, err)
+			}
+		})
+	}
+
+	// Without TLS options, the default client is used.
+	client, err := (&service{}).httpClient()
+	if client != nil || err != nil {
+		t.Errorf(`httpClient(): wanted the default client, got %v, %v`, client, err)
+	}
+}
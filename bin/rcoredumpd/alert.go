@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webhookTimeout is the maximum duration of the delivery of an alert.
+const webhookTimeout = 10 * time.Second
+
+// alertRule is an AlertRule with its durations parsed.
+type alertRule struct {
+	AlertRule
+	window   time.Duration
+	cooldown time.Duration
+}
+
+// loadAlertRules reads the rules from the JSON file at path, holding a list of
+// AlertRule. The cooldown defaults to the window.
+func loadAlertRules(path string) ([]alertRule, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, wrap(err, `reading alerting rules`)
+	}
+
+	var list []AlertRule
+	err = json.Unmarshal(raw, &list)
+	if err != nil {
+		return nil, wrap(err, `decoding alerting rules`)
+	}
+
+	names := make(map[string]bool)
+	rules := make([]alertRule, 0, len(list))
+	for i, r := range list {
+		rule, err := parseAlertRule(r)
+		if err != nil {
+			return nil, wrap(err, `invalid rule %d`, i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf(`invalid rule %d: duplicate name %q`, i+1, rule.Name)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseAlertRule validates the rule and parses its durations.
+func parseAlertRule(r AlertRule) (rule alertRule, err error) {
+	rule.AlertRule = r
+	if len(r.Name) == 0 {
+		return rule, errors.New(`empty name`)
+	}
+	if len(r.Query) == 0 {
+		return rule, errors.New(`empty query`)
+	}
+	if r.Threshold == 0 {
+		return rule, errors.New(`threshold must be at least 1`)
+	}
+
+	rule.window, err = time.ParseDuration(r.Window)
+	if err != nil {
+		return rule, wrap(err, `parsing window`)
+	}
+	if rule.window <= 0 {
+		return rule, errors.New(`window must be positive`)
+	}
+
+	rule.cooldown = rule.window
+	if len(r.Cooldown) != 0 {
+		rule.cooldown, err = time.ParseDuration(r.Cooldown)
+		if err != nil {
+			return rule, wrap(err, `parsing cooldown`)
+		}
+	}
+	if rule.cooldown < 0 {
+		return rule, errors.New(`cooldown must not be negative`)
+	}
+
+	u, err := url.Parse(r.Webhook)
+	if err != nil {
+		return rule, wrap(err, `parsing webhook`)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rule, fmt.Errorf(`unsupported webhook scheme %q`, u.Scheme)
+	}
+	return rule, nil
+}
+
+// alertSet holds the alerting rules, and their state as of their last
+// evaluation.
+type alertSet struct {
+	sync.Mutex
+	rules  []alertRule
+	states []AlertState
+}
+
+// newAlertSet returns the set of the given rules, none of them being evaluated
+// yet.
+func newAlertSet(rules []alertRule) *alertSet {
+	a := &alertSet{
+		rules:  rules,
+		states: make([]AlertState, len(rules)),
+	}
+	for i, r := range rules {
+		a.states[i].AlertRule = r.AlertRule
+	}
+	return a
+}
+
+// States returns a copy of the states of the rules.
+func (a *alertSet) States() []AlertState {
+	a.Lock()
+	defer a.Unlock()
+
+	states := make([]AlertState, len(a.states))
+	copy(states, a.states)
+	return states
+}
+
+// evaluateAlerts evaluates the alerting rules periodically, until the context
+// is closed.
+func (s *service) evaluateAlerts(ctx context.Context) {
+	t := time.NewTicker(s.alertInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for i := range s.alerts.rules {
+				s.evaluateAlert(ctx, i, now)
+			}
+		}
+	}
+}
+
+// evaluateAlert counts the cores matching the i-th rule over its window, and
+// sends an alert if the threshold is reached. While it stays reached, the
+// alert is sent again once the cooldown expired. An alert failing to be sent
+// is retried on the next evaluation.
+func (s *service) evaluateAlert(ctx context.Context, i int, now time.Time) {
+	rule := s.alerts.rules[i]
+	count, err := s.index.CountRange(rule.Query, now.Add(-rule.window), time.Time{})
+
+	s.alerts.Lock()
+	state := s.alerts.states[i]
+	s.alerts.Unlock()
+
+	state.EvaluatedAt = now
+	state.Error = ""
+	if err != nil {
+		s.logger.Error("evaluating alerting rule", "rule", rule.Name, "err", err)
+		state.Error = err.Error()
+	} else {
+		state.Count = count
+		state.Firing = count >= rule.Threshold
+	}
+
+	if err == nil && state.Firing && (state.FiredAt.IsZero() || now.Sub(state.FiredAt) >= rule.cooldown) {
+		err = s.sendAlert(ctx, rule, Alert{
+			Rule:      rule.Name,
+			Query:     rule.Query,
+			Threshold: rule.Threshold,
+			Window:    rule.Window,
+			Count:     count,
+			FiredAt:   now,
+		})
+		if err != nil {
+			s.logger.Error("sending alert", "rule", rule.Name, "err", err)
+			s.alerted.With(prometheus.Labels{"rule": rule.Name, "status": "failed"}).Inc()
+			state.Error = err.Error()
+		} else {
+			s.logger.Info("alert sent", "rule", rule.Name, "count", count)
+			s.alerted.With(prometheus.Labels{"rule": rule.Name, "status": "sent"}).Inc()
+			state.FiredAt = now
+		}
+	}
+
+	s.alerts.Lock()
+	s.alerts.states[i] = state
+	s.alerts.Unlock()
+}
+
+// sendAlert posts the alert as JSON to the webhook of the rule.
+func (s *service) sendAlert(ctx context.Context, rule alertRule, alert Alert) error {
+	raw, err := json.Marshal(alert)
+	if err != nil {
+		return wrap(err, `encoding alert`)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.Webhook, bytes.NewReader(raw))
+	if err != nil {
+		return wrap(err, `creating request`)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return wrap(err, `sending request`)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf(`unexpected status %s`, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	valid := AlertRule{Name: "crashes", Query: "executable:server", Threshold: 10, Window: "5m", Webhook: "http://localhost/alerts"}
+
+	rule, err := parseAlertRule(valid)
+	if err != nil {
+		t.Fatalf(`parseAlertRule(): unexpected error: %s`, err)
+	}
+	if rule.window != 5*time.Minute || rule.cooldown != 5*time.Minute {
+		t.Errorf(`parseAlertRule(): wanted a window and cooldown of 5m, got %s and %s`, rule.window, rule.cooldown)
+	}
+
+	for n, modify := range map[string]func(r *AlertRule){
+		"empty name":       func(r *AlertRule) { r.Name = "" },
+		"empty query":      func(r *AlertRule) { r.Query = "" },
+		"zero threshold":   func(r *AlertRule) { r.Threshold = 0 },
+		"invalid window":   func(r *AlertRule) { r.Window = "5" },
+		"negative window":  func(r *AlertRule) { r.Window = "-5m" },
+		"invalid cooldown": func(r *AlertRule) { r.Cooldown = "1 hour" },
+		"invalid webhook":  func(r *AlertRule) { r.Webhook = "localhost/alerts" },
+	} {
+		r := valid
+		modify(&r)
+		_, err := parseAlertRule(r)
+		if err == nil {
+			t.Errorf(`parseAlertRule(): wanted an error for %s`, n)
+		}
+	}
+}
+
+func TestService_EvaluateAlert(t *testing.T) {
+	alerts := make(chan Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		_ = json.NewDecoder(r.Body).Decode(&a)
+		alerts <- a
+	}))
+	defer server.Close()
+
+	rule, err := parseAlertRule(AlertRule{
+		Name:      "crashes",
+		Query:     "hostname:alpha",
+		Threshold: 2,
+		Window:    "5m",
+		Cooldown:  "1h",
+		Webhook:   server.URL,
+	})
+	if err != nil {
+		t.Fatalf(`parseAlertRule(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:  newTestIndex(t),
+		logger: logger,
+		alerts: newAlertSet([]alertRule{rule}),
+		alerted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcoredumpd_alerts_total",
+		}, []string{"rule", "status"}),
+	}
+
+	now := time.Now()
+	for _, c := range []Coredump{
+		{UID: "old", Hostname: "alpha", DumpedAt: now.Add(-10 * time.Minute)},
+		{UID: "first", Hostname: "alpha", DumpedAt: now.Add(-2 * time.Minute)},
+		{UID: "other", Hostname: "beta", DumpedAt: now.Add(-time.Minute)},
+	} {
+		err := s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	// The old core is out of the window, so the threshold isn't reached.
+	s.evaluateAlert(context.Background(), 0, now)
+	state := s.alerts.States()[0]
+	if state.Count != 1 || state.Firing || !state.FiredAt.IsZero() {
+		t.Errorf(`evaluateAlert(): wanted a count of 1 without alert, got %#v`, state)
+	}
+
+	err = s.index.Index(Coredump{UID: "second", Hostname: "alpha", DumpedAt: now})
+	if err != nil {
+		t.Fatalf(`indexing second: %s`, err)
+	}
+
+	s.evaluateAlert(context.Background(), 0, now)
+	state = s.alerts.States()[0]
+	if state.Count != 2 || !state.Firing || !state.FiredAt.Equal(now) {
+		t.Errorf(`evaluateAlert(): wanted a count of 2 with an alert, got %#v`, state)
+	}
+	select {
+	case a := <-alerts:
+		if a.Rule != "crashes" || a.Count != 2 {
+			t.Errorf(`evaluateAlert(): unexpected alert %#v`, a)
+		}
+	default:
+		t.Errorf(`evaluateAlert(): wanted an alert to be sent`)
+	}
+
+	// The alert isn't sent again during the cooldown.
+	s.evaluateAlert(context.Background(), 0, now.Add(time.Minute))
+	select {
+	case a := <-alerts:
+		t.Errorf(`evaluateAlert(): unexpected alert %#v during the cooldown`, a)
+	default:
+	}
+}
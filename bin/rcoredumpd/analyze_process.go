@@ -1,48 +1,333 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"debug/elf"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/elwinar/rcoredump/pkg/buildinfo"
+	"github.com/elwinar/rcoredump/pkg/corenote"
+	"github.com/elwinar/rcoredump/pkg/debuginfod"
+	"github.com/elwinar/rcoredump/pkg/demangle"
+	"github.com/elwinar/rcoredump/pkg/elfx"
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+	"github.com/elwinar/rcoredump/pkg/sanitizer"
 	"github.com/inconshreveable/log15"
 )
 
+// commandFile returns the content of the command file given to the debugger,
+// made of the prelude then the commands of the analyzer, each on its own line,
+// and a single quit command. The commands of the analyzer are split by
+// splitCommands. Quit commands are removed, as they would stop the analysis
+// early.
+func commandFile(analyzer string, prelude ...string) []byte {
+	var buf bytes.Buffer
+	for _, command := range prelude {
+		buf.WriteString(command + "\n")
+	}
+	for _, command := range splitCommands(analyzer) {
+		switch command {
+		case "q", "quit", "exit":
+			continue
+		}
+		buf.WriteString(command + "\n")
+	}
+	buf.WriteString("q\n")
+	return buf.Bytes()
+}
+
+// splitCommands splits the commands given to a debugger, separated by newlines
+// or semicolons, the latter allowing several commands in a configuration file.
+// A semicolon inside a quoted string (e.g: print "a;b"), or escaped by a
+// backslash (e.g: echo a\;b), is part of the command. The commands are
+// trimmed, and the empty ones skipped.
+func splitCommands(commands string) []string {
+	var split []string
+	var command strings.Builder
+	flush := func() {
+		if c := strings.TrimSpace(command.String()); len(c) != 0 {
+			split = append(split, c)
+		}
+		command.Reset()
+	}
+
+	var quote rune
+	var escaped bool
+	for _, r := range commands {
+		switch {
+		case r == '\n':
+			// The debuggers read their commands by lines, so a
+			// newline always ends the command.
+			quote, escaped = 0, false
+			flush()
+			continue
+		case escaped:
+			escaped = false
+			if quote == 0 && r == ';' {
+				command.WriteRune(r)
+				continue
+			}
+			command.WriteRune('\\')
+		case r == '\\':
+			escaped = true
+			continue
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ';':
+			flush()
+			continue
+		}
+		command.WriteRune(r)
+	}
+	if escaped {
+		command.WriteRune('\\')
+	}
+	flush()
+	return split
+}
+
+// artifact is a named output of the debugger, stored along the core, made of
+// the output of its commands.
+type artifact struct {
+	name     string
+	commands string
+}
+
+// artifactName matches the valid names of artifacts, which are file names.
+var artifactName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseArtifacts parses a comma-separated list of artifacts, each being a name
+// and the commands producing it, separated by semicolons as for the analyzers
+// (e.g: "registers=info all-registers,libraries=info sharedlibrary").
+func parseArtifacts(raw string) ([]artifact, error) {
+	var artifacts []artifact
+	names := make(map[string]bool)
+	for _, def := range strings.Split(raw, ",") {
+		def = strings.TrimSpace(def)
+		if len(def) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(def, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf(`invalid artifact %q: expected name=commands`, def)
+		}
+		name := strings.TrimSpace(parts[0])
+		if !artifactName.MatchString(name) {
+			return nil, fmt.Errorf(`invalid artifact name %q`, name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf(`duplicate artifact %q`, name)
+		}
+		names[name] = true
+		artifacts = append(artifacts, artifact{name: name, commands: parts[1]})
+	}
+	return artifacts, nil
+}
+
+// analyzerLimits are the limits of the resources used by the analyzer, so a
+// huge core doesn't starve the server. Zero values mean no limit.
+type analyzerLimits struct {
+	// nice value of the analyzer process.
+	nice int
+	// memoryMax is the maximum size of the address space of the analyzer,
+	// in bytes.
+	memoryMax uint64
+}
+
+// errMissingExecutable is returned when the executable of the core isn't
+// stored, nor could be fetched. See service.awaitExecutable.
+var errMissingExecutable = errors.New("missing executable")
+
+// errAnalyzerOutOfMemory is returned when the analyzer fails because of its
+// memory limit.
+var errAnalyzerOutOfMemory = errors.New("analyzer ran out of memory")
+
+// outOfMemoryRegexp matches the errors printed by gdb and delve when an
+// allocation fails.
+var outOfMemoryRegexp = regexp.MustCompile(`(?i)virtual memory exhausted|out of memory|cannot allocate memory`)
+
+// outOfMemory reports whether the analyzer failed because it ran out of
+// memory, given its error and output.
+func outOfMemory(err error, out []byte) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+		return true
+	}
+	return outOfMemoryRegexp.Match(out)
+}
+
 type analyzeProcess struct {
-	dataDir string
-	index   Index
-	log     log15.Logger
-	store   Store
-	core    Coredump
+	dataDir    string
+	index      Index
+	log        log15.Logger
+	store      Store
+	core       Coredump
+	maxSymbols int
+	// demangle enables the built-in demangler. See demangleTrace.
+	demangle bool
+	// maxTraceSize is the size above which the trace is stored apart, and
+	// only an excerpt is indexed. Zero means no limit.
+	maxTraceSize int64
+	// limits of the analyzer.
+	limits analyzerLimits
+	// debuginfod is used to fetch the executables that weren't sent, and
+	// by the debuggers to fetch the debug files. Disabled if it has no
+	// URLs.
+	debuginfod debuginfod.Client
+	// artifacts to extract, by language.
+	artifacts map[string][]artifact
+	// timeout of the debugger, zero meaning no timeout.
+	timeout time.Duration
+	// base is the core as indexed when the analysis started. See
+	// indexResults.
+	base Coredump
+	// partial is set for the partial analyses, which don't need the
+	// executable. See service.analyzePartially.
+	partial bool
+	// fallback is the error after which the analysis fell back to the
+	// notes of the core, nil if it didn't.
+	fallback error
+	// startedAt is the time the analysis started, recorded as an event
+	// along its outcome.
+	startedAt time.Time
 
 	err        error
 	file       *os.File
 	executable *os.File
 }
 
+// run the given steps of the analysis and index the results. The steps are
+// methods of the process, and are run in order.
+func (p *analyzeProcess) run(steps ...func()) {
+	p.startedAt = time.Now()
+	p.init()
+	for _, step := range steps {
+		step()
+	}
+	p.indexResults()
+	p.cleanup()
+}
+
 // init the process by finding the index core and the associated files.
 func (p *analyzeProcess) init() {
 	if p.err != nil {
 		return
 	}
 
+	// The core is read again as it may have been updated since it was
+	// queued.
 	var err error
+	p.core, err = p.index.Find(p.core.UID)
+	if err != nil {
+		p.err = wrap(err, `finding indexed document`)
+		return
+	}
+	p.base = p.core
+
 	p.executable, err = p.store.Executable(p.core.ExecutableHash)
+	if errors.Is(err, os.ErrNotExist) && len(p.debuginfod.URLs) != 0 && len(p.core.ExecutableBuildID) != 0 {
+		p.executable, err = p.fetchExecutable()
+	}
+	if err != nil && p.partial {
+		// The executable may very well be why the analysis failed.
+		p.log.Debug("analyzing partially without executable", "err", err)
+		p.executable, err = nil, nil
+	}
+	if err != nil && p.core.Format == CoreFormatSanitizer {
+		// The sanitizer reports are read without the executable.
+		p.log.Debug("reading sanitizer report without executable", "err", err)
+		p.executable, err = nil, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		p.err = fmt.Errorf(`%w: %s`, errMissingExecutable, err)
+		return
+	}
 	if err != nil {
-		p.err = wrap(err, `opening core file`)
+		p.err = wrap(err, `opening executable file`)
 		return
 	}
 
+	// The size of an executable stored after the core was indexed isn't
+	// known yet.
+	if p.executable != nil && p.core.ExecutableSize == 0 {
+		info, err := p.executable.Stat()
+		if err != nil {
+			p.err = wrap(err, `getting executable size`)
+			return
+		}
+		p.core.ExecutableSize = info.Size()
+	}
+
 	p.file, err = p.store.Core(p.core.UID)
 	if err != nil {
-		p.err = wrap(err, `opening executable file`)
+		p.err = wrap(err, `opening core file`)
 	}
 }
 
+// fetchExecutable fetches the executable from debuginfod by its build-id, and
+// stores it for the next analyses. The executable is checked to be the one the
+// core was sent for.
+func (p *analyzeProcess) fetchExecutable() (*os.File, error) {
+	p.log.Debug("fetching executable", "build_id", p.core.ExecutableBuildID)
+	src, err := p.debuginfod.Fetch(p.core.ExecutableBuildID, debuginfod.KindExecutable)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// The executable is downloaded to a temporary file first, so it isn't
+	// stored if it doesn't match.
+	tmp, err := ioutil.TempFile(p.dataDir, "debuginfod-")
+	if err != nil {
+		return nil, wrap(err, `creating temporary file`)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return nil, wrap(err, `downloading executable`)
+	}
+	if hash := hex.EncodeToString(h.Sum(nil)); hash != p.core.ExecutableHash {
+		return nil, fmt.Errorf(`fetched executable has hash %s, expected %s`, hash, p.core.ExecutableHash)
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, wrap(err, `rewinding executable`)
+	}
+	p.core.ExecutableSize, err = p.store.StoreExecutable(p.core.ExecutableHash, tmp)
+	if err != nil {
+		return nil, wrap(err, `storing executable`)
+	}
+
+	return p.store.Executable(p.core.ExecutableHash)
+}
+
 func (p *analyzeProcess) cleanup() {
 	if p.executable != nil {
 		p.executable.Close()
@@ -53,15 +338,52 @@ func (p *analyzeProcess) cleanup() {
 	}
 }
 
+// detectFormat checks the format of the executable, as only ELF executables
+// can be analyzed. The format is sent by the forwarder, but older ones don't,
+// in which case it is detected from the stored executable. Unsupported
+// executables are recorded as such instead of failing, so they aren't analyzed
+// again and again.
+func (p *analyzeProcess) detectFormat() {
+	if p.err != nil {
+		return
+	}
+
+	if len(p.core.ExecutableFormat) == 0 {
+		format, err := DetectFormat(p.executable)
+		if err != nil {
+			p.err = wrap(err, `detecting executable format`)
+			return
+		}
+		p.core.ExecutableFormat = format
+
+		_, err = p.executable.Seek(0, io.SeekStart)
+		if err != nil {
+			p.err = wrap(err, `rewinding executable file`)
+			return
+		}
+	}
+
+	if p.core.ExecutableFormat != FormatELF {
+		p.core.AnalysisError = fmt.Sprintf(`unsupported executable format %q`, p.core.ExecutableFormat)
+		p.log.Warn("unsupported executable format", "format", p.core.ExecutableFormat)
+	}
+}
+
+// supported reports whether the executable can be analyzed.
+func (p *analyzeProcess) supported() bool {
+	return p.core.ExecutableFormat == FormatELF
+}
+
 // detectLanguage looks at an executable file's sections to guess which
 // language did generate the executable.
 //
 // Note: the feature is rough, and probably simplist. I don't really care for
-// now, because we only want to distinguish C from Go, and this is enough for
-// this (Go's routines makes stack traces a little different). This could
-// change any moment when we need something more complex.
+// now, because we only want to distinguish C, C++ and Go, and this is enough
+// for this (Go's routines makes stack traces a little different, and C++
+// symbols must be demangled). This could change any moment when we need
+// something more complex.
 func (p *analyzeProcess) detectLanguage() {
-	if p.err != nil {
+	if p.err != nil || !p.supported() {
 		return
 	}
 
@@ -81,50 +403,630 @@ func (p *analyzeProcess) detectLanguage() {
 			break
 		}
 	}
+	if p.core.Lang == LangC && isCPP(file) {
+		p.core.Lang = LangCPP
+	}
 	p.log.Debug("detected language", "lang", p.core.Lang)
 }
 
+// isCPP reports whether the executable was written in C++, i.e if it depends
+// on a C++ standard library, or defines the personality routine used by C++
+// exceptions, which is the case of the static ones.
+func isCPP(file *elf.File) bool {
+	libraries, _ := file.ImportedLibraries()
+	for _, library := range libraries {
+		if strings.HasPrefix(library, "libstdc++.so") || strings.HasPrefix(library, "libc++.so") {
+			return true
+		}
+	}
+
+	for _, load := range []func() ([]elf.Symbol, error){file.DynamicSymbols, file.Symbols} {
+		symbols, _ := load()
+		for _, symbol := range symbols {
+			if symbol.Name == "__gxx_personality_v0" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// readGoBuildInfo reads the version of Go and the main module the Go
+// executables were built with. Those aren't required for the rest of the
+// analysis, so failing to read them isn't an error.
+func (p *analyzeProcess) readGoBuildInfo() {
+	if p.err != nil || p.core.Lang != LangGo {
+		return
+	}
+
+	file, err := elf.NewFile(p.executable)
+	if err != nil {
+		p.err = wrap(err, `opening executable file`)
+		return
+	}
+	defer file.Close()
+
+	info, err := buildinfo.Read(file)
+	if err != nil {
+		p.log.Warn("reading Go build information", "err", err)
+		return
+	}
+	p.core.GoVersion = info.GoVersion
+	p.core.GoModule = info.Module
+	p.core.GoModuleVersion = info.ModuleVersion
+	p.log.Debug("read Go build information", "go_version", p.core.GoVersion, "go_module", p.core.GoModule)
+}
+
+// readCBuildInfo reads the compilers the C and C++ executables were built with
+// and the ABI they require, which gives about as much context as the build
+// information of the Go executables. Those aren't always present, and aren't
+// required for the rest of the analysis.
+func (p *analyzeProcess) readCBuildInfo() {
+	if p.err != nil || (p.core.Lang != LangC && p.core.Lang != LangCPP) {
+		return
+	}
+
+	file, err := elf.NewFile(p.executable)
+	if err != nil {
+		p.err = wrap(err, `opening executable file`)
+		return
+	}
+	defer file.Close()
+
+	f := elfx.File{Path: p.executable.Name(), File: file}
+	p.core.Compilers, err = f.Comments()
+	if err != nil {
+		p.log.Warn("reading compilers", "err", err)
+	}
+	p.core.ABI, err = f.ABITag()
+	if err != nil {
+		p.log.Warn("reading ABI tag", "err", err)
+	}
+	p.log.Debug("read build information", "compilers", p.core.Compilers, "abi", p.core.ABI)
+}
+
+// readNotes reads the notes of the core, which tell the signal that killed
+// the process and its command line. Cores that can't be read are left without
+// them, as they aren't required for the rest of the analysis.
+func (p *analyzeProcess) readNotes() {
+	if p.err != nil {
+		return
+	}
+
+	file, err := elf.NewFile(p.file)
+	if err != nil {
+		p.log.Debug("core isn't an ELF file, skipping notes", "err", err)
+		return
+	}
+	defer file.Close()
+
+	notes, err := corenote.Read(file)
+	if err != nil {
+		p.log.Warn("reading notes", "err", err)
+		return
+	}
+
+	summary := corenote.Summarize(notes)
+	p.core.Signal = summary.Signal
+	p.core.SignalName = signalName(file.Machine, summary.Signal)
+	p.core.Command = summary.Command
+	p.core.Args = summary.Args
+	p.core.MappedFiles = summary.Files
+	p.log.Debug("read notes", "signal", p.core.SignalName, "command", p.core.Command)
+
+	// The registers don't need the symbols, so they are useful even when
+	// the trace isn't.
+	registers, err := corenote.Registers(file.Machine, file.ByteOrder, summary.Registers)
+	if err != nil {
+		p.log.Debug("decoding registers", "err", err)
+		return
+	}
+	p.core.Registers = registers
+}
+
+// findMissingLibraries compares the shared libraries mapped by the process to
+// the ones stored alongside the executable. The mapped files are the ones
+// actually loaded, including the libraries opened with dlopen, so they tell
+// what the debugger will lack better than the dependencies of the executable.
+func (p *analyzeProcess) findMissingLibraries() {
+	if p.err != nil {
+		return
+	}
+
+	p.core.MissingLibraries = nil
+	for _, path := range p.core.MappedFiles {
+		if !isLibrary(path) {
+			continue
+		}
+
+		f, err := p.store.Link(p.core.ExecutableHash, Link{Path: path})
+		if err == nil {
+			f.Close()
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			p.err = wrap(err, `looking up library %s`, path)
+			return
+		}
+		p.core.MissingLibraries = append(p.core.MissingLibraries, path)
+	}
+	if len(p.core.MissingLibraries) != 0 {
+		p.log.Debug("missing libraries", "libraries", p.core.MissingLibraries)
+	}
+}
+
+// libraryRegexp matches the names of the shared libraries, with an optional
+// version (e.g: libc.so.6).
+var libraryRegexp = regexp.MustCompile(`\.so(\.[0-9]+)*$`)
+
+// isLibrary reports whether the mapped file is a shared library, as opposed
+// to the executable or a data file.
+func isLibrary(path string) bool {
+	return libraryRegexp.MatchString(path)
+}
+
+// classifyExecutable looks at the executable to find out how it was linked,
+// which tells what the analysis will need beside the executable itself.
+func (p *analyzeProcess) classifyExecutable() {
+	if p.err != nil || !p.supported() {
+		return
+	}
+
+	file, err := elf.NewFile(p.executable)
+	if err != nil {
+		p.err = wrap(err, `opening executable file`)
+		return
+	}
+	defer file.Close()
+
+	static, pie := elfx.File{Path: p.executable.Name(), File: file}.Type()
+	switch {
+	case static && pie:
+		p.core.ExecutableType = ExecutableTypeStaticPIE
+	case static:
+		p.core.ExecutableType = ExecutableTypeStatic
+	case pie:
+		p.core.ExecutableType = ExecutableTypePIE
+	default:
+		p.core.ExecutableType = ExecutableTypeDynamic
+	}
+	p.log.Debug("classified executable", "type", p.core.ExecutableType)
+}
+
+// extractSymbols lists the symbols exported by the executable, so we can
+// search for the executables defining a given function. The list is capped to
+// avoid bloating the index with huge libraries.
+func (p *analyzeProcess) extractSymbols() {
+	if p.err != nil || !p.supported() || p.maxSymbols == 0 {
+		return
+	}
+
+	file, err := elf.NewFile(p.executable)
+	if err != nil {
+		p.err = wrap(err, `opening executable file`)
+		return
+	}
+	defer file.Close()
+
+	p.log.Debug("extracting symbols")
+	symbols, err := elfx.File{Path: p.executable.Name(), File: file}.ExportedSymbols()
+	if err != nil {
+		p.err = wrap(err, `extracting symbols`)
+		return
+	}
+
+	if len(symbols) > p.maxSymbols {
+		p.log.Debug("truncating symbols", "count", len(symbols), "max", p.maxSymbols)
+		symbols = symbols[:p.maxSymbols]
+	}
+	p.core.Symbols = symbols
+	p.log.Debug("extracted symbols", "count", len(symbols))
+}
+
 // extractStackTrace shell out to configuration-defined command to delegate the
 // task of extracting the stack trace itself and any information judged
 // interesting to index.
 func (p *analyzeProcess) extractStackTrace() {
-	if p.err != nil {
+	if p.err != nil || !p.supported() {
 		return
 	}
 
-	var cmd string
-	switch p.core.Lang {
+	// The resources used by a previous analysis are replaced.
+	p.core.AnalyzerCPU, p.core.AnalyzerMaxRSS = 0, 0
+	out, err := p.runDebugger(filepath.Join(p.dataDir, debuggerCommandFile(p.core.Lang, "")))
+	if err != nil {
+		// The notes of the core are already read, and are still useful
+		// without the trace.
+		p.log.Warn("extracting stack trace, falling back to the notes", "err", err)
+		p.fallback = wrap(err, "extracting stack trace")
+		return
+	}
+
+	p.core.AnalysisPath = AnalysisPathDebugger
+	p.core.AnalysisError = ""
+	p.core.Trace = p.demangleTrace(string(out))
+	if p.core.Lang == LangGo {
+		p.core.Trace = crashingGoroutineFirst(p.core.Trace)
+	}
+	p.core.TraceTruncated = false
+	p.core.Functions = traceFunctions(p.core.Trace)
+	p.log.Debug("extracted stack trace", "functions", len(p.core.Functions))
+}
+
+// debuggerCommandFile returns the name of the command file of the debugger for
+// the language, in the data directory, or the one of the given artifact.
+func debuggerCommandFile(lang, artifact string) string {
+	var name string
+	switch lang {
 	case LangC:
-		cmd = fmt.Sprintf("gdb --nx --command %s/gdb.cmd --batch %s %s", p.dataDir, p.executable.Name(), p.file.Name())
+		name = "gdb"
+	case LangCPP:
+		name = "gdb-cpp"
 	case LangGo:
-		cmd = fmt.Sprintf("dlv core %s %s --init %s/delve.cmd", p.executable.Name(), p.file.Name(), p.dataDir)
+		name = "delve"
+	}
+	if len(artifact) != 0 {
+		name += "-artifact-" + artifact
+	}
+	return name + ".cmd"
+}
+
+// runDebugger runs the debugger of the core with the command file at the given
+// path, and returns its output. The resources used by the debugger are added
+// to the ones of the core. If the process has a timeout, the debugger is
+// killed once it expires.
+func (p *analyzeProcess) runDebugger(command string) ([]byte, error) {
+	sysroot, hasSysroot, err := p.store.Sysroot(p.core.ExecutableHash)
+	if err != nil {
+		return nil, wrap(err, "looking up sysroot")
+	}
+
+	var args []string
+	switch p.core.Lang {
+	case LangC, LangCPP:
+		args = []string{"gdb", "--nx", "--command", command, "--batch"}
+		// Use the libraries sent by the forwarder instead of the
+		// local ones, as they are the ones the process was using.
+		if hasSysroot {
+			args = append(args, "-iex", "set sysroot "+sysroot)
+		}
+		// Fetch the debug files missing from the executable and its
+		// libraries.
+		if len(p.debuginfod.URLs) != 0 {
+			args = append(args, "-iex", "set debuginfod enabled on")
+		}
+		args = append(args, p.executable.Name(), p.file.Name())
+	case LangGo:
+		args = []string{"dlv", "core", p.executable.Name(), p.file.Name(), "--init", command}
 	default:
-		p.err = wrap(fmt.Errorf(`unhandled lang %s`, p.core.Lang), "extracting stack trace")
+		return nil, fmt.Errorf(`unhandled lang %s`, p.core.Lang)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if len(p.debuginfod.URLs) != 0 {
+		cmd.Env = append(os.Environ(), "DEBUGINFOD_URLS="+strings.Join(p.debuginfod.URLs, " "))
+	}
+	err = startAnalyzer(cmd, p.limits)
+	if err != nil {
+		return nil, wrap(err, "starting analyzer")
+	}
+	var timedOut int32
+	if p.timeout != 0 {
+		timer := time.AfterFunc(p.timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			_ = cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+	err = cmd.Wait()
+	maxRSS, cpu := analyzerUsage(cmd.ProcessState)
+	if maxRSS > p.core.AnalyzerMaxRSS {
+		p.core.AnalyzerMaxRSS = maxRSS
+	}
+	p.core.AnalyzerCPU += cpu.Seconds()
+	// The timeout is checked first, as the analyzer killed by the timer
+	// would pass for one killed for its memory usage.
+	if err != nil && atomic.LoadInt32(&timedOut) != 0 {
+		return nil, fmt.Errorf(`analyzer timed out after %s`, p.timeout)
+	}
+	if err != nil && p.limits.memoryMax != 0 && outOfMemory(err, out.Bytes()) {
+		return nil, fmt.Errorf(`%w, limited to %s`, errAnalyzerOutOfMemory, datasize.ByteSize(p.limits.memoryMax).HR())
+	}
+	if err != nil {
+		return nil, wrap(err, "%s", out.String())
+	}
+
+	return out.Bytes(), nil
+}
+
+// extractArtifacts runs the debugger with the commands of each artifact
+// configured for the language, and stores their outputs. The artifacts of a
+// previous analysis are replaced. An artifact failing doesn't fail the
+// analysis, the stack trace being the important part.
+func (p *analyzeProcess) extractArtifacts() {
+	if p.err != nil || p.fallback != nil || !p.supported() {
 		return
 	}
 
-	chunks := strings.Split(cmd, " ")
-	out, err := exec.Command(chunks[0], chunks[1:]...).CombinedOutput()
+	err := p.store.DeleteArtifacts(p.core.UID)
 	if err != nil {
-		p.err = wrap(err, "extracting stack trace: %s", string(out))
+		p.err = wrap(err, "removing previous artifacts")
 		return
 	}
 
-	p.core.Trace = string(out)
-	p.log.Debug("extracted stack trace")
+	for _, a := range p.artifacts[p.core.Lang] {
+		out, err := p.runDebugger(filepath.Join(p.dataDir, debuggerCommandFile(p.core.Lang, a.name)))
+		if err != nil {
+			p.log.Warn("extracting artifact", "artifact", a.name, "err", err)
+			continue
+		}
+
+		_, err = p.store.StoreArtifact(p.core.UID, a.name, bytes.NewReader(out))
+		if err != nil {
+			p.err = wrap(err, "storing artifact %s", a.name)
+			return
+		}
+	}
+	p.log.Debug("extracted artifacts", "count", len(p.artifacts[p.core.Lang]))
 }
 
-func (p *analyzeProcess) indexResults() {
+// goroutineHeader matches the first line of the goroutines listed by delve's
+// goroutines command, the current one (i.e the one that crashed) being marked
+// by a star (e.g: "* Goroutine 1 - User: ./main.go:9 main.main (0x49a0f5)").
+// The frames printed with the -t option follow, indented by a tab.
+var goroutineHeader = regexp.MustCompile(`^[* ] Goroutine \d+ - `)
+
+// crashingGoroutineFirst moves the crashing goroutine at the beginning of the
+// goroutines listed in the trace, so it is the first one read, and its
+// functions are the first indexed.
+func crashingGoroutineFirst(trace string) string {
+	lines := strings.SplitAfter(trace, "\n")
+
+	var buf strings.Builder
+	for i := 0; i < len(lines); {
+		if !goroutineHeader.MatchString(lines[i]) {
+			buf.WriteString(lines[i])
+			i++
+			continue
+		}
+
+		// Split the listing by goroutine, each with its frames.
+		var goroutines []string
+		crashing := -1
+		for i < len(lines) && goroutineHeader.MatchString(lines[i]) {
+			if lines[i][0] == '*' {
+				crashing = len(goroutines)
+			}
+			goroutine := lines[i]
+			for i++; i < len(lines) && strings.HasPrefix(lines[i], "\t"); i++ {
+				goroutine += lines[i]
+			}
+			goroutines = append(goroutines, goroutine)
+		}
+
+		if crashing > 0 {
+			buf.WriteString(goroutines[crashing])
+			goroutines = append(goroutines[:crashing], goroutines[crashing+1:]...)
+		}
+		for _, goroutine := range goroutines {
+			buf.WriteString(goroutine)
+		}
+	}
+	return buf.String()
+}
+
+// traceFrame matches the frames of the traces output by gdb (e.g: "#1
+// 0x00005555555551a4 in main () at main.c:12") and delve (e.g: " 1
+// 0x000000000045a8e1 in main.main"), capturing the rest of the line starting
+// with the function's name. The address is mandatory for delve, so the source
+// lines printed by gdb (e.g: "12\t  crash(NULL);") aren't mistaken for frames.
+var traceFrame = regexp.MustCompile(`(?m)^(?:#\d+\s+(?:0x[0-9a-fA-F]+ in )?|\s*\d+\s+0x[0-9a-fA-F]+ in )(.*)$`)
+
+// traceFunctions returns the names of the functions of the trace's frames, in
+// order and without duplicates. They are indexed apart from the trace, so they
+// stay searchable when the trace is truncated.
+func traceFunctions(trace string) []string {
+	var functions []string
+	seen := make(map[string]bool)
+	for _, match := range traceFrame.FindAllStringSubmatch(trace, -1) {
+		function := frameFunction(match[1])
+		if len(function) == 0 || seen[function] {
+			continue
+		}
+		seen[function] = true
+		functions = append(functions, function)
+	}
+	return functions
+}
+
+// frameFunction returns the function's name at the beginning of the frame,
+// which ends with the arguments or a space. C++ templates arguments can
+// contain both, so they are skipped.
+func frameFunction(frame string) string {
+	depth := 0
+	for i, c := range frame {
+		switch c {
+		case '<':
+			depth++
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case '(', ' ', '\t', '\r':
+			if depth == 0 {
+				return frame[:i]
+			}
+		}
+	}
+	return frame
+}
+
+// demangleTrace demangles the C++ symbols gdb left mangled in the trace (e.g:
+// the ones of the frames without debugging information). By default, it relies
+// on c++filt, which is shipped alongside gdb by most distributions, for the C++
+// traces only, and the trace is returned as is if it isn't available. The
+// built-in demangler doesn't need c++filt, and is also used for the C traces,
+// as C programs can load C++ libraries.
+func (p *analyzeProcess) demangleTrace(trace string) string {
+	switch {
+	case p.demangle && (p.core.Lang == LangC || p.core.Lang == LangCPP):
+		return demangle.Text(trace)
+	case p.core.Lang == LangCPP:
+		return p.cppfilt(trace)
+	default:
+		return trace
+	}
+}
+
+// cppfilt demangles the trace using c++filt, if available.
+func (p *analyzeProcess) cppfilt(trace string) string {
+	path, err := exec.LookPath("c++filt")
+	if err != nil {
+		p.log.Debug("c++filt not found, skipping demangling")
+		return trace
+	}
+
+	cmd := exec.Command(path)
+	cmd.Stdin = strings.NewReader(trace)
+	out, err := cmd.Output()
+	if err != nil {
+		p.log.Warn("demangling stack trace", "err", err)
+		return trace
+	}
+
+	return string(out)
+}
+
+// maxSanitizerReportSize is the size of the sanitizer reports above which they
+// are truncated, the rest being unlikely to be useful.
+const maxSanitizerReportSize = 16 << 20
+
+// readSanitizerReport reads the sanitizer report received in place of the
+// core. The report is indexed as the trace, along with the error it describes
+// and the functions of its first stack. The files that aren't sanitizer
+// reports are indexed as the trace anyway, with an analysis error, as their
+// analysis would fail the same way every time.
+func (p *analyzeProcess) readSanitizerReport() {
+	if p.err != nil {
+		return
+	}
+
+	p.log.Debug("reading sanitizer report")
+	raw, err := ioutil.ReadAll(io.LimitReader(p.file, maxSanitizerReportSize))
+	if err != nil {
+		p.err = wrap(err, "reading sanitizer report")
+		return
+	}
+	p.core.Trace = string(raw)
+	p.core.AnalysisPath = AnalysisPathSanitizer
+
+	report, err := sanitizer.Parse(bytes.NewReader(raw))
+	if err != nil {
+		p.log.Warn("parsing sanitizer report", "err", err)
+		p.core.AnalysisError = err.Error()
+		return
+	}
+	p.core.Sanitizer = report.Sanitizer
+	p.core.SanitizerError = report.Error
+	p.core.SanitizerAccess = report.Access
+	p.core.SanitizerSummary = report.Summary
+	p.core.Functions = report.Functions
+	p.core.AnalysisError = ""
+}
+
+// markAnalyzed marks the core as analyzed, so it isn't picked up again on
+// startup. Unsupported executables are marked too, as their analysis would
+// fail the same way every time.
+func (p *analyzeProcess) markAnalyzed() {
 	if p.err != nil {
 		return
 	}
 
 	p.core.Analyzed = true
 	p.core.AnalyzedAt = time.Now()
+	p.core.Events = addEvents(p.core.Events, Event{Name: EventAnalysisStarted, At: p.startedAt}, Event{Name: EventAnalysisFinished, At: p.core.AnalyzedAt})
+	p.core.AnalysisSkipped = false
+	p.core.SkipReason = ""
+
+	p.core.PartiallyAnalyzed = p.fallback != nil
+	if p.fallback != nil {
+		p.core.AnalysisPath = AnalysisPathNotes
+		p.core.AnalysisError = p.fallback.Error()
+	}
+}
+
+// markFailed records the failure of the analysis in the events of the indexed
+// core, as the results of a failed analysis aren't indexed.
+func (p *analyzeProcess) markFailed() {
+	if p.err == nil {
+		return
+	}
+
+	err := p.index.Update(p.core.UID, func(c *Coredump) error {
+		c.Events = addEvents(c.Events, Event{Name: EventAnalysisStarted, At: p.startedAt}, newEvent(EventAnalysisFailed))
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		p.log.Error("recording analysis failure", "err", err)
+	}
+}
+
+func (p *analyzeProcess) indexResults() {
+	if p.err != nil {
+		return
+	}
+
+	// Huge traces make the documents slow to index and retrieve, so only an
+	// excerpt is indexed, the full trace being kept in the store.
+	if p.maxTraceSize > 0 && int64(len(p.core.Trace)) > p.maxTraceSize {
+		p.log.Debug("storing trace", "size", len(p.core.Trace), "max", p.maxTraceSize)
+		_, err := p.store.StoreTrace(p.core.UID, strings.NewReader(p.core.Trace))
+		if err != nil {
+			p.err = wrap(err, "storing trace")
+			return
+		}
+		p.core.Trace = traceExcerpt(p.core.Trace, p.maxTraceSize)
+		p.core.TraceTruncated = true
+	}
+
+	// The core may have been updated during the analysis, so the results
+	// are merged with the indexed core instead of replacing it.
 	p.log.Debug("indexing analysis result")
-	err := p.index.Index(p.core)
+	err := p.index.Update(p.core.UID, func(c *Coredump) error {
+		p.core = mergeCore(p.base, p.core, *c)
+		*c = p.core
+		return nil
+	})
 	if err != nil {
 		p.err = wrap(err, "indexing results")
 		return
 	}
 }
+
+// mergeCore merges the result of the analysis of a core with the updates done
+// during the analysis: the fields of the current core that changed since the
+// analysis started (the base) are kept, the others being taken from the
+// result.
+func mergeCore(base, result, current Coredump) Coredump {
+	b, r, c := reflect.ValueOf(base), reflect.ValueOf(&result).Elem(), reflect.ValueOf(current)
+	for i := 0; i < r.NumField(); i++ {
+		if !reflect.DeepEqual(b.Field(i).Interface(), c.Field(i).Interface()) {
+			r.Field(i).Set(c.Field(i))
+		}
+	}
+	return result
+}
+
+// traceExcerpt returns the beginning of the trace, at most max bytes long. The
+// trace is cut at the end of a line if possible, so frames aren't split.
+func traceExcerpt(trace string, max int64) string {
+	excerpt := trace[:max]
+	if i := strings.LastIndexByte(excerpt, '\n'); i > 0 {
+		return excerpt[:i+1]
+	}
+	return strings.ToValidUTF8(excerpt, "")
+}
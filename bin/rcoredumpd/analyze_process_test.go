@@ -0,0 +1,628 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+	"github.com/inconshreveable/log15"
+)
+
+func TestTraceFunctions(t *testing.T) {
+	for n, c := range map[string]struct {
+		trace string
+		want  []string
+	}{
+		"gdb": {
+			trace: "Program terminated with signal SIGSEGV, Segmentation fault.\n" +
+				"#0  0x0000555555555131 in crash (p=0x0) at main.c:4\n" +
+				"4\t  *p = 0;\n" +
+				"#1  0x0000555555555150 in crash (p=0x0) at main.c:5\n" +
+				"#2  main () at main.c:9\n",
+			want: []string{"crash", "main"},
+		},
+		"delve": {
+			trace: " 0  0x000000000045a8e1 in runtime.raise\n" +
+				"    at /usr/lib/go/src/runtime/sys_linux_amd64.s:165\n" +
+				" 1  0x0000000000497d0f in main.main\n" +
+				"    at ./main.go:12\n",
+			want: []string{"runtime.raise", "main.main"},
+		},
+		"c++": {
+			trace: "#0  0x00007ffff7e5a0b1 in std::__throw_out_of_range(char const*) ()\n" +
+				"#1  0x0000555555555263 in std::vector<int, std::allocator<int> >::at (this=0x7fffffffe0a0, __n=3)\n",
+			want: []string{"std::__throw_out_of_range", "std::vector<int, std::allocator<int> >::at"},
+		},
+		"empty": {
+			trace: "",
+			want:  nil,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := traceFunctions(c.trace)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`traceFunctions(): wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestTraceExcerpt(t *testing.T) {
+	for n, c := range map[string]struct {
+		trace string
+		max   int64
+		want  string
+	}{
+		"cut at line": {
+			trace: "#0  main ()\n#1  start ()\n",
+			max:   16,
+			want:  "#0  main ()\n",
+		},
+		"no line": {
+			trace: "abcdéf",
+			max:   5,
+			want:  "abcd",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := traceExcerpt(c.trace, c.max)
+			if got != c.want {
+				t.Errorf(`traceExcerpt(): wanted %q, got %q`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestAnalyzeProcess_DemangleTrace(t *testing.T) {
+	trace := "#0  0x0000555555555131 in _ZN3foo3barEi ()\n#1  0x0000555555555150 in main ()\n"
+	demangled := "#0  0x0000555555555131 in foo::bar(int) ()\n#1  0x0000555555555150 in main ()\n"
+	_, err := exec.LookPath("c++filt")
+	cppfilt := err == nil
+
+	for n, c := range map[string]struct {
+		lang     string
+		demangle bool
+		cppfilt  bool
+		want     string
+	}{
+		"c":           {lang: LangC, want: trace},
+		"c builtin":   {lang: LangC, demangle: true, want: demangled},
+		"c++ c++filt": {lang: LangCPP, cppfilt: true, want: demangled},
+		"c++ builtin": {lang: LangCPP, demangle: true, want: demangled},
+		"go":          {lang: LangGo, demangle: true, want: trace},
+	} {
+		t.Run(n, func(t *testing.T) {
+			if c.cppfilt && !cppfilt {
+				t.Skip("c++filt not available")
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			p := &analyzeProcess{
+				log:      logger,
+				core:     Coredump{Lang: c.lang},
+				demangle: c.demangle,
+			}
+
+			got := p.demangleTrace(trace)
+			if got != c.want {
+				t.Errorf(`demangleTrace(): wanted %q, got %q`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestAnalyzeProcess_FindMissingLibraries(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreLink("hash", Link{Name: "libc.so.6", Path: "/usr/lib/libc.so.6"}, strings.NewReader("libc"))
+	if err != nil {
+		t.Fatalf(`StoreLink(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	p := &analyzeProcess{
+		log:   logger,
+		store: store,
+		core: Coredump{
+			ExecutableHash: "hash",
+			MappedFiles: []string{
+				"/usr/bin/server",
+				"/usr/lib/libc.so.6",
+				"/usr/lib/libplugin.so",
+				"/usr/lib/locale/locale-archive",
+				"/usr/lib/ld-linux-x86-64.so.2",
+			},
+		},
+	}
+
+	p.findMissingLibraries()
+	if p.err != nil {
+		t.Fatalf(`findMissingLibraries(): unexpected error: %s`, p.err)
+	}
+	want := []string{"/usr/lib/libplugin.so", "/usr/lib/ld-linux-x86-64.so.2"}
+	if !reflect.DeepEqual(p.core.MissingLibraries, want) {
+		t.Errorf(`findMissingLibraries(): wanted %#v, got %#v`, want, p.core.MissingLibraries)
+	}
+}
+
+func TestCommandFile(t *testing.T) {
+	for n, c := range map[string]struct {
+		analyzer string
+		prelude  []string
+		want     string
+	}{
+		"single":     {analyzer: "bt", want: "bt\nq\n"},
+		"newlines":   {analyzer: "goroutines\nbt\n", want: "goroutines\nbt\nq\n"},
+		"semicolons": {analyzer: "bt full; info registers", want: "bt full\ninfo registers\nq\n"},
+		"quit":       {analyzer: "bt\nquit;q", want: "bt\nq\n"},
+		"empty":      {analyzer: " ; \n", want: "q\n"},
+		"quoted":     {analyzer: `print "a;b"; print 'c;d'`, want: "print \"a;b\"\nprint 'c;d'\nq\n"},
+		"escaped":    {analyzer: `echo a\;b\n; print "\";"`, want: "echo a;b\\n\nprint \"\\\";\"\nq\n"},
+		"unclosed":   {analyzer: "print \"a;b\nbt", want: "print \"a;b\nbt\nq\n"},
+		"prelude":    {analyzer: "bt", prelude: []string{"set print demangle on"}, want: "set print demangle on\nbt\nq\n"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := string(commandFile(c.analyzer, c.prelude...))
+			if got != c.want {
+				t.Errorf(`commandFile(%q): wanted %q, got %q`, c.analyzer, c.want, got)
+			}
+		})
+	}
+}
+
+func TestParseArtifacts(t *testing.T) {
+	got, err := parseArtifacts("registers=info all-registers, libraries=info sharedlibrary; info threads,")
+	if err != nil {
+		t.Fatalf(`parseArtifacts(): unexpected error: %s`, err)
+	}
+	want := []artifact{
+		{name: "registers", commands: "info all-registers"},
+		{name: "libraries", commands: "info sharedlibrary; info threads"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`parseArtifacts(): wanted %#v, got %#v`, want, got)
+	}
+
+	for _, raw := range []string{"registers", "registers=", "../registers=bt", "bt=bt,bt=bt full"} {
+		_, err := parseArtifacts(raw)
+		if err == nil {
+			t.Errorf(`parseArtifacts(%q): wanted an error`, raw)
+		}
+	}
+}
+
+func TestCrashingGoroutineFirst(t *testing.T) {
+	bt := "0  0x000000000046306e in runtime.raise\n" +
+		"   at /usr/local/go/src/runtime/sys_linux_amd64.s:154\n" +
+		"1  0x000000000049a0f5 in main.main\n" +
+		"   at ./main.go:9\n"
+	first := "  Goroutine 2 - User: /usr/local/go/src/runtime/proc.go:398 runtime.gopark (0x43b0ee) [force gc (idle)]\n" +
+		"\t0  0x000000000043b0ee in runtime.gopark\n" +
+		"\t   at /usr/local/go/src/runtime/proc.go:398\n"
+	crashing := "* Goroutine 1 - User: ./main.go:9 main.main (0x49a0f5) (thread 27511)\n" +
+		"\t0  0x000000000046306e in runtime.raise\n" +
+		"\t   at /usr/local/go/src/runtime/sys_linux_amd64.s:154\n" +
+		"\t1  0x000000000049a0f5 in main.main\n" +
+		"\t   at ./main.go:9\n"
+	last := "  Goroutine 3 - User: /usr/local/go/src/runtime/proc.go:398 runtime.gopark (0x43b0ee) [GC sweep wait]\n" +
+		"\t0  0x000000000043b0ee in runtime.gopark\n"
+	footer := "[3 goroutines]\n"
+
+	for n, c := range map[string]struct {
+		trace string
+		want  string
+	}{
+		"no goroutines":  {trace: bt, want: bt},
+		"crashing first": {trace: bt + crashing + first + last + footer, want: bt + crashing + first + last + footer},
+		"crashing moved": {trace: bt + first + crashing + last + footer, want: bt + crashing + first + last + footer},
+		"no crashing":    {trace: first + last, want: first + last},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := crashingGoroutineFirst(c.trace)
+			if got != c.want {
+				t.Errorf(`crashingGoroutineFirst(): wanted %q, got %q`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestOutOfMemory(t *testing.T) {
+	for n, c := range map[string]struct {
+		script string
+		want   bool
+	}{
+		"gdb":     {script: `echo "virtual memory exhausted: can't allocate 4064 bytes."; exit 1`, want: true},
+		"delve":   {script: `echo "fatal error: runtime: out of memory"; exit 2`, want: true},
+		"killed":  {script: `kill -9 $$`, want: true},
+		"failure": {script: `echo "No symbol table is loaded."; exit 1`, want: false},
+	} {
+		t.Run(n, func(t *testing.T) {
+			out, err := exec.Command("sh", "-c", c.script).CombinedOutput()
+			if got := outOfMemory(err, out); got != c.want {
+				t.Errorf(`outOfMemory(): wanted %t, got %t`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestAnalyzeProcess_ConcurrentUpdate(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreCore("uid", strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreExecutable("hash", strings.NewReader("executable"))
+	if err != nil {
+		t.Fatalf(`StoreExecutable(): unexpected error: %s`, err)
+	}
+
+	index := newTestIndex(t)
+	err = index.Index(Coredump{UID: "uid", ExecutableHash: "hash", Metadata: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	p := &analyzeProcess{
+		index: index,
+		log:   logger,
+		store: store,
+		// The queued core is outdated, and must be read again.
+		core: Coredump{UID: "uid", ExecutableHash: "hash"},
+	}
+
+	// The metadata of the core are updated while it is analyzed.
+	p.run(func() {
+		err := index.Update("uid", func(c *Coredump) error {
+			c.Metadata["team"] = "core"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf(`Update(): unexpected error: %s`, err)
+		}
+		p.core.Lang = LangC
+		p.core.Trace = "#0 main ()"
+	}, p.markAnalyzed)
+	if p.err != nil {
+		t.Fatalf(`run(): unexpected error: %s`, p.err)
+	}
+
+	c, err := index.Find("uid")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if c.Lang != LangC || c.Trace != "#0 main ()" || !c.Analyzed {
+		t.Errorf(`run(): wanted the results of the analysis, got %#v`, c)
+	}
+	if want := map[string]string{"env": "prod", "team": "core"}; !reflect.DeepEqual(c.Metadata, want) {
+		t.Errorf(`run(): wanted metadata %v, got %v`, want, c.Metadata)
+	}
+}
+
+func TestMergeCore(t *testing.T) {
+	base := Coredump{UID: "uid", Analyzed: true, Metadata: map[string]string{"env": "prod"}}
+
+	result := base
+	result.Trace = "#0 main ()"
+	result.AnalyzedAt = time.Now()
+
+	current := base
+	current.Analyzed = false
+	current.Metadata = map[string]string{"env": "prod", "team": "core"}
+
+	want := result
+	want.Analyzed = false
+	want.Metadata = current.Metadata
+	if got := mergeCore(base, result, current); !reflect.DeepEqual(got, want) {
+		t.Errorf(`mergeCore(): wanted %#v, got %#v`, want, got)
+	}
+}
+
+func TestService_AnalyzePartially(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:          newTestIndex(t),
+		store:          store,
+		logger:         logger,
+		stuckThreshold: 24 * time.Hour,
+	}
+
+	// The executable of the cores is missing, so their analysis fails.
+	for _, c := range []Coredump{
+		{UID: "recent", ExecutableHash: "hash", DumpedAt: time.Now().Add(-time.Hour)},
+		{UID: "stuck", ExecutableHash: "hash", DumpedAt: time.Now().Add(-48 * time.Hour)},
+	} {
+		_, err = store.StoreCore(c.UID, strings.NewReader("core"))
+		if err != nil {
+			t.Fatalf(`StoreCore(%s): unexpected error: %s`, c.UID, err)
+		}
+		err = s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, c.UID, err)
+		}
+		s.analyze(c)
+	}
+
+	recent, err := s.index.Find("recent")
+	if err != nil {
+		t.Fatalf(`Find(recent): unexpected error: %s`, err)
+	}
+	if recent.Analyzed || recent.PartiallyAnalyzed {
+		t.Errorf(`analyze(): wanted the recent core to be left unanalyzed, got %#v`, recent)
+	}
+
+	stuck, err := s.index.Find("stuck")
+	if err != nil {
+		t.Fatalf(`Find(stuck): unexpected error: %s`, err)
+	}
+	if !stuck.Analyzed || !stuck.PartiallyAnalyzed || stuck.AnalysisPath != AnalysisPathNotes || len(stuck.AnalysisError) == 0 {
+		t.Errorf(`analyze(): wanted the stuck core to be partially analyzed, got %#v`, stuck)
+	}
+
+	// Each attempt is recorded in the events of the cores.
+	for _, c := range []struct {
+		core Coredump
+		want []string
+	}{
+		{core: recent, want: []string{EventAnalysisStarted, EventAnalysisFailed}},
+		{core: stuck, want: []string{EventAnalysisStarted, EventAnalysisFailed, EventAnalysisStarted, EventAnalysisFinished}},
+	} {
+		if got := eventNames(c.core.Events); !reflect.DeepEqual(got, c.want) {
+			t.Errorf(`analyze(%s): wanted events %v, got %v`, c.core.UID, c.want, got)
+		}
+	}
+}
+
+// eventNames returns the names of the events, in order.
+func eventNames(events []Event) []string {
+	var names []string
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestService_AnalyzeSanitizerReport(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:  newTestIndex(t),
+		store:  store,
+		logger: logger,
+	}
+
+	// The executable isn't needed to read the reports.
+	report := strings.Join([]string{
+		"==2741==ERROR: AddressSanitizer: heap-use-after-free on address 0x602000000010 at pc 0x4f5b3d bp 0x7ffd sp 0x7ffd",
+		"WRITE of size 4 at 0x602000000010 thread T0",
+		"    #0 0x4f5b3c in release /src/crasher/main.c:6:9",
+		"    #1 0x4f5c41 in main /src/crasher/main.c:12:2",
+		"SUMMARY: AddressSanitizer: heap-use-after-free /src/crasher/main.c:6:9 in release",
+		"",
+	}, "\n")
+	for uid, content := range map[string]string{
+		"report":     report,
+		"not report": "Segmentation fault\n",
+	} {
+		c := Coredump{UID: uid, ExecutableHash: "missing", DumpedAt: time.Now(), Format: CoreFormatSanitizer}
+		_, err = store.StoreCore(c.UID, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf(`StoreCore(%s): unexpected error: %s`, uid, err)
+		}
+		err = s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, uid, err)
+		}
+		s.analyze(c)
+	}
+
+	c, err := s.index.Find("report")
+	if err != nil {
+		t.Fatalf(`Find(report): unexpected error: %s`, err)
+	}
+	want := Coredump{
+		Analyzed:         true,
+		AnalysisPath:     AnalysisPathSanitizer,
+		Trace:            report,
+		Functions:        []string{"release", "main"},
+		Sanitizer:        "AddressSanitizer",
+		SanitizerError:   "heap-use-after-free",
+		SanitizerAccess:  "WRITE of size 4",
+		SanitizerSummary: "heap-use-after-free /src/crasher/main.c:6:9 in release",
+	}
+	got := Coredump{
+		Analyzed:         c.Analyzed,
+		AnalysisPath:     c.AnalysisPath,
+		Trace:            c.Trace,
+		Functions:        c.Functions,
+		Sanitizer:        c.Sanitizer,
+		SanitizerError:   c.SanitizerError,
+		SanitizerAccess:  c.SanitizerAccess,
+		SanitizerSummary: c.SanitizerSummary,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`analyze(report): wanted %#v, got %#v`, want, got)
+	}
+
+	// The other files are indexed as the trace anyway.
+	c, err = s.index.Find("not report")
+	if err != nil {
+		t.Fatalf(`Find(not report): unexpected error: %s`, err)
+	}
+	if !c.Analyzed || c.Trace != "Segmentation fault\n" || len(c.AnalysisError) == 0 || len(c.Sanitizer) != 0 {
+		t.Errorf(`analyze(not report): wanted the file as trace with an analysis error, got %#v`, c)
+	}
+}
+
+func TestService_AwaitExecutable(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		dataDir: root,
+		index:   newTestIndex(t),
+		store:   store,
+		logger:  logger,
+	}
+
+	executable := "executable"
+	sum := sha1.Sum([]byte(executable))
+	hash := hex.EncodeToString(sum[:])
+
+	// The executable of the core is missing, so it awaits it.
+	c := Coredump{UID: "uid", ExecutableHash: hash, DumpedAt: time.Now()}
+	_, err = store.StoreCore(c.UID, strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+	err = s.index.Index(c)
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+	s.analyze(c)
+	c, err = s.index.Find("uid")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if c.Analyzed || !c.AnalysisSkipped || c.SkipReason != SkipReasonAwaitingExecutable {
+		t.Errorf(`analyze(): wanted the core to await its executable, got %#v`, c)
+	}
+
+	// Only the executable of the right hash is stored.
+	_, err = s.storeFile(hash, "/bin/other", "", strings.NewReader("other"))
+	if err != errUnknownExecutable {
+		t.Errorf(`storeFile(other): wanted errUnknownExecutable, got %v`, err)
+	}
+	stored, err := s.storeFile(hash, "/bin/executable", "", strings.NewReader(executable))
+	if err != nil || !stored {
+		t.Fatalf(`storeFile(executable): wanted the executable to be stored, got %t, %v`, stored, err)
+	}
+
+	count, err := s.executableStored(hash)
+	if err != nil {
+		t.Fatalf(`executableStored(): unexpected error: %s`, err)
+	}
+	c, err = s.index.Find("uid")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if count != 1 || c.Analyzed || c.AnalysisSkipped || len(c.SkipReason) != 0 {
+		t.Errorf(`executableStored(): wanted the core to be analyzed again, got %d cores and %#v`, count, c)
+	}
+}
+
+func TestAnalyzeProcess_DebuggerFallback(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreCore("uid", strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreExecutable("hash", strings.NewReader("executable"))
+	if err != nil {
+		t.Fatalf(`StoreExecutable(): unexpected error: %s`, err)
+	}
+
+	index := newTestIndex(t)
+	err = index.Index(Coredump{UID: "uid", ExecutableHash: "hash", ExecutableFormat: FormatELF, Lang: LangC, SignalName: "SIGSEGV"})
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+
+	// The debugger can't be found without a PATH.
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	p := &analyzeProcess{
+		index: index,
+		log:   logger,
+		store: store,
+		core:  Coredump{UID: "uid"},
+	}
+	p.run(p.extractStackTrace, p.extractArtifacts, p.markAnalyzed)
+	if p.err != nil {
+		t.Fatalf(`run(): unexpected error: %s`, p.err)
+	}
+
+	c, err := index.Find("uid")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if !c.Analyzed || !c.PartiallyAnalyzed || c.AnalysisPath != AnalysisPathNotes || !strings.HasPrefix(c.AnalysisError, "extracting stack trace") {
+		t.Errorf(`run(): wanted the core to be partially analyzed, got %#v`, c)
+	}
+	if c.SignalName != "SIGSEGV" {
+		t.Errorf(`run(): wanted the notes to be kept, got signal %q`, c.SignalName)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rlimit64 is the structure used by the prlimit64 syscall.
+type rlimit64 struct {
+	cur uint64
+	max uint64
+}
+
+// startAnalyzer starts the command of the analyzer with the given limits.
+func startAnalyzer(cmd *exec.Cmd, limits analyzerLimits) error {
+	// The nice value is an attribute of the thread on Linux, inherited by
+	// the processes it forks, so the analyzer is started from a dedicated
+	// thread. The thread is never unlocked, and is thus discarded with the
+	// goroutine instead of running the others with a lower priority.
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if limits.nice != 0 {
+			err := syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), limits.nice)
+			if err != nil {
+				errs <- wrap(err, `setting nice value`)
+				return
+			}
+		}
+		errs <- cmd.Start()
+	}()
+	err := <-errs
+	if err != nil {
+		return err
+	}
+
+	// The memory limit can only be set once the process is started, which
+	// is soon enough as it is checked on allocation.
+	if limits.memoryMax != 0 {
+		limit := rlimit64{cur: limits.memoryMax, max: limits.memoryMax}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(cmd.Process.Pid), syscall.RLIMIT_AS, uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+		if errno != 0 {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return wrap(errno, `setting memory limit`)
+		}
+	}
+
+	return nil
+}
+
+// analyzerUsage returns the peak resident memory, in bytes, and the CPU time
+// used by the analyzer once exited.
+func analyzerUsage(state *os.ProcessState) (int64, time.Duration) {
+	var maxRSS int64
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// The peak resident memory is given in kilobytes.
+		maxRSS = usage.Maxrss * 1024
+	}
+	return maxRSS, state.UserTime() + state.SystemTime()
+}
@@ -0,0 +1,111 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestStartAnalyzer(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	err := startAnalyzer(cmd, analyzerLimits{nice: 5, memoryMax: 1 << 30})
+	if err != nil {
+		t.Fatalf(`startAnalyzer(): unexpected error: %s`, err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+
+	// The nice value is the 19th field of the status, after the command
+	// which is between parenthesis.
+	stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		t.Fatalf(`reading status: %s`, err)
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if fields[16] != "5" {
+		t.Errorf(`startAnalyzer(): wanted nice value 5, got %s`, fields[16])
+	}
+
+	limits, err := ioutil.ReadFile("/proc/" + pid + "/limits")
+	if err != nil {
+		t.Fatalf(`reading limits: %s`, err)
+	}
+	for _, line := range strings.Split(string(limits), "\n") {
+		if !strings.HasPrefix(line, "Max address space") {
+			continue
+		}
+		if fields := strings.Fields(line); fields[3] != "1073741824" || fields[4] != "1073741824" {
+			t.Errorf(`startAnalyzer(): unexpected address space limit %q`, line)
+		}
+	}
+}
+
+func TestAnalyzerUsage(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf(`running command: %s`, err)
+	}
+
+	maxRSS, cpu := analyzerUsage(cmd.ProcessState)
+	if maxRSS < 1<<10 {
+		t.Errorf(`analyzerUsage(): unexpected peak resident memory %d`, maxRSS)
+	}
+	if cpu <= 0 {
+		t.Errorf(`analyzerUsage(): unexpected CPU time %s`, cpu)
+	}
+}
+
+func TestAnalyzeProcess_RunDebugger_Timeout(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	// The debugger hangs until killed by the timer.
+	err = ioutil.WriteFile(filepath.Join(root, "gdb"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+	if err != nil {
+		t.Fatalf(`writing debugger: %s`, err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", root+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+
+	file, err := ioutil.TempFile(root, "file-")
+	if err != nil {
+		t.Fatalf(`creating file: %s`, err)
+	}
+	defer file.Close()
+
+	p := &analyzeProcess{
+		store:      store,
+		core:       Coredump{UID: "uid", ExecutableHash: "hash", Lang: LangC},
+		limits:     analyzerLimits{memoryMax: 1 << 30},
+		timeout:    100 * time.Millisecond,
+		file:       file,
+		executable: file,
+	}
+	_, err = p.runDebugger("gdb.cmd")
+	if err == nil || errors.Is(err, errAnalyzerOutOfMemory) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf(`runDebugger(): wanted a timeout, got %v`, err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// startAnalyzer starts the command of the analyzer. The limits are only
+// supported on Linux.
+func startAnalyzer(cmd *exec.Cmd, limits analyzerLimits) error {
+	if limits != (analyzerLimits{}) {
+		return errors.New(`analyzer limits are only supported on linux`)
+	}
+	return cmd.Start()
+}
+
+// analyzerUsage returns the peak resident memory, in bytes, and the CPU time
+// used by the analyzer once exited. The peak resident memory is only known on
+// Linux, and is zero elsewhere.
+func analyzerUsage(state *os.ProcessState) (int64, time.Duration) {
+	return 0, state.UserTime() + state.SystemTime()
+}
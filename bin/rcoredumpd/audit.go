@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditIndex       = "index"
+	auditAnalyze     = "analyze"
+	auditDetect      = "detect"
+	auditUploadFile  = "upload_file"
+	auditDelete      = "delete"
+	auditMarkDeleted = "mark_deleted"
+	auditRemove      = "remove"
+	auditBackup      = "backup"
+	auditSaveSearch  = "save_search"
+	auditDropSearch  = "delete_search"
+	auditExec        = "exec"
+)
+
+// auditSystem is the actor of the actions done by the server itself, like the
+// cleanup of the cores.
+const auditSystem = "rcoredumpd"
+
+// auditLog is an append-only log of the actions modifying the coredumps, kept
+// in a file holding one JSON entry per line.
+type auditLog struct {
+	path string
+
+	// file is only opened by the instances allowed to write, the others
+	// only reading the log.
+	lock sync.Mutex
+	file *os.File
+}
+
+// newAuditLog opens the audit log at path, creating it if necessary unless it
+// is read-only.
+func newAuditLog(path string, readOnly bool) (*auditLog, error) {
+	a := &auditLog{path: path}
+	if readOnly {
+		return a, nil
+	}
+
+	var err error
+	a.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Write appends an entry to the log, synchronously so it isn't lost if the
+// server stops right after the action. Nothing is written by the read-only
+// instances, the log being the one of the primary instance.
+func (a *auditLog) Write(e AuditEntry) error {
+	if a.file == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.file.Write(append(raw, '\n'))
+	if err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Recent returns the last entries of the log, the most recent first.
+func (a *auditLog) Recent(size int) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0, size)
+
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The log is read entirely, only keeping the last lines in a ring.
+	ring := make([][]byte, size)
+	var count int
+	scanner := bufio.NewScanner(f)
+	for size != 0 && scanner.Scan() {
+		ring[count%size] = append(ring[count%size][:0], scanner.Bytes()...)
+		count++
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := count - 1; i >= 0 && i >= count-size; i-- {
+		var e AuditEntry
+		err = json.Unmarshal(ring[i%size], &e)
+		if err != nil {
+			return nil, wrap(err, `decoding entry %d`, i+1)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// audit records an action done by the server itself, or on behalf of the
+// request if given. Failing to record it doesn't fail the action, which is
+// already done.
+func (s *service) audit(r *http.Request, action, target, detail string) {
+	e := AuditEntry{
+		Time:   time.Now(),
+		Action: action,
+		Actor:  auditSystem,
+		Target: target,
+		Detail: detail,
+	}
+	if r != nil {
+		e.Actor = actor(r)
+	}
+
+	err := s.auditLog.Write(e)
+	if err != nil {
+		s.logger.Error("writing audit log", "action", action, "target", target, "err", err)
+	}
+}
+
+// actor returns who made the request. The server doesn't authenticate the
+// requests itself, so this is the user of the basic authentication, as
+// checked by a reverse proxy, or else the remote address.
+func actor(r *http.Request) string {
+	user, _, ok := r.BasicAuth()
+	if ok && len(user) != 0 {
+		return user
+	}
+	return r.RemoteAddr
+}
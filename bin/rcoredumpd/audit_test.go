@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestAuditLog_Recent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+
+	// A missing log has no entry yet.
+	got, err := (&auditLog{path: path}).Recent(10)
+	if err != nil {
+		t.Fatalf(`Recent(): unexpected error: %s`, err)
+	}
+	if len(got) != 0 {
+		t.Errorf(`Recent(): wanted no entry, got %#v`, got)
+	}
+
+	a, err := newAuditLog(path, false)
+	if err != nil {
+		t.Fatalf(`newAuditLog(): unexpected error: %s`, err)
+	}
+	defer a.file.Close()
+
+	at := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	for _, uid := range []string{"first", "second", "third"} {
+		e := AuditEntry{Time: at, Action: auditDelete, Actor: "alice", Target: uid}
+		err = a.Write(e)
+		if err != nil {
+			t.Fatalf(`Write(): unexpected error: %s`, err)
+		}
+		entries = append([]AuditEntry{e}, entries...)
+	}
+
+	for n, c := range map[string]struct {
+		size int
+		want []AuditEntry
+	}{
+		"none":    {size: 0, want: []AuditEntry{}},
+		"last":    {size: 2, want: entries[:2]},
+		"all":     {size: 3, want: entries},
+		"too big": {size: 10, want: entries},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := a.Recent(c.size)
+			if err != nil {
+				t.Fatalf(`Recent(): unexpected error: %s`, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`Recent(): wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestActor(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/cores/segv", nil)
+	r.RemoteAddr = "10.0.0.1:54321"
+	if got := actor(r); got != "10.0.0.1:54321" {
+		t.Errorf(`actor(): wanted the remote address, got %q`, got)
+	}
+
+	r.SetBasicAuth("alice", "secret")
+	if got := actor(r); got != "alice" {
+		t.Errorf(`actor(): wanted the user, got %q`, got)
+	}
+}
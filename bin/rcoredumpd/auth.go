@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// Policies of the auth-reads option.
+const (
+	authReadsPublic    = "public"
+	authReadsProtected = "protected"
+)
+
+// authenticate refuses the requests that don't carry the token of the
+// auth-token option: the ones modifying the coredumps, executables, searches
+// or the server, and the admin ones. The reads of the coredumps and
+// executables are let through unless the auth-reads option protects them too.
+func (s *service) authenticate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !s.authRequired(r) {
+		next(rw, r)
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.authToken)) != 1 {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(rw, http.StatusUnauthorized, ErrCodeUnauthorized, errors.New(`invalid token`))
+		return
+	}
+
+	next(rw, r)
+}
+
+// bearerToken returns the token of the Authorization header of the request,
+// and whether it is a bearer token.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
+
+// authRequired reports whether the request must carry the token.
+func (s *service) authRequired(r *http.Request) bool {
+	// The path is cleaned first, so the requests resolved to the endpoints
+	// by normalizeRequest (e.g: "//cores") aren't let through. The method
+	// is resolved the same way (e.g: "post").
+	p := path.Clean("/" + r.URL.Path)
+	method := strings.ToUpper(r.Method)
+
+	// The CORS preflight requests don't carry the token.
+	if method == http.MethodOptions {
+		return false
+	}
+
+	if under(p, "/admin") {
+		return true
+	}
+
+	// The ad hoc debugger commands are protected by their own token,
+	// sent in the same header.
+	if under(p, "/cores") && strings.HasSuffix(p, "/_exec") {
+		return false
+	}
+
+	// The GraphQL endpoint only reads the coredumps, despite its method.
+	read := method == http.MethodGet || method == http.MethodHead || p == "/graphql"
+	if !read {
+		return true
+	}
+
+	if under(p, "/cores") || under(p, "/executables") || p == "/graphql" {
+		return s.authReads == authReadsProtected
+	}
+	return false
+}
+
+// under reports whether the path is the given one or below it.
+func under(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/urfave/negroni"
+)
+
+func TestService_Authenticate(t *testing.T) {
+	for n, c := range map[string]struct {
+		reads  string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		"ingest":                 {reads: authReadsPublic, method: "POST", path: "/cores", header: "Bearer secret", status: http.StatusOK},
+		"ingest without token":   {reads: authReadsPublic, method: "POST", path: "/cores", status: http.StatusUnauthorized},
+		"ingest with bad token":  {reads: authReadsPublic, method: "POST", path: "/cores", header: "Bearer other", status: http.StatusUnauthorized},
+		"token without scheme":   {reads: authReadsPublic, method: "POST", path: "/cores", header: "secret", status: http.StatusUnauthorized},
+		"token with basic":       {reads: authReadsPublic, method: "POST", path: "/cores", header: "Basic secret", status: http.StatusUnauthorized},
+		"redundant path":         {reads: authReadsPublic, method: "POST", path: "//cores/../cores", status: http.StatusUnauthorized},
+		"method case":            {reads: authReadsPublic, method: "post", path: "/cores", status: http.StatusUnauthorized},
+		"core action":            {reads: authReadsPublic, method: "POST", path: "/cores/1234/_analyze", status: http.StatusUnauthorized},
+		"core detection":         {reads: authReadsPublic, method: "POST", path: "/cores/1234/_detect", status: http.StatusUnauthorized},
+		"core files":             {reads: authReadsPublic, method: "POST", path: "/cores/1234/files", status: http.StatusUnauthorized},
+		"core deletion":          {reads: authReadsPublic, method: "DELETE", path: "/cores/1234", status: http.StatusUnauthorized},
+		"exec":                   {reads: authReadsPublic, method: "POST", path: "/cores/1234/_exec", status: http.StatusOK},
+		"preflight":              {reads: authReadsPublic, method: "OPTIONS", path: "/cores", status: http.StatusOK},
+		"executable files":       {reads: authReadsPublic, method: "POST", path: "/executables/abcd/files", status: http.StatusUnauthorized},
+		"search saving":          {reads: authReadsPublic, method: "POST", path: "/searches", status: http.StatusUnauthorized},
+		"search deletion":        {reads: authReadsPublic, method: "DELETE", path: "/searches/crashes", status: http.StatusUnauthorized},
+		"backup":                 {reads: authReadsPublic, method: "POST", path: "/admin/backup", status: http.StatusUnauthorized},
+		"backup with token":      {reads: authReadsPublic, method: "POST", path: "/admin/backup", header: "Bearer secret", status: http.StatusOK},
+		"audit":                  {reads: authReadsPublic, method: "GET", path: "/admin/audit", status: http.StatusUnauthorized},
+		"public read":            {reads: authReadsPublic, method: "GET", path: "/cores/1234", status: http.StatusOK},
+		"public search":          {reads: authReadsPublic, method: "GET", path: "/cores", status: http.StatusOK},
+		"public graphql":         {reads: authReadsPublic, method: "POST", path: "/graphql", status: http.StatusOK},
+		"public executable":      {reads: authReadsPublic, method: "GET", path: "/executables/abcd", status: http.StatusOK},
+		"public lookup":          {reads: authReadsPublic, method: "HEAD", path: "/executables/abcd", status: http.StatusOK},
+		"protected read":         {reads: authReadsProtected, method: "GET", path: "/cores/1234", status: http.StatusUnauthorized},
+		"protected read token":   {reads: authReadsProtected, method: "GET", path: "/cores", header: "Bearer secret", status: http.StatusOK},
+		"protected graphql":      {reads: authReadsProtected, method: "POST", path: "/graphql", status: http.StatusUnauthorized},
+		"protected executable":   {reads: authReadsProtected, method: "GET", path: "/executables/abcd", status: http.StatusUnauthorized},
+		"protected lookup":       {reads: authReadsProtected, method: "HEAD", path: "/executables/abcd", status: http.StatusUnauthorized},
+		"protected lookup token": {reads: authReadsProtected, method: "HEAD", path: "/executables/abcd", header: "Bearer secret", status: http.StatusOK},
+		"other read":             {reads: authReadsProtected, method: "GET", path: "/searches", status: http.StatusOK},
+		"version":                {reads: authReadsProtected, method: "GET", path: "/version", status: http.StatusOK},
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := &service{authToken: "secret", authReads: c.reads}
+			stack := negroni.New()
+			stack.Use(negroni.HandlerFunc(s.authenticate))
+			stack.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(c.method, "/", nil)
+			r.URL.Path = c.path
+			if len(c.header) != 0 {
+				r.Header.Set("Authorization", c.header)
+			}
+			stack.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Errorf(`wanted status %d, got %d`, c.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf(`wanted WWW-Authenticate header, got %q`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedIndex is an Index keeping the most recently found cores in memory, so
+// the cores displayed repeatedly don't hit the underlying index each time. The
+// cached core is dropped when it is indexed, updated or deleted through the
+// cache, so the cache can't be used in front of an index written by another
+// instance.
+type cachedIndex struct {
+	index Index
+	size  int
+	// lookups counts the calls to Find, by result (hit, miss).
+	lookups *prometheus.CounterVec
+
+	mu sync.Mutex
+	// entries are the elements of the cached cores in order, by UID.
+	entries map[string]*list.Element
+	// order holds the cached cores, the most recently found first.
+	order *list.List
+	// generation is incremented each time a core is dropped, so a core
+	// read from the underlying index while it was modified isn't cached.
+	generation uint64
+}
+
+// compile-time check that the cachedIndex actually implements the Index
+// interface.
+var _ Index = new(cachedIndex)
+
+// newCachedIndex returns the index with a cache of the given number of cores
+// in front of its Find method.
+func newCachedIndex(index Index, size int, lookups *prometheus.CounterVec) *cachedIndex {
+	return &cachedIndex{
+		index:   index,
+		size:    size,
+		lookups: lookups,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Find returns the cached core if any, or else finds it in the underlying
+// index and caches it.
+func (i *cachedIndex) Find(uid string) (Coredump, error) {
+	i.mu.Lock()
+	if e, ok := i.entries[uid]; ok {
+		i.order.MoveToFront(e)
+		c := copyCoredump(e.Value.(Coredump))
+		i.mu.Unlock()
+		i.lookups.With(prometheus.Labels{"result": "hit"}).Inc()
+		return c, nil
+	}
+	generation := i.generation
+	i.mu.Unlock()
+	i.lookups.With(prometheus.Labels{"result": "miss"}).Inc()
+
+	c, err := i.index.Find(uid)
+	if err != nil {
+		return c, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if generation != i.generation {
+		return c, nil
+	}
+	if _, ok := i.entries[uid]; !ok {
+		i.entries[uid] = i.order.PushFront(copyCoredump(c))
+	}
+	for i.order.Len() > i.size {
+		e := i.order.Back()
+		i.order.Remove(e)
+		delete(i.entries, e.Value.(Coredump).UID)
+	}
+	return c, nil
+}
+
+func (i *cachedIndex) Index(c Coredump) error {
+	defer i.drop(c.UID)
+	return i.index.Index(c)
+}
+
+func (i *cachedIndex) Update(uid string, mutate func(*Coredump) error) error {
+	defer i.drop(uid)
+	return i.index.Update(uid, mutate)
+}
+
+func (i *cachedIndex) Delete(uid string) error {
+	defer i.drop(uid)
+	return i.index.Delete(uid)
+}
+
+func (i *cachedIndex) Search(q, sort, order string, size, from int) ([]Coredump, uint64, error) {
+	return i.index.Search(q, sort, order, size, from)
+}
+
+func (i *cachedIndex) SearchFunc(r SearchRequest, fn func(Hit) error) (uint64, error) {
+	return i.index.SearchFunc(r, fn)
+}
+
+func (i *cachedIndex) Count(q string) (uint64, error) {
+	return i.index.Count(q)
+}
+
+func (i *cachedIndex) CountRange(q string, start, end time.Time) (uint64, error) {
+	return i.index.CountRange(q, start, end)
+}
+
+func (i *cachedIndex) Facets(q string, fields []string, size int) ([]Facet, error) {
+	return i.index.Facets(q, fields, size)
+}
+
+func (i *cachedIndex) Histogram(r HistogramRequest) (Histogram, error) {
+	return i.index.Histogram(r)
+}
+
+func (i *cachedIndex) Backup(dir string) error {
+	return i.index.Backup(dir)
+}
+
+func (i *cachedIndex) CapMetadata(c Coredump) (Coredump, error) {
+	return i.index.CapMetadata(c)
+}
+
+// drop the cached core with the given UID, if any.
+func (i *cachedIndex) drop(uid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.generation++
+	if e, ok := i.entries[uid]; ok {
+		i.order.Remove(e)
+		delete(i.entries, uid)
+	}
+}
+
+// copyCoredump returns a copy of the core whose maps can be modified without
+// modifying the cached core.
+func copyCoredump(c Coredump) Coredump {
+	if c.Metadata != nil {
+		metadata := make(map[string]string, len(c.Metadata))
+		for k, v := range c.Metadata {
+			metadata[k] = v
+		}
+		c.Metadata = metadata
+	}
+	if c.Registers != nil {
+		registers := make(map[string]uint64, len(c.Registers))
+		for k, v := range c.Registers {
+			registers[k] = v
+		}
+		c.Registers = registers
+	}
+	return c
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingIndex counts the calls to Find of the underlying index.
+type countingIndex struct {
+	BleveIndex
+	finds int
+}
+
+func (i *countingIndex) Find(uid string) (Coredump, error) {
+	i.finds++
+	return i.BleveIndex.Find(uid)
+}
+
+func TestCachedIndex(t *testing.T) {
+	underlying := &countingIndex{BleveIndex: newTestIndex(t).(BleveIndex)}
+	lookups := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rcoredumpd_find_cache_lookups_total",
+	}, []string{"result"})
+	index := newCachedIndex(underlying, 2, lookups)
+
+	for _, uid := range []string{"a", "b", "c"} {
+		err := index.Index(Coredump{UID: uid, Hostname: "alpha", Metadata: map[string]string{"env": "prod"}})
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, uid, err)
+		}
+	}
+
+	find := func(uid string, finds int) Coredump {
+		t.Helper()
+		c, err := index.Find(uid)
+		if err != nil {
+			t.Fatalf(`Find(%s): unexpected error: %s`, uid, err)
+		}
+		if underlying.finds != finds {
+			t.Errorf(`Find(%s): wanted %d finds in the underlying index, got %d`, uid, finds, underlying.finds)
+		}
+		return c
+	}
+
+	// The found cores are cached, and can be modified without modifying
+	// the cache.
+	c := find("a", 1)
+	c.Metadata["env"] = "dev"
+	c = find("a", 1)
+	if c.Metadata["env"] != "prod" {
+		t.Errorf(`Find(a): wanted the cached core to be unchanged, got %v`, c.Metadata)
+	}
+
+	// The least recently found core is evicted.
+	find("b", 2)
+	find("a", 2)
+	find("c", 3)
+	find("a", 3)
+	find("b", 4)
+
+	// The cores modified through the cache are found again.
+	err := index.Update("a", func(c *Coredump) error {
+		c.Hostname = "beta"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf(`Update(a): unexpected error: %s`, err)
+	}
+	if c := find("a", 5); c.Hostname != "beta" {
+		t.Errorf(`Find(a): wanted the updated core, got %#v`, c)
+	}
+	err = index.Delete("a")
+	if err != nil {
+		t.Fatalf(`Delete(a): unexpected error: %s`, err)
+	}
+	_, err = index.Find("a")
+	if err != ErrNotFound {
+		t.Errorf(`Find(a): wanted ErrNotFound after deletion, got %v`, err)
+	}
+
+	if hits, misses := testutil.ToFloat64(lookups.WithLabelValues("hit")), testutil.ToFloat64(lookups.WithLabelValues("miss")); hits != 3 || misses != 6 {
+		t.Errorf(`Find(): wanted 3 hits and 6 misses, got %v and %v`, hits, misses)
+	}
+}
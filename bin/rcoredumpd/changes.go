@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/rs/xid"
+)
+
+// changeCounter counts the writes to the index, as a cheap token telling the
+// clients whether the results of their searches may have changed.
+type changeCounter struct {
+	// epoch identifies the process, as the count starts over on restart.
+	epoch string
+	count uint64
+}
+
+// newChangeCounter returns a counter of a new epoch.
+func newChangeCounter() *changeCounter {
+	return &changeCounter{
+		epoch: xid.New().String(),
+	}
+}
+
+// bump the counter.
+func (c *changeCounter) bump() {
+	atomic.AddUint64(&c.count, 1)
+}
+
+// token returns the current token of the counter.
+func (c *changeCounter) token() string {
+	return fmt.Sprintf("%s-%d", c.epoch, atomic.LoadUint64(&c.count))
+}
+
+// etag returns the entity tag of the results of the search of the given
+// parameters, given the current token. The token must be read before
+// searching, so a write happening during the search changes the tag of the
+// following searches.
+func (c *changeCounter) etag(params ...interface{}) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%q", params)
+	return fmt.Sprintf(`"%s-%x"`, c.token(), h.Sum64())
+}
+
+// matchETag reports whether the value of an If-None-Match header matches the
+// given entity tag.
+func matchETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// countedIndex is an Index bumping a counter each time a core is indexed,
+// updated or deleted through it. The counter is bumped once the write is
+// done, so a search started before can't be tagged with the new token.
+type countedIndex struct {
+	index   Index
+	changes *changeCounter
+}
+
+// compile-time check that the countedIndex actually implements the Index
+// interface.
+var _ Index = new(countedIndex)
+
+func (i countedIndex) Index(c Coredump) error {
+	defer i.changes.bump()
+	return i.index.Index(c)
+}
+
+func (i countedIndex) Update(uid string, mutate func(*Coredump) error) error {
+	defer i.changes.bump()
+	return i.index.Update(uid, mutate)
+}
+
+func (i countedIndex) Delete(uid string) error {
+	defer i.changes.bump()
+	return i.index.Delete(uid)
+}
+
+func (i countedIndex) Find(uid string) (Coredump, error) {
+	return i.index.Find(uid)
+}
+
+func (i countedIndex) Search(q, sort, order string, size, from int) ([]Coredump, uint64, error) {
+	return i.index.Search(q, sort, order, size, from)
+}
+
+func (i countedIndex) SearchFunc(r SearchRequest, fn func(Hit) error) (uint64, error) {
+	return i.index.SearchFunc(r, fn)
+}
+
+func (i countedIndex) Count(q string) (uint64, error) {
+	return i.index.Count(q)
+}
+
+func (i countedIndex) CountRange(q string, start, end time.Time) (uint64, error) {
+	return i.index.CountRange(q, start, end)
+}
+
+func (i countedIndex) Facets(q string, fields []string, size int) ([]Facet, error) {
+	return i.index.Facets(q, fields, size)
+}
+
+func (i countedIndex) Histogram(r HistogramRequest) (Histogram, error) {
+	return i.index.Histogram(r)
+}
+
+func (i countedIndex) Backup(dir string) error {
+	return i.index.Backup(dir)
+}
+
+func (i countedIndex) CapMetadata(c Coredump) (Coredump, error) {
+	return i.index.CapMetadata(c)
+}
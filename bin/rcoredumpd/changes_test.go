@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestService_SearchCoreETag(t *testing.T) {
+	s := &service{
+		searchDefaultSize: 50,
+		searchMaxSize:     1000,
+		indexChanges:      newChangeCounter(),
+	}
+	s.index = countedIndex{index: newTestIndex(t), changes: s.indexChanges}
+
+	err := s.index.Index(Coredump{UID: "first", Hostname: "alpha"})
+	if err != nil {
+		t.Fatalf(`Index(first): unexpected error: %s`, err)
+	}
+
+	search := func(url, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", url, nil)
+		if len(etag) != 0 {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		s.searchCore(w, r, nil)
+		return w
+	}
+
+	w := search("/cores?q=hostname:alpha", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || len(etag) == 0 {
+		t.Fatalf(`searchCore(): wanted a tagged response, got %d with tag %q`, w.Code, etag)
+	}
+
+	// The results are the same until the index is written.
+	w = search("/cores?q=hostname:alpha", etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf(`searchCore(): wanted 304 for the current tag, got %d`, w.Code)
+	}
+	w = search("/cores?q=hostname:alpha", `"other", W/`+etag)
+	if w.Code != http.StatusNotModified {
+		t.Errorf(`searchCore(): wanted 304 for a list including the current tag, got %d`, w.Code)
+	}
+	w = search("/cores?q=hostname:alpha&size=1", etag)
+	if w.Code != http.StatusOK {
+		t.Errorf(`searchCore(): wanted 200 for other parameters, got %d`, w.Code)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		write func() error
+	}{
+		{"Index", func() error { return s.index.Index(Coredump{UID: "second", Hostname: "beta"}) }},
+		{"Update", func() error {
+			return s.index.Update("second", func(c *Coredump) error {
+				c.Hostname = "alpha"
+				return nil
+			})
+		}},
+		{"Delete", func() error { return s.index.Delete("first") }},
+	} {
+		err := tc.write()
+		if err != nil {
+			t.Fatalf(`%s(): unexpected error: %s`, tc.name, err)
+		}
+		w = search("/cores?q=hostname:alpha", etag)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf(`searchCore(): wanted 200 and a new tag after %s, got %d with tag %q`, tc.name, w.Code, w.Header().Get("ETag"))
+		}
+		etag = w.Header().Get("ETag")
+	}
+}
@@ -1,13 +1,33 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 
 	"github.com/inconshreveable/log15"
 )
 
+// Reasons of the cleanup of a core.
+const (
+	cleanupRetention   = "retention"
+	cleanupGracePeriod = "grace period"
+	cleanupRequest     = "request"
+	cleanupStorageFull = "storage full"
+)
+
+// cleanupItem is a core waiting to be cleaned up.
+type cleanupItem struct {
+	core   Coredump
+	reason string
+	// done, if set, is notified once the core is cleaned up.
+	done *sync.WaitGroup
+}
+
 type cleanupProcess struct {
 	index Index
 	log   log15.Logger
@@ -17,6 +37,29 @@ type cleanupProcess struct {
 	err error
 }
 
+// markDeleted marks the core as deleted instead of removing it, so it can
+// still be recovered during the grace period.
+func (p *cleanupProcess) markDeleted() {
+	if p.err != nil {
+		return
+	}
+
+	// The core is updated in place as it may have been updated since it
+	// was queued, by its analysis for example.
+	p.log.Debug("marking core as deleted")
+	err := p.index.Update(p.core.UID, func(c *Coredump) error {
+		if !c.Deleted {
+			c.Deleted = true
+			c.DeletedAt = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		p.err = wrap(err, `indexing deleted document`)
+		return
+	}
+}
+
 func (p *cleanupProcess) cleanIndex() {
 	if p.err != nil {
 		return
@@ -41,6 +84,18 @@ func (p *cleanupProcess) cleanStore() {
 		p.err = wrap(err, `removing coredump file`)
 		return
 	}
+
+	err = p.store.DeleteTrace(p.core.UID)
+	if err != nil {
+		p.err = wrap(err, `removing trace file`)
+		return
+	}
+
+	err = p.store.DeleteArtifacts(p.core.UID)
+	if err != nil {
+		p.err = wrap(err, `removing artifacts`)
+		return
+	}
 }
 
 func (p *cleanupProcess) cleanExecutable() {
@@ -49,8 +104,9 @@ func (p *cleanupProcess) cleanExecutable() {
 	}
 
 	p.log.Debug("cleaning executable")
+	// The executable may never have been fetched from debuginfod.
 	err := p.store.DeleteExecutable(p.core.ExecutableHash)
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		p.err = wrap(err, `removing executable file`)
 		return
 	}
@@ -61,7 +117,7 @@ func (p *cleanupProcess) canCleanExecutable() bool {
 		return false
 	}
 
-	_, total, err := p.index.Search(fmt.Sprintf(`executable_hash:"%s"`, p.core.ExecutableHash), "date", "asc", 0, 0)
+	total, err := p.index.Count(fmt.Sprintf(`executable_hash:"%s"`, p.core.ExecutableHash))
 	if err != nil {
 		p.err = wrap(err, `searching for executable's coredumps`)
 		return false
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+)
+
+func TestCleanupProcess_CanCleanExecutable(t *testing.T) {
+	index := newTestIndex(t)
+
+	cores := []Coredump{
+		{UID: "first", ExecutableHash: "4b1d7e5a8c3f", DumpedAt: time.Now()},
+		{UID: "second", ExecutableHash: "4b1d7e5a8c3f", DumpedAt: time.Now()},
+		{UID: "other", ExecutableHash: "9e0c2f6d1a7b", DumpedAt: time.Now()},
+	}
+	for _, c := range cores {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	// Each core is removed from the index before checking the executable,
+	// so the executable can only be cleaned with the last core using it.
+	for _, c := range []struct {
+		core Coredump
+		want bool
+	}{
+		{core: cores[0], want: false},
+		{core: cores[1], want: true},
+		{core: cores[2], want: true},
+	} {
+		p := &cleanupProcess{
+			index: index,
+			log:   logger,
+			core:  c.core,
+		}
+		p.cleanIndex()
+
+		got := p.canCleanExecutable()
+		if p.err != nil {
+			t.Fatalf(`canCleanExecutable(%s): unexpected error: %s`, c.core.UID, p.err)
+		}
+		if got != c.want {
+			t.Errorf(`canCleanExecutable(%s): wanted %t, got %t`, c.core.UID, c.want, got)
+		}
+	}
+}
+
+func TestCleanupProcess_MarkDeleted(t *testing.T) {
+	index := newTestIndex(t)
+
+	err := index.Index(Coredump{UID: "segv", DumpedAt: time.Now(), Analyzed: true})
+	if err != nil {
+		t.Fatalf(`indexing: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	// The queued core is outdated, the indexed one must be marked without
+	// losing its analysis.
+	p := &cleanupProcess{
+		index: index,
+		log:   logger,
+		core:  Coredump{UID: "segv"},
+	}
+	p.markDeleted()
+	if p.err != nil {
+		t.Fatalf(`markDeleted(): unexpected error: %s`, p.err)
+	}
+
+	c, err := index.Find("segv")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if !c.Deleted || c.DeletedAt.IsZero() || !c.Analyzed {
+		t.Errorf(`markDeleted(): unexpected core %#v`, c)
+	}
+}
+
+// fullStore is a Store whose free space grows by 10 bytes with each removed
+// core.
+type fullStore struct {
+	Store
+	free uint64
+}
+
+func (s *fullStore) DeleteCore(uid string) error {
+	s.free += 10
+	return s.Store.DeleteCore(uid)
+}
+
+func (s *fullStore) FreeSpace() (uint64, error) {
+	return s.free, nil
+}
+
+func TestService_FreeStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	files, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	store := &fullStore{Store: files, free: 5}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:        newTestIndex(t),
+		store:        store,
+		logger:       logger,
+		auditLog:     &auditLog{},
+		deleteGrace:  time.Hour,
+		minFreeBytes: 20,
+	}
+
+	// The oldest core is already deleted, and the cores are removed for
+	// good regardless of the grace period.
+	now := time.Now()
+	for _, c := range []Coredump{
+		{UID: "deleted", DumpedAt: now.Add(-3 * time.Hour), Deleted: true, DeletedAt: now},
+		{UID: "old", DumpedAt: now.Add(-2 * time.Hour)},
+		{UID: "recent", DumpedAt: now.Add(-time.Hour)},
+	} {
+		_, err = store.StoreCore(c.UID, strings.NewReader("core"))
+		if err != nil {
+			t.Fatalf(`StoreCore(%s): unexpected error: %s`, c.UID, err)
+		}
+		err = s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, c.UID, err)
+		}
+	}
+
+	s.freeStorage(context.Background())
+
+	if store.free != 25 {
+		t.Errorf(`freeStorage(): wanted 25 bytes free, got %d`, store.free)
+	}
+	for uid, removed := range map[string]bool{"deleted": true, "old": true, "recent": false} {
+		_, err := s.index.Find(uid)
+		if removed && err != ErrNotFound {
+			t.Errorf(`Find(%s): wanted the core to be removed, got %v`, uid, err)
+		}
+		if !removed && err != nil {
+			t.Errorf(`Find(%s): wanted the core to be kept, got %v`, uid, err)
+		}
+	}
+
+	// The sweep stops once there is no core left.
+	s.minFreeBytes = 100
+	s.freeStorage(context.Background())
+	if count, _ := s.index.Count("*"); count != 0 {
+		t.Errorf(`freeStorage(): wanted every core to be removed, got %d left`, count)
+	}
+}
+
+// countingStore is a Store counting the removals of each core.
+type countingStore struct {
+	Store
+	mu      sync.Mutex
+	removed map[string]int
+}
+
+func (s *countingStore) DeleteCore(uid string) error {
+	s.mu.Lock()
+	s.removed[uid]++
+	s.mu.Unlock()
+	return s.Store.DeleteCore(uid)
+}
+
+func TestService_QueueCleanable(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	files, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	store := &countingStore{Store: files, removed: make(map[string]int)}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:        newTestIndex(t),
+		store:        store,
+		logger:       logger,
+		auditLog:     &auditLog{},
+		cleanupQueue: make(chan cleanupItem),
+	}
+
+	uids := []string{"first", "second", "third", "fourth"}
+	for _, uid := range uids {
+		_, err = store.StoreCore(uid, strings.NewReader("core"))
+		if err != nil {
+			t.Fatalf(`StoreCore(%s): unexpected error: %s`, uid, err)
+		}
+		err = s.index.Index(Coredump{UID: uid, DumpedAt: time.Now()})
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, uid, err)
+		}
+	}
+
+	// The workers are slow enough for the search to run again while they
+	// still clean the cores up.
+	var workers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for item := range s.cleanupQueue {
+				time.Sleep(10 * time.Millisecond)
+				s.cleanup(item)
+				if item.done != nil {
+					item.done.Done()
+				}
+			}
+		}()
+	}
+
+	s.queueCleanable(context.Background(), cleanupRequest, SearchRequest{Query: "*"})
+	close(s.cleanupQueue)
+	workers.Wait()
+
+	for _, uid := range uids {
+		if store.removed[uid] != 1 {
+			t.Errorf(`queueCleanable(): wanted %s removed once, got %d`, uid, store.removed[uid])
+		}
+	}
+}
+
+func TestService_PinExecutable(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:    newTestIndex(t),
+		store:    store,
+		logger:   logger,
+		auditLog: &auditLog{},
+	}
+
+	core := Coredump{UID: "core", ExecutableHash: "hash", DumpedAt: time.Now()}
+	_, err = store.StoreCore(core.UID, strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreExecutable(core.ExecutableHash, strings.NewReader("executable"))
+	if err != nil {
+		t.Fatalf(`StoreExecutable(): unexpected error: %s`, err)
+	}
+	err = s.index.Index(core)
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+
+	// The executable is removed by the last routine using it, not by the
+	// cleanup of its last core.
+	unpin := s.pinExecutable(core.ExecutableHash)
+	s.cleanup(cleanupItem{core: core, reason: cleanupRequest})
+	if exists, _ := store.ExecutableExists(core.ExecutableHash); !exists {
+		t.Errorf(`cleanup(): wanted the pinned executable to be kept`)
+	}
+	unpin()
+	if exists, _ := store.ExecutableExists(core.ExecutableHash); exists {
+		t.Errorf(`unpin(): wanted the executable to be removed`)
+	}
+}
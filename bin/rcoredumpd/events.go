@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// maxEvents is the number of events kept per core, the oldest ones being
+// dropped first, so a core failing to be analyzed over and over doesn't grow
+// forever.
+const maxEvents = 100
+
+// addEvents returns the events of a core with the given ones appended.
+func addEvents(events []Event, added ...Event) []Event {
+	events = append(events, added...)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	return events
+}
+
+// newEvent returns an event of the given name happening now.
+func newEvent(name string) Event {
+	return Event{Name: name, At: time.Now()}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elwinar/rcoredump/pkg/debuginfod"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// execForbidden matches the calls of the debugger's convenience functions,
+// which include $_shell and would give access to the host despite the
+// allowlist.
+var execForbidden = regexp.MustCompile(`\$_[A-Za-z0-9_]*\s*\(`)
+
+// execReadOnly are the commands that can be allowed, the commands of gdb and
+// delve reading the state of the core, by their names and aliases. Any other
+// word is refused, as the debuggers accept the unambiguous prefixes of the
+// commands (e.g: "py" for "python", "ap" for "append"), and a word allowed
+// because it isn't a known command could run one. Some of those commands can
+// still run others, which is checked by checkExecCommands. The aliases of
+// delve that are also aliases in gdb (e.g: "gr" for "guile-repl") are left out.
+var execReadOnly = map[string]bool{
+	// gdb
+	"backtrace":   true,
+	"bt":          true,
+	"where":       true,
+	"info":        true,
+	"i":           true,
+	"frame":       true,
+	"f":           true,
+	"up":          true,
+	"down":        true,
+	"thread":      true,
+	"print":       true,
+	"p":           true,
+	"inspect":     true,
+	"output":      true,
+	"ptype":       true,
+	"whatis":      true,
+	"list":        true,
+	"l":           true,
+	"x":           true,
+	"disassemble": true,
+	// delve
+	"stack":      true,
+	"goroutines": true,
+	"grs":        true,
+	"goroutine":  true,
+	"threads":    true,
+	"deferred":   true,
+	"locals":     true,
+	"args":       true,
+	"vars":       true,
+	"regs":       true,
+	"ls":         true,
+	"disass":     true,
+	"examinemem": true,
+	"funcs":      true,
+	"types":      true,
+	"libraries":  true,
+}
+
+// execApplied are the commands whose apply subcommand (e.g: "thread apply all
+// bt") runs other commands, which can't be checked against the allowlist.
+var execApplied = []string{"thread", "frame"}
+
+// execScoped are the commands of delve running the command following their
+// argument in the scope of a frame or goroutine (e.g: "frame 1 locals"),
+// which can't be checked against the allowlist either.
+var execScoped = map[string]bool{"frame": true, "up": true, "down": true, "goroutine": true, "deferred": true}
+
+// parseExecCommands parses the value of the exec-commands option, a list of
+// debugger commands separated by commas. See execReadOnly.
+func parseExecCommands(raw string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	for _, command := range strings.Split(raw, ",") {
+		command = strings.TrimSpace(command)
+		if len(command) == 0 {
+			continue
+		}
+		if strings.ContainsAny(command, " \t") {
+			return nil, fmt.Errorf(`invalid command '%s': only the first word of the commands can be allowed`, command)
+		}
+		if !execReadOnly[command] {
+			return nil, fmt.Errorf(`command '%s' can't be allowed: only the read-only commands can be`, command)
+		}
+		allowed[command] = true
+	}
+	return allowed, nil
+}
+
+// checkExecCommands checks that every command, split as for the analyzer
+// options, is allowed.
+func checkExecCommands(commands string, allowed map[string]bool) error {
+	split := splitCommands(commands)
+	if len(split) == 0 {
+		return errors.New(`no command`)
+	}
+	for _, command := range split {
+		words := strings.Fields(command)
+		if !allowed[words[0]] {
+			return fmt.Errorf(`command '%s' isn't allowed`, words[0])
+		}
+		if applies(words) {
+			return fmt.Errorf(`command '%s' isn't allowed`, strings.Join(words[:2], " "))
+		}
+		if scopes(words) {
+			return fmt.Errorf(`commands run by '%s' aren't allowed`, words[0])
+		}
+		if execForbidden.MatchString(command) {
+			return fmt.Errorf(`convenience functions aren't allowed in '%s'`, command)
+		}
+	}
+	return nil
+}
+
+// applies reports whether the words of the command are an apply subcommand,
+// the debuggers accepting any unambiguous prefix of the commands (e.g: "thr a
+// all").
+func applies(words []string) bool {
+	if len(words) < 2 || !strings.HasPrefix("apply", words[1]) {
+		return false
+	}
+	for _, command := range execApplied {
+		if strings.HasPrefix(command, words[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes reports whether the words of the command run another command in the
+// scope of a frame or goroutine, the argument being optional for the up and
+// down commands (e.g: "up locals"). The -exec flag of the goroutines command
+// runs another command for each goroutine.
+func scopes(words []string) bool {
+	switch {
+	case words[0] == "goroutines" || words[0] == "grs":
+		for _, word := range words[1:] {
+			if word == "-exec" {
+				return true
+			}
+		}
+		return false
+	case !execScoped[words[0]]:
+		return false
+	}
+
+	args := words[1:]
+	if len(args) != 0 && isNumber(args[0]) {
+		args = args[1:]
+	} else if words[0] != "up" && words[0] != "down" {
+		// The subcommands of gdb (e.g: "frame level 1").
+		return false
+	}
+	return len(args) != 0
+}
+
+// isNumber reports whether the word is a decimal number.
+func isNumber(word string) bool {
+	_, err := strconv.Atoi(word)
+	return err == nil
+}
+
+// execRequest is the body of the requests to run debugger commands against a
+// core.
+type execRequest struct {
+	Commands string `json:"commands"`
+}
+
+// execCore handles the requests to run debugger commands against a core, in
+// the same conditions as the analysis, and returns the output of the debugger
+// without indexing it. Only the allowed commands can be run, and the requests
+// must be authenticated by the exec-token option.
+func (s *service) execCore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if len(s.execAllowed) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeNotConfigured, errors.New(`ad hoc debugger commands aren't configured`))
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.execToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, errors.New(`invalid token`))
+		return
+	}
+
+	var req execRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "reading request"))
+		return
+	}
+	err = checkExecCommands(req.Commands, s.execAllowed)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+
+	uid := p.ByName("uid")
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+		break
+	case ErrNotFound:
+		writeError(w, http.StatusBadRequest, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("executing", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	switch c.Lang {
+	case LangC, LangCPP, LangGo:
+		break
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("core isn't analyzed"))
+		return
+	}
+	s.audit(r, auditExec, uid, req.Commands)
+
+	out, err := s.exec(c, req.Commands)
+	if err != nil {
+		s.logger.Error("executing", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	write(w, http.StatusOK, map[string]interface{}{"output": string(out)})
+}
+
+// exec runs the commands against the core with its debugger, and returns the
+// output. The core isn't modified, so the read-only instances can run them
+// too, as long as they don't store anything.
+func (s *service) exec(core Coredump, commands string) ([]byte, error) {
+	defer s.pinExecutable(core.ExecutableHash)()
+
+	var prelude []string
+	if core.Lang == LangCPP {
+		prelude = []string{"set print demangle on", "set print asm-demangle on"}
+	}
+	// The command file is written in the temporary directory of the
+	// system, the data directory of the read-only instances being the one
+	// of the primary instance.
+	tmp, err := ioutil.TempFile("", "rcoredumpd-exec-*.cmd")
+	if err != nil {
+		return nil, wrap(err, `creating command file`)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(commandFile(commands, prelude...))
+	tmp.Close()
+	if err != nil {
+		return nil, wrap(err, `writing command file`)
+	}
+
+	p := s.newAnalyzeProcess(core)
+	p.timeout = s.execTimeout
+	if s.readOnly {
+		// Fetching the executable from debuginfod would store it.
+		p.debuginfod = debuginfod.Client{}
+	}
+	p.init()
+	defer p.cleanup()
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return p.runDebugger(tmp.Name())
+}
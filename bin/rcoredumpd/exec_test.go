@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseExecCommands(t *testing.T) {
+	allowed, err := parseExecCommands("bt, info,,print")
+	if err != nil {
+		t.Fatalf(`parseExecCommands(): unexpected error: %s`, err)
+	}
+	if len(allowed) != 3 || !allowed["bt"] || !allowed["info"] || !allowed["print"] {
+		t.Errorf(`parseExecCommands(): wanted bt, info and print, got %v`, allowed)
+	}
+
+	for _, raw := range []string{
+		"bt,shell", "info registers", "python", "with", "taas", "faas", "tfaas", "set", "dump", "run",
+		// The prefixes and aliases of the commands that can't be allowed.
+		"py", "pi", "python-interactive", "gu", "gr", "guile-repl", "sou", "shel", "ap", "app", "gc", "tr",
+		// The unknown commands.
+		"unknown", "sources",
+	} {
+		_, err := parseExecCommands(raw)
+		if err == nil {
+			t.Errorf(`parseExecCommands(%q): wanted an error`, raw)
+		}
+	}
+}
+
+func TestCheckExecCommands(t *testing.T) {
+	allowed := map[string]bool{"bt": true, "info": true, "print": true, "thread": true, "frame": true, "f": true, "up": true, "goroutine": true, "goroutines": true}
+	for _, tc := range []struct {
+		commands string
+		valid    bool
+	}{
+		{"bt full", true},
+		{"info registers; print $pc\n\nbt", true},
+		{"", false},
+		{" ; \n", false},
+		{"shell id", false},
+		{"bt; !id", false},
+		{"| bt | grep main", false},
+		{"print $_shell(\"id\")", false},
+		{"print $_as_string (1)", false},
+		{"thread 2; frame 1; f 0", true},
+		{"print apply", true},
+		{"thread apply all shell id", false},
+		{"thread apply 1 bt", false},
+		{"thread a all bt", false},
+		{"frame apply all shell id", false},
+		{"f app 1 bt", false},
+		{"with print pretty -- shell id", false},
+		{"taas shell id", false},
+		{"set logging file /tmp/x; set logging on", false},
+		{"frame level 1; frame function main; up; up 2", true},
+		{"frame 1 print x", false},
+		{"up print x", false},
+		{"up 1 print x", false},
+		{"goroutine 1 bt", false},
+		{"goroutines -t; goroutine 1", true},
+		{"goroutines -exec print x", false},
+		{`print "a;b"; bt`, true},
+		{`print "a;"; shell id`, false},
+		{`print "a\"; shell id"`, true},
+		{"print \"a\nshell id", false},
+		{`print 1 \; shell id`, true},
+	} {
+		err := checkExecCommands(tc.commands, allowed)
+		if tc.valid && err != nil {
+			t.Errorf(`checkExecCommands(%q): unexpected error: %s`, tc.commands, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf(`checkExecCommands(%q): wanted an error`, tc.commands)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/julienschmidt/httprouter"
+)
+
+// graphqlRequest is the body of the requests to the GraphQL endpoint.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphql handles the GraphQL queries. It serves the same search as the REST
+// endpoints, but lets the client select the fields of the cores, and fetch
+// several things in a single request.
+func (s *service) graphql(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req graphqlRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "reading request"))
+		return
+	}
+	if len(req.Query) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("empty query"))
+		return
+	}
+
+	// Errors of the query are part of the result, as the specification
+	// demands, so the status is always OK.
+	res := graphql.Do(graphql.Params{
+		Schema:         s.graphqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        r.Context(),
+	})
+	write(w, http.StatusOK, res)
+}
+
+// int64Scalar represents the int64 fields, which are larger than the Int
+// scalar of GraphQL. They are serialized as JSON numbers.
+var int64Scalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Int64",
+	Description: "64-bit signed integer.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+})
+
+// metadataType is an entry of the metadata of a core.
+var metadataType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Metadata",
+	Fields: graphql.Fields{
+		"key":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+// registerType is a register of a core, its value being in hexadecimal as in
+// the index.
+var registerType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Register",
+	Fields: graphql.Fields{
+		"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+// linkType is a shared library of the executable of a core, resolved from its
+// JSON field names.
+var linkType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Link",
+	Fields: graphql.Fields{
+		"name":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"path":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"symlinks": &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		"found":    &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"error":    &graphql.Field{Type: graphql.String},
+	},
+})
+
+// eventType is a step of the lifecycle of a core, resolved from its JSON field
+// names.
+var eventType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Event",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"at":   &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+// coreType returns the GraphQL type of the coredumps. Its fields are derived
+// from the ones of the Coredump struct, named after their JSON name, so they
+// stay in sync.
+func coreType() *graphql.Object {
+	t := reflect.TypeOf(Coredump{})
+	fields := graphql.Fields{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+
+		var typ graphql.Output
+		switch t.Field(i).Type {
+		case reflect.TypeOf(""):
+			typ = graphql.String
+		case reflect.TypeOf(false):
+			typ = graphql.Boolean
+		case reflect.TypeOf(0):
+			typ = graphql.Int
+		case reflect.TypeOf(int64(0)):
+			typ = int64Scalar
+		case reflect.TypeOf(float64(0)):
+			typ = graphql.Float
+		case reflect.TypeOf(time.Time{}):
+			typ = graphql.DateTime
+		case reflect.TypeOf([]string{}):
+			typ = graphql.NewList(graphql.NewNonNull(graphql.String))
+		case reflect.TypeOf(map[string]string{}):
+			typ = graphql.NewList(graphql.NewNonNull(metadataType))
+		case reflect.TypeOf(map[string]uint64{}):
+			typ = graphql.NewList(graphql.NewNonNull(registerType))
+		case reflect.TypeOf([]Link{}):
+			typ = graphql.NewList(graphql.NewNonNull(linkType))
+		case reflect.TypeOf([]Event{}):
+			typ = graphql.NewList(graphql.NewNonNull(eventType))
+		default:
+			panic(fmt.Sprintf("unsupported type %s of Coredump field %s", t.Field(i).Type, name))
+		}
+
+		index := i
+		fields[name] = &graphql.Field{
+			Type: typ,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return resolveCoreField(reflect.ValueOf(p.Source).Field(index).Interface()), nil
+			},
+		}
+	}
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Core",
+		Fields: fields,
+	})
+}
+
+// resolveCoreField converts the value of a Coredump field to the one of its
+// GraphQL field. The maps are converted to lists sorted by key, GraphQL
+// having no map type.
+func resolveCoreField(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]string:
+		entries := make([]map[string]interface{}, 0, len(v))
+		for k, value := range v {
+			entries = append(entries, map[string]interface{}{"key": k, "value": value})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i]["key"].(string) < entries[j]["key"].(string)
+		})
+		return entries
+	case map[string]uint64:
+		entries := make([]map[string]interface{}, 0, len(v))
+		for k, value := range v {
+			entries = append(entries, map[string]interface{}{"name": k, "value": fmt.Sprintf("%#x", value)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i]["name"].(string) < entries[j]["name"].(string)
+		})
+		return entries
+	default:
+		return v
+	}
+}
+
+// newGraphQLSchema returns the schema of the GraphQL endpoint, backed by the
+// index of the service.
+func (s *service) newGraphQLSchema() (graphql.Schema, error) {
+	core := coreType()
+
+	searchResult := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"total":   &graphql.Field{Type: graphql.NewNonNull(int64Scalar)},
+			"size":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"results": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(core)))},
+		},
+	})
+
+	facetTerm := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FacetTerm",
+		Fields: graphql.Fields{
+			"term":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"count": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	facet := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Facet",
+		Fields: graphql.Fields{
+			"field":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"total":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"missing": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"other":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"terms":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(facetTerm)))},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"cores": &graphql.Field{
+				Type:        graphql.NewNonNull(searchResult),
+				Description: "Search the cores matching the query, see bleve's query string syntax.",
+				Args: graphql.FieldConfigArgument{
+					"query": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "*"},
+					"sort":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "dumped_at"},
+					"order": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "desc"},
+					"from":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"size":  &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: s.resolveCores,
+			},
+			"core": &graphql.Field{
+				Type:        core,
+				Description: "Get a core by its uid, or null if it doesn't exist.",
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: s.resolveCore,
+			},
+			"facets": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(facet))),
+				Description: "Get the most frequent values of the fields among the cores matching the query.",
+				Args: graphql.FieldConfigArgument{
+					"query":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "*"},
+					"fields": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+					"size":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+				},
+				Resolve: s.resolveFacets,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// resolveCores searches the cores, with the same parameters and limits as the
+// searchCore handler.
+func (s *service) resolveCores(p graphql.ResolveParams) (interface{}, error) {
+	q, _ := p.Args["query"].(string)
+	if len(q) == 0 {
+		q = "*"
+	}
+
+	sort, _ := p.Args["sort"].(string)
+	switch sort {
+	case "dumped_at", "hostname":
+		break
+	default:
+		return nil, fmt.Errorf("invalid sort field '%s'", sort)
+	}
+
+	order, _ := p.Args["order"].(string)
+	switch order {
+	case "asc", "desc":
+		break
+	default:
+		return nil, fmt.Errorf("invalid sort order '%s'", order)
+	}
+
+	size := s.searchDefaultSize
+	if v, ok := p.Args["size"].(int); ok {
+		size = v
+	}
+	if size < 0 {
+		return nil, errors.New("invalid size argument: must be positive")
+	}
+	if size > s.searchMaxSize {
+		size = s.searchMaxSize
+	}
+
+	from, _ := p.Args["from"].(int)
+	if from < 0 {
+		return nil, errors.New("invalid from argument: must be positive")
+	}
+
+	results := []Coredump{}
+	total, err := s.index.SearchFunc(SearchRequest{
+		Query:  q,
+		Sort:   sort,
+		Order:  order,
+		Size:   size,
+		From:   from,
+		Fields: selectedCoreFields(p.Info.FieldASTs),
+	}, func(h Hit) error {
+		results = append(results, h.Coredump)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"total":   total,
+		"size":    size,
+		"results": results,
+	}, nil
+}
+
+// selectedCoreFields returns the fields of the cores selected in the results
+// of a cores query, so only those are loaded from the index. It returns nil,
+// meaning every field, if the selection uses fragments.
+func selectedCoreFields(asts []*ast.Field) []string {
+	known := make(map[string]bool)
+	for _, f := range CoredumpFields() {
+		known[f] = true
+	}
+
+	fields := []string{}
+	for _, field := range asts {
+		if field.SelectionSet == nil {
+			continue
+		}
+		for _, selection := range field.SelectionSet.Selections {
+			results, ok := selection.(*ast.Field)
+			if !ok {
+				return nil
+			}
+			if results.Name.Value != "results" || results.SelectionSet == nil {
+				continue
+			}
+			for _, selection := range results.SelectionSet.Selections {
+				f, ok := selection.(*ast.Field)
+				if !ok {
+					return nil
+				}
+				if known[f.Name.Value] {
+					fields = append(fields, f.Name.Value)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// resolveCore finds a core by its uid.
+func (s *service) resolveCore(p graphql.ResolveParams) (interface{}, error) {
+	uid, _ := p.Args["uid"].(string)
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+		return c, nil
+	case ErrNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// resolveFacets computes the facets of the fields of the cores, the fields
+// being either fields of the cores or metadata (e.g: meta.team).
+func (s *service) resolveFacets(p graphql.ResolveParams) (interface{}, error) {
+	q, _ := p.Args["query"].(string)
+	if len(q) == 0 {
+		q = "*"
+	}
+
+	size, _ := p.Args["size"].(int)
+	if size <= 0 {
+		return nil, errors.New("invalid size argument: must be strictly positive")
+	}
+
+	known := make(map[string]bool)
+	for _, f := range CoredumpFields() {
+		known[f] = true
+	}
+	var fields []string
+	raw, _ := p.Args["fields"].([]interface{})
+	for _, v := range raw {
+		f, _ := v.(string)
+		if !known[f] && !strings.HasPrefix(f, "meta.") {
+			return nil, fmt.Errorf("invalid field '%s'", f)
+		}
+		fields = append(fields, f)
+	}
+
+	return s.index.Facets(q, fields, size)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestService_GraphQL(t *testing.T) {
+	s := &service{
+		index:             newTestIndex(t),
+		searchDefaultSize: 50,
+		searchMaxSize:     1000,
+	}
+	var err error
+	s.graphqlSchema, err = s.newGraphQLSchema()
+	if err != nil {
+		t.Fatalf(`newGraphQLSchema(): unexpected error: %s`, err)
+	}
+
+	now := time.Now()
+	for _, c := range []Coredump{
+		{UID: "first", Hostname: "alpha", DumpedAt: now.Add(-2 * time.Minute), Size: 1 << 40, Metadata: map[string]string{"team": "core"}},
+		{UID: "second", Hostname: "alpha", DumpedAt: now.Add(-time.Minute)},
+		{UID: "third", Hostname: "beta", DumpedAt: now},
+	} {
+		err := s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	for n, c := range map[string]struct {
+		query  string
+		status int
+		want   string
+	}{
+		"cores": {
+			query:  `{ cores(query: "hostname:alpha", sort: "dumped_at", order: "asc", size: 1) { total size results { uid size } } }`,
+			status: http.StatusOK,
+			want:   `{"data":{"cores":{"results":[{"size":1099511627776,"uid":"first"}],"size":1,"total":2}}}`,
+		},
+		"core": {
+			query:  `{ core(uid: "first") { hostname metadata { key value } } missing: core(uid: "none") { uid } }`,
+			status: http.StatusOK,
+			want:   `{"data":{"core":{"hostname":"alpha","metadata":[{"key":"team","value":"core"}]},"missing":null}}`,
+		},
+		"facets": {
+			query:  `{ facets(fields: ["hostname"]) { field total terms { term count } } }`,
+			status: http.StatusOK,
+			want:   `{"data":{"facets":[{"field":"hostname","terms":[{"count":2,"term":"alpha"},{"count":1,"term":"beta"}],"total":3}]}}`,
+		},
+		"invalid sort": {
+			query:  `{ cores(sort: "size") { total } }`,
+			status: http.StatusOK,
+			want:   `{"data":null,"errors":[{"message":"invalid sort field 'size'","locations":[{"line":1,"column":3}],"path":["cores"]}]}`,
+		},
+		"invalid facet field": {
+			query:  `{ facets(fields: ["unknown"]) { field } }`,
+			status: http.StatusOK,
+			want:   `{"data":null,"errors":[{"message":"invalid field 'unknown'","locations":[{"line":1,"column":3}],"path":["facets"]}]}`,
+		},
+		"empty query": {
+			query:  ``,
+			status: http.StatusBadRequest,
+			want:   `{"error":"empty query","code":"invalid_request"}`,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			raw, _ := json.Marshal(graphqlRequest{Query: c.query})
+			w := httptest.NewRecorder()
+			s.graphql(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(raw))), nil)
+
+			if w.Code != c.status {
+				t.Errorf(`graphql(): wanted status %d, got %d`, c.status, w.Code)
+			}
+
+			var got, want interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatalf(`graphql(): invalid response %q: %s`, w.Body.String(), err)
+			}
+			_ = json.Unmarshal([]byte(c.want), &want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf(`graphql(): unexpected response (-want +got):\n%s`, diff)
+			}
+		})
+	}
+}
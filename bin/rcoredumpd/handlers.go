@@ -1,12 +1,24 @@
 package main
 
 import (
+	"crypto/sha1"
+	"debug/elf"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/elwinar/rcoredump/pkg/elfx"
+	"github.com/elwinar/rcoredump/pkg/protocol"
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 
 	"github.com/c2h5oh/datasize"
@@ -14,8 +26,20 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// write a payload and a status to the ResponseWriter.
+// write a payload and a status to the ResponseWriter, as plain text if the
+// format negotiated with the client is text and the payload can be rendered
+// that way, or as JSON.
 func write(w http.ResponseWriter, status int, payload interface{}) {
+	if _, ok := w.(textWriter); ok {
+		if raw, ok := text(payload); ok {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, raw)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	raw, err := json.Marshal(payload)
 	if err != nil {
@@ -24,17 +48,17 @@ func write(w http.ResponseWriter, status int, payload interface{}) {
 	_, _ = w.Write(raw)
 }
 
-// write an error and a status to the ResponseWriter.
-func writeError(w http.ResponseWriter, status int, err error) {
-	write(w, status, Error{Err: err.Error()})
+// write an error, its code and a status to the ResponseWriter.
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	write(w, status, Error{Code: code, Err: err.Error()})
 }
 
 func (s *service) notFound(w http.ResponseWriter, r *http.Request) {
-	writeError(w, http.StatusNotFound, fmt.Errorf(`endpoint %q not found`, r.URL.Path))
+	writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Errorf(`endpoint %q not found`, r.URL.Path))
 }
 
 func (s *service) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
-	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf(`method %q not allowed for endpoint %q`, r.Method, r.URL.Path))
+	writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Errorf(`method %q not allowed for endpoint %q`, r.Method, r.URL.Path))
 }
 
 func (s *service) root(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -52,32 +76,180 @@ func (s *service) about(rw http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	})
 }
 
+// version handles the requests for the compatibility information of the
+// server.
+func (s *service) version(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	write(rw, http.StatusOK, VersionInfo{
+		Version:            Version,
+		MinProtocolVersion: protocol.MinVersion,
+		MaxProtocolVersion: protocol.Version,
+	})
+}
+
+// capabilities returns what the server supports, for the forwarder to adapt
+// what it sends.
+func (s *service) capabilities(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	write(rw, http.StatusOK, Capabilities{
+		VersionInfo: VersionInfo{
+			Version:            Version,
+			MinProtocolVersion: protocol.MinVersion,
+			MaxProtocolVersion: protocol.Version,
+		},
+		Compression:         []string{protocol.Compression, protocol.Uncompressed},
+		Debuginfod:          len(s.debuginfod.URLs) != 0,
+		ReadOnly:            s.readOnly,
+		MaxCoreSize:         s.maxCoreBytes,
+		MaxExecutableSize:   s.maxExecutableBytes,
+		MinForwarderVersion: s.minForwarder,
+		SanitizerReports:    true,
+	})
+}
+
 // indexCore handle the requests for adding cores to the service. It exposes a
 // prometheus metric for monitoring its activity, and only deals with storing
 // the core and indexing the immutable information about it. Once done, it send
 // the UID of the core in the analysis channel for the analyzis routine to pick
 // it up.
 func (s *service) indexCore(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// Refuse the request right away if receiving it would exceed the
+	// in-flight budget, so the server doesn't run out of resources. The
+	// files of the request can't exceed the size declared by the
+	// forwarder, and the length of the body is enforced by the HTTP
+	// server. The requests declaring neither are charged as they are
+	// received instead.
+	size := r.ContentLength
+	var declaredSize int64
+	if rawSize := r.Header.Get(SizeHeader); len(rawSize) != 0 {
+		var err error
+		size, err = strconv.ParseInt(rawSize, 10, 64)
+		if err == nil && size < 0 {
+			err = errors.New("negative size")
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid %s header", SizeHeader))
+			return
+		}
+		declaredSize = size
+	}
+	if size > 0 {
+		if !s.inflight.tryAcquire(size) {
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, errors.New("too many coredumps being received"))
+			return
+		}
+		defer s.inflight.release(size)
+	}
+
+	// Refuse the request right away too if storing it would leave less
+	// than the minimum free space, rather than failing in the middle of
+	// writing it. If the free space can't be read, the request is
+	// accepted, the write failing anyway if the store is actually full.
+	if s.minFreeBytes != 0 {
+		required := uint64(s.minFreeBytes)
+		if size > 0 {
+			required += uint64(size)
+		}
+		free, err := s.store.FreeSpace()
+		if err != nil {
+			s.logger.Error("reading free space", "err", err)
+		}
+		if err == nil && free < required {
+			s.notifyStorageFull()
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusInsufficientStorage, ErrCodeStorageFull, errors.New("not enough free space in the store"))
+			return
+		}
+	}
+
 	req := &indexRequest{
 		index: s.index,
 		log:   s.logger,
 		r:     r,
 		store: s.store,
+
+		analyzeMaxSize:       s.analyzeMaxBytes,
+		maxCoreSize:          s.maxCoreBytes,
+		maxExecutableSize:    s.maxExecutableBytes,
+		maxDecompressedSize:  s.maxDecompressedBytes,
+		declaredSize:         declaredSize,
+		bufferSize:           s.ioBufferBytes,
+		tempDir:              s.dataDir,
+		minForwarderVersion:  s.minForwarderVersion,
+		metadataAllowlist:    s.metadataAllowlist,
+		metadataRejected:     s.metadataRejected,
+		rejectedMetadataKeys: s.rejectedKeys,
+	}
+	if size <= 0 && s.inflight.max != 0 {
+		req.inflight = &s.inflight
 	}
 	req.init()
 	req.read()
+	req.checkForwarderVersion()
 	req.readCore()
+	req.checkCoreSize()
+	req.skipLargeCore()
 	if req.req.IncludeExecutable {
 		req.readExecutable()
+		req.readLinks()
+	}
+	// The executable must not be removed by a cleanup between the moment
+	// it is checked and the moment the core referencing it is indexed.
+	s.executableLocks.Lock(req.req.ExecutableHash)
+	if req.req.IncludeExecutable {
+		req.storeExecutable()
 	} else {
 		req.computeExecutableSize()
 	}
 	req.indexCore()
+	s.executableLocks.Unlock(req.req.ExecutableHash)
 	req.close()
 
 	if req.err != nil {
 		s.logger.Error("indexing", "uid", req.uid, "err", req.err)
-		writeError(w, http.StatusInternalServerError, req.err)
+		// The forwarder is too recent, its request can't be read
+		// correctly.
+		if errors.Is(req.err, protocol.ErrUnsupportedVersion) {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupportedVersion, req.err)
+			return
+		}
+		// The operator deprecated the forwarder, which must be
+		// upgraded before it can send coredumps again.
+		if errors.Is(req.err, errOutdatedForwarder) {
+			writeError(w, http.StatusUpgradeRequired, ErrCodeOutdatedForwarder, req.err)
+			return
+		}
+		if errors.Is(req.err, errUnsupportedFormat) || errors.Is(req.err, errInvalidHash) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, req.err)
+			return
+		}
+		// The core was truncated on the way, the forwarder can send it
+		// again.
+		if errors.Is(req.err, errIncompleteCore) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, req.err)
+			return
+		}
+		if errors.Is(req.err, protocol.ErrUnsupportedCompression) {
+			writeError(w, http.StatusUnsupportedMediaType, ErrCodeInvalidRequest, req.err)
+			return
+		}
+		if errors.Is(req.err, errTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodeTooLarge, req.err)
+			return
+		}
+		if errors.Is(req.err, errInflightExceeded) {
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, req.err)
+			return
+		}
+		// Running out of space isn't something the forwarder can fix
+		// by retrying right away.
+		if errors.Is(req.err, syscall.ENOSPC) {
+			s.notifyStorageFull()
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusInsufficientStorage, ErrCodeStorageFull, req.err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, req.err)
 		return
 	}
 
@@ -91,28 +263,75 @@ func (s *service) indexCore(w http.ResponseWriter, r *http.Request, _ httprouter
 		"executable": req.coredump.Executable,
 	}).Observe(datasize.ByteSize(req.coredump.Size).MBytes())
 
-	s.analysisQueue <- req.coredump
+	s.audit(r, auditIndex, req.coredump.UID, req.coredump.ExecutablePath)
+	if !req.coredump.AnalysisSkipped {
+		s.queueAnalysis(req.coredump)
+	}
+	// The cores received before their executable can now be analyzed.
+	if req.req.IncludeExecutable {
+		_, err := s.executableStored(req.req.ExecutableHash)
+		if err != nil {
+			s.logger.Error("marking cores for analysis", "hash", req.req.ExecutableHash, "err", err)
+		}
+	}
+	s.enqueueRelay(req.req, req.coredump)
 
 	write(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
 }
 
 // analyzeCore handle the requests for re-analyzing a particular core. It
 // should be useful when new features are implemented to re-analyze already
-// existing cores and update them.
+// existing cores and update them, and to force the analysis of the cores too
+// large to be analyzed automatically.
 func (s *service) analyzeCore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	uid := p.ByName("uid")
 
 	c, err := s.index.Find(uid)
 	switch err {
 	case nil:
-		s.analysisQueue <- c
+		s.audit(r, auditAnalyze, uid, "")
+		s.queueAnalysis(c)
 		write(w, http.StatusAccepted, map[string]interface{}{"acknowledged": true})
 	case ErrNotFound:
-		writeError(w, http.StatusBadRequest, errors.New("unknown core"))
+		writeError(w, http.StatusBadRequest, ErrCodeNotFound, errors.New("unknown core"))
 	default:
 		s.logger.Error("analyzing", "uid", uid, "err", err)
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// detectCore handle the requests for re-running the detection steps of the
+// analysis on a particular core, which is done synchronously as those are
+// cheap. The detected values are returned.
+func (s *service) detectCore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+		break
+	case ErrNotFound:
+		writeError(w, http.StatusBadRequest, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("detecting", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
 	}
+
+	c, err = s.detect(c)
+	if err != nil {
+		s.logger.Error("detecting", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	s.audit(r, auditDetect, uid, "")
+
+	write(w, http.StatusOK, map[string]interface{}{
+		"acknowledged":      true,
+		"executable_format": c.ExecutableFormat,
+		"lang":              c.Lang,
+	})
 }
 
 // searchCore handle the requests to search cores matching a number of parameters.
@@ -120,11 +339,40 @@ func (s *service) searchCore(w http.ResponseWriter, r *http.Request, _ httproute
 	var err error
 
 	q := r.FormValue("q")
+	sort := r.FormValue("sort")
+	order := r.FormValue("order")
+
+	// A saved search gives the query, and the default sort of the
+	// results.
+	if name := r.FormValue("search"); len(name) != 0 {
+		if len(q) != 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("q and search parameters are mutually exclusive"))
+			return
+		}
+		saved, err := s.searches.Get(name)
+		switch err {
+		case nil:
+		case ErrNotFound:
+			writeError(w, http.StatusBadRequest, ErrCodeNotFound, fmt.Errorf("unknown saved search '%s'", name))
+			return
+		default:
+			s.logger.Error("reading saved search", "name", name, "err", err)
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+			return
+		}
+		q = saved.Query
+		if len(sort) == 0 {
+			sort = saved.Sort
+		}
+		if len(order) == 0 {
+			order = saved.Order
+		}
+	}
+
 	if len(q) == 0 {
 		q = "*"
 	}
 
-	sort := r.FormValue("sort")
 	if len(sort) == 0 {
 		sort = "dumped_at"
 	}
@@ -132,11 +380,10 @@ func (s *service) searchCore(w http.ResponseWriter, r *http.Request, _ httproute
 	case "dumped_at", "hostname":
 		break
 	default:
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sort field '%s'", sort))
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid sort field '%s'", sort))
 		return
 	}
 
-	order := r.FormValue("order")
 	if len(order) == 0 {
 		order = "desc"
 	}
@@ -144,19 +391,28 @@ func (s *service) searchCore(w http.ResponseWriter, r *http.Request, _ httproute
 	case "asc", "desc":
 		break
 	default:
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sort order '%s'", order))
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid sort order '%s'", order))
 		return
 	}
 
+	size := s.searchDefaultSize
 	rawSize := r.FormValue("size")
-	if len(rawSize) == 0 {
-		rawSize = "50"
+	if len(rawSize) != 0 {
+		size, err = strconv.Atoi(rawSize)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid size parameter"))
+			return
+		}
 	}
-	size, err := strconv.Atoi(rawSize)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, wrap(err, "invalid size parameter"))
+	if size < 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid size parameter: must be positive"))
 		return
 	}
+	// Mapping the documents is expensive, so the size is capped to avoid
+	// a single request taking the server down.
+	if size > s.searchMaxSize {
+		size = s.searchMaxSize
+	}
 
 	rawFrom := r.FormValue("from")
 	if len(rawFrom) == 0 {
@@ -164,24 +420,177 @@ func (s *service) searchCore(w http.ResponseWriter, r *http.Request, _ httproute
 	}
 	from, err := strconv.Atoi(rawFrom)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, wrap(err, "invalid from parameter"))
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid from parameter"))
+		return
+	}
+	if from < 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid from parameter: must be positive"))
 		return
 	}
 
-	res, total, err := s.index.Search(q, sort, order, size, from)
+	// The trace can be huge and is only needed when looking at a specific
+	// core, so it isn't returned by default. A wildcard returns every
+	// field.
+	var fields []string
+	rawFields := r.FormValue("fields")
+	switch rawFields {
+	case "":
+		for _, f := range CoredumpFields() {
+			if f != "trace" {
+				fields = append(fields, f)
+			}
+		}
+	case "*":
+		fields = nil
+	default:
+		known := make(map[string]bool)
+		for _, f := range CoredumpFields() {
+			known[f] = true
+		}
+		for _, f := range strings.Split(rawFields, ",") {
+			if !known[f] {
+				writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid field '%s'", f))
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	// The deleted cores are still searchable during their grace period,
+	// to recover them, but only on demand.
+	var includeDeleted bool
+	rawIncludeDeleted := r.FormValue("include_deleted")
+	if len(rawIncludeDeleted) != 0 {
+		includeDeleted, err = strconv.ParseBool(rawIncludeDeleted)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid include_deleted parameter"))
+			return
+		}
+	}
+
+	var highlight bool
+	rawHighlight := r.FormValue("highlight")
+	if len(rawHighlight) != 0 {
+		highlight, err = strconv.ParseBool(rawHighlight)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid highlight parameter"))
+			return
+		}
+	}
+
+	// The results only change with the index, so the clients can check
+	// the ones they have are still current instead of fetching them
+	// again.
+	if s.indexChanges != nil {
+		etag := s.indexChanges.etag(q, sort, order, size, from, rawFields, includeDeleted, highlight)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	rw := &resultWriter{w: w}
+	total, err := s.index.SearchFunc(SearchRequest{
+		Query:          q,
+		Sort:           sort,
+		Order:          order,
+		Size:           size,
+		From:           from,
+		Fields:         fields,
+		Highlight:      highlight,
+		IncludeDeleted: includeDeleted,
+	}, rw.write)
+	if err != nil && !rw.started() {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
+		// The status is already sent, so the best we can do is to
+		// interrupt the response, which the client will notice as
+		// invalid JSON.
+		s.logger.Error("searching", "err", err)
 		return
 	}
 
-	write(w, http.StatusOK, SearchResult{Results: res, Total: total})
+	err = rw.close(total, size)
+	if err != nil {
+		s.logger.Error("writing response", "err", err)
+	}
+}
+
+// resultWriter writes a SearchResult to the ResponseWriter one coredump at a
+// time, so the results don't have to be held in memory. The status is only
+// sent with the first coredump, so an error happening before can still be
+// reported properly.
+type resultWriter struct {
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+// started reports whether the response was started.
+func (rw *resultWriter) started() bool {
+	return rw.enc != nil
+}
+
+// start sends the status and the beginning of the SearchResult.
+func (rw *resultWriter) start() error {
+	rw.w.WriteHeader(http.StatusOK)
+	rw.enc = json.NewEncoder(rw.w)
+	_, err := io.WriteString(rw.w, `{"results":[`)
+	return err
+}
+
+// write a hit of the results.
+func (rw *resultWriter) write(h Hit) error {
+	var err error
+	if !rw.started() {
+		err = rw.start()
+	} else {
+		_, err = io.WriteString(rw.w, `,`)
+	}
+	if err != nil {
+		return err
+	}
+
+	return rw.enc.Encode(h)
 }
 
-// getCore handles the requests to get the actual core dump file.
+// close writes the end of the SearchResult.
+func (rw *resultWriter) close(total uint64, size int) error {
+	if !rw.started() {
+		err := rw.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(rw.w, `],"total":%d,"size":%d}`, total, size)
+	return err
+}
+
+// getCore handles the requests to get the actual core dump file. Clients
+// accepting JSON get the indexed document instead, with every field.
 func (s *service) getCore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// The router doesn't allow a static route next to the uid parameter.
+	if p.ByName("uid") == "_histogram" {
+		s.histogram(w, r, p)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		s.getCoreDocument(w, r, p)
+		return
+	}
+
 	f, err := s.store.Core(p.ByName("uid"))
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+		return
+	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
 		return
 	}
 	defer f.Close()
@@ -192,20 +601,373 @@ func (s *service) getCore(w http.ResponseWriter, r *http.Request, p httprouter.P
 	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 }
 
-// deleteCore handle the request to remove a coredump.
+// getCoreDocument handles the requests to get the indexed document of a core.
+func (s *service) getCoreDocument(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+		write(w, http.StatusOK, c)
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// getTrace handles the requests to get the stack trace of a core. Large traces
+// are only partially indexed, in which case the full trace is read from the
+// store.
+func (s *service) getTrace(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	if !c.TraceTruncated {
+		if len(c.Trace) == 0 {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("no trace"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, c.Trace)
+		return
+	}
+
+	trace, err := s.store.Trace(uid)
+	if err != nil {
+		s.logger.Error("getting trace", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	defer trace.Close()
+
+	// The trace may be decompressed on the fly, so its size isn't known
+	// and it can't be served by ranges.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = io.Copy(w, trace)
+	if err != nil {
+		s.logger.Error("sending trace", "uid", uid, "err", err)
+	}
+}
+
+// listArtifacts handles the requests to list the artifacts of the analysis of
+// a core.
+func (s *service) listArtifacts(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	_, err := s.index.Find(uid)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	artifacts, err := s.store.Artifacts(uid)
+	if err != nil {
+		s.logger.Error("listing artifacts", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	write(w, http.StatusOK, map[string]interface{}{"artifacts": artifacts})
+}
+
+// getArtifact handles the requests to get an artifact of the analysis of a
+// core.
+func (s *service) getArtifact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid, name := p.ByName("uid"), p.ByName("name")
+
+	// The names are file names in the store.
+	if !artifactName.MatchString(name) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+		return
+	}
+
+	f, err := s.store.Artifact(uid, name)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	defer f.Close()
+
+	// We ignore the error here, because the zero-value is fine in case of
+	// error.
+	info, _ := f.Stat()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// getRegisters handles the requests to get the registers of the thread that
+// received the signal, by name.
+func (s *service) getRegisters(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	if len(c.Registers) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("no registers"))
+		return
+	}
+	write(w, http.StatusOK, c.Registers)
+}
+
+// getMissing handles the requests to get the libraries mapped by the process
+// that are missing from the ones stored alongside the executable. Those can be
+// uploaded using the uploadFile endpoint.
+func (s *service) getMissing(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	missing := c.MissingLibraries
+	if missing == nil {
+		missing = []string{}
+	}
+	write(w, http.StatusOK, missing)
+}
+
+// uploadFile handles the requests to upload a library missing from the
+// analysis of a core, given by its path on the origin host in the path
+// parameter, the body being the content of the file (not a form, which would
+// be consumed by delayRequest). The library is stored alongside the
+// executable, so it serves every core of the executable, and the core is
+// analyzed again.
+func (s *service) uploadFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	uid := p.ByName("uid")
+	path := r.URL.Query().Get("path")
+
+	c, err := s.index.Find(uid)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("unknown core"))
+		return
+	default:
+		s.logger.Error("getting core", "uid", uid, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	// Only the libraries actually mapped by the process are accepted, so
+	// arbitrary files can't be added to the sysroot.
+	var missing bool
+	for _, library := range c.MissingLibraries {
+		if library == path {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf(`%q isn't a missing library of the core`, path))
+		return
+	}
+
+	_, err = s.storeFile(c.ExecutableHash, path, "", r.Body)
+	if err != nil {
+		s.logger.Error("storing library", "uid", uid, "path", path, "err", err)
+		writeFileError(w, err)
+		return
+	}
+
+	s.audit(r, auditUploadFile, uid, path)
+	s.queueAnalysis(c)
+	write(w, http.StatusAccepted, map[string]interface{}{"acknowledged": true})
+}
+
+// uploadExecutableFile handles the requests to upload a file for an
+// executable, given by its path on the origin host in the path parameter, the
+// body being the content of the file (see uploadFile). This is used for the
+// libraries that couldn't be sent by the forwarder, and for the separate
+// debug files (e.g: /usr/lib/debug/.build-id/xx/yyy.debug), which the debugger
+// looks for in the sysroot. If the build_id parameter is given, the file must
+// have the same build-id. This is also used for the executable itself when it
+// isn't stored, e.g: because the forwarder failed to send it. The cores that
+// can benefit from the file are analyzed again.
+func (s *service) uploadExecutableFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	hash := p.ByName("hash")
+	if !validHash(hash) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf(`%w %q`, errInvalidHash, hash))
+		return
+	}
+	path := r.URL.Query().Get("path")
+	buildID := r.URL.Query().Get("build_id")
+	if !filepath.IsAbs(path) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf(`path must be absolute, got %q`, path))
+		return
+	}
+
+	executable, err := s.storeFile(hash, path, buildID, r.Body)
+	if err != nil {
+		s.logger.Error("storing file", "hash", hash, "path", path, "err", err)
+		writeFileError(w, err)
+		return
+	}
+
+	s.audit(r, auditUploadFile, hash, path)
+
+	// A library only benefits to the cores it is missing from, while a
+	// debug file can benefit to any core of the executable. The executable
+	// itself benefits to the cores awaiting it.
+	var count int
+	switch {
+	case executable:
+		count, err = s.executableStored(hash)
+	case isLibrary(path):
+		count, err = s.markUnanalyzed(map[string]string{
+			"executable_hash":   hash,
+			"missing_libraries": path,
+		})
+	default:
+		count, err = s.markUnanalyzed(map[string]string{"executable_hash": hash})
+	}
+	if err != nil {
+		s.logger.Error("marking cores for analysis", "hash", hash, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	write(w, http.StatusAccepted, map[string]interface{}{"acknowledged": true, "cores": count})
+}
+
+// Errors returned by storeFile.
+var (
+	errUnknownExecutable = errors.New("unknown executable")
+	errBuildIDMismatch   = errors.New("build-id mismatch")
+)
+
+// storeFile stores a file of an executable in its sysroot, at the given path.
+// If buildID isn't empty, the file is checked to have the same build-id
+// before being stored. If the executable isn't stored yet, e.g: because its
+// upload by the forwarder failed, the file is stored as the executable if it
+// has its hash, and executable is true.
+func (s *service) storeFile(hash, path, buildID string, src io.Reader) (executable bool, err error) {
+	// The file is written to a temporary file first so it can be checked
+	// without holding the lock of the executable during the upload.
+	tmp, err := ioutil.TempFile(s.dataDir, "upload-")
+	if err != nil {
+		return false, wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return false, wrap(err, "reading file")
+	}
+
+	if len(buildID) != 0 {
+		file, err := elf.NewFile(tmp)
+		if err != nil {
+			return false, fmt.Errorf(`%w: reading file: %s`, errBuildIDMismatch, err)
+		}
+		actual, err := elfx.File{Path: path, File: file}.BuildID()
+		if err != nil {
+			return false, wrap(err, "reading build-id")
+		}
+		if !strings.EqualFold(actual, buildID) {
+			return false, fmt.Errorf(`%w: expected %q, got %q`, errBuildIDMismatch, buildID, actual)
+		}
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, wrap(err, "rewinding file")
+	}
+
+	// The executable must not be removed by a cleanup while its file is
+	// being stored.
+	s.executableLocks.Lock(hash)
+	defer s.executableLocks.Unlock(hash)
+
+	exists, err := s.store.ExecutableExists(hash)
+	if err != nil {
+		return false, wrap(err, "looking up executable")
+	}
+	if !exists {
+		if hex.EncodeToString(h.Sum(nil)) != hash {
+			return false, errUnknownExecutable
+		}
+		_, err = s.store.StoreExecutable(hash, tmp)
+		return err == nil, err
+	}
+
+	_, err = s.store.StoreLink(hash, Link{Name: filepath.Base(path), Path: path, Found: true}, tmp)
+	return false, err
+}
+
+// writeFileError writes the error returned by storeFile.
+func writeFileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnknownExecutable):
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, err)
+	case errors.Is(err, errBuildIDMismatch):
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+	case errors.Is(err, syscall.ENOSPC):
+		writeError(w, http.StatusInsufficientStorage, ErrCodeStorageFull, err)
+	default:
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// deleteCore handle the request to remove a coredump. If a grace period is
+// configured, the coredump is only marked as deleted, and deleting it again
+// removes it for good.
 func (s *service) deleteCore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	uid := p.ByName("uid")
 
 	c, err := s.index.Find(uid)
 	switch err {
 	case nil:
-		s.cleanupQueue <- c
+		s.audit(r, auditDelete, uid, "")
+		s.cleanupQueue <- cleanupItem{core: c, reason: cleanupRequest}
 		write(w, http.StatusAccepted, map[string]interface{}{"acknowledged": true})
 	case ErrNotFound:
-		writeError(w, http.StatusBadRequest, errors.New("unknown core"))
+		writeError(w, http.StatusBadRequest, ErrCodeNotFound, errors.New("unknown core"))
 	default:
 		s.logger.Error("analyzing", "uid", uid, "err", err)
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
 		return
 	}
 }
@@ -213,15 +975,21 @@ func (s *service) deleteCore(w http.ResponseWriter, r *http.Request, p httproute
 // lookupExecutable handles the requests to check if a executable matching the given
 // hash actually exists. It doesn't return anything (except in case of error).
 func (s *service) lookupExecutable(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	exists, err := s.store.ExecutableExists(p.ByName("hash"))
+	hash := p.ByName("hash")
+	if !validHash(hash) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf(`%w %q`, errInvalidHash, hash))
+		return
+	}
+
+	exists, err := s.store.ExecutableExists(hash)
 	if err != nil {
-		s.logger.Warn("looking up executable", "hash", p.ByName("hash"), "err", err)
-		writeError(w, http.StatusInternalServerError, err)
+		s.logger.Warn("looking up executable", "hash", hash, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
 		return
 	}
 
 	if !exists {
-		writeError(w, http.StatusNotFound, errors.New(`not found`))
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
 		return
 	}
 
@@ -230,9 +998,19 @@ func (s *service) lookupExecutable(w http.ResponseWriter, r *http.Request, p htt
 
 // getExecutable handles the requests to get the actual executable.
 func (s *service) getExecutable(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	f, err := s.store.Executable(p.ByName("hash"))
+	hash := p.ByName("hash")
+	if !validHash(hash) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf(`%w %q`, errInvalidHash, hash))
+		return
+	}
+
+	f, err := s.store.Executable(hash)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+		return
+	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
 		return
 	}
 	defer f.Close()
@@ -242,3 +1020,265 @@ func (s *service) getExecutable(w http.ResponseWriter, r *http.Request, p httpro
 	info, _ := f.Stat()
 	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 }
+
+// backupNow handles the requests to snapshot the index on demand.
+func (s *service) backupNow(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if len(s.backupDir) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeNotConfigured, errors.New(`index backups aren't configured`))
+		return
+	}
+
+	path, err := s.backup()
+	if err != nil {
+		s.logger.Error("backing up index", "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	s.audit(r, auditBackup, path, "")
+	write(w, http.StatusOK, map[string]interface{}{"acknowledged": true, "path": path})
+}
+
+// Number of entries returned by getAudit by default, and at most.
+const (
+	auditDefaultSize = 100
+	auditMaxSize     = 10000
+)
+
+// getAudit handles the requests to read the most recent entries of the audit
+// log, the number of entries being given by the size parameter.
+func (s *service) getAudit(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	size := auditDefaultSize
+	rawSize := r.FormValue("size")
+	if len(rawSize) != 0 {
+		var err error
+		size, err = strconv.Atoi(rawSize)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid size parameter"))
+			return
+		}
+	}
+	if size < 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid size parameter: must be positive"))
+		return
+	}
+	if size > auditMaxSize {
+		size = auditMaxSize
+	}
+
+	entries, err := s.auditLog.Recent(size)
+	if err != nil {
+		s.logger.Error("reading audit log", "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	write(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// listSearches handles the requests to list the saved searches.
+func (s *service) listSearches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	searches, err := s.searches.List()
+	if err != nil {
+		s.logger.Error("listing saved searches", "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	write(w, http.StatusOK, map[string]interface{}{"searches": searches})
+}
+
+// getSearch handles the requests to get a saved search.
+func (s *service) getSearch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	search, err := s.searches.Get(p.ByName("name"))
+	switch err {
+	case nil:
+		write(w, http.StatusOK, search)
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+	default:
+		s.logger.Error("reading saved search", "name", p.ByName("name"), "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// saveSearch handles the requests to save a search, replacing the one with the
+// same name if any. The body is the SavedSearch, the sort being optional.
+func (s *service) saveSearch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var search SavedSearch
+	err := json.NewDecoder(r.Body).Decode(&search)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "reading request"))
+		return
+	}
+
+	// The name is part of the URL of the search.
+	if len(search.Name) == 0 || strings.Contains(search.Name, "/") {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid name '%s'", search.Name))
+		return
+	}
+	if len(search.Query) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("empty query"))
+		return
+	}
+
+	if len(search.Sort) == 0 {
+		search.Sort = "dumped_at"
+	}
+	switch search.Sort {
+	case "dumped_at", "hostname":
+		break
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid sort field '%s'", search.Sort))
+		return
+	}
+
+	if len(search.Order) == 0 {
+		search.Order = "desc"
+	}
+	switch search.Order {
+	case "asc", "desc":
+		break
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid sort order '%s'", search.Order))
+		return
+	}
+
+	search.UpdatedAt = time.Now()
+	err = s.searches.Save(search)
+	if err != nil {
+		s.logger.Error("saving search", "name", search.Name, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	s.audit(r, auditSaveSearch, search.Name, search.Query)
+	write(w, http.StatusOK, search)
+}
+
+// deleteSearch handles the requests to delete a saved search.
+func (s *service) deleteSearch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := p.ByName("name")
+	err := s.searches.Delete(name)
+	switch err {
+	case nil:
+		s.audit(r, auditDropSearch, name, "")
+		write(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, errors.New(`not found`))
+	default:
+		s.logger.Error("deleting saved search", "name", name, "err", err)
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// getAlerts handles the requests to get the state of the alerting rules.
+func (s *service) getAlerts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	write(w, http.StatusOK, map[string]interface{}{"rules": s.alerts.States()})
+}
+
+// Limits of the histograms.
+const (
+	histogramMaxBuckets  = 1000
+	histogramDefaultSize = 10
+	histogramMaxSize     = 100
+)
+
+// histogram handles the requests to count the cores matching the query q over
+// time, by buckets of the given interval between start and end (by default,
+// the last 24 hours by hour). The cores can be grouped by the values of a
+// field, the size most frequent values having their own series.
+func (s *service) histogram(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var err error
+
+	q := r.FormValue("q")
+	if len(q) == 0 {
+		q = "*"
+	}
+
+	interval := time.Hour
+	rawInterval := r.FormValue("interval")
+	if len(rawInterval) != 0 {
+		interval, err = time.ParseDuration(rawInterval)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid interval parameter"))
+			return
+		}
+	}
+	if interval <= 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid interval parameter: must be positive"))
+		return
+	}
+
+	end := time.Now().UTC()
+	rawEnd := r.FormValue("end")
+	if len(rawEnd) != 0 {
+		end, err = time.Parse(time.RFC3339, rawEnd)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid end parameter"))
+			return
+		}
+	}
+
+	start := end.Add(-24 * time.Hour)
+	rawStart := r.FormValue("start")
+	if len(rawStart) != 0 {
+		start, err = time.Parse(time.RFC3339, rawStart)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid start parameter"))
+			return
+		}
+	}
+	if !start.Before(end) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid start parameter: must be before end"))
+		return
+	}
+	if (end.Sub(start.Truncate(interval))+interval-1)/interval > histogramMaxBuckets {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid interval parameter: more than %d buckets", histogramMaxBuckets))
+		return
+	}
+
+	by := r.FormValue("by")
+	if len(by) != 0 && !strings.HasPrefix(by, "meta.") {
+		known := false
+		for _, f := range CoredumpFields() {
+			known = known || f == by
+		}
+		if !known {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid by field '%s'", by))
+			return
+		}
+	}
+
+	size := histogramDefaultSize
+	rawSize := r.FormValue("size")
+	if len(rawSize) != 0 {
+		size, err = strconv.Atoi(rawSize)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, wrap(err, "invalid size parameter"))
+			return
+		}
+	}
+	if size <= 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid size parameter: must be strictly positive"))
+		return
+	}
+	if size > histogramMaxSize {
+		size = histogramMaxSize
+	}
+
+	h, err := s.index.Histogram(HistogramRequest{
+		Query:    q,
+		Start:    start.UTC(),
+		End:      end.UTC(),
+		Interval: interval,
+		By:       by,
+		Size:     size,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+
+	write(w, http.StatusOK, h)
+}
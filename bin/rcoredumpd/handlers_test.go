@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/protocol"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ingestCoreSizes are the sizes of the cores sent by BenchmarkService_IndexCore,
+// and ingestBufferSizes the sizes of the buffers they are read through.
+var (
+	ingestCoreSizes   = flag.String("ingest-core-sizes", "1MB,16MB,64MB", "sizes of the cores sent by the ingest benchmark, separated by commas")
+	ingestBufferSizes = flag.String("ingest-buffer-sizes", "4KB,1MB", "sizes of the buffers the cores are read through by the ingest benchmark, separated by commas")
+)
+
+func TestService_Routes(t *testing.T) {
+	s := &service{}
+	router := s.routes()
+
+	for n, c := range map[string]struct {
+		method string
+		path   string
+		status int
+		allow  string
+		want   Error
+	}{
+		"unknown endpoint": {
+			method: http.MethodGet,
+			path:   "/unknown",
+			status: http.StatusNotFound,
+			want:   Error{Code: ErrCodeNotFound, Err: `endpoint "/unknown" not found`},
+		},
+		"wrong method": {
+			method: http.MethodPut,
+			path:   "/cores/uid",
+			status: http.StatusMethodNotAllowed,
+			allow:  "DELETE, GET, OPTIONS",
+			want:   Error{Code: ErrCodeMethodNotAllowed, Err: `method "PUT" not allowed for endpoint "/cores/uid"`},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+			if w.Code != c.status {
+				t.Errorf(`wanted status %d, got %d`, c.status, w.Code)
+			}
+			if got := w.Header().Get("Allow"); got != c.allow {
+				t.Errorf(`wanted Allow header %q, got %q`, c.allow, got)
+			}
+			var got Error
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatalf(`decoding body %q: %s`, w.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestService_ExecutableHash(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreCore("victim", strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{dataDir: root, store: store, logger: logger, auditLog: &auditLog{}}
+	router := s.routes()
+
+	// The hashes reaching other files of the store are refused before
+	// touching it.
+	for n, c := range map[string]struct {
+		method string
+		path   string
+	}{
+		"parent directory upload":  {method: http.MethodPost, path: "/executables/%2E%2E/files?path=/cores/victim"},
+		"current directory upload": {method: http.MethodPost, path: "/executables/%2E/files?path=/usr/lib/libc.so.6"},
+		"parent directory lookup":  {method: http.MethodHead, path: "/executables/%2E%2E"},
+		"parent directory get":     {method: http.MethodGet, path: "/executables/%2E%2E"},
+		"uppercase get":            {method: http.MethodGet, path: "/executables/" + strings.ToUpper(testHash)},
+	} {
+		t.Run(n, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader("overwritten")))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf(`wanted status %d, got %d`, http.StatusBadRequest, w.Code)
+			}
+
+			got, err := ioutil.ReadFile(filepath.Join(root, "cores", "victim"))
+			if err != nil || string(got) != "core" {
+				t.Errorf(`wanted the core to be kept, got %q, %v`, got, err)
+			}
+		})
+	}
+}
+
+// discardStore is a Store writing the cores to the null device instead of
+// files, so the ingest path is measured without the disk but with the system
+// calls. They are copied through a buffer of the given size, as the FileStore
+// does.
+type discardStore struct {
+	Store
+	bufferSize int
+}
+
+func (s discardStore) StoreCore(uid string, src io.Reader) (int64, error) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return copyBuffer(f, src, s.bufferSize)
+}
+
+func (discardStore) ExecutableExists(hash string) (bool, error) {
+	return false, nil
+}
+
+// newIngestService returns a service able to receive cores, read through
+// buffers of the given size and discarded once indexed.
+func newIngestService(tb testing.TB, bufferSize int) *service {
+	tb.Helper()
+
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		tb.Fatalf(`creating temporary directory: %s`, err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(root) })
+	store, err := NewFileStore(root, false, bufferSize)
+	if err != nil {
+		tb.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:         newTestIndex(tb),
+		store:         discardStore{Store: store, bufferSize: bufferSize},
+		ioBufferBytes: bufferSize,
+		logger:        logger,
+		auditLog:      &auditLog{},
+		analysisQueue: make(chan Coredump, 100),
+		received:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "received"}, []string{"hostname", "executable"}),
+		receivedSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "sizes"}, []string{"hostname", "executable"}),
+	}
+
+	// The queued cores aren't analyzed.
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.analysisQueue:
+			case <-done:
+				return
+			}
+		}
+	}()
+	tb.Cleanup(func() { close(done) })
+	return s
+}
+
+// newIngestBody returns the body of a request sending a synthetic core of the
+// given size. Half of the core is random, so it compresses like a real one.
+func newIngestBody(tb testing.TB, size int) []byte {
+	tb.Helper()
+
+	core := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(core[:size/2])
+
+	var body bytes.Buffer
+	err := protocol.Encode(&body, IndexRequest{
+		DumpedAt:        time.Now(),
+		Hostname:        "bench",
+		ExecutableHash:  testHash,
+		ExecutablePath:  "/usr/bin/bench",
+		ProtocolVersion: protocol.Version,
+	}, bytes.NewReader(core), nil, nil)
+	if err != nil {
+		tb.Fatalf(`Encode(): unexpected error: %s`, err)
+	}
+	return body.Bytes()
+}
+
+// ingest sends the body to the indexCore endpoint.
+func ingest(tb testing.TB, s *service, body []byte) {
+	w := httptest.NewRecorder()
+	s.indexCore(w, httptest.NewRequest(http.MethodPost, "/cores", bytes.NewReader(body)), nil)
+	if w.Code != http.StatusOK {
+		tb.Fatalf(`indexCore(): wanted status %d, got %d: %s`, http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+// benchSize is a size given to a benchmark, along with its representation.
+type benchSize struct {
+	raw   string
+	bytes int
+}
+
+// parseSizes parses a list of sizes separated by commas.
+func parseSizes(tb testing.TB, raw string) []benchSize {
+	tb.Helper()
+
+	var sizes []benchSize
+	for _, r := range strings.Split(raw, ",") {
+		var size datasize.ByteSize
+		err := size.UnmarshalText([]byte(r))
+		if err != nil {
+			tb.Fatalf(`invalid size %q: %s`, r, err)
+		}
+		sizes = append(sizes, benchSize{raw: r, bytes: int(size.Bytes())})
+	}
+	return sizes
+}
+
+func BenchmarkService_IndexCore(b *testing.B) {
+	for _, size := range parseSizes(b, *ingestCoreSizes) {
+		body := newIngestBody(b, size.bytes)
+		for _, buffer := range parseSizes(b, *ingestBufferSizes) {
+			b.Run(fmt.Sprintf("core=%s/buffer=%s", size.raw, buffer.raw), func(b *testing.B) {
+				s := newIngestService(b, buffer.bytes)
+				b.SetBytes(int64(size.bytes))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ingest(b, s, body)
+				}
+			})
+		}
+	}
+}
+
+// TestService_IndexCore_Allocations checks that the memory allocated by the
+// ingest path doesn't grow with the size of the core, i.e the core is streamed
+// to the store instead of being buffered.
+func TestService_IndexCore_Allocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation profiling in short mode")
+	}
+
+	s := newIngestService(t, 1<<20)
+	allocated := func(size int) uint64 {
+		body := newIngestBody(t, size)
+		ingest(t, s, body)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < 5; i++ {
+			ingest(t, s, body)
+		}
+		runtime.ReadMemStats(&after)
+		return (after.TotalAlloc - before.TotalAlloc) / 5
+	}
+
+	// The index allocates a varying amount of memory on each run, so the
+	// difference is compared to the difference of sizes, which it would
+	// reach if the core was buffered even once.
+	small, large := allocated(1<<20), allocated(16<<20)
+	if large > small && large-small > (16<<20-1<<20)/2 {
+		t.Errorf(`wanted the allocations not to grow with the size of the core, got %d bytes for 1MB and %d bytes for 16MB`, small, large)
+	}
+}
@@ -1,22 +1,42 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/index/store/boltdb"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	bolt "github.com/etcd-io/bbolt"
 	structmapper "gopkg.in/anexia-it/go-structmapper.v1"
 )
 
 type Index interface {
 	Index(Coredump) error
 	Find(string) (Coredump, error)
+	Update(string, func(*Coredump) error) error
 	Delete(string) error
 	Search(string, string, string, int, int) ([]Coredump, uint64, error)
+	SearchFunc(SearchRequest, func(Hit) error) (uint64, error)
+	Count(string) (uint64, error)
+	CountRange(string, time.Time, time.Time) (uint64, error)
+	Facets(string, []string, int) ([]Facet, error)
+	Histogram(HistogramRequest) (Histogram, error)
+	Backup(string) error
+	CapMetadata(Coredump) (Coredump, error)
 }
 
 var (
@@ -24,6 +44,9 @@ var (
 )
 
 type BleveIndex struct {
+	// path of the index on disk.
+	path string
+
 	// the index is the actual struct we are interfacing with.
 	index bleve.Index
 
@@ -34,42 +57,228 @@ type BleveIndex struct {
 	// fields. In addition, this allows searching on those fields, which
 	// isn't possible by default.
 	mapper *structmapper.Mapper
+
+	// fields tracks the metadata keys of the index to cap their number,
+	// nil for a read-only index.
+	fields *metadataFields
+	// onCapped is called with the metadata keys of a core that weren't
+	// indexed because of the cap, if not nil.
+	onCapped func(uid string, keys []string)
+	// onMalformed is called with the documents skipped by the searches
+	// because they can't be mapped to a core, if not nil.
+	onMalformed func(uid string, err error)
+	// updates serializes the updates of a given core. See Update.
+	updates *keyedMutex
 }
 
 // compile-time check that the BleveIndex actually implements the Index
 // interface.
 var _ Index = new(BleveIndex)
 
-func NewBleveIndex(path string) (Index, error) {
+// NewBleveIndex opens the index at path, creating it if necessary. A
+// read-only index must already exist, and is opened without write access so
+// it can be shared with another instance. The number of distinct metadata keys
+// indexed is capped by maxMetadataFields, zero meaning no limit, the keys
+// above being stored in the rejected_metadata field and given to onCapped.
+// The documents that can't be mapped to a core are skipped by the searches
+// and given to onMalformed.
+func NewBleveIndex(path string, readOnly bool, maxMetadataFields int, onCapped func(uid string, keys []string), onMalformed func(uid string, err error)) (Index, error) {
+	index, err := newBleveIndex(path, readOnly, maxMetadataFields, onCapped, onMalformed)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// newBleveIndex is NewBleveIndex, returning the actual BleveIndex.
+func newBleveIndex(path string, readOnly bool, maxMetadataFields int, onCapped func(uid string, keys []string), onMalformed func(uid string, err error)) (BleveIndex, error) {
 	_, err := os.Stat(path)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return nil, wrap(err, `checking for index`)
+		return BleveIndex{}, wrap(err, `checking for index`)
 	}
 
 	var index bleve.Index
-	if errors.Is(err, os.ErrNotExist) {
-		index, err = bleve.New(path, bleve.NewIndexMapping())
-	} else {
+	switch {
+	case readOnly:
+		index, err = bleve.OpenUsing(path, map[string]interface{}{
+			"read_only": true,
+		})
+	case errors.Is(err, os.ErrNotExist):
+		index, err = bleve.New(path, newIndexMapping())
+	default:
 		index, err = bleve.Open(path)
 	}
+	if isIndexCorrupted(err) {
+		return BleveIndex{}, wrap(corruptedIndexError{path: path, err: err}, `opening index`)
+	}
 	if err != nil {
-		return nil, wrap(err, `opening index`)
+		return BleveIndex{}, wrap(err, `opening index`)
 	}
 
 	// Initialize the structmapper to use the JSON tag. This avoid having
 	// to re-define every field with yet another tag.
 	mapper, err := structmapper.NewMapper(structmapper.OptionTagName("json"))
 	if err != nil {
-		return nil, wrap(err, `initializing mapper`)
+		return BleveIndex{}, wrap(err, `initializing mapper`)
+	}
+
+	var fields *metadataFields
+	if !readOnly {
+		fields, err = loadMetadataFields(filepath.Join(path, "metadata_fields.json"), maxMetadataFields, index.Fields)
+		if err != nil {
+			return BleveIndex{}, wrap(err, `loading metadata fields`)
+		}
 	}
 
 	return BleveIndex{
-		index:  index,
-		mapper: mapper,
+		path:        path,
+		index:       index,
+		mapper:      mapper,
+		fields:      fields,
+		onCapped:    onCapped,
+		onMalformed: onMalformed,
+		updates:     &keyedMutex{},
 	}, nil
 }
 
+// corruptedIndexError is returned when the index at path can't be opened
+// because its files are corrupted, e.g: by an unclean shutdown.
+type corruptedIndexError struct {
+	path string
+	err  error
+}
+
+func (e corruptedIndexError) Error() string {
+	return fmt.Sprintf(`index at %s is corrupted: %s`, e.path, e.err)
+}
+
+func (e corruptedIndexError) Unwrap() error {
+	return e.err
+}
+
+// replaceCorruptedIndex moves the corrupted index at path to backup, and
+// creates a new empty index in its place, so a shard set still finds all its
+// shards.
+func replaceCorruptedIndex(path, backup string) error {
+	err := os.Rename(path, backup)
+	if err != nil {
+		return wrap(err, `moving corrupted index`)
+	}
+
+	index, err := bleve.New(path, newIndexMapping())
+	if err != nil {
+		return wrap(err, `creating index`)
+	}
+	return index.Close()
+}
+
+// isIndexCorrupted reports whether the error of opening an index is caused by
+// corrupted files, as opposed to e.g: a permission issue.
+func isIndexCorrupted(err error) bool {
+	for _, corrupted := range []error{
+		bleve.ErrorIndexMetaMissing,
+		bleve.ErrorIndexMetaCorrupt,
+		bolt.ErrInvalid,
+		bolt.ErrChecksum,
+		bolt.ErrVersionMismatch,
+	} {
+		if errors.Is(err, corrupted) {
+			return true
+		}
+	}
+	return false
+}
+
+// newIndexMapping returns the mapping used for new indexes. Most fields use
+// bleve's dynamic mapping, only those that need a specific analyzer are
+// defined here.
+func newIndexMapping() mapping.IndexMapping {
+	m := bleve.NewIndexMapping()
+
+	// Symbols and functions are identifiers, they must be searched as a
+	// whole.
+	symbols := bleve.NewTextFieldMapping()
+	symbols.Analyzer = keyword.Name
+	m.DefaultMapping.AddFieldMappingsAt("symbols", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("functions", symbols)
+
+	// Signal names and commands are searched as a whole too (e.g:
+	// signal_name:SIGSEGV).
+	m.DefaultMapping.AddFieldMappingsAt("signal_name", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("command", symbols)
+
+	// Paths are searched as a whole too (e.g:
+	// missing_libraries:"/usr/lib/libfoo.so.1").
+	m.DefaultMapping.AddFieldMappingsAt("mapped_files", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("missing_libraries", symbols)
+
+	// Go versions and modules too (e.g: go_version:go1.21*).
+	m.DefaultMapping.AddFieldMappingsAt("go_version", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("go_module", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("go_module_version", symbols)
+	// The ABI too, but not the compilers, which are searched by their
+	// words (e.g: compilers:clang).
+	m.DefaultMapping.AddFieldMappingsAt("abi", symbols)
+	// The sanitizers and their errors too (e.g:
+	// sanitizer_error:heap-buffer-overflow).
+	m.DefaultMapping.AddFieldMappingsAt("sanitizer", symbols)
+	m.DefaultMapping.AddFieldMappingsAt("sanitizer_error", symbols)
+
+	// The metadata rejected by the allowlist are only stored, as indexing
+	// them is what the allowlist prevents.
+	stored := bleve.NewTextFieldMapping()
+	stored.Index = false
+	stored.IncludeInAll = false
+	m.DefaultMapping.AddFieldMappingsAt("rejected_metadata", stored)
+	// The links too, as they are only displayed, the ones missing being
+	// searched through links_complete.
+	m.DefaultMapping.AddFieldMappingsAt("links", stored)
+	// The events too, their names being indexed apart as a whole (e.g:
+	// event:analysis_failed).
+	m.DefaultMapping.AddFieldMappingsAt("events", stored)
+	m.DefaultMapping.AddFieldMappingsAt("event", symbols)
+
+	return m
+}
+
+// CapMetadata moves the metadata keys of the core above the cap of the index
+// to its rejected_metadata field. It is done by Index anyway, but calling it
+// first allows to keep the returned core, so the keys aren't capped again
+// when it is indexed again.
+func (i BleveIndex) CapMetadata(c Coredump) (Coredump, error) {
+	if i.fields == nil {
+		return c, nil
+	}
+
+	metadata, capped, err := i.fields.admit(c.Metadata)
+	if err != nil {
+		return c, wrap(err, `tracking metadata fields`)
+	}
+	if len(capped) == 0 {
+		return c, nil
+	}
+
+	c.Metadata = metadata
+	c.RejectedMetadata = mergeMetadata(c.RejectedMetadata, capped)
+	if i.onCapped != nil {
+		keys := make([]string, 0, len(capped))
+		for k := range capped {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		i.onCapped(c.UID, keys)
+	}
+	return c, nil
+}
+
 func (i BleveIndex) Index(c Coredump) error {
+	// Each metadata key is a field of the index, whose number must be
+	// kept in check. The keys above the cap are only stored.
+	c, err := i.CapMetadata(c)
+	if err != nil {
+		return err
+	}
+
 	m, err := i.mapper.ToMap(c)
 	if err != nil {
 		return wrap(err, `mapping Coredump`)
@@ -79,6 +288,42 @@ func (i BleveIndex) Index(c Coredump) error {
 		m[fmt.Sprintf("meta.%s", k)] = v
 	}
 
+	// The registers are stored as one hexadecimal string per register,
+	// because bleve stores numbers as float64, which can't hold every
+	// 64 bits value.
+	delete(m, "registers")
+	for k, v := range c.Registers {
+		m[fmt.Sprintf("registers.%s", k)] = fmt.Sprintf("%#x", v)
+	}
+
+	// The links are stored as a single JSON string, because bleve
+	// flattens the arrays of objects into one array per field, losing
+	// which path goes with which name.
+	delete(m, "links")
+	if len(c.Links) != 0 {
+		raw, err := json.Marshal(c.Links)
+		if err != nil {
+			return wrap(err, `encoding links`)
+		}
+		m["links"] = string(raw)
+	}
+
+	// The events are stored as a single JSON string for the same reason.
+	delete(m, "events")
+	if len(c.Events) != 0 {
+		raw, err := json.Marshal(c.Events)
+		if err != nil {
+			return wrap(err, `encoding events`)
+		}
+		m["events"] = string(raw)
+
+		names := make([]string, 0, len(c.Events))
+		for _, e := range c.Events {
+			names = append(names, e.Name)
+		}
+		m["event"] = names
+	}
+
 	return i.index.Index(c.UID, m)
 }
 
@@ -95,13 +340,445 @@ func (i BleveIndex) Find(uid string) (c Coredump, err error) {
 		return c, ErrNotFound
 	}
 
-	err = i.mapper.ToStruct(res.Hits[0].Fields, &c)
+	c, err = i.toCoredump(res.Hits[0].Fields)
 	if err != nil {
 		return c, wrap(err, `mapping result to coredump`)
 	}
 
+	return c, nil
+}
+
+// Update changes some fields of a core: bleve doesn't support partial
+// updates, so the core is read, modified by the mutate function, and indexed
+// again. The updates of a core are serialized, so none of them is lost, as
+// long as the existing cores are only modified this way. ErrNotFound is
+// returned if the core isn't indexed, the mutate function not being called,
+// and the core isn't indexed again if the mutate function fails.
+func (i BleveIndex) Update(uid string, mutate func(*Coredump) error) error {
+	i.updates.Lock(uid)
+	defer i.updates.Unlock(uid)
+
+	c, err := i.Find(uid)
+	if err != nil {
+		return err
+	}
+
+	err = mutate(&c)
+	if err != nil {
+		return err
+	}
+
+	return i.Index(c)
+}
+
+func (i BleveIndex) Delete(uid string) error {
+	return i.index.Delete(uid)
+}
+
+func (i BleveIndex) Search(q, sort, order string, size, from int) (cores []Coredump, total uint64, err error) {
+	total, err = i.SearchFunc(SearchRequest{
+		Query: q,
+		Sort:  sort,
+		Order: order,
+		Size:  size,
+		From:  from,
+	}, func(h Hit) error {
+		cores = append(cores, h.Coredump)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return cores, total, nil
+}
+
+// SearchRequest are the parameters of a search.
+type SearchRequest struct {
+	// Query string, see bleve's query string syntax.
+	Query string
+	// Field to sort the results by, and order of the sort (asc or desc).
+	Sort  string
+	Order string
+	// Number of results to return, and offset of the first one.
+	Size int
+	From int
+	// Fields of the coredumps to fill, or nil for every field. See
+	// CoredumpFields.
+	Fields []string
+	// Highlight the parts of the fields matching the query.
+	Highlight bool
+	// IncludeDeleted returns the cores deleted but still in their grace
+	// period, which are excluded by default.
+	IncludeDeleted bool
+	// Terms the coredumps must have, by field, in addition to matching the
+	// query. Unlike the query, they aren't parsed, so they can hold values
+	// given by the users. The query can be empty if they are set.
+	Terms map[string]string
+}
+
+// query returns the bleve query of the search.
+func (r SearchRequest) query() query.Query {
+	var q query.Query = bleve.NewQueryStringQuery(r.Query)
+	if len(r.Terms) != 0 {
+		c := bleve.NewConjunctionQuery()
+		if r.Query != "" {
+			c.AddQuery(q)
+		}
+		for field, value := range r.Terms {
+			term := bleve.NewTermQuery(value)
+			term.SetField(field)
+			c.AddQuery(term)
+		}
+		q = c
+	}
+	if r.IncludeDeleted {
+		return q
+	}
+
+	deleted := bleve.NewBoolFieldQuery(true)
+	deleted.SetField("deleted")
+	b := bleve.NewBooleanQuery()
+	b.AddMust(q)
+	b.AddMustNot(deleted)
+	return b
+}
+
+// searchPageSize is the number of documents loaded at once by SearchFunc.
+const searchPageSize = 100
+
+// SearchFunc calls fn for each coredump matching the search, in order, and
+// returns the total number of matching coredumps. The documents are loaded by
+// pages, so the memory usage doesn't depend on the size of the search. An
+// error returned by fn stops the search.
+//
+// A document that can't be mapped to a coredump is skipped and given to
+// onMalformed, rather than failing the search for the others. It is still
+// counted in the total.
+func (i BleveIndex) SearchFunc(r SearchRequest, fn func(Hit) error) (total uint64, err error) {
+	sort := r.Sort
+	if r.Order == "desc" {
+		sort = "-" + sort
+	}
+
+	// The metadata and registers are stored as one field per key, which
+	// can't be selected without knowing the keys, so every field is loaded
+	// and the unwanted ones are filtered out.
+	keep := make(map[string]bool, len(r.Fields))
+	for _, f := range r.Fields {
+		keep[f] = true
+	}
+	loadFields := r.Fields
+	if r.Fields == nil || keep["metadata"] || keep["registers"] {
+		loadFields = []string{"*"}
+	}
+
+	for loaded := 0; ; {
+		req := bleve.NewSearchRequest(r.query())
+		req.Fields = loadFields
+		req.From = r.From + loaded
+		req.Size = r.Size - loaded
+		if req.Size > searchPageSize {
+			req.Size = searchPageSize
+		}
+		req.SortBy([]string{sort})
+		if r.Highlight {
+			req.Highlight = bleve.NewHighlight()
+		}
+
+		res, err := i.index.Search(req)
+		if err != nil {
+			return 0, wrap(err, `searching for coredumps`)
+		}
+		total = res.Total
+
+		for _, d := range res.Hits {
+			if r.Fields != nil {
+				for k := range d.Fields {
+					if !keep[k] && !(keep["metadata"] && strings.HasPrefix(k, "meta.")) && !(keep["registers"] && strings.HasPrefix(k, "registers.")) {
+						delete(d.Fields, k)
+					}
+				}
+			}
+
+			c, err := i.toCoredump(d.Fields)
+			if err != nil {
+				if i.onMalformed != nil {
+					i.onMalformed(d.ID, err)
+				}
+				continue
+			}
+
+			err = fn(Hit{Coredump: c, Highlights: d.Fragments})
+			if err != nil {
+				return 0, err
+			}
+		}
+		loaded += len(res.Hits)
+
+		if len(res.Hits) < req.Size || loaded >= r.Size {
+			return total, nil
+		}
+	}
+}
+
+// Count returns the number of coredumps matching the query, without loading
+// any of them.
+func (i BleveIndex) Count(q string) (uint64, error) {
+	req := bleve.NewSearchRequest(bleve.NewQueryStringQuery(q))
+	req.Size = 0
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return 0, wrap(err, `counting coredumps`)
+	}
+
+	return res.Total, nil
+}
+
+// CountRange returns the number of coredumps matching the query dumped between
+// start, included, and end, excluded. A zero time leaves the range open on
+// that side. Unlike Count, the deleted cores are excluded.
+func (i BleveIndex) CountRange(q string, start, end time.Time) (uint64, error) {
+	req := bleve.NewSearchRequest(rangeQuery(q, start, end))
+	req.Size = 0
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return 0, wrap(err, `counting coredumps`)
+	}
+
+	return res.Total, nil
+}
+
+// rangeQuery returns the bleve query of the coredumps matching the query
+// string dumped between start, included, and end, excluded, the deleted cores
+// being excluded. A zero time leaves the range open on that side.
+func rangeQuery(q string, start, end time.Time) query.Query {
+	qry := SearchRequest{Query: q}.query()
+	if start.IsZero() && end.IsZero() {
+		return qry
+	}
+
+	dumped := bleve.NewDateRangeQuery(start, end)
+	dumped.SetField("dumped_at")
+	return bleve.NewConjunctionQuery(qry, dumped)
+}
+
+// Facet is the distribution of the values of a field among the coredumps
+// matching a query.
+type Facet struct {
+	Field string `json:"field"`
+	// Number of values of the field, of coredumps without the field, and
+	// of values not in the terms.
+	Total   int `json:"total"`
+	Missing int `json:"missing"`
+	Other   int `json:"other"`
+	// Most frequent values, with their count.
+	Terms []FacetTerm `json:"terms"`
+}
+
+// FacetTerm is a value of a facet, and the number of coredumps with it.
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// Facets returns the size most frequent values of each field, among the
+// coredumps matching the query. The deleted cores are excluded.
+func (i BleveIndex) Facets(q string, fields []string, size int) ([]Facet, error) {
+	req := bleve.NewSearchRequest(SearchRequest{Query: q}.query())
+	req.Size = 0
+	for _, field := range fields {
+		req.AddFacet(field, bleve.NewFacetRequest(field, size))
+	}
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return nil, wrap(err, `computing facets`)
+	}
+
+	facets := make([]Facet, 0, len(fields))
+	for _, field := range fields {
+		facet := Facet{Field: field, Terms: []FacetTerm{}}
+		if r, ok := res.Facets[field]; ok {
+			facet.Total = r.Total
+			facet.Missing = r.Missing
+			facet.Other = r.Other
+			for _, t := range r.Terms {
+				facet.Terms = append(facet.Terms, FacetTerm{Term: t.Term, Count: t.Count})
+			}
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+// HistogramRequest are the parameters of a histogram of the coredumps.
+type HistogramRequest struct {
+	// Query string, see bleve's query string syntax.
+	Query string
+	// Range of the histogram, and duration of its buckets. The range is
+	// aligned on the interval.
+	Start    time.Time
+	End      time.Time
+	Interval time.Duration
+	// Field to group the coredumps by, empty for no grouping, and maximum
+	// number of series, the most frequent values being kept.
+	By   string
+	Size int
+}
+
+// buckets returns the start times of the buckets of the histogram.
+func (r HistogramRequest) buckets() []time.Time {
+	var buckets []time.Time
+	for t := r.Start.Truncate(r.Interval); t.Before(r.End); t = t.Add(r.Interval) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// Histogram returns the number of coredumps dumped in each interval of the
+// range, overall and for the most frequent values of the By field if any.
+// Each series is computed by a single search, using a date range facet.
+func (i BleveIndex) Histogram(r HistogramRequest) (h Histogram, err error) {
+	starts := r.buckets()
+	if len(starts) == 0 {
+		return Histogram{Buckets: []HistogramBucket{}}, nil
+	}
+	base := rangeQuery(r.Query, starts[0], starts[len(starts)-1].Add(r.Interval))
+
+	h.Buckets, err = i.histogram(base, starts, r.Interval)
+	if err != nil {
+		return h, err
+	}
+	if len(r.By) == 0 {
+		return h, nil
+	}
+
+	req := bleve.NewSearchRequest(base)
+	req.Size = 0
+	req.AddFacet(r.By, bleve.NewFacetRequest(r.By, r.Size))
+	res, err := i.index.Search(req)
+	if err != nil {
+		return h, wrap(err, `computing series`)
+	}
+
+	h.Series = []HistogramSeries{}
+	if facet, ok := res.Facets[r.By]; ok {
+		for _, t := range facet.Terms {
+			term := bleve.NewTermQuery(t.Term)
+			term.SetField(r.By)
+			buckets, err := i.histogram(bleve.NewConjunctionQuery(base, term), starts, r.Interval)
+			if err != nil {
+				return h, err
+			}
+			h.Series = append(h.Series, HistogramSeries{Term: t.Term, Buckets: buckets})
+		}
+	}
+	return h, nil
+}
+
+// histogram counts the coredumps matching the query in each bucket.
+func (i BleveIndex) histogram(q query.Query, starts []time.Time, interval time.Duration) ([]HistogramBucket, error) {
+	facet := bleve.NewFacetRequest("dumped_at", len(starts))
+	for _, start := range starts {
+		facet.AddDateTimeRange(start.Format(time.RFC3339Nano), start, start.Add(interval))
+	}
+	req := bleve.NewSearchRequest(q)
+	req.Size = 0
+	req.AddFacet("dumped_at", facet)
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return nil, wrap(err, `computing histogram`)
+	}
+
+	// The empty buckets aren't returned by bleve.
+	counts := make(map[string]uint64)
+	if r, ok := res.Facets["dumped_at"]; ok {
+		for _, d := range r.DateRanges {
+			counts[d.Name] = uint64(d.Count)
+		}
+	}
+
+	buckets := make([]HistogramBucket, 0, len(starts))
+	for _, start := range starts {
+		buckets = append(buckets, HistogramBucket{
+			Start: start,
+			Count: counts[start.Format(time.RFC3339Nano)],
+		})
+	}
+	return buckets, nil
+}
+
+// CoredumpFields returns the names of the fields of the Coredump struct, as
+// they can be given to SearchFunc.
+func CoredumpFields() []string {
+	t := reflect.TypeOf(Coredump{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// arrayFields are the fields of the Coredump struct that are slices. Bleve
+// returns the stored values of those fields as a single value instead of a
+// slice when there is only one element, which the mapper doesn't handle.
+var arrayFields = []string{"args", "compilers", "functions", "mapped_files", "missing_libraries", "symbols"}
+
+// toCoredump converts the stored fields of a document into a Coredump.
+func (i BleveIndex) toCoredump(fields map[string]interface{}) (c Coredump, err error) {
+	for _, k := range arrayFields {
+		v, ok := fields[k]
+		if !ok {
+			continue
+		}
+		if _, ok := v.([]interface{}); !ok {
+			fields[k] = []interface{}{v}
+		}
+	}
+
+	links, hasLinks := fields["links"]
+	delete(fields, "links")
+	events, hasEvents := fields["events"]
+	delete(fields, "events")
+	delete(fields, "event")
+
+	err = i.mapper.ToStruct(fields, &c)
+	if err != nil {
+		return c, err
+	}
+
+	if hasLinks {
+		raw, ok := links.(string)
+		if !ok {
+			return c, fmt.Errorf(`unexpected type for links in core %s: %T`, c.UID, links)
+		}
+		err = json.Unmarshal([]byte(raw), &c.Links)
+		if err != nil {
+			return c, fmt.Errorf(`parsing links in core %s: %w`, c.UID, err)
+		}
+	}
+
+	if hasEvents {
+		raw, ok := events.(string)
+		if !ok {
+			return c, fmt.Errorf(`unexpected type for events in core %s: %T`, c.UID, events)
+		}
+		err = json.Unmarshal([]byte(raw), &c.Events)
+		if err != nil {
+			return c, fmt.Errorf(`parsing events in core %s: %w`, c.UID, err)
+		}
+	}
+
 	c.Metadata = make(map[string]string)
-	for k, v := range res.Hits[0].Fields {
+	for k, v := range fields {
 		if !strings.HasPrefix(k, "meta.") {
 			continue
 		}
@@ -111,50 +788,102 @@ func (i BleveIndex) Find(uid string) (c Coredump, err error) {
 		c.Metadata[strings.TrimPrefix(k, "meta.")] = v.(string)
 	}
 
+	for k, v := range fields {
+		if !strings.HasPrefix(k, "registers.") {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return c, fmt.Errorf(`unexpected type for register %s in core %s: %T`, k, c.UID, v)
+		}
+		value, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return c, fmt.Errorf(`parsing register %s in core %s: %w`, k, c.UID, err)
+		}
+		if c.Registers == nil {
+			c.Registers = make(map[string]uint64)
+		}
+		c.Registers[strings.TrimPrefix(k, "registers.")] = value
+	}
+
 	return c, nil
 }
 
-func (i BleveIndex) Delete(uid string) error {
-	return i.index.Delete(uid)
-}
+// Backup writes a consistent copy of the index in the given directory, which
+// must not exist yet. The copy is done from an isolated reader of the
+// underlying store, so the index can still be written to in the meantime.
+func (i BleveIndex) Backup(dir string) error {
+	_, kvstore, err := i.index.Advanced()
+	if err != nil {
+		return wrap(err, `getting index store`)
+	}
 
-func (i BleveIndex) Search(q, sort, order string, size, from int) (cores []Coredump, total uint64, err error) {
-	req := bleve.NewSearchRequest(bleve.NewQueryStringQuery(q))
-	req.Fields = []string{"*"}
-	req.From = from
-	req.Size = size
+	reader, err := kvstore.Reader()
+	if err != nil {
+		return wrap(err, `opening index reader`)
+	}
+	defer reader.Close()
 
-	if order == "desc" {
-		sort = "-" + sort
+	err = os.Mkdir(dir, os.ModeDir|0774)
+	if err != nil {
+		return wrap(err, `creating backup directory`)
 	}
-	req.SortBy([]string{sort})
 
-	res, err := i.index.Search(req)
+	// The metadata file tells bleve which kind of index and store to use
+	// when opening the backup, it never changes after the index creation.
+	meta, err := ioutil.ReadFile(filepath.Join(i.path, "index_meta.json"))
+	if err != nil {
+		return wrap(err, `reading index metadata`)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "index_meta.json"), meta, 0664)
 	if err != nil {
-		return nil, 0, wrap(err, `searching for coredumps`)
+		return wrap(err, `writing index metadata`)
 	}
 
-	for _, d := range res.Hits {
-		var c Coredump
+	backup, err := boltdb.New(nil, map[string]interface{}{
+		"path": filepath.Join(dir, "store"),
+	})
+	if err != nil {
+		return wrap(err, `creating backup store`)
+	}
+	defer backup.Close()
 
-		err := i.mapper.ToStruct(d.Fields, &c)
-		if err != nil {
-			return nil, 0, wrap(err, `mapping to coredump`)
-		}
+	writer, err := backup.Writer()
+	if err != nil {
+		return wrap(err, `opening backup writer`)
+	}
+	defer writer.Close()
 
-		c.Metadata = make(map[string]string)
-		for k, v := range d.Fields {
-			if !strings.HasPrefix(k, "meta.") {
-				continue
-			}
-			if _, ok := v.(string); !ok {
-				return nil, 0, fmt.Errorf(`unexpected type for metadata value %s in core %s: %T`, k, c.UID, v)
+	// Copy the key-value pairs by batches to keep the memory usage in
+	// check for big indexes.
+	const batchSize = 1000
+	batch := writer.NewBatch()
+	defer batch.Close()
+
+	it := reader.PrefixIterator(nil)
+	defer it.Close()
+
+	var count int
+	for k, v, ok := it.Current(); ok; k, v, ok = it.Current() {
+		batch.Set(k, v)
+		count++
+
+		if count%batchSize == 0 {
+			err = writer.ExecuteBatch(batch)
+			if err != nil {
+				return wrap(err, `writing backup batch`)
 			}
-			c.Metadata[strings.TrimPrefix(k, "meta.")] = v.(string)
+			batch.Reset()
 		}
 
-		cores = append(cores, c)
+		it.Next()
+	}
+
+	err = writer.ExecuteBatch(batch)
+	if err != nil {
+		return wrap(err, `writing backup batch`)
 	}
 
-	return cores, res.Total, nil
+	return nil
 }
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 
+	"github.com/elwinar/rcoredump/pkg/protocol"
 	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+	"github.com/elwinar/rcoredump/pkg/semver"
 
 	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/xid"
 )
 
@@ -21,47 +23,97 @@ type indexRequest struct {
 	r     *http.Request
 	index Index
 	store Store
+	// analyzeMaxSize is the size above which the core isn't analyzed
+	// automatically. Zero means no limit.
+	analyzeMaxSize int64
+	// maxCoreSize and maxExecutableSize are the sizes above which the
+	// request is refused. Zero means no limit.
+	maxCoreSize       int64
+	maxExecutableSize int64
+	// maxDecompressedSize is the total size of the members of the request
+	// once decompressed above which the request is refused, so a small
+	// compressed body can't fill the store. Zero means no limit.
+	maxDecompressedSize int64
+	// declaredSize is the total size of the files of the request declared
+	// by the forwarder, which they can't exceed. Zero if undeclared.
+	declaredSize int64
+	// inflight, if set, is the budget the files of the request are charged
+	// to as they are received, for the requests that didn't declare their
+	// size. They are refused once it is exhausted.
+	inflight *budget
+	// bufferSize is the size of the buffer the body is read through, the
+	// default one if it isn't positive.
+	bufferSize int
+	// tempDir is the directory the executable and its libraries are
+	// received to before being stored.
+	tempDir string
+	// minForwarderVersion is the version below which the forwarders are
+	// refused.
+	minForwarderVersion semver.Version
+	// metadataAllowlist is the allowlist of the metadata keys, nil to
+	// allow every key. The rejected keys are counted, and dropped or
+	// bucketed depending on metadataRejected.
+	metadataAllowlist    *allowlist
+	metadataRejected     string
+	rejectedMetadataKeys prometheus.Counter
 
-	err      error
-	uid      string
-	body     *bufio.Reader
-	reader   *gzip.Reader
-	req      IndexRequest
-	coredump Coredump
+	err          error
+	uid          string
+	dec          *protocol.Decoder
+	decompressed *limitedReader
+	declared     *limitedReader
+	charged      int64
+	req          IndexRequest
+	coredump     Coredump
+	// executable and links are the temporary files the executable and its
+	// libraries were received to, see storeExecutable.
+	executable *os.File
+	links      []receivedLink
+	temporary  []*os.File
+}
+
+// receivedLink is a library received to a temporary file.
+type receivedLink struct {
+	link Link
+	file *os.File
 }
 
 func (r *indexRequest) init() {
 	r.uid = xid.New().String()
 	r.log = r.log.New("uid", r.uid)
-	r.body = bufio.NewReader(r.r.Body)
+	r.dec = protocol.NewDecoderSize(r.r.Body, r.bufferSize)
+	if r.maxDecompressedSize != 0 {
+		r.decompressed = &limitedReader{max: r.maxDecompressedSize, left: r.maxDecompressedSize, err: errDecompressionBomb}
+	}
+	if r.declaredSize > 0 {
+		r.declared = &limitedReader{max: r.declaredSize, left: r.declaredSize, err: errLargerThanDeclared}
+	}
 	r.coredump = Coredump{
 		IndexerVersion: Version,
 		UID:            r.uid,
+		Events:         []Event{newEvent(EventReceived)},
 	}
 }
 
 func (r *indexRequest) close() {
-	if r.reader != nil {
-		r.reader.Close()
-	}
+	r.dec.Close()
 
-	_, _ = io.Copy(ioutil.Discard, r.r.Body)
+	// There is no point in receiving the rest of a request that is
+	// refused for its size or its forwarder.
+	if !errors.Is(r.err, errTooLarge) && !errors.Is(r.err, errOutdatedForwarder) && !errors.Is(r.err, errInflightExceeded) {
+		_, _ = io.Copy(ioutil.Discard, r.r.Body)
+	}
 
 	r.r.Body.Close()
-}
 
-func (r *indexRequest) prepareReader() error {
-	var err error
-	if r.reader == nil {
-		r.reader, err = gzip.NewReader(r.body)
-	} else {
-		err = r.reader.Reset(r.body)
+	if r.inflight != nil {
+		r.inflight.release(r.charged)
 	}
-	if err != nil {
-		return err
+
+	for _, f := range r.temporary {
+		f.Close()
+		os.Remove(f.Name())
 	}
-	r.reader.Multistream(false)
-	return nil
 }
 
 func (r *indexRequest) read() {
@@ -69,25 +121,104 @@ func (r *indexRequest) read() {
 		return
 	}
 
-	err := r.prepareReader()
+	var err error
+	r.req, err = r.dec.ReadHeader()
 	if err != nil {
-		r.err = wrap(err, "preparing gzip reader")
+		r.err = wrap(err, "reading header")
 		return
 	}
 
-	err = json.NewDecoder(r.reader).Decode(&r.req)
-	if err != nil {
-		r.err = wrap(err, "parsing header")
+	switch r.req.Format {
+	case CoreFormatCoredump, CoreFormatSanitizer:
+	default:
+		r.err = fmt.Errorf("%w %q", errUnsupportedFormat, r.req.Format)
+		return
+	}
+
+	// The hash may be missing if the forwarder failed to compute it, in
+	// which case the executable can't be stored.
+	if (len(r.req.ExecutableHash) != 0 || r.req.IncludeExecutable) && !validHash(r.req.ExecutableHash) {
+		r.err = fmt.Errorf("%w %q", errInvalidHash, r.req.ExecutableHash)
 		return
 	}
 
 	r.coredump.DumpedAt = r.req.DumpedAt
 	r.coredump.Executable = filepath.Base(r.req.ExecutablePath)
+	r.coredump.ExecutableBuildID = r.req.ExecutableBuildID
+	r.coredump.ExecutableFormat = r.req.ExecutableFormat
 	r.coredump.ExecutableHash = r.req.ExecutableHash
 	r.coredump.ExecutablePath = r.req.ExecutablePath
+	r.coredump.Format = r.req.Format
 	r.coredump.ForwarderVersion = r.req.ForwarderVersion
 	r.coredump.Hostname = r.req.Hostname
+	r.coredump.LinksTruncated = r.req.LinksTruncated
+	// The libraries are only resolved by the forwarder when it sends the
+	// executable.
+	if r.req.IncludeExecutable {
+		r.coredump.Links = r.req.Links
+		r.coredump.LinksComplete = !r.req.LinksTruncated
+		for _, link := range r.req.Links {
+			if !link.Sent() {
+				r.coredump.LinksComplete = false
+			}
+		}
+	}
 	r.coredump.Metadata = r.req.Metadata
+
+	// Each metadata key is a field of the index, so the keys outside of
+	// the allowlist are left out of it.
+	allowed, rejected := r.metadataAllowlist.filter(r.req.Metadata)
+	if len(rejected) != 0 {
+		r.log.Debug("rejecting metadata keys", "keys", len(rejected))
+		r.rejectedMetadataKeys.Add(float64(len(rejected)))
+		r.coredump.Metadata = allowed
+		if r.metadataRejected == metadataRejectedBucket {
+			r.coredump.RejectedMetadata = encodeMetadata(rejected)
+		}
+	}
+}
+
+// errUnsupportedFormat is returned when the file sent in place of the coredump
+// is of an unknown format.
+var errUnsupportedFormat = errors.New("unsupported format")
+
+// errIncompleteCore is returned when the size of the coredump received doesn't
+// match the one announced by the forwarder.
+var errIncompleteCore = errors.New("incomplete core")
+
+// checkCoreSize refuses the request if the coredump received doesn't have the
+// size announced by the forwarder, removing it. The forwarders only announce
+// it when they know it before sending, e.g: not when reading from stdin.
+func (r *indexRequest) checkCoreSize() {
+	if r.err != nil || r.req.ExpectedCoreSize == 0 || r.coredump.Size == r.req.ExpectedCoreSize {
+		return
+	}
+
+	_ = r.store.DeleteCore(r.uid)
+	r.err = fmt.Errorf("%w: received %d bytes, expected %d", errIncompleteCore, r.coredump.Size, r.req.ExpectedCoreSize)
+}
+
+// errOutdatedForwarder is returned when the forwarder of a request is older
+// than the minimum version accepted.
+var errOutdatedForwarder = errors.New("outdated forwarder")
+
+// checkForwarderVersion refuses the request if its forwarder is too old. The
+// versions that can't be parsed are accepted, as they are the ones of the
+// development builds, not of old releases.
+func (r *indexRequest) checkForwarderVersion() {
+	if r.err != nil || r.minForwarderVersion == (semver.Version{}) {
+		return
+	}
+
+	version, err := semver.Parse(r.req.ForwarderVersion)
+	if err != nil {
+		r.log.Debug("accepting unversioned forwarder", "forwarder_version", r.req.ForwarderVersion)
+		return
+	}
+
+	if version.Less(r.minForwarderVersion) {
+		r.err = fmt.Errorf("%w %s, the minimum version is %s", errOutdatedForwarder, version, r.minForwarderVersion)
+	}
 }
 
 func (r *indexRequest) readCore() {
@@ -95,13 +226,28 @@ func (r *indexRequest) readCore() {
 		return
 	}
 
-	err := r.prepareReader()
+	member, err := r.nextMember()
 	if err != nil {
-		r.err = wrap(err, "preparing gzip reader")
+		r.err = wrap(err, "reading core")
 		return
 	}
 
-	r.coredump.Size, r.err = r.store.StoreCore(r.uid, r.reader)
+	r.coredump.Size, r.err = r.store.StoreCore(r.uid, limit(member, r.maxCoreSize))
+	if errors.Is(r.err, errTooLarge) || errors.Is(r.err, errInflightExceeded) {
+		_ = r.store.DeleteCore(r.uid)
+	}
+}
+
+// skipLargeCore marks the core as not to be analyzed if it is too large, so an
+// outlier doesn't exhaust the server. It can still be analyzed on request.
+func (r *indexRequest) skipLargeCore() {
+	if r.err != nil || r.analyzeMaxSize == 0 || r.coredump.Size <= r.analyzeMaxSize {
+		return
+	}
+
+	r.log.Debug("skipping analysis of large core", "size", r.coredump.Size)
+	r.coredump.AnalysisSkipped = true
+	r.coredump.SkipReason = SkipReasonTooLarge
 }
 
 func (r *indexRequest) readExecutable() {
@@ -109,23 +255,41 @@ func (r *indexRequest) readExecutable() {
 		return
 	}
 
-	err := r.prepareReader()
+	member, err := r.nextMember()
 	if err != nil {
-		r.err = wrap(err, "preparing gzip reader")
+		r.err = wrap(err, "reading executable")
 		return
 	}
 
-	r.coredump.ExecutableSize, r.err = r.store.StoreExecutable(r.req.ExecutableHash, r.reader)
+	r.executable, r.coredump.ExecutableSize, r.err = r.receive(limit(member, r.maxExecutableSize))
+	if errors.Is(r.err, errTooLarge) || errors.Is(r.err, errInflightExceeded) {
+		_ = r.store.DeleteCore(r.uid)
+	}
+	if r.err != nil {
+		r.err = wrap(r.err, "reading executable")
+	}
 }
 
 // computeExecutableSize is used if the executable wasn't sent by the forwarder
-// because it already exists.
+// because it already exists, or because it can be fetched from debuginfod.
 func (r *indexRequest) computeExecutableSize() {
 	if r.err != nil {
 		return
 	}
 
-	// We open the real file, this also ensure the file is available.
+	// The executable may also be missing, e.g: because its upload failed.
+	// The core is indexed anyway, its size being updated by the analysis
+	// once the executable is fetched or uploaded.
+	exists, err := r.store.ExecutableExists(r.req.ExecutableHash)
+	if err != nil {
+		r.err = wrap(err, "looking up executable")
+		return
+	}
+	if !exists {
+		r.log.Debug("executable not stored", "hash", r.req.ExecutableHash)
+		return
+	}
+
 	executable, err := r.store.Executable(r.req.ExecutableHash)
 	if err != nil {
 		r.err = wrap(err, "opening executable file")
@@ -146,9 +310,189 @@ func (r *indexRequest) indexCore() {
 		return
 	}
 
-	err := r.index.Index(r.coredump)
+	// Every file of the request is stored by now.
+	r.coredump.Events = addEvents(r.coredump.Events, newEvent(EventStored))
+
+	// The core is kept with its metadata capped, so they aren't capped
+	// again when it is indexed after the analysis.
+	var err error
+	r.coredump, err = r.index.CapMetadata(r.coredump)
+	if err != nil {
+		r.err = wrap(err, "indexing core")
+		return
+	}
+
+	err = r.index.Index(r.coredump)
 	if err != nil {
 		r.err = wrap(err, "indexing core")
 		return
 	}
 }
+
+// readLinks stores the libraries sent after the executable. Only the libraries
+// that were found by the forwarder are sent.
+func (r *indexRequest) readLinks() {
+	if r.err != nil {
+		return
+	}
+
+	for _, link := range r.req.Links {
+		if !link.Sent() {
+			continue
+		}
+
+		member, err := r.nextMember()
+		if err != nil {
+			r.err = wrap(err, "reading link %s", link.Name)
+			return
+		}
+
+		file, _, err := r.receive(member)
+		if errors.Is(err, errTooLarge) || errors.Is(err, errInflightExceeded) {
+			_ = r.store.DeleteCore(r.uid)
+		}
+		if err != nil {
+			r.err = wrap(err, "reading link %s", link.Name)
+			return
+		}
+		r.links = append(r.links, receivedLink{link: link, file: file})
+	}
+}
+
+// receive copies a member of the request to a temporary file, so the lock of
+// the executable isn't held while reading it from the network. The file is
+// removed once the request is closed.
+func (r *indexRequest) receive(member io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile(r.tempDir, "receive-")
+	if err != nil {
+		return nil, 0, wrap(err, "creating temporary file")
+	}
+	r.temporary = append(r.temporary, f)
+
+	written, err := copyBuffer(f, member, r.bufferSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, wrap(err, "rewinding temporary file")
+	}
+	return f, written, nil
+}
+
+// storeExecutable stores the executable and the libraries received with the
+// core. The lock of the executable must be held.
+func (r *indexRequest) storeExecutable() {
+	if r.err != nil {
+		return
+	}
+
+	_, err := r.store.StoreExecutable(r.req.ExecutableHash, r.executable)
+	if err != nil {
+		r.err = wrap(err, "storing executable")
+		return
+	}
+
+	for _, l := range r.links {
+		_, err = r.store.StoreLink(r.req.ExecutableHash, l.link, l.file)
+		if err != nil {
+			r.err = wrap(err, "storing link %s", l.link.Name)
+			return
+		}
+	}
+}
+
+// nextMember returns a reader over the next member of the request body,
+// counted against the maximum decompressed size of the request, its declared
+// size, and the in-flight budget.
+func (r *indexRequest) nextMember() (io.Reader, error) {
+	member, err := r.dec.NextMember()
+	if err != nil {
+		return member, err
+	}
+
+	// The members are read one after the other, so they can share the
+	// same limits.
+	if r.decompressed != nil {
+		r.decompressed.r = member
+		member = r.decompressed
+	}
+	if r.declared != nil {
+		r.declared.r = member
+		member = r.declared
+	}
+	if r.inflight != nil {
+		member = &chargedReader{r: member, request: r}
+	}
+	return member, nil
+}
+
+// errInflightExceeded is returned when a request that didn't declare its size
+// exhausts the in-flight budget while being received.
+var errInflightExceeded = errors.New("too many coredumps being received")
+
+// chargedReader charges the bytes read to the in-flight budget of the request,
+// failing with errInflightExceeded once it is exhausted.
+type chargedReader struct {
+	r       io.Reader
+	request *indexRequest
+}
+
+// Read implements io.Reader.
+func (c *chargedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	if !c.request.inflight.tryAcquire(int64(n)) {
+		return 0, errInflightExceeded
+	}
+	c.request.charged += int64(n)
+	return n, err
+}
+
+// errTooLarge is returned when the coredump or the executable of a request is
+// larger than the server accepts.
+var errTooLarge = errors.New("too large")
+
+// errDecompressionBomb is returned when the members of a request are larger
+// than the server accepts once decompressed. It is an errTooLarge, so the
+// request is refused the same way.
+var errDecompressionBomb = fmt.Errorf("%w once decompressed", errTooLarge)
+
+// errLargerThanDeclared is returned when the members of a request are larger
+// than the size declared by the forwarder. It is an errTooLarge, so the
+// request is refused the same way.
+var errLargerThanDeclared = fmt.Errorf("%w for the declared size", errTooLarge)
+
+// limit the size of the reader, which fails with errTooLarge once more than
+// max bytes are read. Zero means no limit.
+func limit(r io.Reader, max int64) io.Reader {
+	if max == 0 {
+		return r
+	}
+	return &limitedReader{r: r, max: max, left: max, err: errTooLarge}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	left int64
+	// err is the error returned once the limit is exceeded.
+	err error
+}
+
+// Read implements io.Reader. It reads one byte more than allowed to tell
+// apart the readers that are exactly at the limit.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		return int(l.left), fmt.Errorf("%w: larger than %d bytes", l.err, l.max)
+	}
+	l.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/protocol"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+	"github.com/elwinar/rcoredump/pkg/semver"
+
+	"github.com/inconshreveable/log15"
+)
+
+// testHash is a valid executable hash, the sha1 hash of an empty file.
+const testHash = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+
+func TestIndexRequest_SkipLargeCore(t *testing.T) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	for n, c := range map[string]struct {
+		size    int64
+		maxSize int64
+		want    bool
+	}{
+		"no limit": {size: 1 << 40, maxSize: 0, want: false},
+		"below":    {size: 1 << 20, maxSize: 1 << 30, want: false},
+		"at limit": {size: 1 << 30, maxSize: 1 << 30, want: false},
+		"above":    {size: 1<<30 + 1, maxSize: 1 << 30, want: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			r := &indexRequest{
+				log:            logger,
+				analyzeMaxSize: c.maxSize,
+				coredump:       Coredump{Size: c.size},
+			}
+			r.skipLargeCore()
+
+			if r.coredump.AnalysisSkipped != c.want {
+				t.Errorf(`skipLargeCore(): wanted skipped %t, got %t`, c.want, r.coredump.AnalysisSkipped)
+			}
+			if c.want && r.coredump.SkipReason != SkipReasonTooLarge {
+				t.Errorf(`skipLargeCore(): unexpected reason %q`, r.coredump.SkipReason)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_CheckForwarderVersion(t *testing.T) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	for n, c := range map[string]struct {
+		version  string
+		min      string
+		outdated bool
+	}{
+		"no minimum":  {version: "v0.1.0", min: "", outdated: false},
+		"older":       {version: "v1.3.9", min: "1.4.0", outdated: true},
+		"pre-release": {version: "v1.4.0-rc.1", min: "1.4.0", outdated: true},
+		"same":        {version: "v1.4.0", min: "1.4.0", outdated: false},
+		"newer":       {version: "v2.0.0", min: "1.4.0", outdated: false},
+		"development": {version: "N/C", min: "1.4.0", outdated: false},
+	} {
+		t.Run(n, func(t *testing.T) {
+			r := &indexRequest{
+				log: logger,
+				req: IndexRequest{ForwarderVersion: c.version},
+			}
+			if len(c.min) != 0 {
+				r.minForwarderVersion, _ = semver.Parse(c.min)
+			}
+			r.checkForwarderVersion()
+
+			if errors.Is(r.err, errOutdatedForwarder) != c.outdated {
+				t.Errorf(`checkForwarderVersion(): wanted outdated %t, got %v`, c.outdated, r.err)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_CheckCoreSize(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	for n, c := range map[string]struct {
+		expected   int64
+		incomplete bool
+	}{
+		"unknown":   {expected: 0, incomplete: false},
+		"complete":  {expected: 4, incomplete: false},
+		"truncated": {expected: 8, incomplete: true},
+		"larger":    {expected: 2, incomplete: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			r := &indexRequest{
+				store: store,
+				uid:   n,
+				req:   IndexRequest{ExpectedCoreSize: c.expected},
+			}
+			r.coredump.Size, err = store.StoreCore(n, strings.NewReader("core"))
+			if err != nil {
+				t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+			}
+			r.checkCoreSize()
+
+			if errors.Is(r.err, errIncompleteCore) != c.incomplete {
+				t.Errorf(`checkCoreSize(): wanted incomplete %t, got %v`, c.incomplete, r.err)
+			}
+			f, err := store.Core(n)
+			if err == nil {
+				f.Close()
+			}
+			if c.incomplete && !os.IsNotExist(err) {
+				t.Errorf(`Core(): wanted the incomplete core to be removed, got %v`, err)
+			}
+			if !c.incomplete && err != nil {
+				t.Errorf(`Core(): wanted the core to be kept, got %v`, err)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_Links(t *testing.T) {
+	found := Link{Name: "libc.so.6", Path: "/lib/libc.so.6", Found: true}
+	missing := Link{Name: "libfoo.so.2", Path: "libfoo.so.2"}
+
+	for n, c := range map[string]struct {
+		req      IndexRequest
+		links    []Link
+		complete bool
+	}{
+		"complete":     {req: IndexRequest{IncludeExecutable: true, Links: []Link{found}}, links: []Link{found}, complete: true},
+		"missing":      {req: IndexRequest{IncludeExecutable: true, Links: []Link{found, missing}}, links: []Link{found, missing}, complete: false},
+		"truncated":    {req: IndexRequest{IncludeExecutable: true, Links: []Link{found}, LinksTruncated: true}, links: []Link{found}, complete: false},
+		"not resolved": {req: IndexRequest{IncludeExecutable: false}, links: nil, complete: false},
+	} {
+		t.Run(n, func(t *testing.T) {
+			c.req.ExecutableHash = testHash
+			var body bytes.Buffer
+			err := protocol.NewEncoder(&body).WriteHeader(c.req)
+			if err != nil {
+				t.Fatalf(`WriteHeader(): unexpected error: %s`, err)
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			r := &indexRequest{log: logger, r: httptest.NewRequest("POST", "/cores", &body)}
+			r.init()
+			r.read()
+			if r.err != nil {
+				t.Fatalf(`read(): unexpected error: %s`, r.err)
+			}
+			if !reflect.DeepEqual(r.coredump.Links, c.links) {
+				t.Errorf(`read(): wanted links %#v, got %#v`, c.links, r.coredump.Links)
+			}
+			if r.coredump.LinksComplete != c.complete {
+				t.Errorf(`read(): wanted links complete %t, got %t`, c.complete, r.coredump.LinksComplete)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_Format(t *testing.T) {
+	for n, c := range map[string]struct {
+		format  string
+		wantErr error
+	}{
+		"coredump":  {format: CoreFormatCoredump},
+		"sanitizer": {format: CoreFormatSanitizer},
+		"unknown":   {format: "minidump", wantErr: errUnsupportedFormat},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var body bytes.Buffer
+			err := protocol.NewEncoder(&body).WriteHeader(IndexRequest{Format: c.format})
+			if err != nil {
+				t.Fatalf(`WriteHeader(): unexpected error: %s`, err)
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			r := &indexRequest{log: logger, r: httptest.NewRequest("POST", "/cores", &body)}
+			r.init()
+			r.read()
+			if !errors.Is(r.err, c.wantErr) {
+				t.Fatalf(`read(): wanted error %v, got %v`, c.wantErr, r.err)
+			}
+			if c.wantErr == nil && r.coredump.Format != c.format {
+				t.Errorf(`read(): wanted format %q, got %q`, c.format, r.coredump.Format)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_Events(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	var body bytes.Buffer
+	err = protocol.Encode(&body, IndexRequest{ProtocolVersion: protocol.Version, DumpedAt: time.Now()}, strings.NewReader("core"), nil, nil)
+	if err != nil {
+		t.Fatalf(`Encode(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	r := &indexRequest{log: logger, r: httptest.NewRequest("POST", "/cores", &body), index: newTestIndex(t), store: store}
+	r.init()
+	r.read()
+	r.readCore()
+	r.indexCore()
+	r.close()
+	if r.err != nil {
+		t.Fatalf(`indexing: unexpected error: %s`, r.err)
+	}
+
+	c, err := r.index.Find(r.uid)
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	want := []string{EventReceived, EventStored}
+	if got := eventNames(c.Events); !reflect.DeepEqual(got, want) {
+		t.Errorf(`indexCore(): wanted events %v, got %v`, want, got)
+	}
+}
+
+func TestIndexRequest_MaxDecompressedSize(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	// The core and the executable compress to a few bytes each.
+	core := strings.Repeat("c", 1<<16)
+	executable := strings.Repeat("e", 1<<16)
+
+	for n, c := range map[string]struct {
+		max      int64
+		tooLarge bool
+	}{
+		"no limit":          {max: 0, tooLarge: false},
+		"at limit":          {max: 2 << 16, tooLarge: false},
+		"above with core":   {max: 1 << 10, tooLarge: true},
+		"above with binary": {max: 1<<16 + 1<<10, tooLarge: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			hash := fmt.Sprintf("%x", sha1.Sum([]byte(n)))
+			var body bytes.Buffer
+			err := protocol.Encode(&body, IndexRequest{ExecutableHash: hash, IncludeExecutable: true}, strings.NewReader(core), strings.NewReader(executable), nil)
+			if err != nil {
+				t.Fatalf(`Encode(): unexpected error: %s`, err)
+			}
+			if body.Len() > 1<<10 {
+				t.Fatalf(`Encode(): wanted a small body, got %d bytes`, body.Len())
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			r := &indexRequest{
+				log:                 logger,
+				r:                   httptest.NewRequest("POST", "/cores", &body),
+				store:               store,
+				maxDecompressedSize: c.max,
+				tempDir:             root,
+			}
+			r.init()
+			r.read()
+			r.readCore()
+			r.readExecutable()
+			r.storeExecutable()
+			r.close()
+
+			if received, _ := filepath.Glob(filepath.Join(root, "receive-*")); len(received) != 0 {
+				t.Errorf(`wanted the temporary files to be removed, got %v`, received)
+			}
+
+			if errors.Is(r.err, errDecompressionBomb) != c.tooLarge || errors.Is(r.err, errTooLarge) != c.tooLarge {
+				t.Fatalf(`wanted too large %t, got %v`, c.tooLarge, r.err)
+			}
+			if !c.tooLarge && r.err != nil {
+				t.Fatalf(`unexpected error: %s`, r.err)
+			}
+			for name, open := range map[string]func() (*os.File, error){
+				"core":       func() (*os.File, error) { return store.Core(r.uid) },
+				"executable": func() (*os.File, error) { return store.Executable(hash) },
+			} {
+				f, err := open()
+				if err == nil {
+					f.Close()
+				}
+				if c.tooLarge && !os.IsNotExist(err) {
+					t.Errorf(`wanted the %s to be removed, got %v`, name, err)
+				}
+				if !c.tooLarge && err != nil {
+					t.Errorf(`wanted the %s to be kept, got %v`, name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexRequest_DeclaredSize(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	core := strings.Repeat("c", 1<<16)
+	for n, c := range map[string]struct {
+		declared int64
+		inflight int64
+		want     error
+	}{
+		"declared":               {declared: 1 << 16},
+		"larger than declared":   {declared: 1 << 10, want: errLargerThanDeclared},
+		"undeclared":             {inflight: 1 << 17},
+		"undeclared above":       {inflight: 1 << 10, want: errInflightExceeded},
+		"undeclared without max": {},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var body bytes.Buffer
+			err := protocol.Encode(&body, IndexRequest{}, strings.NewReader(core), nil, nil)
+			if err != nil {
+				t.Fatalf(`Encode(): unexpected error: %s`, err)
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			r := &indexRequest{
+				log:          logger,
+				r:            httptest.NewRequest("POST", "/cores", &body),
+				store:        store,
+				declaredSize: c.declared,
+			}
+			inflight := &budget{max: c.inflight}
+			if c.inflight != 0 {
+				r.inflight = inflight
+			}
+			r.init()
+			r.read()
+			r.readCore()
+			r.close()
+
+			if c.want == nil && r.err != nil {
+				t.Fatalf(`unexpected error: %s`, r.err)
+			}
+			if c.want != nil && !errors.Is(r.err, c.want) {
+				t.Fatalf(`wanted error %v, got %v`, c.want, r.err)
+			}
+			if inflight.current != 0 {
+				t.Errorf(`wanted the budget to be released, got %d bytes charged`, inflight.current)
+			}
+
+			f, err := store.Core(r.uid)
+			if err == nil {
+				f.Close()
+			}
+			if c.want != nil && !os.IsNotExist(err) {
+				t.Errorf(`wanted the core to be removed, got %v`, err)
+			}
+			if c.want == nil && err != nil {
+				t.Errorf(`wanted the core to be kept, got %v`, err)
+			}
+		})
+	}
+}
+
+func TestIndexRequest_InvalidHash(t *testing.T) {
+	for n, c := range map[string]struct {
+		req   IndexRequest
+		valid bool
+	}{
+		"valid":                 {req: IndexRequest{ExecutableHash: testHash, IncludeExecutable: true}, valid: true},
+		"missing":               {req: IndexRequest{}, valid: true},
+		"missing with binary":   {req: IndexRequest{IncludeExecutable: true}, valid: false},
+		"parent directory":      {req: IndexRequest{ExecutableHash: ".."}, valid: false},
+		"current directory":     {req: IndexRequest{ExecutableHash: ".", IncludeExecutable: true}, valid: false},
+		"uppercase":             {req: IndexRequest{ExecutableHash: strings.ToUpper(testHash)}, valid: false},
+		"path of the same size": {req: IndexRequest{ExecutableHash: "../cores/" + testHash[:31]}, valid: false},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var body bytes.Buffer
+			err := protocol.NewEncoder(&body).WriteHeader(c.req)
+			if err != nil {
+				t.Fatalf(`WriteHeader(): unexpected error: %s`, err)
+			}
+
+			logger := log15.New()
+			logger.SetHandler(log15.DiscardHandler())
+			r := &indexRequest{log: logger, r: httptest.NewRequest("POST", "/cores", &body)}
+			r.init()
+			r.read()
+			if c.valid && r.err != nil {
+				t.Errorf(`read(): unexpected error: %s`, r.err)
+			}
+			if !c.valid && !errors.Is(r.err, errInvalidHash) {
+				t.Errorf(`read(): wanted invalid hash, got %v`, r.err)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	for n, c := range map[string]struct {
+		size     int
+		max      int64
+		tooLarge bool
+	}{
+		"no limit": {size: 1 << 16, max: 0, tooLarge: false},
+		"below":    {size: 1 << 10, max: 1 << 16, tooLarge: false},
+		"at limit": {size: 1 << 16, max: 1 << 16, tooLarge: false},
+		"above":    {size: 1<<16 + 1, max: 1 << 16, tooLarge: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			read, err := ioutil.ReadAll(limit(strings.NewReader(strings.Repeat("x", c.size)), c.max))
+			if errors.Is(err, errTooLarge) != c.tooLarge {
+				t.Fatalf(`ReadAll(): wanted too large %t, got %v`, c.tooLarge, err)
+			}
+			if err == nil && len(read) != c.size {
+				t.Errorf(`ReadAll(): wanted %d bytes, got %d`, c.size, len(read))
+			}
+			if int64(len(read)) > c.max && c.max != 0 {
+				t.Errorf(`ReadAll(): read %d bytes above the limit of %d`, len(read), c.max)
+			}
+		})
+	}
+}
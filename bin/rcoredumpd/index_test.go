@@ -0,0 +1,623 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// newTestIndex returns a new index in a temporary directory, removed during
+// the test cleanup.
+func newTestIndex(t testing.TB) Index {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	index, err := NewBleveIndex(filepath.Join(dir, "index"), false, 0, nil, nil)
+	if err != nil {
+		t.Fatalf(`creating index: %s`, err)
+	}
+	return index
+}
+
+func TestBleveIndex_SearchFunc_Highlight(t *testing.T) {
+	index := newTestIndex(t)
+
+	for _, c := range []Coredump{
+		{
+			UID:      "segv",
+			DumpedAt: time.Now(),
+			Trace:    "Program terminated with signal SIGSEGV, Segmentation fault.\n#0  0x0000555555555131 in main ()",
+		},
+		{
+			UID:      "abrt",
+			DumpedAt: time.Now(),
+			Trace:    "Program terminated with signal SIGABRT, Aborted.\n#0  0x00007ffff7e1b18b in raise ()",
+		},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	for n, highlight := range map[string]bool{
+		"enabled":  true,
+		"disabled": false,
+	} {
+		t.Run(n, func(t *testing.T) {
+			var hits []Hit
+			_, err := index.SearchFunc(SearchRequest{
+				Query:     "trace:SIGSEGV",
+				Sort:      "dumped_at",
+				Order:     "desc",
+				Size:      10,
+				Highlight: highlight,
+			}, func(h Hit) error {
+				hits = append(hits, h)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf(`SearchFunc(): unexpected error: %s`, err)
+			}
+
+			if len(hits) != 1 || hits[0].UID != "segv" {
+				t.Fatalf(`SearchFunc(): unexpected hits %#v`, hits)
+			}
+
+			fragments := hits[0].Highlights["trace"]
+			if !highlight {
+				if hits[0].Highlights != nil {
+					t.Errorf(`SearchFunc(): unexpected highlights %#v`, hits[0].Highlights)
+				}
+				return
+			}
+
+			if len(fragments) == 0 || !strings.Contains(fragments[0], "<mark>SIGSEGV</mark>") {
+				t.Errorf(`SearchFunc(): wanted a highlighted trace fragment, got %#v`, fragments)
+			}
+		})
+	}
+}
+
+func TestBleveIndex_Count(t *testing.T) {
+	index := newTestIndex(t)
+
+	for _, c := range []Coredump{
+		{UID: "first", Hostname: "alpha", DumpedAt: time.Now()},
+		{UID: "second", Hostname: "alpha", DumpedAt: time.Now()},
+		{UID: "third", Hostname: "beta", DumpedAt: time.Now()},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	for q, want := range map[string]uint64{
+		"*":              3,
+		"hostname:alpha": 2,
+		"hostname:gamma": 0,
+	} {
+		got, err := index.Count(q)
+		if err != nil {
+			t.Fatalf(`Count(%q): unexpected error: %s`, q, err)
+		}
+		if got != want {
+			t.Errorf(`Count(%q): wanted %d, got %d`, q, want, got)
+		}
+	}
+}
+
+func TestBleveIndex_Registers(t *testing.T) {
+	index := newTestIndex(t)
+
+	want := map[string]uint64{
+		"rip": 0x55aff284e139,
+		"rax": 0xffffffffffffffff,
+	}
+	err := index.Index(Coredump{UID: "segv", DumpedAt: time.Now(), Registers: want})
+	if err != nil {
+		t.Fatalf(`indexing: %s`, err)
+	}
+
+	c, err := index.Find("segv")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if !reflect.DeepEqual(c.Registers, want) {
+		t.Errorf(`Find(): wanted registers %#v, got %#v`, want, c.Registers)
+	}
+
+	total, err := index.Count("registers.rip:0x55aff284e139")
+	if err != nil {
+		t.Fatalf(`Count(): unexpected error: %s`, err)
+	}
+	if total != 1 {
+		t.Errorf(`Count(): wanted 1 core, got %d`, total)
+	}
+}
+
+func TestBleveIndex_Links(t *testing.T) {
+	index := newTestIndex(t)
+
+	want := []Link{
+		{Name: "libc.so.6", Path: "/lib/libc-2.31.so", Symlinks: []string{"/lib/libc.so.6"}, Found: true},
+		{Name: "libfoo.so.2", Path: "libfoo.so.2", Found: false},
+		{Name: "libbar.so", Path: "/lib/libbar.so", Found: true, Error: "permission denied"},
+	}
+	for _, c := range []Coredump{
+		{UID: "incomplete", DumpedAt: time.Now(), Links: want},
+		{UID: "complete", DumpedAt: time.Now(), Links: want[:1], LinksComplete: true},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	c, err := index.Find("incomplete")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if !reflect.DeepEqual(c.Links, want) {
+		t.Errorf(`Find(): wanted links %#v, got %#v`, want, c.Links)
+	}
+
+	// The links are only searchable through links_complete.
+	for q, want := range map[string]uint64{
+		"links_complete:T*": 1,
+		"links_complete:F*": 1,
+		"libfoo":            0,
+	} {
+		total, err := index.Count(q)
+		if err != nil {
+			t.Fatalf(`Count(%q): unexpected error: %s`, q, err)
+		}
+		if total != want {
+			t.Errorf(`Count(%q): wanted %d cores, got %d`, q, want, total)
+		}
+	}
+}
+
+func TestBleveIndex_Events(t *testing.T) {
+	index := newTestIndex(t)
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []Event{
+		{Name: EventReceived, At: at},
+		{Name: EventStored, At: at.Add(time.Second)},
+		{Name: EventAnalysisStarted, At: at.Add(2 * time.Second)},
+		{Name: EventAnalysisFailed, At: at.Add(3 * time.Second)},
+	}
+	for _, c := range []Coredump{
+		{UID: "failed", DumpedAt: time.Now(), Events: want},
+		{UID: "stored", DumpedAt: time.Now(), Events: want[:2]},
+		{UID: "none", DumpedAt: time.Now()},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	c, err := index.Find("failed")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if !reflect.DeepEqual(c.Events, want) {
+		t.Errorf(`Find(): wanted events %#v, got %#v`, want, c.Events)
+	}
+
+	// Only the names of the events are searchable.
+	for q, want := range map[string]uint64{
+		"event:stored":                         2,
+		"event:analysis_failed":                1,
+		"+event:stored -event:analysis_failed": 1,
+		"events:2020":                          0,
+	} {
+		total, err := index.Count(q)
+		if err != nil {
+			t.Fatalf(`Count(%q): unexpected error: %s`, q, err)
+		}
+		if total != want {
+			t.Errorf(`Count(%q): wanted %d cores, got %d`, q, want, total)
+		}
+	}
+}
+
+func TestBleveIndex_RejectedMetadata(t *testing.T) {
+	index := newTestIndex(t)
+
+	want := `{"build":"1234"}`
+	err := index.Index(Coredump{UID: "segv", DumpedAt: time.Now(), RejectedMetadata: want})
+	if err != nil {
+		t.Fatalf(`indexing: %s`, err)
+	}
+
+	c, err := index.Find("segv")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if c.RejectedMetadata != want {
+		t.Errorf(`Find(): wanted rejected metadata %q, got %q`, want, c.RejectedMetadata)
+	}
+
+	// The rejected metadata are stored, but not indexed.
+	for _, q := range []string{"rejected_metadata:1234", "1234"} {
+		total, err := index.Count(q)
+		if err != nil {
+			t.Fatalf(`Count(%q): unexpected error: %s`, q, err)
+		}
+		if total != 0 {
+			t.Errorf(`Count(%q): wanted 0 core, got %d`, q, total)
+		}
+	}
+}
+
+func TestBleveIndex_MaxMetadataFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var capped []string
+	index, err := NewBleveIndex(filepath.Join(dir, "index"), false, 2, func(uid string, keys []string) {
+		capped = append(capped, keys...)
+	}, nil)
+	if err != nil {
+		t.Fatalf(`creating index: %s`, err)
+	}
+
+	for _, c := range []Coredump{
+		{UID: "first", DumpedAt: time.Now(), Metadata: map[string]string{"team": "payments"}},
+		{UID: "second", DumpedAt: time.Now(), Metadata: map[string]string{"team": "search", "env": "prod", "build": "1234"}},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	// Only one of the new keys of the second core fits.
+	if len(capped) != 1 {
+		t.Fatalf(`Index(): wanted 1 capped key, got %v`, capped)
+	}
+
+	c, err := index.Find("second")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if len(c.Metadata) != 2 || c.Metadata["team"] != "search" {
+		t.Errorf(`Find(): wanted the team and one more indexed key, got %v`, c.Metadata)
+	}
+	var rejected map[string]string
+	err = json.Unmarshal([]byte(c.RejectedMetadata), &rejected)
+	if err != nil {
+		t.Fatalf(`Find(): invalid rejected metadata %q: %s`, c.RejectedMetadata, err)
+	}
+	if _, ok := rejected[capped[0]]; !ok || len(rejected) != 1 {
+		t.Errorf(`Find(): wanted the capped key in the rejected metadata, got %v`, rejected)
+	}
+}
+
+func TestBleveIndex_SearchFunc_Deleted(t *testing.T) {
+	index := newTestIndex(t)
+
+	for _, c := range []Coredump{
+		{UID: "kept", DumpedAt: time.Now()},
+		{UID: "deleted", DumpedAt: time.Now(), Deleted: true, DeletedAt: time.Now()},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	for n, c := range map[string]struct {
+		includeDeleted bool
+		want           []string
+	}{
+		"excluded": {includeDeleted: false, want: []string{"kept"}},
+		"included": {includeDeleted: true, want: []string{"deleted", "kept"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var got []string
+			_, err := index.SearchFunc(SearchRequest{
+				Query:          "*",
+				Sort:           "uid",
+				Order:          "asc",
+				Size:           10,
+				IncludeDeleted: c.includeDeleted,
+			}, func(h Hit) error {
+				got = append(got, h.UID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf(`SearchFunc(): unexpected error: %s`, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`SearchFunc(): wanted %v, got %v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestBleveIndex_SearchFunc_Terms(t *testing.T) {
+	index := newTestIndex(t)
+
+	hash := "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	for _, c := range []Coredump{
+		{UID: "missing", ExecutableHash: hash, DumpedAt: time.Now(), MissingLibraries: []string{`/usr/lib/lib" OR "foo.so`}},
+		{UID: "other", ExecutableHash: hash, DumpedAt: time.Now(), MissingLibraries: []string{"/usr/lib/libfoo.so"}},
+		{UID: "awaiting", ExecutableHash: hash, DumpedAt: time.Now(), SkipReason: SkipReasonAwaitingExecutable},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	for n, c := range map[string]struct {
+		query string
+		terms map[string]string
+		want  []string
+	}{
+		"hash": {
+			terms: map[string]string{"executable_hash": hash},
+			want:  []string{"awaiting", "missing", "other"},
+		},
+		"skip reason": {
+			terms: map[string]string{"executable_hash": hash, "skip_reason": SkipReasonAwaitingExecutable},
+			want:  []string{"awaiting"},
+		},
+		"query syntax": {
+			terms: map[string]string{"missing_libraries": `/usr/lib/lib" OR "foo.so`},
+			want:  []string{"missing"},
+		},
+		"query and terms": {
+			query: `missing_libraries:"/usr/lib/libfoo.so"`,
+			terms: map[string]string{"executable_hash": hash},
+			want:  []string{"other"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var got []string
+			_, err := index.SearchFunc(SearchRequest{
+				Query: c.query,
+				Terms: c.terms,
+				Sort:  "uid",
+				Order: "asc",
+				Size:  10,
+			}, func(h Hit) error {
+				got = append(got, h.UID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf(`SearchFunc(): unexpected error: %s`, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`SearchFunc(): wanted %v, got %v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestBleveIndex_Histogram(t *testing.T) {
+	index := newTestIndex(t)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []Coredump{
+		{UID: "first", Hostname: "alpha", DumpedAt: start.Add(10 * time.Minute)},
+		{UID: "second", Hostname: "beta", DumpedAt: start.Add(20 * time.Minute)},
+		{UID: "third", Hostname: "alpha", DumpedAt: start.Add(2*time.Hour + 30*time.Minute)},
+		{UID: "outside", Hostname: "alpha", DumpedAt: start.Add(-time.Hour)},
+		{UID: "deleted", Hostname: "alpha", DumpedAt: start.Add(time.Minute), Deleted: true},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`indexing %s: %s`, c.UID, err)
+		}
+	}
+
+	buckets := func(counts ...uint64) []HistogramBucket {
+		var buckets []HistogramBucket
+		for i, c := range counts {
+			buckets = append(buckets, HistogramBucket{Start: start.Add(time.Duration(i) * time.Hour), Count: c})
+		}
+		return buckets
+	}
+
+	got, err := index.Histogram(HistogramRequest{
+		Query:    "*",
+		Start:    start.Add(5 * time.Minute),
+		End:      start.Add(3 * time.Hour),
+		Interval: time.Hour,
+		By:       "hostname",
+		Size:     10,
+	})
+	if err != nil {
+		t.Fatalf(`Histogram(): unexpected error: %s`, err)
+	}
+
+	want := Histogram{
+		Buckets: buckets(2, 0, 1),
+		Series: []HistogramSeries{
+			{Term: "alpha", Buckets: buckets(1, 0, 1)},
+			{Term: "beta", Buckets: buckets(1, 0, 0)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`Histogram(): wanted %#v, got %#v`, want, got)
+	}
+}
+
+func TestBleveIndex_Update(t *testing.T) {
+	index := newTestIndex(t)
+
+	err := index.Index(Coredump{UID: "uid", Metadata: map[string]string{}})
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+
+	// Concurrent updates must not overwrite each other.
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			err := index.Update("uid", func(c *Coredump) error {
+				c.Metadata[fmt.Sprintf("key%d", n)] = "value"
+				return nil
+			})
+			if err != nil {
+				t.Errorf(`Update(): unexpected error: %s`, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	c, err := index.Find("uid")
+	if err != nil {
+		t.Fatalf(`Find(): unexpected error: %s`, err)
+	}
+	if len(c.Metadata) != 10 {
+		t.Errorf(`Update(): wanted 10 metadata keys, got %v`, c.Metadata)
+	}
+
+	err = index.Update("unknown", func(c *Coredump) error {
+		t.Errorf(`Update(): unexpected call for an unknown core`)
+		return nil
+	})
+	if err != ErrNotFound {
+		t.Errorf(`Update(): wanted ErrNotFound for an unknown core, got %v`, err)
+	}
+
+	// The core isn't indexed again if the mutation fails.
+	failed := errors.New("failed")
+	err = index.Update("uid", func(c *Coredump) error {
+		c.Metadata = nil
+		return failed
+	})
+	if err != failed {
+		t.Errorf(`Update(): wanted the error of the mutation, got %v`, err)
+	}
+	c, err = index.Find("uid")
+	if err != nil || len(c.Metadata) != 10 {
+		t.Errorf(`Update(): wanted the core unchanged after a failed mutation, got %v, %v`, c.Metadata, err)
+	}
+}
+
+func TestNewBleveIndex_Corrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, corrupt := range map[string]func(path string) error{
+		"meta": func(path string) error {
+			return ioutil.WriteFile(filepath.Join(path, "index_meta.json"), []byte("{"), 0644)
+		},
+		"store": func(path string) error {
+			return os.Truncate(filepath.Join(path, "store"), 100)
+		},
+	} {
+		path := filepath.Join(dir, name)
+		index, err := NewBleveIndex(path, false, 0, nil, nil)
+		if err != nil {
+			t.Fatalf(`NewBleveIndex(%s): unexpected error: %s`, name, err)
+		}
+		err = index.(BleveIndex).index.Close()
+		if err != nil {
+			t.Fatalf(`closing %s: unexpected error: %s`, name, err)
+		}
+		err = corrupt(path)
+		if err != nil {
+			t.Fatalf(`corrupting %s: unexpected error: %s`, name, err)
+		}
+
+		_, err = NewBleveIndex(path, false, 0, nil, nil)
+		var corrupted corruptedIndexError
+		if !errors.As(err, &corrupted) || corrupted.path != path {
+			t.Errorf(`NewBleveIndex(%s): wanted a corrupted index error, got %v`, name, err)
+		}
+	}
+
+	// Other errors aren't mistaken for corruption.
+	_, err = NewBleveIndex(filepath.Join(dir, "missing"), true, 0, nil, nil)
+	if err == nil || errors.As(err, new(corruptedIndexError)) {
+		t.Errorf(`NewBleveIndex(missing): wanted an error other than corruption, got %v`, err)
+	}
+}
+
+func TestBleveIndex_Search_Malformed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var malformed []string
+	index, err := NewBleveIndex(filepath.Join(dir, "index"), false, 0, nil, func(uid string, err error) {
+		malformed = append(malformed, uid)
+	})
+	if err != nil {
+		t.Fatalf(`creating index: %s`, err)
+	}
+
+	now := time.Now()
+	for _, c := range []Coredump{
+		{UID: "first", Hostname: "alpha", DumpedAt: now.Add(-2 * time.Minute)},
+		{UID: "second", Hostname: "alpha", DumpedAt: now},
+	} {
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, c.UID, err)
+		}
+	}
+
+	// The metadata values must be strings.
+	err = index.(BleveIndex).index.Index("malformed", map[string]interface{}{
+		"uid":       "malformed",
+		"hostname":  "alpha",
+		"dumped_at": now.Add(-time.Minute),
+		"meta.team": 42,
+	})
+	if err != nil {
+		t.Fatalf(`indexing malformed document: %s`, err)
+	}
+
+	cores, total, err := index.Search("hostname:alpha", "dumped_at", "desc", 10, 0)
+	if err != nil {
+		t.Fatalf(`Search(): unexpected error: %s`, err)
+	}
+	var got []string
+	for _, c := range cores {
+		got = append(got, c.UID)
+	}
+	if want := []string{"second", "first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf(`Search(): wanted %v, got %v`, want, got)
+	}
+	if total != 3 {
+		t.Errorf(`Search(): wanted the malformed document in the total of 3, got %d`, total)
+	}
+	if want := []string{"malformed"}; !reflect.DeepEqual(malformed, want) {
+		t.Errorf(`Search(): wanted %v to be reported as malformed, got %v`, want, malformed)
+	}
+}
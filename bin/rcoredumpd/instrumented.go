@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instruments observe the operations of a dependency of the service, by
+// operation.
+type instruments struct {
+	// durations of the operations.
+	durations *prometheus.HistogramVec
+	// errors counts the failed operations. Not finding a core or a file
+	// isn't a failure, as it is an expected result of most lookups.
+	errors *prometheus.CounterVec
+	// log of the operations, at the debug level.
+	log log15.Logger
+}
+
+// newInstruments returns the instruments of the operations of the given
+// dependency (e.g: store), registered under the rcoredumpd_<name>_op_*
+// names.
+func newInstruments(name string, log log15.Logger) instruments {
+	return instruments{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "rcoredumpd_" + name + "_op_duration_seconds",
+			Help: "duration of the operations of the " + name + ", by operation",
+		}, []string{"op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcoredumpd_" + name + "_op_errors_total",
+			Help: "number of failed operations of the " + name + ", by operation",
+		}, []string{"op"}),
+		log: log.New("component", name),
+	}
+}
+
+// register the metrics of the instruments.
+func (i instruments) register(registerer prometheus.Registerer) {
+	registerer.MustRegister(i.durations, i.errors)
+}
+
+// observe the operation started at the given time, which failed if *err isn't
+// nil. It is meant to be deferred, with the named error of the method.
+func (i instruments) observe(op string, start time.Time, err *error) {
+	duration := time.Since(start)
+	i.durations.With(prometheus.Labels{"op": op}).Observe(duration.Seconds())
+	failed := *err != nil && !errors.Is(*err, ErrNotFound) && !errors.Is(*err, os.ErrNotExist)
+	if failed {
+		i.errors.With(prometheus.Labels{"op": op}).Inc()
+	}
+	i.log.Debug("operation", "op", op, "duration", duration, "err", *err)
+}
+
+// instrumentedStore is a Store observing the calls to the underlying store,
+// by method. The duration of the methods returning a file doesn't include the
+// reads of the file.
+type instrumentedStore struct {
+	instruments
+	store Store
+}
+
+// compile-time check that the instrumentedStore actually implements the Store
+// interface.
+var _ Store = new(instrumentedStore)
+
+func (s instrumentedStore) Core(uid string) (_ *os.File, err error) {
+	defer s.observe("core", time.Now(), &err)
+	return s.store.Core(uid)
+}
+
+func (s instrumentedStore) StoreCore(uid string, src io.Reader) (_ int64, err error) {
+	defer s.observe("store_core", time.Now(), &err)
+	return s.store.StoreCore(uid, src)
+}
+
+func (s instrumentedStore) DeleteCore(uid string) (err error) {
+	defer s.observe("delete_core", time.Now(), &err)
+	return s.store.DeleteCore(uid)
+}
+
+func (s instrumentedStore) Executable(hash string) (_ *os.File, err error) {
+	defer s.observe("executable", time.Now(), &err)
+	return s.store.Executable(hash)
+}
+
+func (s instrumentedStore) StoreExecutable(hash string, src io.Reader) (_ int64, err error) {
+	defer s.observe("store_executable", time.Now(), &err)
+	return s.store.StoreExecutable(hash, src)
+}
+
+func (s instrumentedStore) DeleteExecutable(hash string) (err error) {
+	defer s.observe("delete_executable", time.Now(), &err)
+	return s.store.DeleteExecutable(hash)
+}
+
+func (s instrumentedStore) ExecutableExists(hash string) (_ bool, err error) {
+	defer s.observe("executable_exists", time.Now(), &err)
+	return s.store.ExecutableExists(hash)
+}
+
+func (s instrumentedStore) StoreLink(hash string, link Link, src io.Reader) (_ int64, err error) {
+	defer s.observe("store_link", time.Now(), &err)
+	return s.store.StoreLink(hash, link, src)
+}
+
+func (s instrumentedStore) Link(hash string, link Link) (_ *os.File, err error) {
+	defer s.observe("link", time.Now(), &err)
+	return s.store.Link(hash, link)
+}
+
+func (s instrumentedStore) Sysroot(hash string) (_ string, _ bool, err error) {
+	defer s.observe("sysroot", time.Now(), &err)
+	return s.store.Sysroot(hash)
+}
+
+func (s instrumentedStore) Trace(uid string) (_ io.ReadCloser, err error) {
+	defer s.observe("trace", time.Now(), &err)
+	return s.store.Trace(uid)
+}
+
+func (s instrumentedStore) StoreTrace(uid string, src io.Reader) (_ int64, err error) {
+	defer s.observe("store_trace", time.Now(), &err)
+	return s.store.StoreTrace(uid, src)
+}
+
+func (s instrumentedStore) DeleteTrace(uid string) (err error) {
+	defer s.observe("delete_trace", time.Now(), &err)
+	return s.store.DeleteTrace(uid)
+}
+
+func (s instrumentedStore) Artifact(uid, name string) (_ *os.File, err error) {
+	defer s.observe("artifact", time.Now(), &err)
+	return s.store.Artifact(uid, name)
+}
+
+func (s instrumentedStore) Artifacts(uid string) (_ []ArtifactInfo, err error) {
+	defer s.observe("artifacts", time.Now(), &err)
+	return s.store.Artifacts(uid)
+}
+
+func (s instrumentedStore) StoreArtifact(uid, name string, src io.Reader) (_ int64, err error) {
+	defer s.observe("store_artifact", time.Now(), &err)
+	return s.store.StoreArtifact(uid, name, src)
+}
+
+func (s instrumentedStore) DeleteArtifacts(uid string) (err error) {
+	defer s.observe("delete_artifacts", time.Now(), &err)
+	return s.store.DeleteArtifacts(uid)
+}
+
+func (s instrumentedStore) FreeSpace() (_ uint64, err error) {
+	defer s.observe("free_space", time.Now(), &err)
+	return s.store.FreeSpace()
+}
+
+// instrumentedIndex is an Index observing the calls to the underlying index,
+// by method. The duration of SearchFunc includes the calls to its function.
+type instrumentedIndex struct {
+	instruments
+	index Index
+}
+
+// compile-time check that the instrumentedIndex actually implements the Index
+// interface.
+var _ Index = new(instrumentedIndex)
+
+func (i instrumentedIndex) Index(c Coredump) (err error) {
+	defer i.observe("index", time.Now(), &err)
+	return i.index.Index(c)
+}
+
+func (i instrumentedIndex) Update(uid string, mutate func(*Coredump) error) (err error) {
+	defer i.observe("update", time.Now(), &err)
+	return i.index.Update(uid, mutate)
+}
+
+func (i instrumentedIndex) Delete(uid string) (err error) {
+	defer i.observe("delete", time.Now(), &err)
+	return i.index.Delete(uid)
+}
+
+func (i instrumentedIndex) Find(uid string) (_ Coredump, err error) {
+	defer i.observe("find", time.Now(), &err)
+	return i.index.Find(uid)
+}
+
+func (i instrumentedIndex) Search(q, sort, order string, size, from int) (_ []Coredump, _ uint64, err error) {
+	defer i.observe("search", time.Now(), &err)
+	return i.index.Search(q, sort, order, size, from)
+}
+
+func (i instrumentedIndex) SearchFunc(r SearchRequest, fn func(Hit) error) (_ uint64, err error) {
+	defer i.observe("search_func", time.Now(), &err)
+	return i.index.SearchFunc(r, fn)
+}
+
+func (i instrumentedIndex) Count(q string) (_ uint64, err error) {
+	defer i.observe("count", time.Now(), &err)
+	return i.index.Count(q)
+}
+
+func (i instrumentedIndex) CountRange(q string, start, end time.Time) (_ uint64, err error) {
+	defer i.observe("count_range", time.Now(), &err)
+	return i.index.CountRange(q, start, end)
+}
+
+func (i instrumentedIndex) Facets(q string, fields []string, size int) (_ []Facet, err error) {
+	defer i.observe("facets", time.Now(), &err)
+	return i.index.Facets(q, fields, size)
+}
+
+func (i instrumentedIndex) Histogram(r HistogramRequest) (_ Histogram, err error) {
+	defer i.observe("histogram", time.Now(), &err)
+	return i.index.Histogram(r)
+}
+
+func (i instrumentedIndex) Backup(dir string) (err error) {
+	defer i.observe("backup", time.Now(), &err)
+	return i.index.Backup(dir)
+}
+
+func (i instrumentedIndex) CapMetadata(c Coredump) (_ Coredump, err error) {
+	defer i.observe("cap_metadata", time.Now(), &err)
+	return i.index.CapMetadata(c)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failingReader fails every read.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("failing")
+}
+
+// gather returns the value of the metrics of the instruments, by metric name
+// and operation: the count of observations for the durations, the value for
+// the errors.
+func gather(t *testing.T, i instruments) map[string]map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	i.register(registry)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf(`gathering metrics: %s`, err)
+	}
+	values := make(map[string]map[string]float64)
+	for _, family := range families {
+		values[family.GetName()] = make(map[string]float64)
+		for _, m := range family.GetMetric() {
+			op := m.GetLabel()[0].GetValue()
+			if h := m.GetHistogram(); h != nil {
+				values[family.GetName()][op] = float64(h.GetSampleCount())
+			} else {
+				values[family.GetName()][op] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	underlying, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	store := instrumentedStore{instruments: newInstruments("store", logger), store: underlying}
+
+	// The calls are forwarded to the underlying store.
+	n, err := store.StoreCore("uid", strings.NewReader("core"))
+	if err != nil || n != 4 {
+		t.Fatalf(`StoreCore(): wanted 4 bytes written, got %d, %v`, n, err)
+	}
+	f, err := store.Core("uid")
+	if err != nil {
+		t.Fatalf(`Core(): unexpected error: %s`, err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(content) != "core" {
+		t.Errorf(`Core(): wanted the stored core, got %q`, content)
+	}
+
+	// The missing files aren't failures.
+	_, err = store.Core("missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(`Core(missing): wanted os.ErrNotExist, got %v`, err)
+	}
+	_, err = store.StoreCore("failing", failingReader{})
+	if err == nil {
+		t.Errorf(`StoreCore(failing): wanted an error`)
+	}
+
+	want := map[string]map[string]float64{
+		"rcoredumpd_store_op_duration_seconds": {"store_core": 2, "core": 2},
+		"rcoredumpd_store_op_errors_total":     {"store_core": 1},
+	}
+	if got := gather(t, store.instruments); !reflect.DeepEqual(got, want) {
+		t.Errorf(`wanted metrics %v, got %v`, want, got)
+	}
+}
+
+func TestInstrumentedIndex(t *testing.T) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	index := instrumentedIndex{instruments: newInstruments("index", logger), index: newTestIndex(t)}
+
+	// The calls are forwarded to the underlying index.
+	err := index.Index(Coredump{UID: "uid", Hostname: "alpha"})
+	if err != nil {
+		t.Fatalf(`Index(): unexpected error: %s`, err)
+	}
+	c, err := index.Find("uid")
+	if err != nil || c.Hostname != "alpha" {
+		t.Errorf(`Find(): wanted the indexed core, got %#v, %v`, c, err)
+	}
+
+	// The missing cores aren't failures.
+	_, err = index.Find("missing")
+	if err != ErrNotFound {
+		t.Errorf(`Find(missing): wanted ErrNotFound, got %v`, err)
+	}
+	err = index.Update("uid", func(*Coredump) error { return errors.New("failing") })
+	if err == nil {
+		t.Errorf(`Update(): wanted the error of the mutation`)
+	}
+
+	want := map[string]map[string]float64{
+		"rcoredumpd_index_op_duration_seconds": {"index": 1, "find": 2, "update": 1},
+		"rcoredumpd_index_op_errors_total":     {"update": 1},
+	}
+	if got := gather(t, index.instruments); !reflect.DeepEqual(got, want) {
+		t.Errorf(`wanted metrics %v, got %v`, want, got)
+	}
+}
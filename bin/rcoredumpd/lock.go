@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sync"
+)
+
+// keyedMutex is a set of mutexes identified by a key, so routines working on
+// the same resource can be serialized without blocking the others. The
+// mutexes are created on demand, and removed once unused.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of routines holding or waiting for the lock.
+	refs int
+}
+
+// Lock the mutex identified by key.
+func (m *keyedMutex) Lock(key string) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+}
+
+// Unlock the mutex identified by key. Like for sync.Mutex, it is a run-time
+// error if the mutex isn't locked.
+func (m *keyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.locks[key]
+	if !ok {
+		panic("rcoredumpd: unlock of unlocked keyed mutex")
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+	l.Unlock()
+}
+
+// budget is a quantity of a resource shared by routines, each acquiring a
+// part of it for some time. Unlike a semaphore, acquiring never blocks, so
+// the callers can apply backpressure instead of piling up.
+type budget struct {
+	mu      sync.Mutex
+	max     int64
+	current int64
+	// onChange is called with the current value after each change, while
+	// holding the lock.
+	onChange func(int64)
+}
+
+// tryAcquire acquires n units of the budget if they are available, and reports
+// whether they were acquired. A budget of 0 is unlimited. Requesting more than
+// the budget is equivalent to requesting all of it.
+func (b *budget) tryAcquire(n int64) bool {
+	if b.max == 0 {
+		return true
+	}
+	if n > b.max {
+		n = b.max
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current+n > b.max {
+		return false
+	}
+	b.current += n
+	if b.onChange != nil {
+		b.onChange(b.current)
+	}
+	return true
+}
+
+// release n units of the budget, previously acquired with tryAcquire.
+func (b *budget) release(n int64) {
+	if b.max == 0 {
+		return
+	}
+	if n > b.max {
+		n = b.max
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current -= n
+	if b.onChange != nil {
+		b.onChange(b.current)
+	}
+}
+
+// pinSet counts the routines using a resource identified by a key, e.g: an
+// executable read by a debugger, so it isn't removed while in use. Unlike a
+// lock, pinning never blocks: the removal of a pinned resource is deferred to
+// the last routine unpinning it instead.
+type pinSet struct {
+	mu       sync.Mutex
+	pins     map[string]int
+	deferred map[string]bool
+}
+
+// pin the resource identified by key.
+func (p *pinSet) pin(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pins == nil {
+		p.pins = make(map[string]int)
+	}
+	p.pins[key]++
+}
+
+// unpin the resource identified by key, and reports whether its removal was
+// deferred to the caller, being the last routine using it.
+func (p *pinSet) unpin(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pins[key]--
+	if p.pins[key] > 0 {
+		return false
+	}
+	delete(p.pins, key)
+	deferred := p.deferred[key]
+	delete(p.deferred, key)
+	return deferred
+}
+
+// deferRemoval reports whether the resource identified by key is pinned, in
+// which case its removal is deferred to the last routine unpinning it.
+func (p *pinSet) deferRemoval(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pins[key] == 0 {
+		return false
+	}
+	if p.deferred == nil {
+		p.deferred = make(map[string]bool)
+	}
+	p.deferred[key] = true
+	return true
+}
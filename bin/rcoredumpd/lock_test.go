@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var m keyedMutex
+	var wg sync.WaitGroup
+	counters := map[string]*int{"a": new(int), "b": new(int)}
+	for i := 0; i < 100; i++ {
+		for key := range counters {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				m.Lock(key)
+				defer m.Unlock(key)
+				// Racy without the lock, which the race detector
+				// would notice.
+				*counters[key]++
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	for key, count := range counters {
+		if *count != 100 {
+			t.Errorf(`counter %q: wanted 100, got %d`, key, *count)
+		}
+	}
+
+	if len(m.locks) != 0 {
+		t.Errorf(`wanted no remaining lock, got %d`, len(m.locks))
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := budget{max: 100}
+
+	for _, c := range []struct {
+		acquire int64
+		release int64
+		want    bool
+		current int64
+	}{
+		{acquire: 60, want: true, current: 60},
+		{acquire: 50, want: false, current: 60},
+		{acquire: 40, want: true, current: 100},
+		{release: 60, current: 40},
+		{acquire: 1000, want: false, current: 40},
+		{release: 40, current: 0},
+		{acquire: 1000, want: true, current: 100},
+	} {
+		if c.release != 0 {
+			b.release(c.release)
+		} else if got := b.tryAcquire(c.acquire); got != c.want {
+			t.Errorf(`tryAcquire(%d): wanted %t, got %t`, c.acquire, c.want, got)
+		}
+		if b.current != c.current {
+			t.Errorf(`wanted %d units acquired, got %d`, c.current, b.current)
+		}
+	}
+
+	unlimited := budget{}
+	if !unlimited.tryAcquire(1 << 40) {
+		t.Errorf(`tryAcquire(%d): unlimited budget refused`, int64(1<<40))
+	}
+}
+
+func TestPinSet(t *testing.T) {
+	var p pinSet
+
+	if p.deferRemoval("a") {
+		t.Errorf(`deferRemoval(unpinned): wanted false, got true`)
+	}
+
+	p.pin("a")
+	p.pin("a")
+	p.pin("b")
+	if !p.deferRemoval("a") {
+		t.Errorf(`deferRemoval(pinned): wanted true, got false`)
+	}
+
+	// The removal is left to the last routine using the resource.
+	if p.unpin("a") {
+		t.Errorf(`unpin(): wanted false while still pinned, got true`)
+	}
+	if !p.unpin("a") {
+		t.Errorf(`unpin(last): wanted true, got false`)
+	}
+	if p.unpin("b") {
+		t.Errorf(`unpin(not deferred): wanted false, got true`)
+	}
+
+	if len(p.pins) != 0 || len(p.deferred) != 0 {
+		t.Errorf(`wanted no remaining pin, got %d pins and %d deferred removals`, len(p.pins), len(p.deferred))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// What to do with the metadata keys outside of the allowlist.
+const (
+	// The keys are dropped.
+	metadataRejectedDrop = "drop"
+	// The keys are kept in the rejected_metadata field, which is stored
+	// but not indexed.
+	metadataRejectedBucket = "bucket"
+)
+
+// allowlist of the metadata keys to index. Each distinct key is a field of the
+// index, so the keys sent by the forwarders must be bounded to keep the index
+// healthy.
+type allowlist struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+// parseAllowlist parses a comma-separated list of keys, a trailing star
+// matching any suffix (e.g: "team,k8s.*"). An empty list returns nil, which
+// allows every key.
+func parseAllowlist(raw string) *allowlist {
+	var a allowlist
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case len(key) == 0:
+			continue
+		case strings.HasSuffix(key, "*"):
+			a.prefixes = append(a.prefixes, strings.TrimSuffix(key, "*"))
+		default:
+			if a.keys == nil {
+				a.keys = make(map[string]bool)
+			}
+			a.keys[key] = true
+		}
+	}
+	if a.keys == nil && a.prefixes == nil {
+		return nil
+	}
+	return &a
+}
+
+// allowed reports whether the key is in the allowlist.
+func (a *allowlist) allowed(key string) bool {
+	if a == nil || a.keys[key] {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter splits the metadata between the allowed keys and the rejected ones.
+// The rejected map is nil if every key is allowed.
+func (a *allowlist) filter(metadata map[string]string) (allowed, rejected map[string]string) {
+	if a == nil {
+		return metadata, nil
+	}
+
+	allowed = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if a.allowed(k) {
+			allowed[k] = v
+			continue
+		}
+		if rejected == nil {
+			rejected = make(map[string]string)
+		}
+		rejected[k] = v
+	}
+	return allowed, rejected
+}
+
+// encodeMetadata encodes the metadata as a single JSON object, for them to be
+// stored in one field.
+func encodeMetadata(metadata map[string]string) string {
+	// A map of strings can't fail to be encoded.
+	raw, _ := json.Marshal(metadata)
+	return string(raw)
+}
+
+// mergeMetadata adds the metadata to the JSON object of raw, as encoded by
+// encodeMetadata.
+func mergeMetadata(raw string, metadata map[string]string) string {
+	merged := make(map[string]string)
+	if len(raw) != 0 {
+		_ = json.Unmarshal([]byte(raw), &merged)
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return encodeMetadata(merged)
+}
+
+// metadataFields tracks the distinct metadata keys of the index, each being a
+// field, to cap their number. The known keys are persisted in a file, so the
+// cap holds across restarts.
+type metadataFields struct {
+	sync.Mutex
+	// path of the file the known keys are persisted in.
+	path string
+	// max is the maximum number of keys, zero meaning no limit. The keys
+	// are tracked anyway, so the limit can be enabled later.
+	max   int
+	known map[string]bool
+}
+
+// loadMetadataFields reads the known keys from the file at path. If it doesn't
+// exist, the keys are the ones of the given fields of the index, so the
+// tracking can start on an existing index.
+func loadMetadataFields(path string, max int, fields func() ([]string, error)) (*metadataFields, error) {
+	f := &metadataFields{
+		path:  path,
+		max:   max,
+		known: make(map[string]bool),
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		var keys []string
+		err = json.Unmarshal(raw, &keys)
+		if err != nil {
+			return nil, wrap(err, `reading known metadata fields`)
+		}
+		for _, k := range keys {
+			f.known[k] = true
+		}
+	case errors.Is(err, os.ErrNotExist):
+		names, err := fields()
+		if err != nil {
+			return nil, wrap(err, `listing index fields`)
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, "meta.") {
+				f.known[strings.TrimPrefix(name, "meta.")] = true
+			}
+		}
+		err = f.save()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, wrap(err, `reading known metadata fields`)
+	}
+
+	return f, nil
+}
+
+// admit splits the metadata between the keys that can be indexed, either
+// because they are known or because the limit isn't reached yet, and the
+// capped ones. The new keys are persisted.
+func (f *metadataFields) admit(metadata map[string]string) (admitted, capped map[string]string, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	admitted = make(map[string]string, len(metadata))
+	added := false
+	for k, v := range metadata {
+		switch {
+		case f.known[k]:
+		case f.max == 0 || len(f.known) < f.max:
+			f.known[k] = true
+			added = true
+		default:
+			if capped == nil {
+				capped = make(map[string]string)
+			}
+			capped[k] = v
+			continue
+		}
+		admitted[k] = v
+	}
+
+	if added {
+		err = f.save()
+	}
+	return admitted, capped, err
+}
+
+// save the known keys, replacing the file atomically so a crash doesn't
+// leave it corrupted.
+func (f *metadataFields) save() error {
+	keys := make([]string, 0, len(f.known))
+	for k := range f.known {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		return wrap(err, `encoding known metadata fields`)
+	}
+
+	tmp := f.path + ".tmp"
+	err = ioutil.WriteFile(tmp, raw, 0664)
+	if err != nil {
+		return wrap(err, `writing known metadata fields`)
+	}
+	err = os.Rename(tmp, f.path)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return wrap(err, `writing known metadata fields`)
+	}
+	return nil
+}
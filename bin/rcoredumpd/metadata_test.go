@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAllowlist_Filter(t *testing.T) {
+	metadata := map[string]string{
+		"team":          "payments",
+		"k8s.namespace": "shop",
+		"k8s.pod":       "api-7d9f",
+		"build":         "1234",
+	}
+
+	for n, c := range map[string]struct {
+		allowlist    string
+		wantAllowed  map[string]string
+		wantRejected map[string]string
+	}{
+		"disabled": {
+			allowlist:   "",
+			wantAllowed: metadata,
+		},
+		"keys and prefixes": {
+			allowlist:    "team, k8s.*",
+			wantAllowed:  map[string]string{"team": "payments", "k8s.namespace": "shop", "k8s.pod": "api-7d9f"},
+			wantRejected: map[string]string{"build": "1234"},
+		},
+		"nothing allowed": {
+			allowlist:    "host",
+			wantAllowed:  map[string]string{},
+			wantRejected: metadata,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			allowed, rejected := parseAllowlist(c.allowlist).filter(metadata)
+			if !reflect.DeepEqual(allowed, c.wantAllowed) {
+				t.Errorf(`filter(): wanted allowed %v, got %v`, c.wantAllowed, allowed)
+			}
+			if !reflect.DeepEqual(rejected, c.wantRejected) {
+				t.Errorf(`filter(): wanted rejected %v, got %v`, c.wantRejected, rejected)
+			}
+		})
+	}
+}
+
+func TestLoadMetadataFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "metadata_fields.json")
+
+	// Without the file, the known keys are the ones of the index.
+	f, err := loadMetadataFields(path, 3, func() ([]string, error) {
+		return []string{"hostname", "meta.team", "registers.rip"}, nil
+	})
+	if err != nil {
+		t.Fatalf(`loadMetadataFields(): unexpected error: %s`, err)
+	}
+
+	admitted, capped, err := f.admit(map[string]string{"team": "payments", "env": "prod"})
+	if err != nil {
+		t.Fatalf(`admit(): unexpected error: %s`, err)
+	}
+	if len(admitted) != 2 || capped != nil {
+		t.Errorf(`admit(): wanted every key admitted, got %v and %v`, admitted, capped)
+	}
+
+	// The known keys are read back from the file, the index fields being
+	// ignored.
+	f, err = loadMetadataFields(path, 3, func() ([]string, error) {
+		t.Errorf(`loadMetadataFields(): unexpected listing of the index fields`)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf(`loadMetadataFields(): unexpected error: %s`, err)
+	}
+
+	admitted, capped, err = f.admit(map[string]string{"team": "search", "build": "1234", "region": "eu"})
+	if err != nil {
+		t.Fatalf(`admit(): unexpected error: %s`, err)
+	}
+	if len(admitted) != 2 || admitted["team"] != "search" || len(capped) != 1 {
+		t.Errorf(`admit(): wanted the team and one new key admitted, got %v and %v`, admitted, capped)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// Formats of the responses written by write and writeError.
+const (
+	formatJSON = "json"
+	formatText = "text"
+)
+
+// textWriter is the ResponseWriter of the requests asking for plain text
+// responses, as negotiated by negotiateFormat.
+type textWriter struct {
+	http.ResponseWriter
+}
+
+// negotiateFormat is a middleware selecting the format of the responses
+// written by write and writeError: plain text if requested by the format
+// parameter (e.g: "?format=text") or preferred by the Accept header, JSON
+// otherwise. The responses written by the handlers themselves (e.g: the
+// coredump files) aren't affected.
+func negotiateFormat(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	// The query is read directly, so the body of the form requests isn't
+	// consumed.
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = acceptedFormat(r.Header.Get("Accept"))
+	}
+
+	if format == formatText {
+		rw = textWriter{ResponseWriter: rw}
+	}
+	next(rw, r)
+}
+
+// acceptedFormat returns the format preferred by the Accept header, JSON
+// being preferred when the quality values are the same or the header is
+// missing.
+func acceptedFormat(accept string) string {
+	var jsonQuality, textQuality float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			if quality > jsonQuality {
+				jsonQuality = quality
+			}
+		case "text/plain", "text/*":
+			if quality > textQuality {
+				textQuality = quality
+			}
+		}
+	}
+
+	if textQuality > jsonQuality {
+		return formatText
+	}
+	return formatJSON
+}
+
+// text renders a payload as plain text: the message of the errors, and a
+// line per key of the maps. It returns false for the other payloads, which
+// are only rendered as JSON.
+func text(payload interface{}) (string, bool) {
+	switch p := payload.(type) {
+	case Error:
+		return fmt.Sprintf("%s: %s\n", p.Code, p.Err), true
+	case string:
+		return p + "\n", true
+	case map[string]string:
+		lines := make([]string, 0, len(p))
+		for k, v := range p {
+			lines = append(lines, fmt.Sprintf("%s: %s\n", k, v))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, ""), true
+	case map[string]interface{}:
+		lines := make([]string, 0, len(p))
+		for k, v := range p {
+			lines = append(lines, fmt.Sprintf("%s: %v\n", k, v))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, ""), true
+	default:
+		return "", false
+	}
+}
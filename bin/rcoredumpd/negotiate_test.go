@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestAcceptedFormat(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                    formatJSON,
+		"*/*":                                 formatJSON,
+		"application/json":                    formatJSON,
+		"text/plain":                          formatText,
+		"text/plain, application/json":        formatJSON,
+		"text/plain, application/json;q=0.5":  formatText,
+		"text/*;q=0.9, */*;q=0.1":             formatText,
+		"text/html, application/xhtml+xml":    formatJSON,
+		"text/plain;q=invalid, */*;q=invalid": formatJSON,
+	} {
+		if got := acceptedFormat(accept); got != want {
+			t.Errorf(`acceptedFormat(%q): wanted %q, got %q`, accept, want, got)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	for n, c := range map[string]struct {
+		url         string
+		accept      string
+		payload     interface{}
+		contentType string
+		body        string
+	}{
+		"default": {
+			url:         "/",
+			payload:     Error{Code: ErrCodeNotFound, Err: "unknown core"},
+			contentType: "application/json",
+			body:        `{"code":"not_found","error":"unknown core"}`,
+		},
+		"accept json": {
+			url:         "/",
+			accept:      "application/json",
+			payload:     Error{Code: ErrCodeNotFound, Err: "unknown core"},
+			contentType: "application/json",
+			body:        `{"code":"not_found","error":"unknown core"}`,
+		},
+		"accept text": {
+			url:         "/",
+			accept:      "text/plain",
+			payload:     Error{Code: ErrCodeNotFound, Err: "unknown core"},
+			contentType: "text/plain; charset=utf-8",
+			body:        "not_found: unknown core\n",
+		},
+		"format parameter": {
+			url:         "/?format=text",
+			accept:      "application/json",
+			payload:     map[string]interface{}{"acknowledged": true, "count": 2},
+			contentType: "text/plain; charset=utf-8",
+			body:        "acknowledged: true\ncount: 2\n",
+		},
+		"not rendered as text": {
+			url:         "/?format=text",
+			payload:     []string{"a", "b"},
+			contentType: "application/json",
+			body:        `["a","b"]`,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			r := httptest.NewRequest("GET", c.url, nil)
+			if len(c.accept) != 0 {
+				r.Header.Set("Accept", c.accept)
+			}
+			w := httptest.NewRecorder()
+			negotiateFormat(w, r, func(w http.ResponseWriter, r *http.Request) {
+				write(w, http.StatusNotFound, c.payload)
+			})
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf(`wanted status %d, got %d`, http.StatusNotFound, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != c.contentType {
+				t.Errorf(`wanted content type %q, got %q`, c.contentType, got)
+			}
+			if got := w.Body.String(); got != c.body {
+				t.Errorf(`wanted body %q, got %q`, c.body, got)
+			}
+		})
+	}
+
+	// The errors are rendered the same way.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	negotiateFormat(w, r, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("invalid query"))
+	})
+	if got := w.Body.String(); got != "invalid_request: invalid query\n" {
+		t.Errorf(`writeError(): wanted the error as text, got %q`, got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/urfave/negroni"
+)
+
+// normalizeRequest resolves the requests differing from a route of the router
+// only by the case of their method, a trailing slash or a redundant element of
+// their path (e.g: "post /cores/"). The GET and HEAD requests are redirected
+// to the route by the router itself, but the other ones are served in place,
+// because the clients can't always send their body again once redirected.
+func normalizeRequest(router *httprouter.Router) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		method := strings.ToUpper(r.Method)
+		path := r.URL.Path
+		if method != http.MethodGet && method != http.MethodHead {
+			if h, _, _ := router.Lookup(method, path); h == nil {
+				fixed := httprouter.CleanPath(path)
+				if len(fixed) > 1 {
+					fixed = strings.TrimSuffix(fixed, "/")
+				}
+				if h, _, _ := router.Lookup(method, fixed); h != nil {
+					path = fixed
+				}
+			}
+		}
+
+		if method == r.Method && path == r.URL.Path {
+			next(rw, r)
+			return
+		}
+
+		// Shallow copy the request, so the outer middlewares still see
+		// the original one.
+		normalized := new(http.Request)
+		*normalized = *r
+		u := *r.URL
+		u.Path = path
+		u.RawPath = ""
+		normalized.URL = &u
+		normalized.Method = method
+		next(rw, normalized)
+	}
+}
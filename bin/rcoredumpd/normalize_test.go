@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/urfave/negroni"
+)
+
+func TestNormalizeRequest(t *testing.T) {
+	router := httprouter.New()
+	router.GET("/cores", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Write([]byte("search"))
+	})
+	router.POST("/cores", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	stack := negroni.New()
+	stack.Use(normalizeRequest(router))
+	stack.UseHandler(router)
+
+	for n, c := range map[string]struct {
+		method   string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		"exact":          {method: "POST", path: "/cores", status: http.StatusOK, body: "core"},
+		"method case":    {method: "post", path: "/cores", status: http.StatusOK, body: "core"},
+		"trailing slash": {method: "POST", path: "/cores/", status: http.StatusOK, body: "core"},
+		"redundant":      {method: "POST", path: "//cores/../cores", status: http.StatusOK, body: "core"},
+		"unknown":        {method: "POST", path: "/unknown/", status: http.StatusNotFound},
+		"redirected":     {method: "GET", path: "/cores/", status: http.StatusMovedPermanently, location: "/cores"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader("core"))
+			r.Method = c.method
+			r.URL.Path = c.path
+			stack.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Fatalf(`wanted status %d, got %d`, c.status, w.Code)
+			}
+			if len(c.body) != 0 && w.Body.String() != c.body {
+				t.Errorf(`wanted body %q, got %q`, c.body, w.Body.String())
+			}
+			if got := w.Header().Get("Location"); got != c.location {
+				t.Errorf(`wanted location %q, got %q`, c.location, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+)
+
+// pendingSet tracks the cores queued for analysis and the ones being
+// analyzed, so a core isn't queued twice, nor analyzed twice at once, which
+// would waste a debugger run and race on indexing the results.
+type pendingSet struct {
+	mu      sync.Mutex
+	queued  map[string]struct{}
+	running map[string]struct{}
+}
+
+// queue marks the core as queued, and reports whether it must actually be
+// queued. A core already queued isn't, as its analysis is still to come. A
+// core being analyzed is only queued again if rerun is set, e.g: because files
+// were uploaded for it since its analysis started.
+func (p *pendingSet) queue(uid string, rerun bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.queued[uid]; ok {
+		return false
+	}
+	if _, ok := p.running[uid]; ok && !rerun {
+		return false
+	}
+	if p.queued == nil {
+		p.queued = make(map[string]struct{})
+	}
+	p.queued[uid] = struct{}{}
+	return true
+}
+
+// forget a core marked as queued that wasn't queued after all.
+func (p *pendingSet) forget(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.queued, uid)
+}
+
+// start marks the core as being analyzed, and reports whether it can be, that
+// is if it isn't already being analyzed.
+func (p *pendingSet) start(uid string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.queued, uid)
+	if _, ok := p.running[uid]; ok {
+		return false
+	}
+	if p.running == nil {
+		p.running = make(map[string]struct{})
+	}
+	p.running[uid] = struct{}{}
+	return true
+}
+
+// done marks the analysis of the core as done.
+func (p *pendingSet) done(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.running, uid)
+}
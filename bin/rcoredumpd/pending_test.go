@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+)
+
+func TestService_QueueUnanalyzed(t *testing.T) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		index:         newTestIndex(t),
+		logger:        logger,
+		analysisQueue: make(chan Coredump, 10),
+	}
+
+	now := time.Now()
+	for _, c := range []Coredump{
+		{UID: "first", DumpedAt: now.Add(-3 * time.Minute)},
+		{UID: "second", DumpedAt: now.Add(-2 * time.Minute)},
+		{UID: "queued", DumpedAt: now.Add(-time.Minute)},
+		{UID: "analyzed", DumpedAt: now, Analyzed: true},
+	} {
+		err := s.index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, c.UID, err)
+		}
+	}
+	s.pending.queue("queued", false)
+
+	// Nothing is analyzed, so the cores are still unanalyzed after being
+	// queued, and must only be queued once anyway.
+	queued := func() []string {
+		t.Helper()
+		s.queueUnanalyzed(context.Background())
+		var uids []string
+		for len(s.analysisQueue) != 0 {
+			uids = append(uids, (<-s.analysisQueue).UID)
+		}
+		sort.Strings(uids)
+		return uids
+	}
+	if got := queued(); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf(`queueUnanalyzed(): wanted first and second to be queued, got %v`, got)
+	}
+
+	// The cores still pending aren't queued again, until analyzed.
+	if got := queued(); len(got) != 0 {
+		t.Errorf(`queueUnanalyzed(): wanted the pending cores to be skipped, got %v`, got)
+	}
+	for _, uid := range []string{"first", "queued"} {
+		s.pending.start(uid)
+		s.pending.done(uid)
+	}
+	if got := queued(); len(got) != 2 || got[0] != "first" || got[1] != "queued" {
+		t.Errorf(`queueUnanalyzed(): wanted first and queued to be queued again, got %v`, got)
+	}
+}
+
+func TestPendingSet(t *testing.T) {
+	var p pendingSet
+
+	// Concurrent requests queue and start a core only once.
+	race := func(fn func() bool) int {
+		t.Helper()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var ok int
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if fn() {
+					mu.Lock()
+					ok++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return ok
+	}
+	if n := race(func() bool { return p.queue("core", true) }); n != 1 {
+		t.Errorf(`queue(): wanted the core to be queued once, got %d`, n)
+	}
+	if n := race(func() bool { return p.start("core") }); n != 1 {
+		t.Errorf(`start(): wanted the core to be started once, got %d`, n)
+	}
+
+	// A core being analyzed is only queued again on request, and can't be
+	// started again until done.
+	if p.queue("core", false) {
+		t.Errorf(`queue(): wanted the running core not to be queued`)
+	}
+	if !p.queue("core", true) {
+		t.Errorf(`queue(): wanted the running core to be queued on rerun`)
+	}
+	if p.start("core") {
+		t.Errorf(`start(): wanted the running core not to be started again`)
+	}
+	p.done("core")
+	if !p.queue("core", false) || !p.start("core") {
+		t.Errorf(`queue(), start(): wanted the analyzed core to be queued and started again`)
+	}
+	p.done("core")
+}
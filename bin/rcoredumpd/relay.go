@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/client"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Number of attempts to relay a core, and delay before the first retry. The
+// delay is doubled after each attempt.
+const (
+	relayAttempts = 5
+	relayBackoff  = 5 * time.Second
+)
+
+// relayItem is a core waiting to be relayed.
+type relayItem struct {
+	// header of the request the core was received with.
+	header   IndexRequest
+	coredump Coredump
+	// receivedAt is used to compute the relay lag.
+	receivedAt time.Time
+}
+
+// enqueueRelay queues the core for relay to the secondary server, if
+// configured. The queue is bounded so a slow or unreachable secondary doesn't
+// use up the primary's memory: when full, the core is dropped.
+func (s *service) enqueueRelay(header IndexRequest, core Coredump) {
+	if len(s.relayDest) == 0 {
+		return
+	}
+
+	select {
+	case s.relayQueue <- relayItem{header: header, coredump: core, receivedAt: time.Now()}:
+	default:
+		s.logger.Warn("relay queue full, dropping core", "uid", core.UID)
+		s.relayed.With(prometheus.Labels{"status": "dropped"}).Inc()
+	}
+}
+
+// relayCores relays the queued cores until the context is closed. The cores
+// are relayed one at a time, in the order they were received.
+func (s *service) relayCores(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Debug("stopping relay queue")
+			return
+		case item := <-s.relayQueue:
+			s.relayWithRetry(ctx, item)
+		}
+	}
+}
+
+// relayWithRetry relays the core, retrying with an exponential backoff until
+// it succeeds, the attempts are exhausted, or the context is closed.
+func (s *service) relayWithRetry(ctx context.Context, item relayItem) {
+	log := s.logger.New("uid", item.coredump.UID)
+	delay := relayBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.relay(item)
+		if err == nil {
+			log.Debug("relayed core", "attempt", attempt)
+			s.relayed.With(prometheus.Labels{"status": "relayed"}).Inc()
+			s.relayLag.Set(time.Since(item.receivedAt).Seconds())
+			return
+		}
+
+		// Requests refused by the secondary would be refused again.
+		var statusErr client.StatusError
+		retryable := !errors.As(err, &statusErr) || statusErr.Status >= http.StatusInternalServerError
+		if !retryable || attempt == relayAttempts {
+			log.Error("relaying core", "attempt", attempt, "err", err)
+			s.relayed.With(prometheus.Labels{"status": "failed"}).Inc()
+			return
+		}
+
+		log.Warn("relaying core, retrying", "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			s.relayed.With(prometheus.Labels{"status": "failed"}).Inc()
+			return
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// relay sends the core to the secondary server the way the forwarder sent it,
+// with the executable and its libraries if the secondary doesn't have them.
+func (s *service) relay(item relayItem) error {
+	core := item.coredump
+	hash := core.ExecutableHash
+
+	found, err := s.relayClient.LookupExecutable(hash)
+	if err != nil {
+		return wrap(err, "looking up executable")
+	}
+
+	header := item.header
+	header.ExecutableHash = hash
+	header.IncludeExecutable = !found
+
+	// The libraries are only stored when received along with the
+	// executable, and only needed by the secondary in the same case.
+	if !header.IncludeExecutable || !item.header.IncludeExecutable {
+		header.Links = nil
+	}
+
+	files, err := s.openRelayFiles(core, header)
+	defer files.close()
+	if err != nil {
+		return err
+	}
+
+	// The files are closed once sent, see relayFiles.close.
+	return s.relayClient.Send(client.Upload{
+		Header: header,
+		Size:   files.size,
+		OpenCore: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(files.core), nil
+		},
+		OpenExecutable: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(files.executable), nil
+		},
+		OpenLink: func(link Link) (io.ReadCloser, error) {
+			return ioutil.NopCloser(files.links[link.Path]), nil
+		},
+	})
+}
+
+// relayFiles are the files of a core being relayed.
+type relayFiles struct {
+	core       *os.File
+	executable *os.File
+	links      map[string]*os.File
+	// size is the total size of the files.
+	size int64
+}
+
+// openRelayFiles opens the files of the core to relay. The lock of the
+// executable is only held while opening them, so it isn't removed in the
+// meantime, not while sending them, which can be long: the opened files stay
+// readable even if removed.
+func (s *service) openRelayFiles(core Coredump, header IndexRequest) (*relayFiles, error) {
+	hash := core.ExecutableHash
+	s.executableLocks.Lock(hash)
+	defer s.executableLocks.Unlock(hash)
+
+	files := &relayFiles{links: make(map[string]*os.File)}
+	var err error
+	files.core, err = s.store.Core(core.UID)
+	if err != nil {
+		return files, wrap(err, "opening core")
+	}
+	err = files.add(files.core)
+	if err != nil {
+		return files, wrap(err, "reading core")
+	}
+
+	if !header.IncludeExecutable {
+		return files, nil
+	}
+
+	files.executable, err = s.store.Executable(hash)
+	if err != nil {
+		return files, wrap(err, "opening executable")
+	}
+	err = files.add(files.executable)
+	if err != nil {
+		return files, wrap(err, "reading executable")
+	}
+
+	for _, link := range header.Links {
+		if !link.Sent() {
+			continue
+		}
+		f, err := s.store.Link(hash, link)
+		if err != nil {
+			return files, wrap(err, "opening link %s", link.Name)
+		}
+		files.links[link.Path] = f
+		err = files.add(f)
+		if err != nil {
+			return files, wrap(err, "reading link %s", link.Name)
+		}
+	}
+
+	return files, nil
+}
+
+// add the size of the file to the total size.
+func (f *relayFiles) add(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	f.size += info.Size()
+	return nil
+}
+
+// close the opened files.
+func (f *relayFiles) close() {
+	if f.core != nil {
+		f.core.Close()
+	}
+	if f.executable != nil {
+		f.executable.Close()
+	}
+	for _, file := range f.links {
+		file.Close()
+	}
+}
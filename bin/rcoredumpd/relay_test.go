@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/client"
+	"github.com/elwinar/rcoredump/pkg/protocol"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/inconshreveable/log15"
+)
+
+func TestService_Relay(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	link := Link{Name: "libc.so.6", Path: "/usr/lib/libc.so.6", Found: true}
+	core := Coredump{UID: "core", ExecutableHash: "hash"}
+	_, err = store.StoreCore(core.UID, strings.NewReader("core"))
+	if err != nil {
+		t.Fatalf(`StoreCore(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreExecutable(core.ExecutableHash, strings.NewReader("executable"))
+	if err != nil {
+		t.Fatalf(`StoreExecutable(): unexpected error: %s`, err)
+	}
+	_, err = store.StoreLink(core.ExecutableHash, link, strings.NewReader("libc"))
+	if err != nil {
+		t.Fatalf(`StoreLink(): unexpected error: %s`, err)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := &service{
+		store:  store,
+		logger: logger,
+	}
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// The lock of the executable isn't held while its files are
+		// sent.
+		locked := make(chan struct{})
+		go func() {
+			s.executableLocks.Lock(core.ExecutableHash)
+			s.executableLocks.Unlock(core.ExecutableHash)
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(time.Second):
+			t.Errorf(`wanted the lock of the executable to be released while sending`)
+		}
+
+		if got := r.Header.Get(SizeHeader); got != "18" {
+			t.Errorf(`wanted size 18, got %s`, got)
+		}
+		dec := protocol.NewDecoder(r.Body)
+		header, err := dec.ReadHeader()
+		if err != nil {
+			t.Errorf(`ReadHeader(): unexpected error: %s`, err)
+		}
+		if !header.IncludeExecutable || len(header.Links) != 1 {
+			t.Errorf(`wanted the executable and its link to be sent, got %#v`, header)
+		}
+		for _, want := range []string{"core", "executable", "libc"} {
+			member, err := dec.NextMember()
+			if err != nil {
+				t.Errorf(`NextMember(): unexpected error: %s`, err)
+				return
+			}
+			got, err := ioutil.ReadAll(member)
+			if err != nil {
+				t.Errorf(`reading member: unexpected error: %s`, err)
+				return
+			}
+			if string(got) != want {
+				t.Errorf(`wanted member %q, got %q`, want, got)
+			}
+		}
+	}))
+	defer secondary.Close()
+	s.relayClient = client.Client{Dest: secondary.URL}
+
+	err = s.relay(relayItem{
+		header:   IndexRequest{IncludeExecutable: true, Links: []Link{link}},
+		coredump: core,
+	})
+	if err != nil {
+		t.Errorf(`relay(): unexpected error: %s`, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// savedSearches are the named queries of the users, kept in a JSON file. The
+// file is read on each access, so the read-only instances sharing the data
+// directory see the searches saved on the primary one.
+type savedSearches struct {
+	sync.Mutex
+	path string
+}
+
+// List returns the saved searches, sorted by name.
+func (s *savedSearches) List() ([]SavedSearch, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	searches, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortSearches(searches), nil
+}
+
+// Get returns the saved search with the given name, or ErrNotFound.
+func (s *savedSearches) Get(name string) (SavedSearch, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	searches, err := s.read()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	search, ok := searches[name]
+	if !ok {
+		return SavedSearch{}, ErrNotFound
+	}
+	return search, nil
+}
+
+// Save the search, replacing the one with the same name if any.
+func (s *savedSearches) Save(search SavedSearch) error {
+	s.Lock()
+	defer s.Unlock()
+
+	searches, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	searches[search.Name] = search
+	return s.write(searches)
+}
+
+// Delete the saved search with the given name, or return ErrNotFound.
+func (s *savedSearches) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	searches, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := searches[name]; !ok {
+		return ErrNotFound
+	}
+	delete(searches, name)
+	return s.write(searches)
+}
+
+// read the searches from the file, by name. A missing file holds no search.
+func (s *savedSearches) read() (map[string]SavedSearch, error) {
+	searches := make(map[string]SavedSearch)
+
+	raw, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return searches, nil
+	}
+	if err != nil {
+		return nil, wrap(err, `reading saved searches`)
+	}
+
+	var list []SavedSearch
+	err = json.Unmarshal(raw, &list)
+	if err != nil {
+		return nil, wrap(err, `decoding saved searches`)
+	}
+	for _, search := range list {
+		searches[search.Name] = search
+	}
+	return searches, nil
+}
+
+// write the searches to the file, replacing it atomically so a crash doesn't
+// leave it corrupted.
+func (s *savedSearches) write(searches map[string]SavedSearch) error {
+	raw, err := json.MarshalIndent(sortSearches(searches), "", "\t")
+	if err != nil {
+		return wrap(err, `encoding saved searches`)
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, raw, 0664)
+	if err != nil {
+		return wrap(err, `writing saved searches`)
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return wrap(err, `writing saved searches`)
+	}
+	return nil
+}
+
+// sortSearches returns the searches sorted by name.
+func sortSearches(searches map[string]SavedSearch) []SavedSearch {
+	list := make([]SavedSearch, 0, len(searches))
+	for _, search := range searches {
+		list = append(list, search)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestSavedSearches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &savedSearches{path: filepath.Join(dir, "searches.json")}
+
+	list, err := s.List()
+	if err != nil || len(list) != 0 {
+		t.Fatalf(`List(): wanted no search, got %#v, %v`, list, err)
+	}
+
+	segfaults := SavedSearch{Name: "segfaults", Query: "signal_name:SIGSEGV", Sort: "dumped_at", Order: "desc"}
+	prod := SavedSearch{Name: "prod", Query: "meta.env:prod", Sort: "hostname", Order: "asc"}
+	for _, search := range []SavedSearch{segfaults, prod, {Name: "prod", Query: "+meta.env:prod +lang:Go", Sort: "hostname", Order: "asc"}} {
+		err = s.Save(search)
+		if err != nil {
+			t.Fatalf(`Save(%s): unexpected error: %s`, search.Name, err)
+		}
+	}
+	prod.Query = "+meta.env:prod +lang:Go"
+
+	// A new instance reads the searches saved by the first one.
+	s = &savedSearches{path: s.path}
+	list, err = s.List()
+	if err != nil {
+		t.Fatalf(`List(): unexpected error: %s`, err)
+	}
+	if want := []SavedSearch{prod, segfaults}; !reflect.DeepEqual(list, want) {
+		t.Errorf(`List(): wanted %#v, got %#v`, want, list)
+	}
+
+	got, err := s.Get("segfaults")
+	if err != nil || got != segfaults {
+		t.Errorf(`Get(segfaults): wanted %#v, got %#v, %v`, segfaults, got, err)
+	}
+
+	err = s.Delete("segfaults")
+	if err != nil {
+		t.Fatalf(`Delete(segfaults): unexpected error: %s`, err)
+	}
+	_, err = s.Get("segfaults")
+	if err != ErrNotFound {
+		t.Errorf(`Get(segfaults): wanted ErrNotFound after deletion, got %v`, err)
+	}
+	err = s.Delete("segfaults")
+	if err != ErrNotFound {
+		t.Errorf(`Delete(segfaults): wanted ErrNotFound for an unknown search, got %v`, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/blevesearch/bleve"
+)
+
+// IndexShardSet is an index split in several bleve indexes, the shards, so
+// none of them grows too large. Each core is stored in a single shard, chosen
+// by the hash of its UID, so the operations on a given core only involve its
+// shard. The searches are run on every shard, and their results merged.
+//
+// The number of shards can't change once the index is created, as the cores
+// would be looked for in the wrong shards.
+type IndexShardSet struct {
+	shards []BleveIndex
+	// all searches the alias of the shards. Bleve runs the searches of an
+	// alias on each index, and merges the results, sorting the hits again
+	// and fixing up the facets.
+	all BleveIndex
+}
+
+// compile-time check that the IndexShardSet actually implements the Index
+// interface.
+var _ Index = new(IndexShardSet)
+
+// NewIndexShardSet opens the index of the given number of shards at path,
+// creating it if necessary. The shards are opened as for NewBleveIndex, the
+// number of distinct metadata keys being capped for each shard.
+func NewIndexShardSet(path string, count int, readOnly bool, maxMetadataFields int, onCapped func(uid string, keys []string), onMalformed func(uid string, err error)) (Index, error) {
+	// An index created without shards, or with another number of them,
+	// can't be opened.
+	_, err := os.Stat(filepath.Join(path, "index_meta.json"))
+	if err == nil {
+		return nil, fmt.Errorf(`index at %s isn't sharded`, path)
+	}
+	existing, err := filepath.Glob(filepath.Join(path, "shard-*"))
+	if err != nil {
+		return nil, wrap(err, `listing shards`)
+	}
+	if len(existing) != 0 && len(existing) != count {
+		return nil, fmt.Errorf(`index at %s has %d shards, not %d`, path, len(existing), count)
+	}
+
+	if !readOnly {
+		err = os.MkdirAll(path, os.ModeDir|0774)
+		if err != nil {
+			return nil, wrap(err, `creating index directory`)
+		}
+	}
+
+	var s IndexShardSet
+	indexes := make([]bleve.Index, 0, count)
+	for n := 0; n < count; n++ {
+		shard, err := newBleveIndex(filepath.Join(path, fmt.Sprintf("shard-%d", n)), readOnly, maxMetadataFields, onCapped, onMalformed)
+		if err != nil {
+			// The shards already opened are closed, so they can be
+			// opened again (e.g: once a corrupted shard is replaced).
+			for _, index := range indexes {
+				_ = index.Close()
+			}
+			return nil, wrap(err, `opening shard %d`, n)
+		}
+		s.shards = append(s.shards, shard)
+		indexes = append(indexes, shard.index)
+	}
+
+	s.all = BleveIndex{
+		path:        path,
+		index:       shardAlias{bleve.NewIndexAlias(indexes...)},
+		mapper:      s.shards[0].mapper,
+		onMalformed: onMalformed,
+	}
+	return s, nil
+}
+
+// shardAlias is the alias of the shards. Bleve returns the results of the
+// other shards when a shard fails, which would be mistaken for the complete
+// results, so the search fails instead.
+type shardAlias struct {
+	bleve.IndexAlias
+}
+
+func (a shardAlias) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	return a.SearchInContext(context.Background(), req)
+}
+
+func (a shardAlias) SearchInContext(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	res, err := a.IndexAlias.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for name, err := range res.Status.Errors {
+		return nil, wrap(err, `searching shard %s`, name)
+	}
+	return res, nil
+}
+
+// shard returns the shard of the core with the given UID.
+func (s IndexShardSet) shard(uid string) BleveIndex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(uid))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s IndexShardSet) Index(c Coredump) error {
+	return s.shard(c.UID).Index(c)
+}
+
+func (s IndexShardSet) Find(uid string) (Coredump, error) {
+	return s.shard(uid).Find(uid)
+}
+
+func (s IndexShardSet) Update(uid string, mutate func(*Coredump) error) error {
+	return s.shard(uid).Update(uid, mutate)
+}
+
+func (s IndexShardSet) Delete(uid string) error {
+	return s.shard(uid).Delete(uid)
+}
+
+// CapMetadata caps the metadata keys of the core in its shard, as the shards
+// track their keys separately.
+func (s IndexShardSet) CapMetadata(c Coredump) (Coredump, error) {
+	return s.shard(c.UID).CapMetadata(c)
+}
+
+func (s IndexShardSet) Search(q, sort, order string, size, from int) ([]Coredump, uint64, error) {
+	return s.all.Search(q, sort, order, size, from)
+}
+
+// SearchFunc is BleveIndex.SearchFunc over every shard. Each shard has to load
+// the documents preceding a page to merge them, so deep pages are slower than
+// for a single index.
+func (s IndexShardSet) SearchFunc(r SearchRequest, fn func(Hit) error) (uint64, error) {
+	return s.all.SearchFunc(r, fn)
+}
+
+func (s IndexShardSet) Count(q string) (uint64, error) {
+	return s.all.Count(q)
+}
+
+func (s IndexShardSet) CountRange(q string, start, end time.Time) (uint64, error) {
+	return s.all.CountRange(q, start, end)
+}
+
+func (s IndexShardSet) Facets(q string, fields []string, size int) ([]Facet, error) {
+	return s.all.Facets(q, fields, size)
+}
+
+func (s IndexShardSet) Histogram(r HistogramRequest) (Histogram, error) {
+	return s.all.Histogram(r)
+}
+
+// Backup writes a copy of each shard in the given directory, which must not
+// exist yet. The shards are copied one after the other, so the copy is only
+// consistent for each shard.
+func (s IndexShardSet) Backup(dir string) error {
+	err := os.Mkdir(dir, os.ModeDir|0774)
+	if err != nil {
+		return wrap(err, `creating backup directory`)
+	}
+
+	for n, shard := range s.shards {
+		err = shard.Backup(filepath.Join(dir, fmt.Sprintf("shard-%d", n)))
+		if err != nil {
+			return wrap(err, `backing up shard %d`, n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestIndexShardSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "index")
+
+	index, err := NewIndexShardSet(path, 3, false, 0, nil, nil)
+	if err != nil {
+		t.Fatalf(`NewIndexShardSet(): unexpected error: %s`, err)
+	}
+	set := index.(IndexShardSet)
+
+	// The cores are dumped a minute apart, in the reverse order of their
+	// UIDs.
+	now := time.Now().UTC().Truncate(time.Second)
+	var uids []string
+	for n := 0; n < 30; n++ {
+		c := Coredump{
+			UID:      fmt.Sprintf("core-%02d", n),
+			Hostname: []string{"alpha", "beta"}[n%2],
+			DumpedAt: now.Add(-time.Duration(n) * time.Minute),
+		}
+		err := index.Index(c)
+		if err != nil {
+			t.Fatalf(`Index(%s): unexpected error: %s`, c.UID, err)
+		}
+		uids = append(uids, c.UID)
+	}
+
+	// Each core is stored in a single shard, found by its UID.
+	for n, shard := range set.shards {
+		count, err := shard.Count("*")
+		if err != nil {
+			t.Fatalf(`Count(): unexpected error on shard %d: %s`, n, err)
+		}
+		if count == 0 || count == 30 {
+			t.Errorf(`Index(): wanted the cores to be spread between the shards, got %d in shard %d`, count, n)
+		}
+	}
+	c, err := index.Find("core-07")
+	if err != nil || c.Hostname != "beta" {
+		t.Errorf(`Find(core-07): wanted the core of beta, got %#v, %v`, c, err)
+	}
+
+	total, err := index.Count("hostname:alpha")
+	if err != nil || total != 15 {
+		t.Errorf(`Count(): wanted 15 cores, got %d, %v`, total, err)
+	}
+
+	// The results of the shards are merged in order, for every page.
+	for _, tc := range []struct {
+		order      string
+		size, from int
+		want       []string
+	}{
+		{"desc", 30, 0, uids},
+		{"desc", 5, 0, uids[:5]},
+		{"desc", 5, 12, uids[12:17]},
+		{"asc", 4, 0, []string{"core-29", "core-28", "core-27", "core-26"}},
+		{"asc", 4, 25, []string{"core-04", "core-03", "core-02", "core-01"}},
+	} {
+		cores, total, err := index.Search("*", "dumped_at", tc.order, tc.size, tc.from)
+		if err != nil {
+			t.Fatalf(`Search(%s, %d, %d): unexpected error: %s`, tc.order, tc.size, tc.from, err)
+		}
+		if total != 30 {
+			t.Errorf(`Search(%s, %d, %d): wanted a total of 30, got %d`, tc.order, tc.size, tc.from, total)
+		}
+		var got []string
+		for _, c := range cores {
+			got = append(got, c.UID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf(`Search(%s, %d, %d): wanted %v, got %v`, tc.order, tc.size, tc.from, tc.want, got)
+		}
+	}
+
+	// The pages of SearchFunc are merged too.
+	var got []string
+	_, err = index.SearchFunc(SearchRequest{Query: "*", Sort: "dumped_at", Order: "asc", Size: 30}, func(h Hit) error {
+		got = append(got, h.Coredump.UID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf(`SearchFunc(): unexpected error: %s`, err)
+	}
+	for n := range got {
+		if got[n] != uids[len(uids)-1-n] {
+			t.Errorf(`SearchFunc(): wanted the cores by ascending dump date, got %v`, got)
+			break
+		}
+	}
+
+	facets, err := index.Facets("*", []string{"hostname"}, 10)
+	if err != nil {
+		t.Fatalf(`Facets(): unexpected error: %s`, err)
+	}
+	if want := []FacetTerm{{Term: "alpha", Count: 15}, {Term: "beta", Count: 15}}; len(facets) != 1 || !reflect.DeepEqual(facets[0].Terms, want) {
+		t.Errorf(`Facets(): wanted %v, got %#v`, want, facets)
+	}
+
+	err = index.Delete("core-07")
+	if err != nil {
+		t.Fatalf(`Delete(core-07): unexpected error: %s`, err)
+	}
+	_, err = index.Find("core-07")
+	if err != ErrNotFound {
+		t.Errorf(`Find(core-07): wanted ErrNotFound after deletion, got %v`, err)
+	}
+
+	// The index can't be opened with another number of shards, nor as a
+	// single index.
+	_, err = NewIndexShardSet(path, 2, true, 0, nil, nil)
+	if err == nil {
+		t.Errorf(`NewIndexShardSet(): wanted an error for another number of shards`)
+	}
+	single := filepath.Join(dir, "single")
+	_, err = NewBleveIndex(single, false, 0, nil, nil)
+	if err != nil {
+		t.Fatalf(`NewBleveIndex(): unexpected error: %s`, err)
+	}
+	_, err = NewIndexShardSet(single, 3, true, 0, nil, nil)
+	if err == nil {
+		t.Errorf(`NewIndexShardSet(): wanted an error for an index without shards`)
+	}
+}
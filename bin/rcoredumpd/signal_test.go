@@ -0,0 +1,34 @@
+package main
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+func TestSignalName(t *testing.T) {
+	for n, c := range map[string]struct {
+		machine elf.Machine
+		signal  int
+		want    string
+	}{
+		"x86_64 SIGSEGV":  {machine: elf.EM_X86_64, signal: 11, want: "SIGSEGV"},
+		"x86_64 SIGABRT":  {machine: elf.EM_X86_64, signal: 6, want: "SIGABRT"},
+		"x86_64 SIGBUS":   {machine: elf.EM_X86_64, signal: 7, want: "SIGBUS"},
+		"x86_64 SIGFPE":   {machine: elf.EM_X86_64, signal: 8, want: "SIGFPE"},
+		"x86_64 SIGILL":   {machine: elf.EM_X86_64, signal: 4, want: "SIGILL"},
+		"x86_64 SIGQUIT":  {machine: elf.EM_X86_64, signal: 3, want: "SIGQUIT"},
+		"aarch64 SIGSEGV": {machine: elf.EM_AARCH64, signal: 11, want: "SIGSEGV"},
+		"mips SIGBUS":     {machine: elf.EM_MIPS, signal: 10, want: "SIGBUS"},
+		"mips SIGSYS":     {machine: elf.EM_MIPS, signal: 12, want: "SIGSYS"},
+		"sparc SIGUSR1":   {machine: elf.EM_SPARCV9, signal: 30, want: "SIGUSR1"},
+		"unknown":         {machine: elf.EM_X86_64, signal: 64, want: ""},
+		"none":            {machine: elf.EM_X86_64, signal: 0, want: ""},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := signalName(c.machine, c.signal)
+			if got != c.want {
+				t.Errorf(`signalName(%s, %d): wanted %q, got %q`, c.machine, c.signal, c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/elwinar/rcoredump/pkg/nats"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sink receives the analyzed cores, to publish them to other systems (e.g:
+// for alerting).
+type Sink interface {
+	Publish(Coredump) error
+	Close() error
+}
+
+// NATSSink publishes the cores as JSON documents on a NATS subject.
+type NATSSink struct {
+	client  *nats.Client
+	subject string
+}
+
+// compile-time check that the NATSSink actually implements the Sink
+// interface.
+var _ Sink = new(NATSSink)
+
+// NewNATSSink returns a sink publishing on the subject of the server at rawURL.
+// The connection is only opened on the first publication.
+func NewNATSSink(rawURL, subject string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, wrap(err, "parsing URL")
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if len(subject) == 0 {
+		return nil, errors.New("empty subject")
+	}
+
+	return &NATSSink{
+		client:  &nats.Client{URL: rawURL, Name: "rcoredumpd"},
+		subject: subject,
+	}, nil
+}
+
+// Publish the core.
+func (s *NATSSink) Publish(core Coredump) error {
+	raw, err := json.Marshal(core)
+	if err != nil {
+		return wrap(err, "encoding core")
+	}
+	return s.client.Publish(s.subject, raw)
+}
+
+// Close the connection to the server.
+func (s *NATSSink) Close() error {
+	return s.client.Close()
+}
+
+// enqueueSink queues the analyzed core for publication, if a sink is
+// configured. As for the relay, the queue is bounded so a slow or unreachable
+// sink doesn't slow down the analysis: when full, the core is dropped.
+func (s *service) enqueueSink(core Coredump) {
+	if s.sink == nil {
+		return
+	}
+
+	select {
+	case s.sinkQueue <- core:
+	default:
+		s.logger.Warn("sink queue full, dropping core", "uid", core.UID)
+		s.published.With(prometheus.Labels{"status": "dropped"}).Inc()
+	}
+}
+
+// publishCores publishes the queued cores until the context is closed. The
+// cores are published one at a time, in the order they were analyzed, and
+// aren't retried.
+func (s *service) publishCores(ctx context.Context) {
+	defer s.sink.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Debug("stopping sink queue")
+			return
+		case core := <-s.sinkQueue:
+			err := s.sink.Publish(core)
+			if err != nil {
+				s.logger.Error("publishing core", "uid", core.UID, "err", err)
+				s.published.With(prometheus.Labels{"status": "failed"}).Inc()
+				continue
+			}
+			s.published.With(prometheus.Labels{"status": "published"}).Inc()
+		}
+	}
+}
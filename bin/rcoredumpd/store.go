@@ -1,10 +1,15 @@
 package main
 
 import (
+	"compress/gzip"
+	"crypto/sha1"
 	"errors"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
 )
 
 type Store interface {
@@ -15,18 +20,36 @@ type Store interface {
 	StoreExecutable(hash string, src io.Reader) (int64, error)
 	DeleteExecutable(hash string) error
 	ExecutableExists(hash string) (bool, error)
+	StoreLink(hash string, link Link, src io.Reader) (int64, error)
+	Link(hash string, link Link) (*os.File, error)
+	Sysroot(hash string) (string, bool, error)
+	Trace(uid string) (io.ReadCloser, error)
+	StoreTrace(uid string, src io.Reader) (int64, error)
+	DeleteTrace(uid string) error
+	Artifact(uid, name string) (*os.File, error)
+	Artifacts(uid string) ([]ArtifactInfo, error)
+	StoreArtifact(uid, name string, src io.Reader) (int64, error)
+	DeleteArtifacts(uid string) error
+	FreeSpace() (uint64, error)
 }
 
 type FileStore struct {
 	root string
+	// compressTraces indicates that the traces are written gzipped. Both
+	// kinds of traces are read, so the option can be changed at any time.
+	compressTraces bool
+	// bufferSize is the size of the buffer the cores, executables and
+	// links are copied through, the default one of io.Copy if it isn't
+	// positive.
+	bufferSize int
 }
 
 // compile-time check that the FileStore actually implements the Store
 // interface.
 var _ Store = new(FileStore)
 
-func NewFileStore(root string) (Store, error) {
-	s := FileStore{root: root}
+func NewFileStore(root string, compressTraces bool, bufferSize int) (Store, error) {
+	s := FileStore{root: root, compressTraces: compressTraces, bufferSize: bufferSize}
 	return s, s.init()
 }
 
@@ -35,6 +58,9 @@ func (s FileStore) init() error {
 		s.root,
 		filepath.Join(s.root, "executables/"),
 		filepath.Join(s.root, "cores/"),
+		filepath.Join(s.root, "links/"),
+		filepath.Join(s.root, "traces/"),
+		filepath.Join(s.root, "artifacts/"),
 	} {
 		err := os.Mkdir(dir, os.ModeDir|0774)
 		if err != nil && !errors.Is(err, os.ErrExist) {
@@ -56,7 +82,7 @@ func (s FileStore) StoreCore(uid string, src io.Reader) (int64, error) {
 	}
 	defer f.Close()
 
-	written, err := io.Copy(f, src)
+	written, err := copyBuffer(f, src, s.bufferSize)
 	if err != nil {
 		return 0, wrap(err, "reading core")
 	}
@@ -73,21 +99,20 @@ func (s FileStore) Executable(hash string) (*os.File, error) {
 }
 
 func (s FileStore) StoreExecutable(hash string, src io.Reader) (int64, error) {
-	f, err := os.Create(filepath.Join(s.root, "executables", hash))
+	written, err := s.replace(filepath.Join(s.root, "executables", hash), src)
 	if err != nil {
-		return 0, wrap(err, "creating executable file")
-	}
-	defer f.Close()
-
-	written, err := io.Copy(f, src)
-	if err != nil {
-		return 0, wrap(err, "reading executable")
+		return 0, wrap(err, "writing executable")
 	}
 
 	return written, nil
 }
 
 func (s FileStore) DeleteExecutable(hash string) error {
+	err := os.RemoveAll(filepath.Join(s.root, "links", hash))
+	if err != nil {
+		return wrap(err, "removing links")
+	}
+
 	return os.Remove(filepath.Join(s.root, "executables", hash))
 }
 
@@ -100,3 +125,291 @@ func (s FileStore) ExecutableExists(hash string) (exists bool, err error) {
 	}
 	return exists, err
 }
+
+// StoreLink stores a library of the executable in the executable's sysroot,
+// i.e a directory mimicking the origin host's filesystem, and recreates the
+// symbolic links leading to it so the debugger can find it under any name.
+func (s FileStore) StoreLink(hash string, link Link, src io.Reader) (int64, error) {
+	sysroot := filepath.Join(s.root, "links", hash)
+	path := sysrootPath(sysroot, link.Path)
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0774)
+	if err != nil {
+		return 0, wrap(err, "creating link directory")
+	}
+
+	written, err := s.replace(path, src)
+	if err != nil {
+		return 0, wrap(err, "writing link")
+	}
+
+	// Each symlink of the chain points directly to the library, which is
+	// equivalent for the debugger. Relative targets keep the sysroot
+	// relocatable.
+	for _, symlink := range link.Symlinks {
+		symlink = sysrootPath(sysroot, symlink)
+		if symlink == path {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(symlink), os.ModeDir|0774)
+		if err != nil {
+			return 0, wrap(err, "creating symlink directory")
+		}
+
+		target, err := filepath.Rel(filepath.Dir(symlink), path)
+		if err != nil {
+			return 0, wrap(err, "computing symlink target")
+		}
+
+		err = os.Remove(symlink)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, wrap(err, "removing existing symlink")
+		}
+
+		err = os.Symlink(target, symlink)
+		if err != nil {
+			return 0, wrap(err, "creating symlink")
+		}
+	}
+
+	return written, nil
+}
+
+// Link returns the file of a library stored in the executable's sysroot.
+func (s FileStore) Link(hash string, link Link) (*os.File, error) {
+	return os.Open(sysrootPath(filepath.Join(s.root, "links", hash), link.Path))
+}
+
+// Sysroot returns the path of the directory containing the libraries of the
+// executable, and a boolean indicating if it exists.
+func (s FileStore) Sysroot(hash string) (string, bool, error) {
+	path := filepath.Join(s.root, "links", hash)
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, false, nil
+	}
+	if err != nil {
+		return path, false, err
+	}
+	return path, true, nil
+}
+
+// Trace returns the trace of the core, decompressing it if it was stored
+// compressed.
+func (s FileStore) Trace(uid string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.root, "traces", uid+".gz"))
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Open(filepath.Join(s.root, "traces", uid))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, wrap(err, "reading compressed trace")
+	}
+	return gzipReadCloser{Reader: r, file: f}, nil
+}
+
+func (s FileStore) StoreTrace(uid string, src io.Reader) (int64, error) {
+	name, stale := uid, uid+".gz"
+	if s.compressTraces {
+		name, stale = stale, name
+	}
+
+	f, err := os.Create(filepath.Join(s.root, "traces", name))
+	if err != nil {
+		return 0, wrap(err, "creating trace file")
+	}
+	defer f.Close()
+
+	var w io.WriteCloser = nopWriteCloser{f}
+	if s.compressTraces {
+		w = gzip.NewWriter(f)
+	}
+
+	written, err := io.Copy(w, src)
+	if err != nil {
+		return 0, wrap(err, "reading trace")
+	}
+
+	// Closing the gzip writer flushes the compressed data.
+	err = w.Close()
+	if err != nil {
+		return 0, wrap(err, "compressing trace")
+	}
+
+	// The trace could have been stored the other way before, if the
+	// option changed since then.
+	err = os.Remove(filepath.Join(s.root, "traces", stale))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, wrap(err, "removing stale trace file")
+	}
+
+	return written, nil
+}
+
+// DeleteTrace removes the trace of the core. Most cores don't have one, so a
+// missing trace isn't an error.
+func (s FileStore) DeleteTrace(uid string) error {
+	for _, name := range []string{uid, uid + ".gz"} {
+		err := os.Remove(filepath.Join(s.root, "traces", name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying file.
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r gzipReadCloser) Close() error {
+	err := r.Reader.Close()
+	if err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// nopWriteCloser is a writer whose closing is done elsewhere.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// errInvalidHash is returned when an executable hash isn't a hex-encoded sha1
+// hash, as computed by the forwarder.
+var errInvalidHash = errors.New("invalid executable hash")
+
+// validHash reports whether the hash is a hex-encoded sha1 hash. The hashes name
+// the files of the executables in the store, so any other value (e.g: "..")
+// could reach the files of other executables or cores.
+func validHash(hash string) bool {
+	if len(hash) != 2*sha1.Size {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// sysrootPath returns the path of the given origin host's path in the
+// sysroot. The path is cleaned as if it was absolute, so it can't escape the
+// sysroot.
+func sysrootPath(sysroot, path string) string {
+	return filepath.Join(sysroot, filepath.Clean("/"+path))
+}
+
+// Artifact returns the file of an artifact of the core.
+func (s FileStore) Artifact(uid, name string) (*os.File, error) {
+	return os.Open(filepath.Join(s.root, "artifacts", uid, name))
+}
+
+// Artifacts returns the artifacts of the core, sorted by name. A core without
+// artifacts has none.
+func (s FileStore) Artifacts(uid string) ([]ArtifactInfo, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.root, "artifacts", uid))
+	if errors.Is(err, os.ErrNotExist) {
+		return []ArtifactInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := make([]ArtifactInfo, 0, len(entries))
+	for _, e := range entries {
+		artifacts = append(artifacts, ArtifactInfo{Name: e.Name(), Size: e.Size()})
+	}
+	return artifacts, nil
+}
+
+// StoreArtifact stores an artifact of the core, replacing the one with the
+// same name if any.
+func (s FileStore) StoreArtifact(uid, name string, src io.Reader) (int64, error) {
+	dir := filepath.Join(s.root, "artifacts", uid)
+	err := os.MkdirAll(dir, os.ModeDir|0774)
+	if err != nil {
+		return 0, wrap(err, "creating artifacts directory")
+	}
+
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return 0, wrap(err, "creating artifact file")
+	}
+	defer f.Close()
+
+	written, err := io.Copy(f, src)
+	if err != nil {
+		return 0, wrap(err, "reading artifact")
+	}
+
+	return written, nil
+}
+
+// DeleteArtifacts removes the artifacts of the core, if any.
+func (s FileStore) DeleteArtifacts(uid string) error {
+	return os.RemoveAll(filepath.Join(s.root, "artifacts", uid))
+}
+
+// FreeSpace returns the space left on the filesystem of the store, as
+// available to the unprivileged users.
+func (s FileStore) FreeSpace() (uint64, error) {
+	return freeSpace(s.root)
+}
+
+// replace writes src to the file at path through a temporary file renamed over
+// it, so the routines reading the file in the meantime, e.g: a debugger, never
+// see it partially written.
+func (s FileStore) replace(path string, src io.Reader) (int64, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return 0, wrap(err, "creating temporary file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// The temporary files are only readable by their owner.
+	err = f.Chmod(0664)
+	if err != nil {
+		return 0, wrap(err, "changing temporary file mode")
+	}
+
+	written, err := copyBuffer(f, src, s.bufferSize)
+	if err != nil {
+		return 0, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return 0, wrap(err, "closing temporary file")
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return 0, wrap(err, "renaming temporary file")
+	}
+
+	return written, nil
+}
+
+// copyBuffer copies src to dst through a buffer of the given size, or with
+// io.Copy if it isn't positive. The ReadFrom method of dst is hidden, as the
+// one of the files would use its own 32KB buffer instead.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		return io.Copy(dst, src)
+	}
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, make([]byte, size))
+}
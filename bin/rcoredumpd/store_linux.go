@@ -0,0 +1,19 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the space available to the unprivileged users on the
+// filesystem of the path.
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, wrap(err, `reading filesystem statistics`)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
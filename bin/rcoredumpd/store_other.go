@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// freeSpace returns the space available on the filesystem of the path. It is
+// only supported on Linux.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New(`free space is only supported on linux`)
+}
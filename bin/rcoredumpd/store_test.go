@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestFileStore_Trace(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	trace := strings.Repeat("#0  0x0000555555555131 in main () at main.c:4\n", 100)
+
+	// The traces are stored alternatively compressed and uncompressed, to
+	// check the option can be changed at any time.
+	for _, c := range []struct {
+		compress bool
+		file     string
+	}{
+		{compress: true, file: "uid.gz"},
+		{compress: false, file: "uid"},
+		{compress: true, file: "uid.gz"},
+	} {
+		store, err := NewFileStore(root, c.compress, 0)
+		if err != nil {
+			t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+		}
+
+		_, err = store.StoreTrace("uid", strings.NewReader(trace))
+		if err != nil {
+			t.Fatalf(`StoreTrace(): unexpected error: %s`, err)
+		}
+
+		files, err := filepath.Glob(filepath.Join(root, "traces", "*"))
+		if err != nil {
+			t.Fatalf(`listing traces: %s`, err)
+		}
+		if len(files) != 1 || filepath.Base(files[0]) != c.file {
+			t.Errorf(`StoreTrace(): wanted only %s, got %v`, c.file, files)
+		}
+
+		r, err := store.Trace("uid")
+		if err != nil {
+			t.Fatalf(`Trace(): unexpected error: %s`, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf(`Trace(): reading: %s`, err)
+		}
+		if string(got) != trace {
+			t.Errorf(`Trace(): wanted the stored trace, got %q`, got)
+		}
+	}
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	err = store.DeleteTrace("uid")
+	if err != nil {
+		t.Fatalf(`DeleteTrace(): unexpected error: %s`, err)
+	}
+
+	_, err = store.Trace("uid")
+	if !os.IsNotExist(err) {
+		t.Errorf(`Trace(): wanted a not exist error after DeleteTrace(), got %v`, err)
+	}
+}
+
+func TestFileStore_Artifacts(t *testing.T) {
+	root, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileStore(root, false, 0)
+	if err != nil {
+		t.Fatalf(`NewFileStore(): unexpected error: %s`, err)
+	}
+
+	artifacts, err := store.Artifacts("uid")
+	if err != nil || len(artifacts) != 0 {
+		t.Errorf(`Artifacts(): wanted no artifact, got %#v, %v`, artifacts, err)
+	}
+
+	for name, content := range map[string]string{
+		"registers": "rax 0x0 0\n",
+		"libraries": "No shared libraries loaded at this time.\n",
+	} {
+		_, err = store.StoreArtifact("uid", name, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf(`StoreArtifact(%s): unexpected error: %s`, name, err)
+		}
+	}
+
+	artifacts, err = store.Artifacts("uid")
+	if err != nil {
+		t.Fatalf(`Artifacts(): unexpected error: %s`, err)
+	}
+	want := []ArtifactInfo{{Name: "libraries", Size: 41}, {Name: "registers", Size: 10}}
+	if !reflect.DeepEqual(artifacts, want) {
+		t.Errorf(`Artifacts(): wanted %#v, got %#v`, want, artifacts)
+	}
+
+	f, err := store.Artifact("uid", "registers")
+	if err != nil {
+		t.Fatalf(`Artifact(): unexpected error: %s`, err)
+	}
+	got, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(got) != "rax 0x0 0\n" {
+		t.Errorf(`Artifact(): wanted the stored artifact, got %q`, got)
+	}
+
+	err = store.DeleteArtifacts("uid")
+	if err != nil {
+		t.Fatalf(`DeleteArtifacts(): unexpected error: %s`, err)
+	}
+	_, err = store.Artifact("uid", "registers")
+	if !os.IsNotExist(err) {
+		t.Errorf(`Artifact(): wanted a not exist error after DeleteArtifacts(), got %v`, err)
+	}
+}
+
+func TestCopyBuffer(t *testing.T) {
+	f, err := ioutil.TempFile("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary file: %s`, err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	defer f.Close()
+
+	data := strings.Repeat("core", 1000)
+	for _, size := range []int{0, 1, 7, 1 << 20} {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf(`rewinding file: %s`, err)
+		}
+
+		written, err := copyBuffer(f, strings.NewReader(data), size)
+		if err != nil {
+			t.Fatalf(`copyBuffer(%d): unexpected error: %s`, size, err)
+		}
+		if written != int64(len(data)) {
+			t.Errorf(`copyBuffer(%d): wanted %d bytes written, got %d`, size, len(data), written)
+		}
+
+		got, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatalf(`reading file: %s`, err)
+		}
+		if string(got) != data {
+			t.Errorf(`copyBuffer(%d): wanted the data copied, got %d bytes`, size, len(got))
+		}
+	}
+}
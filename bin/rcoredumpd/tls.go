@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// certificate is the certificate the server listens with over HTTPS, read
+// from its files. It can be read again from them while serving, so the
+// certificate can be rotated without restarting the server.
+type certificate struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// loadCertificate reads the certificate and key from their files.
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the certificate and key from their files again. The current
+// certificate is kept if they can't be read.
+func (c *certificate) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return wrap(err, "loading certificate")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// get returns the current certificate, as the GetCertificate callback of the
+// TLS configuration of the server.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Reload the certificate on SIGHUP.
+func (s *service) reloadCertificate(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			s.logger.Info("reloading certificate", "cert", s.tlsCert, "key", s.tlsKey)
+			err := s.certificate.reload()
+			if err != nil {
+				s.logger.Error("reloading certificate", "err", err)
+			}
+		}
+	}
+}
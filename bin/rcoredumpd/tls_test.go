@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for the given name and its
+// key at the given paths.
+func writeCertificate(t *testing.T, name, certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`generating key: %s`, err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(`creating certificate: %s`, err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf(`encoding key: %s`, err)
+	}
+
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	if err != nil {
+		t.Fatalf(`writing certificate: %s`, err)
+	}
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	if err != nil {
+		t.Fatalf(`writing key: %s`, err)
+	}
+}
+
+func TestCertificate_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcoredumpd")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certPath, keyPath := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+
+	// name returns the name of the certificate currently served.
+	name := func(c *certificate) string {
+		cert, err := c.get(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf(`get(): unexpected error: %s`, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf(`parsing certificate: %s`, err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	_, err = loadCertificate(certPath, keyPath)
+	if err == nil {
+		t.Errorf(`loadCertificate(missing files): wanted error, got nil`)
+	}
+
+	writeCertificate(t, "first", certPath, keyPath)
+	c, err := loadCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf(`loadCertificate(): unexpected error: %s`, err)
+	}
+	if got := name(c); got != "first" {
+		t.Errorf(`wanted certificate first, got %s`, got)
+	}
+
+	writeCertificate(t, "second", certPath, keyPath)
+	err = c.reload()
+	if err != nil {
+		t.Fatalf(`reload(): unexpected error: %s`, err)
+	}
+	if got := name(c); got != "second" {
+		t.Errorf(`reload(): wanted certificate second, got %s`, got)
+	}
+
+	// A broken certificate file doesn't replace the current certificate.
+	err = ioutil.WriteFile(certPath, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatalf(`writing certificate: %s`, err)
+	}
+	err = c.reload()
+	if err == nil {
+		t.Errorf(`reload(broken file): wanted error, got nil`)
+	}
+	if got := name(c); got != "second" {
+		t.Errorf(`reload(broken file): wanted certificate second kept, got %s`, got)
+	}
+}
@@ -0,0 +1,11 @@
+int exported_variable = 42;
+
+int exported_function(int a)
+{
+	return a + exported_variable;
+}
+
+static int hidden_function(int a)
+{
+	return a;
+}
@@ -0,0 +1,118 @@
+// apport contains a parser for the problem reports generated by Ubuntu's
+// apport crash handler (usually found as .crash files in /var/crash). The
+// format is described in
+// https://wiki.ubuntu.com/Apport#Crash_report_format, and implemented by
+// apport's problem_report module.
+package apport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotBinary = errors.New(`not a binary field`)
+	ErrMissing   = errors.New(`missing field`)
+)
+
+// Report is a problem report, i.e a list of key-value pairs. Multi-line
+// values are joined using \n, and binary values are kept in their encoded
+// form. See Report.Open.
+type Report map[string]string
+
+// DateLayout is the layout of the Date field of the reports, as generated by
+// Python's time.asctime.
+const DateLayout = time.ANSIC
+
+// binaryPrefix is the value of the first line of a binary field.
+const binaryPrefix = "base64"
+
+// Parse reads a problem report from r.
+func Parse(r io.Reader) (Report, error) {
+	report := Report{}
+
+	// Lines can be very long for binary fields, so we don't use a
+	// bufio.Scanner that would impose a maximum size.
+	reader := bufio.NewReader(r)
+	var key string
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf(`reading line %d: %w`, n, err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		// Lines starting with a space are the continuation of the
+		// previous field's value.
+		if strings.HasPrefix(line, " ") {
+			if len(key) == 0 {
+				return nil, fmt.Errorf(`line %d: continuation line without a field`, n)
+			}
+			if len(report[key]) != 0 {
+				report[key] += "\n"
+			}
+			report[key] += line[1:]
+			continue
+		}
+
+		chunks := strings.SplitN(line, ":", 2)
+		if len(chunks) != 2 {
+			return nil, fmt.Errorf(`line %d: invalid field %q`, n, line)
+		}
+		key = chunks[0]
+		report[key] = strings.TrimPrefix(chunks[1], " ")
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return report, nil
+}
+
+// IsBinary reports whether the field named key is a binary one.
+func (r Report) IsBinary(key string) bool {
+	return r[key] == binaryPrefix || strings.HasPrefix(r[key], binaryPrefix+"\n")
+}
+
+// Open returns a reader on the decoded content of the binary field named key.
+//
+// NOTE Binary values are gzip streams, with every chunk written by apport
+// base64-encoded on its own line. Because of the padding, the lines must be
+// decoded separately.
+func (r Report) Open(key string) (io.Reader, error) {
+	value, ok := r[key]
+	if !ok {
+		return nil, ErrMissing
+	}
+	if !r.IsBinary(key) {
+		return nil, ErrNotBinary
+	}
+
+	lines := strings.Split(value, "\n")[1:]
+	readers := make([]io.Reader, 0, len(lines))
+	for _, line := range lines {
+		readers = append(readers, base64.NewDecoder(base64.StdEncoding, strings.NewReader(line)))
+	}
+
+	return gzip.NewReader(io.MultiReader(readers...))
+}
+
+// Date returns the parsed value of the Date field. As the reports don't
+// include a timezone, the date is considered to be local.
+func (r Report) Date() (time.Time, error) {
+	value, ok := r["Date"]
+	if !ok {
+		return time.Time{}, ErrMissing
+	}
+	return time.ParseInLocation(DateLayout, value, time.Local)
+}
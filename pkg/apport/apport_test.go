@@ -0,0 +1,107 @@
+package apport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elwinar/rcoredump/pkg/testingx"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	const path = `testdata/sample.crash`
+
+	report, err := Parse(testingx.Open(t, `sample.crash`))
+	if err != nil {
+		t.Fatalf(`Parse(%q): unexpected error: %s`, path, err)
+	}
+
+	// The binary field is checked separately.
+	if !report.IsBinary("CoreDump") {
+		t.Errorf(`Parse(%q): expected CoreDump to be a binary field`, path)
+	}
+	delete(report, "CoreDump")
+
+	expected := Report{
+		"ProblemType":         "Crash",
+		"Architecture":        "amd64",
+		"Date":                "Tue Oct 13 14:21:07 2020",
+		"DistroRelease":       "Ubuntu 20.04",
+		"ExecutablePath":      "/usr/bin/crasher",
+		"ExecutableTimestamp": "1602591600",
+		"Package":             "crasher 1.2.3-0ubuntu1",
+		"ProcCmdline":         "/usr/bin/crasher --flag value",
+		"ProcCwd":             "/home/user",
+		"ProcMaps":            "00400000-00452000 r-xp 00000000 08:02 173521 /usr/bin/crasher\n00651000-00652000 r--p 00051000 08:02 173521 /usr/bin/crasher",
+		"Signal":              "11",
+		"Uname":               "Linux 5.4.0-48-generic x86_64",
+	}
+
+	if !cmp.Equal(report, expected) {
+		t.Errorf(`Parse(%q): unexpected result`, path)
+		t.Log(cmp.Diff(report, expected))
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for n, input := range map[string]string{
+		"orphan continuation": " value\n",
+		"missing colon":       "Key value\n",
+	} {
+		t.Run(n, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(input))
+			if err == nil {
+				t.Errorf(`Parse(%q): expected error, got nil`, input)
+			}
+		})
+	}
+}
+
+func TestReport_Open(t *testing.T) {
+	report, err := Parse(testingx.Open(t, `sample.crash`))
+	if err != nil {
+		t.Fatalf(`Parse(): unexpected error: %s`, err)
+	}
+
+	r, err := report.Open("CoreDump")
+	if err != nil {
+		t.Fatalf(`Report.Open("CoreDump"): unexpected error: %s`, err)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf(`Report.Open("CoreDump"): reading: %s`, err)
+	}
+
+	expected := testingx.ReadFile(t, `sample.core`)
+	if !bytes.Equal(got, expected) {
+		t.Errorf(`Report.Open("CoreDump"): unexpected content`)
+	}
+
+	_, err = report.Open("Package")
+	if err != ErrNotBinary {
+		t.Errorf(`Report.Open("Package"): wanted %v, got %v`, ErrNotBinary, err)
+	}
+
+	_, err = report.Open("Missing")
+	if err != ErrMissing {
+		t.Errorf(`Report.Open("Missing"): wanted %v, got %v`, ErrMissing, err)
+	}
+}
+
+func TestReport_Date(t *testing.T) {
+	report := Report{"Date": "Tue Oct 13 14:21:07 2020"}
+
+	got, err := report.Date()
+	if err != nil {
+		t.Fatalf(`Report.Date(): unexpected error: %s`, err)
+	}
+
+	expected := time.Date(2020, time.October, 13, 14, 21, 7, 0, time.Local)
+	if !got.Equal(expected) {
+		t.Errorf(`Report.Date(): wanted %s, got %s`, expected, got)
+	}
+}
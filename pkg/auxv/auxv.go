@@ -29,11 +29,88 @@ func (t *Type) ReadFrom(r io.Reader) error {
 	return nil
 }
 
-//go:generate stringer -type Type
+// Types of the auxilliary vector entries, as defined by the kernel.
 const (
-	TypePlatform Type = 15
+	TypeNull            Type = 0
+	TypeIgnore          Type = 1
+	TypeExecFD          Type = 2
+	TypePHDR            Type = 3
+	TypePHEnt           Type = 4
+	TypePHNum           Type = 5
+	TypePageSize        Type = 6
+	TypeBase            Type = 7
+	TypeFlags           Type = 8
+	TypeEntry           Type = 9
+	TypeNotELF          Type = 10
+	TypeUID             Type = 11
+	TypeEUID            Type = 12
+	TypeGID             Type = 13
+	TypeEGID            Type = 14
+	TypePlatform        Type = 15
+	TypeHWCap           Type = 16
+	TypeClockTick       Type = 17
+	TypeSecure          Type = 23
+	TypeBasePlatform    Type = 24
+	TypeRandom          Type = 25
+	TypeHWCap2          Type = 26
+	TypeRSeqFeatureSize Type = 27
+	TypeRSeqAlign       Type = 28
+	TypeHWCap3          Type = 29
+	TypeHWCap4          Type = 30
+	TypeExecFn          Type = 31
+	TypeSysinfo         Type = 32
+	TypeSysinfoEHDR     Type = 33
+	TypeMinSigStkSz     Type = 51
 )
 
+// names of the types, as used by the kernel and the C library.
+var names = map[Type]string{
+	TypeNull:            "AT_NULL",
+	TypeIgnore:          "AT_IGNORE",
+	TypeExecFD:          "AT_EXECFD",
+	TypePHDR:            "AT_PHDR",
+	TypePHEnt:           "AT_PHENT",
+	TypePHNum:           "AT_PHNUM",
+	TypePageSize:        "AT_PAGESZ",
+	TypeBase:            "AT_BASE",
+	TypeFlags:           "AT_FLAGS",
+	TypeEntry:           "AT_ENTRY",
+	TypeNotELF:          "AT_NOTELF",
+	TypeUID:             "AT_UID",
+	TypeEUID:            "AT_EUID",
+	TypeGID:             "AT_GID",
+	TypeEGID:            "AT_EGID",
+	TypePlatform:        "AT_PLATFORM",
+	TypeHWCap:           "AT_HWCAP",
+	TypeClockTick:       "AT_CLKTCK",
+	TypeSecure:          "AT_SECURE",
+	TypeBasePlatform:    "AT_BASE_PLATFORM",
+	TypeRandom:          "AT_RANDOM",
+	TypeHWCap2:          "AT_HWCAP2",
+	TypeRSeqFeatureSize: "AT_RSEQ_FEATURE_SIZE",
+	TypeRSeqAlign:       "AT_RSEQ_ALIGN",
+	TypeHWCap3:          "AT_HWCAP3",
+	TypeHWCap4:          "AT_HWCAP4",
+	TypeExecFn:          "AT_EXECFN",
+	TypeSysinfo:         "AT_SYSINFO",
+	TypeSysinfoEHDR:     "AT_SYSINFO_EHDR",
+	TypeMinSigStkSz:     "AT_MINSIGSTKSZ",
+}
+
+// String returns the name of the type, or its value for the unknown ones.
+func (t Type) String() string {
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("AT_%d", Word(t))
+}
+
+// IsString reports whether the value of the type is a pointer to a
+// null-terminated string, that can be read with Word.ReadString.
+func (t Type) IsString() bool {
+	return t == TypePlatform || t == TypeBasePlatform || t == TypeExecFn
+}
+
 // Vector is an auxilliary vector, i.e the list of key-value pairs provided by
 // the kernel about the environment in which a program is operating.
 // See https://www.gnu.org/software/libc/manual/html_node/Auxiliary-Vector.html.
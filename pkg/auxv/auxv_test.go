@@ -25,3 +25,15 @@ func TestVector_ReadFrom(t *testing.T) {
 		t.Log(cmp.Diff(vector, expected))
 	}
 }
+
+func TestType_String(t *testing.T) {
+	for typ, want := range map[Type]string{
+		TypePlatform:    "AT_PLATFORM",
+		TypeSysinfoEHDR: "AT_SYSINFO_EHDR",
+		Type(99):        "AT_99",
+	} {
+		if got := typ.String(); got != want {
+			t.Errorf(`Type(%d).String(): wanted %q, got %q`, Word(typ), want, got)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package buildinfo reads the build information embedded by the Go toolchain
+// in the executables it builds: the version of Go, and the main module.
+//
+// The format is the one read by the debug/buildinfo package of the standard
+// library (see also runtime/debug.BuildInfo), which isn't used so the older
+// toolchains are still supported.
+package buildinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// magic starts the .go.buildinfo section.
+var magic = []byte("\xff Go buildinf:")
+
+// Size of the header of the section, the strings following it if they are
+// inline.
+const headerSize = 32
+
+// Flags of the header.
+const (
+	flagBigEndian = 1 << 0
+	flagInline    = 1 << 1
+)
+
+// Errors returned when reading the build information.
+var (
+	// ErrNotFound is returned when the executable has no build
+	// information, i.e it wasn't built by the Go toolchain.
+	ErrNotFound = errors.New("no build information")
+	// ErrInvalid is returned when the build information can't be
+	// decoded.
+	ErrInvalid = errors.New("invalid build information")
+)
+
+// Info is the build information of an executable.
+type Info struct {
+	// GoVersion is the version of Go that built the executable (e.g:
+	// go1.21.3).
+	GoVersion string
+	// Path of the main package.
+	Path string
+	// Module is the path of the main module, and ModuleVersion its version
+	// ("(devel)" if it was built from a working directory). They are empty
+	// if the executable wasn't built in module mode.
+	Module        string
+	ModuleVersion string
+}
+
+// Read the build information of the executable, from its .go.buildinfo
+// section.
+func Read(file *elf.File) (Info, error) {
+	section := file.Section(".go.buildinfo")
+	if section == nil {
+		return Info{}, ErrNotFound
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Parse(data, memory{file})
+}
+
+// Parse the content of the .go.buildinfo section. Since Go 1.18, the strings
+// are inline; before, the section points to them, and they are read from the
+// memory of the executable, which can be nil for the recent executables.
+func Parse(data []byte, mem io.ReaderAt) (Info, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, magic) {
+		return Info{}, fmt.Errorf(`%w: missing header`, ErrInvalid)
+	}
+	ptrSize := int(data[len(magic)])
+	flags := data[len(magic)+1]
+
+	var version, modinfo string
+	if flags&flagInline != 0 {
+		var rest []byte
+		var ok bool
+		version, rest, ok = inlineString(data[headerSize:])
+		if ok {
+			modinfo, _, ok = inlineString(rest)
+		}
+		if !ok {
+			return Info{}, fmt.Errorf(`%w: truncated strings`, ErrInvalid)
+		}
+	} else {
+		if ptrSize != 4 && ptrSize != 8 {
+			return Info{}, fmt.Errorf(`%w: pointer size %d`, ErrInvalid, ptrSize)
+		}
+		if mem == nil {
+			return Info{}, fmt.Errorf(`%w: pointed strings without memory`, ErrInvalid)
+		}
+
+		var order binary.ByteOrder = binary.LittleEndian
+		if flags&flagBigEndian != 0 {
+			order = binary.BigEndian
+		}
+		r := reader{mem: mem, ptrSize: ptrSize, order: order}
+		var err error
+		version, err = r.string(r.pointer(data[16:]))
+		if err != nil {
+			return Info{}, err
+		}
+		modinfo, err = r.string(r.pointer(data[16+ptrSize:]))
+		if err != nil {
+			return Info{}, err
+		}
+	}
+
+	info := Info{GoVersion: version}
+	parseModinfo(&info, modinfo)
+	return info, nil
+}
+
+// inlineString reads a string prefixed by its length as an uvarint, and
+// returns the rest of the data.
+func inlineString(data []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return "", nil, false
+	}
+	return string(data[n : n+int(length)]), data[n+int(length):], true
+}
+
+// parseModinfo fills the information from the module description, made of
+// tab-separated lines (e.g: "mod\texample.com/server\tv1.2.0\th1:...").
+func parseModinfo(info *Info, modinfo string) {
+	// The description is surrounded by 16 bytes sentinels, ending with a
+	// newline for the first one.
+	if len(modinfo) >= 33 && modinfo[len(modinfo)-17] == '\n' {
+		modinfo = modinfo[16 : len(modinfo)-16]
+	}
+
+	for _, line := range strings.Split(modinfo, "\n") {
+		fields := strings.Split(line, "\t")
+		switch {
+		case fields[0] == "path" && len(fields) >= 2:
+			info.Path = fields[1]
+		case fields[0] == "mod" && len(fields) >= 3:
+			info.Module = fields[1]
+			info.ModuleVersion = fields[2]
+		}
+	}
+}
+
+// reader reads the strings pointed to by the section from the memory of the
+// executable.
+type reader struct {
+	mem     io.ReaderAt
+	ptrSize int
+	order   binary.ByteOrder
+}
+
+// pointer decodes the pointer at the beginning of the data.
+func (r reader) pointer(data []byte) uint64 {
+	if r.ptrSize == 4 {
+		return uint64(r.order.Uint32(data))
+	}
+	return r.order.Uint64(data)
+}
+
+// string reads the Go string whose header (pointer and length) is at addr.
+func (r reader) string(addr uint64) (string, error) {
+	header := make([]byte, 2*r.ptrSize)
+	_, err := r.mem.ReadAt(header, int64(addr))
+	if err != nil {
+		return "", fmt.Errorf(`%w: reading string header at %#x: %s`, ErrInvalid, addr, err)
+	}
+
+	// The strings are limited to avoid huge allocations on corrupted
+	// files. The module description is the longest, and rarely goes above
+	// a few kilobytes.
+	length := r.pointer(header[r.ptrSize:])
+	if length > 1<<20 {
+		return "", fmt.Errorf(`%w: string of %d bytes at %#x`, ErrInvalid, length, addr)
+	}
+
+	data := make([]byte, length)
+	_, err = r.mem.ReadAt(data, int64(r.pointer(header)))
+	if err != nil {
+		return "", fmt.Errorf(`%w: reading string at %#x: %s`, ErrInvalid, addr, err)
+	}
+	return string(data), nil
+}
+
+// memory of an executable, read by virtual address from its loaded segments.
+type memory struct {
+	file *elf.File
+}
+
+// ReadAt implements io.ReaderAt.
+func (m memory) ReadAt(p []byte, addr int64) (int, error) {
+	for _, prog := range m.file.Progs {
+		if prog.Type != elf.PT_LOAD || uint64(addr) < prog.Vaddr || uint64(addr)+uint64(len(p)) > prog.Vaddr+prog.Filesz {
+			continue
+		}
+		return prog.ReadAt(p, addr-int64(prog.Vaddr))
+	}
+	return 0, fmt.Errorf(`address %#x not loaded`, addr)
+}
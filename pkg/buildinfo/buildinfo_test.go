@@ -0,0 +1,96 @@
+package buildinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/testingx"
+)
+
+// modinfo is a module description as embedded by the toolchain, with its
+// sentinels.
+const modinfo = "0w\xaf\x0c\x92t\x08\x02A\xe1\xc1\x07\xe6\xd6\x18\xe6" +
+	"path\texample.com/server/cmd/server\n" +
+	"mod\texample.com/server\tv1.2.0\th1:abc=\n" +
+	"dep\tgolang.org/x/sys\tv0.1.0\th1:def=\n" +
+	"\xf92C1\x86\x18 r\x00\x82B\x10A\x16\xd8\xf2"
+
+// pointed returns the section of a 64 bits little-endian executable built
+// before Go 1.18, and the memory its pointers refer to.
+func pointed(version string) ([]byte, []byte) {
+	const base = 0x1000
+
+	var mem bytes.Buffer
+	header := func(s string, at int) {
+		binary.Write(&mem, binary.LittleEndian, uint64(base+at))
+		binary.Write(&mem, binary.LittleEndian, uint64(len(s)))
+	}
+	// The string headers are followed by the strings themselves.
+	header(version, 32)
+	header(modinfo, 32+len(version))
+	mem.WriteString(version)
+	mem.WriteString(modinfo)
+
+	data := make([]byte, headerSize)
+	copy(data, magic)
+	data[len(magic)] = 8
+	binary.LittleEndian.PutUint64(data[16:], base)
+	binary.LittleEndian.PutUint64(data[24:], base+16)
+
+	return data, append(make([]byte, base), mem.Bytes()...)
+}
+
+func TestParse(t *testing.T) {
+	old, mem := pointed("go1.16.15")
+
+	for n, c := range map[string]struct {
+		data []byte
+		mem  []byte
+		want Info
+	}{
+		"inline": {
+			data: testingx.ReadFile(t, "go1.27.buildinfo"),
+			want: Info{GoVersion: "go1.27.1", Path: "example.com/crasher", Module: "example.com/crasher", ModuleVersion: "(devel)"},
+		},
+		"pointed": {
+			data: old,
+			mem:  mem,
+			want: Info{GoVersion: "go1.16.15", Path: "example.com/server/cmd/server", Module: "example.com/server", ModuleVersion: "v1.2.0"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := Parse(c.data, bytes.NewReader(c.mem))
+			if err != nil {
+				t.Fatalf(`Parse(): unexpected error: %s`, err)
+			}
+			if got != c.want {
+				t.Errorf(`Parse(): wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	inline := testingx.ReadFile(t, "go1.27.buildinfo")
+	old, mem := pointed("go1.16.15")
+
+	for n, c := range map[string]struct {
+		data []byte
+		mem  []byte
+	}{
+		"empty":           {data: nil},
+		"bad magic":       {data: append([]byte("\xff Go buildinfo"), inline[14:]...)},
+		"truncated":       {data: inline[:40]},
+		"missing memory":  {data: old, mem: mem[:0x1010]},
+		"dangling string": {data: old, mem: mem[:0x1030]},
+	} {
+		t.Run(n, func(t *testing.T) {
+			_, err := Parse(c.data, bytes.NewReader(c.mem))
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf(`Parse(): wanted ErrInvalid, got %v`, err)
+			}
+		})
+	}
+}
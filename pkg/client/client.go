@@ -0,0 +1,280 @@
+// Package client implements the protocol used to send coredumps to a rcoredumpd
+// server. It is used by the forwarder, and by the server itself to relay the
+// coredumps it receives to another one.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/elwinar/rcoredump/pkg/protocol"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// Client of a rcoredumpd server.
+type Client struct {
+	// Address of the server (e.g: http://localhost:1105).
+	Dest string
+	// HTTP client used for the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+	// Bearer token sent in the Authorization header of the requests, none
+	// if empty.
+	Token string
+}
+
+// Upload is a coredump to send to the server, along with its files. The files
+// are opened one after the other while the request is being sent, and closed
+// once sent.
+type Upload struct {
+	// Header of the request. The executable is only sent if
+	// IncludeExecutable is set, followed by the libraries of Links that are
+	// Sent.
+	Header IndexRequest
+	// Total size of the files before compression, 0 if unknown. See
+	// SizeHeader.
+	Size int64
+	// OpenCore opens the coredump.
+	OpenCore func() (io.ReadCloser, error)
+	// OpenExecutable opens the executable.
+	OpenExecutable func() (io.ReadCloser, error)
+	// OpenLink opens the file of a library.
+	OpenLink func(Link) (io.ReadCloser, error)
+}
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	Status int
+	Err    Error
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s (%s)", e.Status, e.Err.Err, e.Err.Code)
+}
+
+func (c Client) http() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// newRequest prepares a request to the endpoint at path, authenticated with
+// the token if any.
+func (c Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.Dest+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// LookupExecutable checks if the server already has the executable with the
+// given hash, in which case it doesn't need to be sent.
+func (c Client) LookupExecutable(hash string) (bool, error) {
+	req, err := c.newRequest(http.MethodHead, "/executables/"+hash, nil)
+	if err != nil {
+		return false, wrap(err, "preparing request")
+	}
+	res, err := c.http().Do(req)
+	if err != nil {
+		return false, wrap(err, "executing request")
+	}
+	defer res.Body.Close()
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false, wrap(err, "reading response")
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		var err Error
+		jsonErr := json.Unmarshal(raw, &err)
+		if jsonErr != nil {
+			return false, wrap(jsonErr, "reading unexpected response")
+		}
+		return false, wrap(errors.New(err.Err), "unexpected response")
+	}
+}
+
+// Version returns the compatibility information of the server. Servers
+// predating the endpoint answer with a StatusError with a 404 status.
+func (c Client) Version() (VersionInfo, error) {
+	var info VersionInfo
+	err := c.get("/version", &info)
+	return info, err
+}
+
+// Capabilities returns what the server supports. Servers predating the
+// endpoint answer with a StatusError with a 404 status.
+func (c Client) Capabilities() (Capabilities, error) {
+	var caps Capabilities
+	err := c.get("/capabilities", &caps)
+	return caps, err
+}
+
+// get decodes the JSON response of the endpoint at path into v.
+func (c Client) get(path string, v interface{}) error {
+	req, err := c.newRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return wrap(err, "preparing request")
+	}
+	res, err := c.http().Do(req)
+	if err != nil {
+		return wrap(err, "executing request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var err Error
+		_ = json.NewDecoder(res.Body).Decode(&err)
+		return StatusError{Status: res.StatusCode, Err: err}
+	}
+
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		return wrap(err, "reading response")
+	}
+
+	return nil
+}
+
+// Compatible reports whether the server can read the coredumps sent by the
+// client.
+func Compatible(info VersionInfo) bool {
+	return protocol.Version >= info.MinProtocolVersion && protocol.Version <= info.MaxProtocolVersion
+}
+
+// Send the coredump to the server. If the server answers with an unexpected
+// status, the returned error is a StatusError.
+func (c Client) Send(u Upload) error {
+	// We will use chunked transfer encoding to avoid keeping the whole
+	// dump in memory more than necessary. We will do this by giving the
+	// request a pipe as body, so it will read from it and send the
+	// content in multiple packets. This is a necessity given that a dump
+	// can measure in GB.
+	pr, pw := io.Pipe()
+
+	// Fill up the pipe in a routine so the sending happens in parallel and
+	// the memory consumption is kept in check.
+	written := make(chan error, 1)
+	go func() {
+		err := u.write(pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	req, err := c.newRequest(http.MethodPost, "/cores", pr)
+	if err != nil {
+		pr.Close()
+		<-written
+		return wrap(err, "preparing request")
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// Tell the server how much it is about to receive, if we know it.
+	if u.Size > 0 {
+		req.Header.Set(SizeHeader, strconv.FormatInt(u.Size, 10))
+	}
+
+	res, err := c.http().Do(req)
+	if err != nil {
+		// The body is closed on error, so the routine is done. Its
+		// error is more telling, unless it was caused by the closing.
+		werr := <-written
+		if werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+			return wrap(werr, "writing request")
+		}
+		return wrap(err, "sending request")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}()
+
+	// The server can answer before reading the whole body, in which case
+	// the routine must be stopped.
+	pr.Close()
+	werr := <-written
+
+	if res.StatusCode != http.StatusOK {
+		var err Error
+		_ = json.NewDecoder(res.Body).Decode(&err)
+		return StatusError{Status: res.StatusCode, Err: err}
+	}
+
+	if werr != nil {
+		return wrap(werr, "writing request")
+	}
+
+	return nil
+}
+
+// write the body of the request. See the protocol package.
+func (u Upload) write(w io.Writer) error {
+	enc := protocol.NewEncoder(w)
+
+	err := enc.WriteHeader(u.Header)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(enc, u.OpenCore)
+	if err != nil {
+		return wrap(err, "writing core")
+	}
+
+	if !u.Header.IncludeExecutable {
+		return nil
+	}
+
+	err = writeFile(enc, u.OpenExecutable)
+	if err != nil {
+		return wrap(err, "writing executable")
+	}
+
+	for _, link := range u.Header.Links {
+		if !link.Sent() {
+			continue
+		}
+
+		err = writeFile(enc, func() (io.ReadCloser, error) {
+			return u.OpenLink(link)
+		})
+		if err != nil {
+			return wrap(err, "writing link %s", link.Name)
+		}
+	}
+
+	return nil
+}
+
+// writeFile opens the file only when it is its turn to be written, so the
+// files aren't all open at once.
+func writeFile(enc *protocol.Encoder, open func() (io.ReadCloser, error)) error {
+	f, err := open()
+	if err != nil {
+		return wrap(err, "opening file")
+	}
+	defer f.Close()
+
+	_, err = enc.WriteMember(f)
+	return err
+}
+
+// wrap an error using the provided message and arguments.
+func wrap(err error, msg string, args ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(msg, args...), err)
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/protocol"
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestClient_Send(t *testing.T) {
+	var header IndexRequest
+	var files []string
+	var size string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size = r.Header.Get(SizeHeader)
+
+		dec := protocol.NewDecoder(r.Body)
+		defer dec.Close()
+
+		var err error
+		header, err = dec.ReadHeader()
+		if err != nil {
+			t.Errorf(`reading header: %s`, err)
+			return
+		}
+
+		for {
+			member, err := dec.NextMember()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf(`reading member: %s`, err)
+				return
+			}
+
+			raw, err := ioutil.ReadAll(member)
+			if err != nil {
+				t.Errorf(`reading file: %s`, err)
+				return
+			}
+			files = append(files, string(raw))
+		}
+
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer server.Close()
+
+	open := func(content string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(content)), nil
+		}
+	}
+
+	err := Client{Dest: server.URL}.Send(Upload{
+		Header: IndexRequest{
+			Hostname:          "localhost",
+			IncludeExecutable: true,
+			Links: []Link{
+				{Name: "libc.so.6", Path: "/lib/libc.so.6", Found: true},
+				{Name: "libmissing.so", Found: false},
+				{Name: "libm.so.6", Path: "/lib/libm.so.6", Found: true},
+			},
+		},
+		Size:           42,
+		OpenCore:       open("core"),
+		OpenExecutable: open("executable"),
+		OpenLink: func(link Link) (io.ReadCloser, error) {
+			return open(link.Path)()
+		},
+	})
+	if err != nil {
+		t.Fatalf(`Send(): unexpected error: %s`, err)
+	}
+
+	if header.Hostname != "localhost" {
+		t.Errorf(`Send(): wanted header hostname %q, got %q`, "localhost", header.Hostname)
+	}
+
+	want := []string{"core", "executable", "/lib/libc.so.6", "/lib/libm.so.6"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf(`Send(): wanted files %#v, got %#v`, want, files)
+	}
+
+	if size != "42" {
+		t.Errorf(`Send(): wanted size header %q, got %q`, "42", size)
+	}
+}
+
+func TestClient_Send_Status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"code":"unavailable","error":"too many coredumps being received"}`))
+	}))
+	defer server.Close()
+
+	err := Client{Dest: server.URL}.Send(Upload{
+		OpenCore: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("core")), nil
+		},
+	})
+
+	var statusErr StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf(`Send(): wanted a StatusError, got %v`, err)
+	}
+	if statusErr.Status != http.StatusServiceUnavailable || statusErr.Err.Code != ErrCodeUnavailable {
+		t.Errorf(`Send(): wanted status %d and code %s, got %d and %s`, http.StatusServiceUnavailable, ErrCodeUnavailable, statusErr.Status, statusErr.Err.Code)
+	}
+}
+
+func TestClient_Version(t *testing.T) {
+	for n, c := range map[string]struct {
+		info VersionInfo
+		want bool
+	}{
+		"compatible":   {info: VersionInfo{MinProtocolVersion: protocol.MinVersion, MaxProtocolVersion: protocol.Version}, want: true},
+		"older server": {info: VersionInfo{MinProtocolVersion: 0, MaxProtocolVersion: protocol.Version - 1}, want: false},
+		"newer server": {info: VersionInfo{MinProtocolVersion: protocol.Version + 1, MaxProtocolVersion: protocol.Version + 1}, want: false},
+	} {
+		t.Run(n, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/version" {
+					t.Errorf(`Version(): unexpected path %s`, r.URL.Path)
+				}
+				json.NewEncoder(w).Encode(c.info)
+			}))
+			defer server.Close()
+
+			info, err := Client{Dest: server.URL}.Version()
+			if err != nil {
+				t.Fatalf(`Version(): unexpected error: %s`, err)
+			}
+			if info != c.info {
+				t.Errorf(`Version(): wanted %#v, got %#v`, c.info, info)
+			}
+			if Compatible(info) != c.want {
+				t.Errorf(`Compatible(): wanted %t, got %t`, c.want, !c.want)
+			}
+		})
+	}
+}
+
+func TestClient_Capabilities(t *testing.T) {
+	want := Capabilities{
+		VersionInfo:       VersionInfo{Version: "v1.0.0", MinProtocolVersion: protocol.MinVersion, MaxProtocolVersion: protocol.Version},
+		Compression:       []string{protocol.Compression},
+		Debuginfod:        true,
+		MaxCoreSize:       1 << 30,
+		MaxExecutableSize: 1 << 20,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/capabilities" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","error":"not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer server.Close()
+
+	caps, err := Client{Dest: server.URL}.Capabilities()
+	if err != nil {
+		t.Fatalf(`Capabilities(): unexpected error: %s`, err)
+	}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf(`Capabilities(): wanted %#v, got %#v`, want, caps)
+	}
+
+	// Older servers don't know the endpoint.
+	_, err = Client{Dest: server.URL + "/old"}.Capabilities()
+	var statusErr StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusNotFound {
+		t.Errorf(`Capabilities(): wanted a 404 StatusError, got %v`, err)
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	var headers []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{}`))
+		case http.MethodPost:
+			io.Copy(ioutil.Discard, r.Body)
+			w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+	defer server.Close()
+
+	for n, c := range map[string]struct {
+		token string
+		want  string
+	}{
+		"token":    {token: "secret", want: "Bearer secret"},
+		"no token": {want: ""},
+	} {
+		t.Run(n, func(t *testing.T) {
+			headers = nil
+			client := Client{Dest: server.URL, Token: c.token}
+			_, err := client.Capabilities()
+			if err != nil {
+				t.Fatalf(`Capabilities(): unexpected error: %s`, err)
+			}
+			_, err = client.LookupExecutable("hash")
+			if err != nil {
+				t.Fatalf(`LookupExecutable(): unexpected error: %s`, err)
+			}
+			err = client.Send(Upload{
+				OpenCore: func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(strings.NewReader("core")), nil
+				},
+			})
+			if err != nil {
+				t.Fatalf(`Send(): unexpected error: %s`, err)
+			}
+
+			want := []string{c.want, c.want, c.want}
+			if !reflect.DeepEqual(headers, want) {
+				t.Errorf(`wanted Authorization headers %#v, got %#v`, want, headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,450 @@
+// Package corenote reads the notes of ELF core files, which describe the state
+// of the process when it was dumped: the signal that killed it, its command
+// line, the registers of its threads, its mapped files, etc.
+//
+// The layout of the notes is described in the kernel's
+// include/uapi/linux/elfcore.h and fs/binfmt_elf.c. Only the Linux notes are
+// handled.
+package corenote
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Types of the notes.
+const (
+	// NTPRStatus holds the status of a thread, the first one being the
+	// one that received the signal.
+	NTPRStatus = 1
+	// NTPRPSInfo holds the information about the process.
+	NTPRPSInfo = 3
+	// NTAuxv holds the auxiliary vector of the process.
+	NTAuxv = 6
+	// NTSigInfo holds the siginfo of the signal that killed the process.
+	// It was added in Linux 3.7.
+	NTSigInfo = 0x53494749
+	// NTFile holds the files mapped by the process. It was added in
+	// Linux 3.7.
+	NTFile = 0x46494c45
+)
+
+// ATPlatform is the type of the auxiliary vector entry holding the address
+// of the platform string (e.g: "x86_64"). See getauxval(3).
+const ATPlatform = 15
+
+// maxPlatform is the maximum length of the platform string read from the
+// memory of the process, which is much longer than any known platform.
+const maxPlatform = 64
+
+// Sizes of the command and arguments fields of the NT_PRPSINFO note, which
+// end it whatever the platform.
+const (
+	fnameSize  = 16
+	psargsSize = 80
+)
+
+// ErrInvalid is returned when a note can't be decoded, either because it
+// isn't of the expected type or because it is too short.
+var ErrInvalid = errors.New("invalid note")
+
+// Note of a core.
+type Note struct {
+	Name string
+	Type uint32
+	Desc []byte
+
+	// The layout of the descriptors depends on the class and byte order
+	// of the core.
+	class elf.Class
+	order binary.ByteOrder
+}
+
+// Read the notes of the PT_NOTE segments of the core.
+func Read(file *elf.File) ([]Note, error) {
+	var notes []Note
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, Parse(data, file.Class, file.ByteOrder)...)
+	}
+	return notes, nil
+}
+
+// Parse the notes of a PT_NOTE segment. The parsing stops at the first
+// malformed note.
+func Parse(data []byte, class elf.Class, order binary.ByteOrder) []Note {
+	var notes []Note
+	for len(data) >= 12 {
+		nameSize := order.Uint32(data[0:4])
+		descSize := order.Uint32(data[4:8])
+		typ := order.Uint32(data[8:12])
+		data = data[12:]
+
+		nameEnd := align4(nameSize)
+		if uint64(len(data)) < nameEnd+uint64(descSize) {
+			break
+		}
+
+		notes = append(notes, Note{
+			Name:  cstring(data[:nameSize]),
+			Type:  typ,
+			Desc:  data[nameEnd : nameEnd+uint64(descSize)],
+			class: class,
+			order: order,
+		})
+
+		if next := nameEnd + align4(descSize); next < uint64(len(data)) {
+			data = data[next:]
+		} else {
+			data = nil
+		}
+	}
+	return notes
+}
+
+// PRStatus is the status of a thread.
+type PRStatus struct {
+	// Signal the thread received.
+	Signal int
+	PID    int
+	PPID   int
+	PGRP   int
+	SID    int
+	// Registers are the general purpose registers of the thread, whose
+	// layout depends on the architecture (see the user_regs_struct of the
+	// kernel).
+	Registers []byte
+}
+
+// PRStatus decodes a NT_PRSTATUS note.
+func (n Note) PRStatus() (s PRStatus, err error) {
+	// The signal (3 ints), current signal (short and padding), and the
+	// pending and held signals (longs) are followed by the ids, the 4
+	// timevals (2 longs each), the registers, and a fpvalid int (padded
+	// to a long).
+	word := n.wordSize()
+	ids := 16 + 2*word
+	regs := ids + 16 + 8*word
+	if err := n.check(NTPRStatus, regs+word); err != nil {
+		return s, err
+	}
+
+	s.Signal = int(int16(n.order.Uint16(n.Desc[12:14])))
+	s.PID = n.int(ids)
+	s.PPID = n.int(ids + 4)
+	s.PGRP = n.int(ids + 8)
+	s.SID = n.int(ids + 12)
+	s.Registers = n.Desc[regs : len(n.Desc)-word]
+	return s, nil
+}
+
+// PRPSInfo is the information about the process.
+type PRPSInfo struct {
+	PID  int
+	PPID int
+	PGRP int
+	SID  int
+	UID  int
+	GID  int
+	// Command is the name of the process's executable, truncated to 15
+	// characters by the kernel.
+	Command string
+	// Args are the arguments of the process, the first one being the
+	// command. The kernel only records the first 80 bytes of the command
+	// line, separated by spaces, so the last argument can be truncated,
+	// and the arguments containing spaces are split.
+	Args []string
+}
+
+// PRPSInfo decodes a NT_PRPSINFO note.
+func (n Note) PRPSInfo() (i PRPSInfo, err error) {
+	// The state (4 chars, padded to a long) and flags (long) are followed
+	// by the user and group ids, whose size depends on the platform, the
+	// process ids, the command and the arguments.
+	word := n.wordSize()
+	uids := 2 * word
+	ids := len(n.Desc) - psargsSize - fnameSize - 16
+	if err := n.check(NTPRPSInfo, uids+16+fnameSize+psargsSize); err != nil {
+		return i, err
+	}
+
+	switch (ids - uids) / 2 {
+	case 2:
+		i.UID = int(n.order.Uint16(n.Desc[uids:]))
+		i.GID = int(n.order.Uint16(n.Desc[uids+2:]))
+	case 4:
+		i.UID = n.int(uids)
+		i.GID = n.int(uids + 4)
+	}
+	i.PID = n.int(ids)
+	i.PPID = n.int(ids + 4)
+	i.PGRP = n.int(ids + 8)
+	i.SID = n.int(ids + 12)
+	i.Command = cstring(n.Desc[ids+16 : ids+16+fnameSize])
+	i.Args = strings.Fields(cstring(n.Desc[ids+16+fnameSize:]))
+	return i, nil
+}
+
+// SigInfo is the information about the signal that killed the process.
+type SigInfo struct {
+	Signo int
+	Errno int
+	Code  int
+	// Addr is the address that caused the fault, only meaningful for the
+	// SIGSEGV, SIGBUS, SIGILL, and SIGFPE signals.
+	Addr uint64
+}
+
+// SigInfo decodes a NT_SIGINFO note. Its layout is the generic one, which
+// differs on MIPS.
+func (n Note) SigInfo() (i SigInfo, err error) {
+	// The 3 ints are followed by the union, aligned on a long.
+	word := n.wordSize()
+	union := align(12, word)
+	if err := n.check(NTSigInfo, union+word); err != nil {
+		return i, err
+	}
+
+	i.Signo = n.int(0)
+	i.Errno = n.int(4)
+	i.Code = n.int(8)
+	i.Addr = n.word(union)
+	return i, nil
+}
+
+// MappedFile is a file mapped in the memory of the process.
+type MappedFile struct {
+	Start uint64
+	End   uint64
+	// Offset of the mapping in the file.
+	Offset uint64
+	Name   string
+}
+
+// Files decodes a NT_FILE note.
+func (n Note) Files() ([]MappedFile, error) {
+	// The number of files and the page size are followed by the
+	// mappings (3 longs each, the offset being in pages) then the
+	// names, NUL-separated.
+	word := n.wordSize()
+	if err := n.check(NTFile, 2*word); err != nil {
+		return nil, err
+	}
+
+	count := n.word(0)
+	pageSize := n.word(word)
+	names := 2*word + int(count)*3*word
+	if count > uint64(len(n.Desc)) || len(n.Desc) < names {
+		return nil, fmt.Errorf(`%w: %d files in %d bytes`, ErrInvalid, count, len(n.Desc))
+	}
+
+	files := make([]MappedFile, count)
+	rest := strings.Split(string(n.Desc[names:]), "\x00")
+	if len(rest) < int(count) {
+		return nil, fmt.Errorf(`%w: %d names for %d files`, ErrInvalid, len(rest), count)
+	}
+	for i := range files {
+		offset := 2*word + i*3*word
+		files[i] = MappedFile{
+			Start:  n.word(offset),
+			End:    n.word(offset + word),
+			Offset: n.word(offset+2*word) * pageSize,
+			Name:   rest[i],
+		}
+	}
+	return files, nil
+}
+
+// Auxv decodes a NT_AUXV note, returning the values of the auxiliary vector
+// by type. See getauxval(3).
+func (n Note) Auxv() (map[uint64]uint64, error) {
+	word := n.wordSize()
+	if err := n.check(NTAuxv, 0); err != nil {
+		return nil, err
+	}
+
+	auxv := make(map[uint64]uint64)
+	for offset := 0; offset+2*word <= len(n.Desc); offset += 2 * word {
+		typ := n.word(offset)
+		// AT_NULL ends the vector.
+		if typ == 0 {
+			break
+		}
+		auxv[typ] = n.word(offset + word)
+	}
+	return auxv, nil
+}
+
+// Platform returns the platform string the kernel gave to the process, which
+// the dynamic linker uses to expand $PLATFORM in the library paths. It is read
+// from the memory dumped in the core at the address given by the AT_PLATFORM
+// entry of the auxiliary vector. An empty string is returned if the core
+// doesn't hold the entry.
+func Platform(file *elf.File, notes []Note) (string, error) {
+	var addr uint64
+	for _, n := range notes {
+		if n.Type != NTAuxv {
+			continue
+		}
+		auxv, err := n.Auxv()
+		if err != nil {
+			return "", err
+		}
+		addr = auxv[ATPlatform]
+		break
+	}
+	if addr == 0 {
+		return "", nil
+	}
+
+	// The string is on the stack of the process, which is dumped in a
+	// PT_LOAD segment. The segments of the mapped files can have no data
+	// in the core, so the address must be in the dumped part.
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr >= prog.Vaddr+prog.Filesz {
+			continue
+		}
+
+		size := prog.Vaddr + prog.Filesz - addr
+		if size > maxPlatform {
+			size = maxPlatform
+		}
+		buf := make([]byte, size)
+		if _, err := prog.ReadAt(buf, int64(addr-prog.Vaddr)); err != nil {
+			return "", err
+		}
+		if bytes.IndexByte(buf, 0) < 0 {
+			return "", fmt.Errorf(`%w: unterminated platform string at %#x`, ErrInvalid, addr)
+		}
+		return cstring(buf), nil
+	}
+	return "", fmt.Errorf(`%w: platform string at %#x not dumped`, ErrInvalid, addr)
+}
+
+// Summary of the notes of a core, as indexed.
+type Summary struct {
+	// Signal that killed the process, 0 if unknown.
+	Signal int
+	// Command and Args of the process. See PRPSInfo.
+	Command string
+	Args    []string
+	// Registers of the thread that received the signal, to be decoded
+	// with the Registers function.
+	Registers []byte
+	// Files mapped by the process, in the order of their first mapping.
+	// See MappedFile.
+	Files []string
+}
+
+// Summarize the notes of a core. The notes that can't be decoded are ignored.
+func Summarize(notes []Note) Summary {
+	var s Summary
+	sigInfo := false
+	prStatus := false
+	for _, n := range notes {
+		switch n.Type {
+		case NTSigInfo:
+			// The siginfo is more reliable than the status of the
+			// thread, which is only used if it is missing.
+			i, err := n.SigInfo()
+			if err == nil {
+				s.Signal = i.Signo
+				sigInfo = true
+			}
+		case NTPRStatus:
+			// Only the first thread received the signal.
+			st, err := n.PRStatus()
+			if err != nil || prStatus {
+				continue
+			}
+			if !sigInfo {
+				s.Signal = st.Signal
+			}
+			s.Registers = st.Registers
+			prStatus = true
+		case NTPRPSInfo:
+			i, err := n.PRPSInfo()
+			if err == nil {
+				s.Command = i.Command
+				s.Args = i.Args
+			}
+		case NTFile:
+			// Each file is usually mapped multiple times, one per
+			// segment.
+			files, err := n.Files()
+			if err != nil {
+				continue
+			}
+			known := make(map[string]bool, len(s.Files))
+			for _, f := range s.Files {
+				known[f] = true
+			}
+			for _, f := range files {
+				if !known[f.Name] {
+					known[f.Name] = true
+					s.Files = append(s.Files, f.Name)
+				}
+			}
+		}
+	}
+	return s
+}
+
+// check the type and size of the note.
+func (n Note) check(typ uint32, size int) error {
+	if n.Type != typ {
+		return fmt.Errorf(`%w: unexpected type %#x`, ErrInvalid, n.Type)
+	}
+	if len(n.Desc) < size {
+		return fmt.Errorf(`%w: %d bytes, expected at least %d`, ErrInvalid, len(n.Desc), size)
+	}
+	return nil
+}
+
+func (n Note) wordSize() int {
+	if n.class == elf.ELFCLASS32 {
+		return 4
+	}
+	return 8
+}
+
+// word reads a long at the given offset.
+func (n Note) word(offset int) uint64 {
+	if n.class == elf.ELFCLASS32 {
+		return uint64(n.order.Uint32(n.Desc[offset:]))
+	}
+	return n.order.Uint64(n.Desc[offset:])
+}
+
+// int reads an int at the given offset.
+func (n Note) int(offset int) int {
+	return int(int32(n.order.Uint32(n.Desc[offset:])))
+}
+
+// cstring returns the string up to the first NUL byte.
+func cstring(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func align4(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
+
+func align(n, to int) int {
+	return (n + to - 1) / to * to
+}
@@ -0,0 +1,246 @@
+package corenote
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/testingx"
+	"github.com/google/go-cmp/cmp"
+)
+
+// decoded are the decoded notes of a core, as stored in the golden files.
+type decoded struct {
+	Summary  Summary
+	PRStatus []PRStatus
+	PRPSInfo []PRPSInfo
+	SigInfo  []SigInfo
+	Files    [][]MappedFile
+	Auxv     []map[uint64]uint64
+}
+
+// TestParse_Golden decodes the notes of real cores, stored in testdata along
+// with the expected result.
+func TestParse_Golden(t *testing.T) {
+	for n, c := range map[string]struct {
+		class elf.Class
+		order binary.ByteOrder
+	}{
+		"x86_64": {class: elf.ELFCLASS64, order: binary.LittleEndian},
+	} {
+		t.Run(n, func(t *testing.T) {
+			notes := Parse(testingx.ReadFile(t, n+".notes"), c.class, c.order)
+
+			got := decoded{Summary: Summarize(notes)}
+			for _, note := range notes {
+				var err error
+				switch note.Type {
+				case NTPRStatus:
+					var s PRStatus
+					s, err = note.PRStatus()
+					got.PRStatus = append(got.PRStatus, s)
+				case NTPRPSInfo:
+					var i PRPSInfo
+					i, err = note.PRPSInfo()
+					got.PRPSInfo = append(got.PRPSInfo, i)
+				case NTSigInfo:
+					var i SigInfo
+					i, err = note.SigInfo()
+					got.SigInfo = append(got.SigInfo, i)
+				case NTFile:
+					var files []MappedFile
+					files, err = note.Files()
+					got.Files = append(got.Files, files)
+				case NTAuxv:
+					var auxv map[uint64]uint64
+					auxv, err = note.Auxv()
+					got.Auxv = append(got.Auxv, auxv)
+				}
+				if err != nil {
+					t.Errorf(`decoding note %#x: unexpected error: %s`, note.Type, err)
+				}
+			}
+
+			var want decoded
+			testingx.GoldenJSON(t, n+".golden.json", got, &want)
+			if !cmp.Equal(got, want) {
+				t.Errorf(`Parse(): unexpected result`)
+				t.Log(cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+// note encodes a little-endian ELF note.
+func note(typ uint32, desc []byte) []byte {
+	const name = "CORE"
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(name)+1))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(desc)))
+	binary.Write(&buf, binary.LittleEndian, typ)
+	buf.WriteString(name)
+	buf.Write(make([]byte, align4(uint32(len(name)+1))-uint64(len(name))))
+	buf.Write(desc)
+	buf.Write(make([]byte, align4(uint32(len(desc)))-uint64(len(desc))))
+	return buf.Bytes()
+}
+
+// sigInfo returns the descriptor of a 64 bits NT_SIGINFO note.
+func sigInfo(signo int32) []byte {
+	desc := make([]byte, 128)
+	binary.LittleEndian.PutUint32(desc, uint32(signo))
+	return desc
+}
+
+// prStatus returns the descriptor of a 64 bits NT_PRSTATUS note.
+func prStatus(signal int16) []byte {
+	desc := make([]byte, 336)
+	binary.LittleEndian.PutUint16(desc[12:], uint16(signal))
+	return desc
+}
+
+// prpsInfo32 returns the descriptor of a 32 bits NT_PRPSINFO note, with 16
+// bits user ids.
+func prpsInfo32(uid uint16, pid uint32, fname, psargs string) []byte {
+	desc := make([]byte, 124)
+	binary.LittleEndian.PutUint16(desc[8:], uid)
+	binary.LittleEndian.PutUint32(desc[12:], pid)
+	copy(desc[28:], fname)
+	copy(desc[44:], psargs)
+	return desc
+}
+
+func TestSummarize(t *testing.T) {
+	// The registers of the 64 bits NT_PRSTATUS notes.
+	registers := make([]byte, 216)
+
+	for n, c := range map[string]struct {
+		notes [][]byte
+		class elf.Class
+		want  Summary
+	}{
+		"siginfo": {
+			notes: [][]byte{note(NTPRStatus, prStatus(6)), note(NTSigInfo, sigInfo(11))},
+			class: elf.ELFCLASS64,
+			want:  Summary{Signal: 11, Registers: registers},
+		},
+		"prstatus": {
+			notes: [][]byte{note(NTPRStatus, prStatus(11)), note(NTPRStatus, prStatus(0))},
+			class: elf.ELFCLASS64,
+			want:  Summary{Signal: 11, Registers: registers},
+		},
+		"prpsinfo 32 bits": {
+			notes: [][]byte{note(NTPRPSInfo, prpsInfo32(1000, 42, "server", "/usr/bin/server -c /etc/server.conf "))},
+			class: elf.ELFCLASS32,
+			want:  Summary{Command: "server", Args: []string{"/usr/bin/server", "-c", "/etc/server.conf"}},
+		},
+		"short notes": {
+			notes: [][]byte{note(NTPRPSInfo, []byte("server")), note(NTSigInfo, []byte{11})},
+			class: elf.ELFCLASS64,
+			want:  Summary{},
+		},
+		"truncated": {
+			notes: [][]byte{note(NTSigInfo, sigInfo(11))[:20]},
+			class: elf.ELFCLASS64,
+			want:  Summary{},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got := Summarize(Parse(bytes.Join(c.notes, nil), c.class, binary.LittleEndian))
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`Summarize(): wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestNote_PRPSInfo(t *testing.T) {
+	notes := Parse(note(NTPRPSInfo, prpsInfo32(1000, 42, "server", "server")), elf.ELFCLASS32, binary.LittleEndian)
+	if len(notes) != 1 {
+		t.Fatalf(`Parse(): wanted 1 note, got %d`, len(notes))
+	}
+
+	got, err := notes[0].PRPSInfo()
+	if err != nil {
+		t.Fatalf(`PRPSInfo(): unexpected error: %s`, err)
+	}
+	want := PRPSInfo{PID: 42, UID: 1000, Command: "server", Args: []string{"server"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`PRPSInfo(): wanted %#v, got %#v`, want, got)
+	}
+
+	_, err = notes[0].SigInfo()
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf(`SigInfo(): wanted ErrInvalid, got %v`, err)
+	}
+}
+
+func TestPlatform(t *testing.T) {
+	// The AT_PLATFORM entry of the real core points to its stack.
+	const addr = 140721598456777
+	notes := Parse(testingx.ReadFile(t, "x86_64.notes"), elf.ELFCLASS64, binary.LittleEndian)
+
+	// load returns a PT_LOAD segment starting 16 bytes before the
+	// platform string.
+	load := func(data string) *elf.Prog {
+		mem := append(make([]byte, 16), data...)
+		return &elf.Prog{
+			ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: addr - 16, Filesz: uint64(len(mem))},
+			ReaderAt:   bytes.NewReader(mem),
+		}
+	}
+
+	for n, c := range map[string]struct {
+		notes []Note
+		progs []*elf.Prog
+		want  string
+		err   error
+	}{
+		"dumped":       {notes: notes, progs: []*elf.Prog{load("x86_64\x00./crash\x00")}, want: "x86_64"},
+		"end of dump":  {notes: notes, progs: []*elf.Prog{load("x86_64\x00")}, want: "x86_64"},
+		"no auxv":      {notes: nil, progs: []*elf.Prog{load("x86_64\x00")}, want: ""},
+		"not dumped":   {notes: notes, progs: nil, err: ErrInvalid},
+		"unterminated": {notes: notes, progs: []*elf.Prog{load("x86_64")}, err: ErrInvalid},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := Platform(&elf.File{Progs: c.progs}, c.notes)
+			if !errors.Is(err, c.err) {
+				t.Fatalf(`Platform(): wanted error %v, got %v`, c.err, err)
+			}
+			if got != c.want {
+				t.Errorf(`Platform(): wanted %q, got %q`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	notes := Parse(testingx.ReadFile(t, "x86_64.notes"), elf.ELFCLASS64, binary.LittleEndian)
+	summary := Summarize(notes)
+
+	got, err := Registers(elf.EM_X86_64, binary.LittleEndian, summary.Registers)
+	if err != nil {
+		t.Fatalf(`Registers(): unexpected error: %s`, err)
+	}
+	if len(got) != 27 {
+		t.Errorf(`Registers(): wanted 27 registers, got %d`, len(got))
+	}
+	// The crash program dereferences a NULL pointer from its main
+	// function, in the executable mapped at 0x55aff284d000.
+	if rip := got["rip"]; rip < 0x55aff284d000 || rip >= 0x55aff2852000 {
+		t.Errorf(`Registers(): unexpected rip %#x`, rip)
+	}
+
+	_, err = Registers(elf.EM_X86_64, binary.LittleEndian, summary.Registers[:100])
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf(`Registers(): wanted ErrInvalid for truncated registers, got %v`, err)
+	}
+
+	_, err = Registers(elf.EM_MIPS, binary.LittleEndian, summary.Registers)
+	if !errors.Is(err, ErrUnsupportedMachine) {
+		t.Errorf(`Registers(): wanted ErrUnsupportedMachine, got %v`, err)
+	}
+}
@@ -0,0 +1,64 @@
+package corenote
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedMachine is returned when the registers of a machine can't be
+// decoded.
+var ErrUnsupportedMachine = errors.New("unsupported machine")
+
+// registerLayout is the layout of the general purpose registers of a machine
+// in the NT_PRSTATUS note, as defined by its user_regs_struct.
+type registerLayout struct {
+	// Size of each register, in bytes.
+	size int
+	// Names of the registers, in order.
+	names []string
+}
+
+// registerLayouts by machine. Supporting a new machine only requires adding
+// its layout.
+var registerLayouts = map[elf.Machine]registerLayout{
+	elf.EM_X86_64: {size: 8, names: []string{
+		"r15", "r14", "r13", "r12", "rbp", "rbx", "r11", "r10", "r9", "r8",
+		"rax", "rcx", "rdx", "rsi", "rdi", "orig_rax", "rip", "cs",
+		"eflags", "rsp", "ss", "fs_base", "gs_base", "ds", "es", "fs", "gs",
+	}},
+	elf.EM_386: {size: 4, names: []string{
+		"ebx", "ecx", "edx", "esi", "edi", "ebp", "eax", "ds", "es", "fs",
+		"gs", "orig_eax", "eip", "cs", "eflags", "esp", "ss",
+	}},
+	elf.EM_AARCH64: {size: 8, names: []string{
+		"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8", "x9", "x10",
+		"x11", "x12", "x13", "x14", "x15", "x16", "x17", "x18", "x19",
+		"x20", "x21", "x22", "x23", "x24", "x25", "x26", "x27", "x28",
+		"x29", "x30", "sp", "pc", "pstate",
+	}},
+}
+
+// Registers decodes the general purpose registers of a thread (see
+// PRStatus), returning their values by name.
+func Registers(machine elf.Machine, order binary.ByteOrder, data []byte) (map[string]uint64, error) {
+	layout, ok := registerLayouts[machine]
+	if !ok {
+		return nil, fmt.Errorf(`%w: %s`, ErrUnsupportedMachine, machine)
+	}
+	if len(data) < layout.size*len(layout.names) {
+		return nil, fmt.Errorf(`%w: %d bytes of registers, expected at least %d`, ErrInvalid, len(data), layout.size*len(layout.names))
+	}
+
+	registers := make(map[string]uint64, len(layout.names))
+	for i, name := range layout.names {
+		offset := i * layout.size
+		if layout.size == 4 {
+			registers[name] = uint64(order.Uint32(data[offset:]))
+		} else {
+			registers[name] = order.Uint64(data[offset:])
+		}
+	}
+	return registers, nil
+}
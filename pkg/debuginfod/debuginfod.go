@@ -0,0 +1,75 @@
+// Package debuginfod implements a client of the debuginfod servers, which
+// serve the executables and their debug files by build-id (see
+// https://sourceware.org/elfutils/Debuginfod.html).
+package debuginfod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNotFound is returned when none of the servers has the requested file.
+var ErrNotFound = errors.New("not found")
+
+// Kinds of files served for a build-id.
+const (
+	KindExecutable = "executable"
+	KindDebugInfo  = "debuginfo"
+)
+
+// ParseURLs splits a list of URLs separated by spaces, as given in the
+// DEBUGINFOD_URLS environment variable of the debuggers.
+func ParseURLs(s string) []string {
+	urls := strings.Fields(s)
+	for i := range urls {
+		urls[i] = strings.TrimSuffix(urls[i], "/")
+	}
+	return urls
+}
+
+// Client of a list of debuginfod servers.
+type Client struct {
+	// URLs of the servers, tried in order.
+	URLs []string
+	// HTTP client used for the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+}
+
+func (c Client) http() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// Fetch the file of the given kind for the build-id from the first server
+// having it. The caller must close the returned file.
+func (c Client) Fetch(buildID, kind string) (io.ReadCloser, error) {
+	var errs []string
+	for _, url := range c.URLs {
+		res, err := c.http().Get(fmt.Sprintf("%s/buildid/%s/%s", url, buildID, kind))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			return res.Body, nil
+		case http.StatusNotFound:
+		default:
+			errs = append(errs, fmt.Sprintf("%s: unexpected status %d", url, res.StatusCode))
+		}
+		res.Body.Close()
+	}
+
+	// The file is only reported missing if every server said so, as it
+	// may otherwise be available once the failing servers are back.
+	if len(errs) != 0 {
+		return nil, fmt.Errorf(`fetching %s of %s: %s`, kind, buildID, strings.Join(errs, "; "))
+	}
+	return nil, fmt.Errorf(`%w: %s of %s`, ErrNotFound, kind, buildID)
+}
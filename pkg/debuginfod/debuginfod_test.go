@@ -0,0 +1,65 @@
+package debuginfod
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseURLs(t *testing.T) {
+	got := ParseURLs(" https://debuginfod.example.com/  http://localhost:8002\n")
+	want := []string{"https://debuginfod.example.com", "http://localhost:8002"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`ParseURLs(): wanted %#v, got %#v`, want, got)
+	}
+}
+
+func TestClient_Fetch(t *testing.T) {
+	empty := httptest.NewServer(http.NotFoundHandler())
+	defer empty.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/buildid/0123abcd/executable" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("\x7fELF"))
+	}))
+	defer full.Close()
+
+	t.Run("found", func(t *testing.T) {
+		file, err := Client{URLs: []string{empty.URL, full.URL}}.Fetch("0123abcd", KindExecutable)
+		if err != nil {
+			t.Fatalf(`Fetch(): unexpected error: %s`, err)
+		}
+		defer file.Close()
+
+		got, err := ioutil.ReadAll(file)
+		if err != nil {
+			t.Fatalf(`reading file: %s`, err)
+		}
+		if string(got) != "\x7fELF" {
+			t.Errorf(`Fetch(): unexpected content %q`, got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := Client{URLs: []string{empty.URL, full.URL}}.Fetch("0123abcd", KindDebugInfo)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(`Fetch(): wanted ErrNotFound, got %v`, err)
+		}
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		_, err := Client{URLs: []string{failing.URL, empty.URL}}.Fetch("0123abcd", KindExecutable)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf(`Fetch(): wanted a failure, got %v`, err)
+		}
+	})
+}
@@ -0,0 +1,1063 @@
+// Package demangle implements the demangling of the C++ symbols mangled
+// following the Itanium C++ ABI, which is used by gcc and clang on every
+// platform but Windows. See
+// https://itanium-cxx-abi.github.io/cxx-abi/abi.html#mangling.
+//
+// The output follows the one of c++filt.
+//
+// NOTE Only the most common parts of the grammar are handled. Notably, the
+// expressions found in some template arguments aren't, and the symbols using
+// them can't be demangled.
+package demangle
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNotMangled is returned when the symbol isn't a mangled C++ symbol.
+var ErrNotMangled = errors.New("not a mangled symbol")
+
+// ErrInvalid is returned when the symbol can't be demangled, either because it
+// is invalid or because it uses an unsupported part of the grammar.
+var ErrInvalid = errors.New("invalid or unsupported mangled symbol")
+
+// symbolRegexp matches the mangled symbols in a text. Clone suffixes (e.g:
+// ".constprop.0") are part of the symbol.
+var symbolRegexp = regexp.MustCompile(`\b_Z[0-9A-Za-z_.$]+`)
+
+// Text demangles every mangled symbol found in the text. The symbols that
+// can't be demangled are left as is.
+func Text(text string) string {
+	return symbolRegexp.ReplaceAllStringFunc(text, func(symbol string) string {
+		// A sentence could end right after the symbol.
+		trimmed := strings.TrimRight(symbol, ".")
+		demangled, err := Symbol(trimmed)
+		if err != nil {
+			return symbol
+		}
+		return demangled + symbol[len(trimmed):]
+	})
+}
+
+// Symbol demangles a single symbol.
+func Symbol(symbol string) (demangled string, err error) {
+	if !strings.HasPrefix(symbol, "_Z") {
+		return "", ErrNotMangled
+	}
+
+	// The parser panics with a parseError when it can't go on, which saves
+	// checking errors after each step of the recursive descent.
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if perr, ok := r.(parseError); ok {
+			err = fmt.Errorf(`%w: %s`, ErrInvalid, string(perr))
+			return
+		}
+		panic(r)
+	}()
+
+	p := &parser{s: symbol, pos: 2}
+	demangled = p.encoding()
+	demangled += p.cloneSuffixes()
+	if p.pos != len(p.s) {
+		p.fail("unexpected %q", p.s[p.pos:])
+	}
+	return demangled, nil
+}
+
+type parseError string
+
+// kind of a node.
+type kind int
+
+const (
+	kindName kind = iota
+	kindPointer
+	kindReference
+	kindRValueReference
+	kindQualified
+	kindFunction
+	kindArray
+	kindMember
+	kindArgPack
+	kindPackExpansion
+)
+
+// node is a name or a type of the symbol. Types are kept as a tree, as the
+// declarator syntax of C++ can't be built by simple concatenation (e.g: a
+// pointer to function is printed "void (*)(int)").
+type node struct {
+	kind kind
+	// s is the printed name for names, the qualifiers for qualified types,
+	// the ref-qualifier for functions, and the dimension for arrays.
+	s     string
+	inner *node
+	// class of the pointers to member.
+	class *node
+	// ret and params of the functions. The return type is nil for the
+	// functions not printing it.
+	ret    *node
+	params []*node
+
+	// last is the last unqualified name of a name, without template
+	// arguments, which is also the name of its constructors.
+	last string
+	// targs are the template arguments of a name ending with them.
+	targs []*node
+	// quals are the cv and ref qualifiers of a method.
+	quals string
+	// noReturn is set for the names of the functions whose return type
+	// isn't encoded even if they are templates: constructors, destructors
+	// and conversion operators.
+	noReturn bool
+}
+
+func (n *node) String() string {
+	return n.decl("")
+}
+
+// decl prints the type around the inner declarator.
+func (n *node) decl(inner string) string {
+	switch n.kind {
+	case kindName:
+		if len(inner) == 0 {
+			return n.s
+		}
+		if inner[0] == ' ' || inner[0] == '*' || inner[0] == '&' {
+			return n.s + inner
+		}
+		return n.s + " " + inner
+	case kindPointer:
+		return n.inner.decl("*" + n.inner.spaced(inner))
+	case kindReference, kindRValueReference:
+		// References to references collapse, the lvalue one winning.
+		if n.inner.kind == kindReference || n.inner.kind == kindRValueReference {
+			collapsed := *n.inner
+			if n.kind == kindReference {
+				collapsed.kind = kindReference
+			}
+			return collapsed.decl(inner)
+		}
+		if n.kind == kindReference {
+			return n.inner.decl("&" + n.inner.spaced(inner))
+		}
+		return n.inner.decl("&&" + n.inner.spaced(inner))
+	case kindQualified:
+		if n.inner.kind == kindFunction {
+			return n.inner.declFunction(inner, n.s)
+		}
+		return n.inner.decl(n.s + n.inner.spaced(inner))
+	case kindFunction:
+		return n.declFunction(inner, "")
+	case kindArray:
+		if len(inner) != 0 {
+			inner = " (" + inner + ")"
+		}
+		return n.inner.decl(inner + " [" + n.s + "]")
+	case kindMember:
+		return n.inner.decl(n.class.String() + "::*" + inner)
+	case kindArgPack:
+		return joinNodes(n.params)
+	case kindPackExpansion:
+		pack := n.inner.findPack()
+		if pack == nil {
+			return n.inner.decl(inner) + "..."
+		}
+		// The pattern is repeated for each element of the pack.
+		parts := make([]string, len(pack.params))
+		for i, elem := range pack.params {
+			parts[i] = n.inner.replace(pack, elem).decl(inner)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+// spaced separates a nested declarator from a pointer or reference to the
+// type, unless the type nests it in parentheses itself.
+func (n *node) spaced(inner string) string {
+	switch n.kind {
+	case kindFunction, kindArray:
+		return inner
+	case kindQualified:
+		return n.inner.spaced(inner)
+	}
+	if strings.HasPrefix(inner, "(") {
+		return " " + inner
+	}
+	return inner
+}
+
+// findPack returns the first argument pack in the type.
+func (n *node) findPack() *node {
+	if n == nil {
+		return nil
+	}
+	if n.kind == kindArgPack {
+		return n
+	}
+	for _, child := range append([]*node{n.inner, n.class, n.ret}, n.params...) {
+		if pack := child.findPack(); pack != nil {
+			return pack
+		}
+	}
+	return nil
+}
+
+// replace returns a copy of the type with the given node replaced.
+func (n *node) replace(old, new *node) *node {
+	if n == nil {
+		return nil
+	}
+	if n == old {
+		return new
+	}
+	replaced := *n
+	replaced.inner = n.inner.replace(old, new)
+	replaced.class = n.class.replace(old, new)
+	replaced.ret = n.ret.replace(old, new)
+	if n.params != nil {
+		replaced.params = make([]*node, len(n.params))
+		for i, param := range n.params {
+			replaced.params[i] = param.replace(old, new)
+		}
+	}
+	return &replaced
+}
+
+func (n *node) declFunction(inner, quals string) string {
+	params := "(" + joinNodes(n.params) + ")" + n.s + quals
+	if len(inner) == 0 {
+		return n.ret.String() + " " + params
+	}
+	// The declarator is nested in the one of the return type, in case it
+	// is a function pointer too.
+	return n.ret.decl("(" + inner + ")" + params)
+}
+
+// joinNodes prints a list of nodes, skipping the empty ones (e.g: the
+// expansion of an empty pack).
+func joinNodes(nodes []*node) string {
+	var parts []string
+	for _, n := range nodes {
+		s := n.String()
+		if len(s) == 0 {
+			continue
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// templateString prints template arguments. A space is added between two
+// closing chevrons, as in C++03.
+func templateString(name string, args []*node) string {
+	s := joinNodes(args)
+	if strings.HasSuffix(s, ">") {
+		s += " "
+	}
+	if strings.HasSuffix(name, "<") {
+		name += " "
+	}
+	return name + "<" + s + ">"
+}
+
+func nameNode(s string) *node {
+	return &node{kind: kindName, s: s, last: s}
+}
+
+// withArgs returns the name followed by the template arguments.
+func withArgs(n *node, args []*node) *node {
+	return &node{
+		kind:     kindName,
+		s:        templateString(n.s, args),
+		last:     n.last,
+		targs:    args,
+		noReturn: n.noReturn,
+	}
+}
+
+// join returns the name qualified by the prefix.
+func join(prefix, n *node) *node {
+	if prefix == nil {
+		return n
+	}
+	joined := *n
+	joined.s = prefix.s + "::" + n.s
+	return &joined
+}
+
+// parser of a mangled symbol.
+type parser struct {
+	s   string
+	pos int
+	// subs are the candidates for substitution, in order.
+	subs []*node
+	// targs are the template arguments the template parameters refer to.
+	targs []*node
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(parseError(fmt.Sprintf(format, args...) + fmt.Sprintf(" at offset %d", p.pos)))
+}
+
+func (p *parser) atEnd() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+// peekAt returns the character at the given offset from the current position,
+// or 0 if out of bounds.
+func (p *parser) peekAt(offset int) byte {
+	if p.pos+offset >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos+offset]
+}
+
+func (p *parser) consume(prefix string) {
+	if !p.peek(prefix) {
+		p.fail("expected %q", prefix)
+	}
+	p.pos += len(prefix)
+}
+
+func (p *parser) addSub(n *node) {
+	p.subs = append(p.subs, n)
+}
+
+// number parses a decimal number, optionally negative (prefixed by "n").
+func (p *parser) number() int {
+	negative := false
+	if p.peek("n") {
+		negative = true
+		p.pos++
+	}
+	start := p.pos
+	for !p.atEnd() && isDigit(p.s[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		p.fail("expected number")
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		p.fail("invalid number: %s", err)
+	}
+	if negative {
+		return -n
+	}
+	return n
+}
+
+// cloneSuffixes parses the suffixes added by the compilers to the clones of
+// the functions (e.g: ".constprop.0").
+func (p *parser) cloneSuffixes() string {
+	var s string
+	for p.peek(".") {
+		start := p.pos
+		p.pos++
+		for !p.atEnd() && (isLower(p.s[p.pos]) || p.s[p.pos] == '_') {
+			p.pos++
+		}
+		for p.peek(".") && isDigit(p.peekAt(1)) {
+			p.pos++
+			for !p.atEnd() && isDigit(p.s[p.pos]) {
+				p.pos++
+			}
+		}
+		if p.pos == start+1 {
+			p.fail("invalid clone suffix")
+		}
+		s += " [clone " + p.s[start:p.pos] + "]"
+	}
+	return s
+}
+
+// encoding parses:
+//
+//	<encoding> ::= <name> <bare-function-type>
+//	           ::= <name>
+//	           ::= <special-name>
+func (p *parser) encoding() string {
+	return p.function(true)
+}
+
+// function parses an encoding, printing the return type of the template
+// functions if asked to. It isn't for the functions enclosing a local name.
+func (p *parser) function(printReturn bool) string {
+	if p.peek("T") || p.peek("G") {
+		return p.specialName()
+	}
+
+	name := p.name()
+	if p.atEnd() || p.peek("E") || p.peek(".") {
+		return name.s
+	}
+
+	// The template parameters of the function's type refer to the
+	// template arguments of the function.
+	if name.targs != nil {
+		p.targs = name.targs
+	}
+
+	var ret *node
+	if name.targs != nil && !name.noReturn {
+		ret = p.typ()
+	}
+
+	s := name.s + "(" + joinNodes(p.bareFunctionType()) + ")" + name.quals
+	if ret != nil && printReturn {
+		s = ret.String() + " " + s
+	}
+	return s
+}
+
+// specialName parses:
+//
+//	<special-name> ::= TV <type> | TT <type> | TI <type> | TS <type>
+//	               ::= Th <offset> _ <encoding> | Tv <offset> _ <offset> _ <encoding>
+//	               ::= GV <name> | TW <name> | TH <name>
+func (p *parser) specialName() string {
+	switch {
+	case p.peek("TV"):
+		p.pos += 2
+		return "vtable for " + p.typ().String()
+	case p.peek("TT"):
+		p.pos += 2
+		return "VTT for " + p.typ().String()
+	case p.peek("TI"):
+		p.pos += 2
+		return "typeinfo for " + p.typ().String()
+	case p.peek("TS"):
+		p.pos += 2
+		return "typeinfo name for " + p.typ().String()
+	case p.peek("Th"):
+		p.pos += 2
+		p.number()
+		p.consume("_")
+		return "non-virtual thunk to " + p.encoding()
+	case p.peek("Tv"):
+		p.pos += 2
+		p.number()
+		p.consume("_")
+		p.number()
+		p.consume("_")
+		return "virtual thunk to " + p.encoding()
+	case p.peek("TW"):
+		p.pos += 2
+		return "TLS wrapper function for " + p.name().s
+	case p.peek("TH"):
+		p.pos += 2
+		return "TLS init function for " + p.name().s
+	case p.peek("GV"):
+		p.pos += 2
+		return "guard variable for " + p.name().s
+	}
+	p.fail("unsupported special name")
+	return ""
+}
+
+// name parses:
+//
+//	<name> ::= <nested-name>
+//	       ::= <unscoped-name>
+//	       ::= <unscoped-template-name> <template-args>
+//	       ::= <local-name>
+func (p *parser) name() *node {
+	var n *node
+	switch {
+	case p.peek("N"):
+		return p.nestedName()
+	case p.peek("Z"):
+		return p.localName()
+	case p.peek("St"):
+		p.pos += 2
+		n = join(nameNode("std"), p.unqualifiedName(nil))
+	case p.peek("S"):
+		// Only the templates can be substituted here.
+		n = p.substitution()
+		if !p.peek("I") {
+			p.fail("expected template arguments after substitution")
+		}
+		return withArgs(n, p.templateArgs())
+	default:
+		n = p.unqualifiedName(nil)
+	}
+
+	if p.peek("I") {
+		p.addSub(n)
+		n = withArgs(n, p.templateArgs())
+	}
+	return n
+}
+
+// nestedName parses:
+//
+//	<nested-name> ::= N [<CV-qualifiers>] [<ref-qualifier>] <prefix> <unqualified-name> E
+//	              ::= N [<CV-qualifiers>] [<ref-qualifier>] <template-prefix> <template-args> E
+//
+// Each prefix of the name is a substitution candidate, but not the whole
+// name.
+func (p *parser) nestedName() *node {
+	p.consume("N")
+	quals := p.cvQualifiers()
+	switch {
+	case p.peek("R"):
+		p.pos++
+		quals += " &"
+	case p.peek("O"):
+		p.pos++
+		quals += " &&"
+	}
+
+	var n *node
+	candidate := false
+	for !p.peek("E") {
+		if p.atEnd() {
+			p.fail("unterminated nested name")
+		}
+		if n != nil && candidate {
+			p.addSub(n)
+		}
+		candidate = true
+
+		switch {
+		case p.peek("St"):
+			p.pos += 2
+			n = join(nameNode("std"), p.unqualifiedName(nil))
+		case p.peek("S"):
+			n = p.substitution()
+			candidate = false
+		case p.peek("I"):
+			if n == nil {
+				p.fail("template arguments without template")
+			}
+			n = withArgs(n, p.templateArgs())
+		case p.peek("T"):
+			n = p.templateParam()
+		case p.peek("C") || (p.peek("D") && isDigit(p.peekAt(1))):
+			if n == nil {
+				p.fail("constructor without class")
+			}
+			n = join(n, p.ctorDtorName(n))
+		default:
+			n = join(n, p.unqualifiedName(n))
+		}
+	}
+	p.consume("E")
+	if n == nil {
+		p.fail("empty nested name")
+	}
+
+	named := *n
+	named.quals = quals
+	return &named
+}
+
+// localName parses:
+//
+//	<local-name> ::= Z <encoding> E <entity name> [<discriminator>]
+//	             ::= Z <encoding> E s [<discriminator>]
+func (p *parser) localName() *node {
+	p.consume("Z")
+	targs := p.targs
+	function := p.function(false)
+	p.targs = targs
+	p.consume("E")
+
+	if p.peek("s") {
+		p.pos++
+		p.discriminator()
+		return nameNode(function + "::string literal")
+	}
+
+	n := p.name()
+	p.discriminator()
+	return join(nameNode(function), n)
+}
+
+// discriminator ::= _ <digit> | __ <number> _
+func (p *parser) discriminator() {
+	switch {
+	case p.peek("__"):
+		p.pos += 2
+		p.number()
+		p.consume("_")
+	case p.peek("_") && isDigit(p.peekAt(1)):
+		p.pos += 2
+	}
+}
+
+// unqualifiedName parses:
+//
+//	<unqualified-name> ::= <operator-name> [<abi-tags>]
+//	                   ::= <source-name> [<abi-tags>]
+//	                   ::= <unnamed-type-name>
+//
+// The prefix is used for the conversion operators.
+func (p *parser) unqualifiedName(prefix *node) *node {
+	var n *node
+	switch {
+	case p.atEnd():
+		p.fail("expected name")
+	case isDigit(p.s[p.pos]):
+		n = nameNode(p.sourceName())
+	case p.peek("L"):
+		// Internal linkage, which isn't printed.
+		p.pos++
+		n = nameNode(p.sourceName())
+		p.discriminator()
+	case p.peek("Ut"):
+		p.pos += 2
+		n = nameNode(fmt.Sprintf("{unnamed type#%d}", p.seqNumber()))
+	case p.peek("Ul"):
+		p.pos += 2
+		params := p.bareFunctionType()
+		p.consume("E")
+		n = nameNode(fmt.Sprintf("{lambda(%s)#%d}", joinNodes(params), p.seqNumber()))
+	case isLower(p.s[p.pos]):
+		n = p.operatorName()
+	default:
+		p.fail("unsupported name")
+	}
+
+	for p.peek("B") {
+		p.pos++
+		n.s += "[abi:" + p.sourceName() + "]"
+	}
+	return n
+}
+
+// seqNumber parses the optional number of the unnamed types and lambdas,
+// terminated by an underscore, and returns their number as printed.
+func (p *parser) seqNumber() int {
+	n := 1
+	if !p.peek("_") {
+		n = p.number() + 2
+	}
+	p.consume("_")
+	return n
+}
+
+// sourceName ::= <length> <identifier>
+func (p *parser) sourceName() string {
+	length := p.number()
+	if length <= 0 || p.pos+length > len(p.s) {
+		p.fail("invalid identifier length %d", length)
+	}
+	name := p.s[p.pos : p.pos+length]
+	p.pos += length
+	if strings.HasPrefix(name, "_GLOBAL__N") {
+		return "(anonymous namespace)"
+	}
+	return name
+}
+
+// ctorDtorName ::= C1 | C2 | C3 | CI1 <type> | CI2 <type> | D0 | D1 | D2
+func (p *parser) ctorDtorName(class *node) *node {
+	var n *node
+	switch {
+	case p.peek("CI1") || p.peek("CI2"):
+		p.pos += 3
+		p.typ()
+		n = nameNode(class.last)
+	case p.peek("C"):
+		p.pos++
+		if !isDigit(p.peekAt(0)) {
+			p.fail("invalid constructor")
+		}
+		p.pos++
+		n = nameNode(class.last)
+	case p.peek("D"):
+		p.pos++
+		if !isDigit(p.peekAt(0)) {
+			p.fail("invalid destructor")
+		}
+		p.pos++
+		n = nameNode("~" + class.last)
+	}
+	n.noReturn = true
+	return n
+}
+
+// operators by their code.
+var operators = map[string]string{
+	"nw": "new", "na": "new[]", "dl": "delete", "da": "delete[]",
+	"ps": "+", "ng": "-", "ad": "&", "de": "*", "co": "~",
+	"pl": "+", "mi": "-", "ml": "*", "dv": "/", "rm": "%",
+	"an": "&", "or": "|", "eo": "^", "aS": "=",
+	"pL": "+=", "mI": "-=", "mL": "*=", "dV": "/=", "rM": "%=",
+	"aN": "&=", "oR": "|=", "eO": "^=",
+	"ls": "<<", "rs": ">>", "lS": "<<=", "rS": ">>=",
+	"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=", "ss": "<=>",
+	"nt": "!", "aa": "&&", "oo": "||", "pp": "++", "mm": "--",
+	"cm": ",", "pm": "->*", "pt": "->", "cl": "()", "ix": "[]", "qu": "?",
+}
+
+// operatorName ::= <operator code> | cv <type> | li <source-name>
+func (p *parser) operatorName() *node {
+	if p.pos+2 > len(p.s) {
+		p.fail("expected operator")
+	}
+	code := p.s[p.pos : p.pos+2]
+	p.pos += 2
+
+	switch code {
+	case "cv":
+		n := nameNode("operator " + p.typ().String())
+		n.noReturn = true
+		return n
+	case "li":
+		return nameNode(`operator"" ` + p.sourceName())
+	}
+
+	op, ok := operators[code]
+	if !ok {
+		p.fail("unknown operator %q", code)
+	}
+	// The operators made of letters are separated from the keyword.
+	if isLower(op[0]) {
+		return nameNode("operator " + op)
+	}
+	return nameNode("operator" + op)
+}
+
+// cvQualifiers ::= [r] [V] [K]
+func (p *parser) cvQualifiers() string {
+	var restrict, volatile, constant bool
+	if p.peek("r") {
+		p.pos++
+		restrict = true
+	}
+	if p.peek("V") {
+		p.pos++
+		volatile = true
+	}
+	if p.peek("K") {
+		p.pos++
+		constant = true
+	}
+
+	var s string
+	if constant {
+		s += " const"
+	}
+	if volatile {
+		s += " volatile"
+	}
+	if restrict {
+		s += " restrict"
+	}
+	return s
+}
+
+// templateArgs ::= I <template-arg>+ E
+func (p *parser) templateArgs() []*node {
+	p.consume("I")
+	var args []*node
+	for !p.peek("E") {
+		if p.atEnd() {
+			p.fail("unterminated template arguments")
+		}
+		args = append(args, p.templateArg())
+	}
+	p.consume("E")
+	return args
+}
+
+// templateArg parses:
+//
+//	<template-arg> ::= <type>
+//	               ::= L <type> <value number> E
+//	               ::= L _Z <encoding> E
+//	               ::= J <template-arg>* E
+func (p *parser) templateArg() *node {
+	switch {
+	case p.peek("L_Z"):
+		p.pos += 3
+		n := nameNode(p.encoding())
+		p.consume("E")
+		return n
+	case p.peek("L"):
+		p.pos++
+		return p.literal()
+	case p.peek("J"):
+		p.pos++
+		var args []*node
+		for !p.peek("E") {
+			if p.atEnd() {
+				p.fail("unterminated argument pack")
+			}
+			args = append(args, p.templateArg())
+		}
+		p.consume("E")
+		return &node{kind: kindArgPack, params: args}
+	case p.peek("X"):
+		p.fail("unsupported expression")
+	}
+	return p.typ()
+}
+
+// literal suffixes by type.
+var literalSuffixes = map[string]string{
+	"int": "", "unsigned int": "u",
+	"long": "l", "unsigned long": "ul",
+	"long long": "ll", "unsigned long long": "ull",
+}
+
+// literal ::= <type> <value number> E
+func (p *parser) literal() *node {
+	t := p.typ()
+	start := p.pos
+	for !p.atEnd() && p.s[p.pos] != 'E' {
+		p.pos++
+	}
+	value := p.s[start:p.pos]
+	p.consume("E")
+	if strings.HasPrefix(value, "n") {
+		value = "-" + value[1:]
+	}
+
+	name := t.String()
+	if name == "bool" {
+		switch value {
+		case "0":
+			return nameNode("false")
+		case "1":
+			return nameNode("true")
+		}
+	}
+	if suffix, ok := literalSuffixes[name]; ok {
+		return nameNode(value + suffix)
+	}
+	return nameNode("(" + name + ")" + value)
+}
+
+// templateParam ::= T_ | T <number> _
+func (p *parser) templateParam() *node {
+	p.consume("T")
+	i := 0
+	if !p.peek("_") {
+		i = p.number() + 1
+	}
+	p.consume("_")
+	if i >= len(p.targs) {
+		p.fail("template parameter %d out of range", i)
+	}
+	return p.targs[i]
+}
+
+// standard abbreviations, by code. Like c++filt, the typedefs (e.g:
+// std::string) are printed in full, as they are ambiguous since C++11.
+var abbreviations = map[byte]*node{
+	'a': {kind: kindName, s: "std::allocator", last: "allocator"},
+	'b': {kind: kindName, s: "std::basic_string", last: "basic_string"},
+	's': {kind: kindName, s: "std::basic_string<char, std::char_traits<char>, std::allocator<char> >", last: "basic_string"},
+	'i': {kind: kindName, s: "std::basic_istream<char, std::char_traits<char> >", last: "basic_istream"},
+	'o': {kind: kindName, s: "std::basic_ostream<char, std::char_traits<char> >", last: "basic_ostream"},
+	'd': {kind: kindName, s: "std::basic_iostream<char, std::char_traits<char> >", last: "basic_iostream"},
+}
+
+// substitution ::= S_ | S <seq-id> _ | Sa | Sb | Ss | Si | So | Sd
+func (p *parser) substitution() *node {
+	p.consume("S")
+	if p.atEnd() {
+		p.fail("unterminated substitution")
+	}
+
+	if n, ok := abbreviations[p.s[p.pos]]; ok {
+		p.pos++
+		return n
+	}
+
+	i := 0
+	if !p.peek("_") {
+		start := p.pos
+		for !p.atEnd() && (isDigit(p.s[p.pos]) || isUpper(p.s[p.pos])) {
+			p.pos++
+		}
+		seq, err := strconv.ParseInt(p.s[start:p.pos], 36, 32)
+		if err != nil {
+			p.fail("invalid substitution: %s", err)
+		}
+		i = int(seq) + 1
+	}
+	p.consume("_")
+	if i >= len(p.subs) {
+		p.fail("substitution %d out of range", i)
+	}
+	return p.subs[i]
+}
+
+// builtin types, by code. The ones with two letters start with D.
+var builtins = map[string]string{
+	"v": "void", "w": "wchar_t", "b": "bool", "c": "char",
+	"a": "signed char", "h": "unsigned char", "s": "short", "t": "unsigned short",
+	"i": "int", "j": "unsigned int", "l": "long", "m": "unsigned long",
+	"x": "long long", "y": "unsigned long long", "n": "__int128", "o": "unsigned __int128",
+	"f": "float", "d": "double", "e": "long double", "g": "__float128", "z": "...",
+	"Dd": "decimal64", "De": "decimal128", "Df": "decimal32", "Dh": "half",
+	"Di": "char32_t", "Ds": "char16_t", "Du": "char8_t",
+	"Da": "auto", "Dc": "decltype(auto)", "Dn": "decltype(nullptr)",
+}
+
+// typ parses a type. Every type but the builtins and the substitutions is a
+// substitution candidate.
+func (p *parser) typ() *node {
+	if p.atEnd() {
+		p.fail("expected type")
+	}
+
+	c := p.s[p.pos]
+	if name, ok := builtins[string(c)]; ok && c != 'D' {
+		p.pos++
+		return nameNode(name)
+	}
+
+	var n *node
+	switch c {
+	case 'D':
+		if p.pos+2 > len(p.s) {
+			p.fail("expected type")
+		}
+		code := p.s[p.pos : p.pos+2]
+		if name, ok := builtins[code]; ok {
+			p.pos += 2
+			return nameNode(name)
+		}
+		if code != "Dp" {
+			p.fail("unsupported type %q", code)
+		}
+		p.pos += 2
+		n = &node{kind: kindPackExpansion, inner: p.typ()}
+	case 'u':
+		p.pos++
+		n = nameNode(p.sourceName())
+	case 'r', 'V', 'K':
+		quals := p.cvQualifiers()
+		n = &node{kind: kindQualified, s: quals, inner: p.typ()}
+		// The qualifiers of an array apply to its elements.
+		if n.inner.kind == kindArray {
+			array := *n.inner
+			n.inner = array.inner
+			array.inner = n
+			n = &array
+		}
+	case 'P':
+		p.pos++
+		n = &node{kind: kindPointer, inner: p.typ()}
+	case 'R':
+		p.pos++
+		n = &node{kind: kindReference, inner: p.typ()}
+	case 'O':
+		p.pos++
+		n = &node{kind: kindRValueReference, inner: p.typ()}
+	case 'C':
+		p.pos++
+		n = nameNode(p.typ().String() + " _Complex")
+	case 'G':
+		p.pos++
+		n = nameNode(p.typ().String() + " _Imaginary")
+	case 'F':
+		n = p.functionType()
+	case 'A':
+		p.pos++
+		var dim string
+		if !p.peek("_") {
+			dim = strconv.Itoa(p.number())
+		}
+		p.consume("_")
+		n = &node{kind: kindArray, s: dim, inner: p.typ()}
+	case 'M':
+		p.pos++
+		class := p.typ()
+		n = &node{kind: kindMember, class: class, inner: p.typ()}
+	case 'T':
+		n = p.templateParam()
+		if p.peek("I") {
+			p.addSub(n)
+			n = withArgs(n, p.templateArgs())
+		}
+	case 'S':
+		if !p.peek("St") {
+			n = p.substitution()
+			if !p.peek("I") {
+				return n
+			}
+			n = withArgs(n, p.templateArgs())
+			break
+		}
+		n = p.name()
+	case 'N', 'Z', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		n = p.name()
+	default:
+		p.fail("unsupported type %q", c)
+	}
+
+	p.addSub(n)
+	return n
+}
+
+// functionType ::= F [Y] <return type> <bare-function-type> [<ref-qualifier>] E
+func (p *parser) functionType() *node {
+	p.consume("F")
+	if p.peek("Y") {
+		p.pos++
+	}
+
+	n := &node{kind: kindFunction, ret: p.typ()}
+	for !p.peek("E") {
+		if p.atEnd() {
+			p.fail("unterminated function type")
+		}
+		switch {
+		case p.peek("RE"):
+			p.pos++
+			n.s = " &"
+			continue
+		case p.peek("OE"):
+			p.pos++
+			n.s = " &&"
+			continue
+		}
+		n.params = append(n.params, p.typ())
+	}
+	p.consume("E")
+
+	if len(n.params) == 1 && n.params[0].kind == kindName && n.params[0].s == "void" {
+		n.params = nil
+	}
+	return n
+}
+
+// bareFunctionType parses the types of the parameters of a function, until
+// the end of the symbol or of the enclosing construct. A single void parameter
+// means no parameters.
+func (p *parser) bareFunctionType() []*node {
+	var params []*node
+	for !p.atEnd() && !p.peek("E") && !p.peek(".") {
+		params = append(params, p.typ())
+	}
+	if len(params) == 0 {
+		p.fail("expected parameters")
+	}
+	if len(params) == 1 && params[0].kind == kindName && params[0].s == "void" {
+		return nil
+	}
+	return params
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+func isLower(c byte) bool { return c >= 'a' && c <= 'z' }
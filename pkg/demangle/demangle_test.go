@@ -0,0 +1,88 @@
+package demangle
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSymbol(t *testing.T) {
+	for n, c := range map[string]struct {
+		symbol string
+		want   string
+	}{
+		"function":             {symbol: "_Z3fooi", want: "foo(int)"},
+		"no parameters":        {symbol: "_Z3foov", want: "foo()"},
+		"variable":             {symbol: "_ZN2ns7counterE", want: "ns::counter"},
+		"nested":               {symbol: "_ZN3foo3barEPKc", want: "foo::bar(char const*)"},
+		"const method":         {symbol: "_ZNK1A1fEv", want: "A::f() const"},
+		"ref-qualified method": {symbol: "_ZNO1S2rrEv", want: "S::rr() &&"},
+		"constructor":          {symbol: "_ZN5Outer5InnerC2ERKS0_", want: "Outer::Inner::Inner(Outer::Inner const&)"},
+		"destructor":           {symbol: "_ZNSaIcED1Ev", want: "std::allocator<char>::~allocator()"},
+		"operator":             {symbol: "_ZN1AnwEm", want: "A::operator new(unsigned long)"},
+		"conversion operator":  {symbol: "_ZN1ScviEv", want: "S::operator int()"},
+		"std abbreviation":     {symbol: "_ZNKSs4sizeEv", want: "std::basic_string<char, std::char_traits<char>, std::allocator<char> >::size() const"},
+		"template":             {symbol: "_ZNKSt6vectorIiSaIiEE4sizeEv", want: "std::vector<int, std::allocator<int> >::size() const"},
+		"template function":    {symbol: "_ZN1AIiE1fIcEEvT_", want: "void A<int>::f<char>(char)"},
+		"literal arguments":    {symbol: "_Z1fILb1ELi5ELj5ELln3ELm4EEvv", want: "void f<true, 5, 5u, -3l, 4ul>()"},
+		"parameter pack":       {symbol: "_Z1fIJidEEvDpRKT_", want: "void f<int, double>(int const&, double const&)"},
+		"empty parameter pack": {symbol: "_Z4packIJEEvDpT_", want: "void pack<>()"},
+		"substitutions":        {symbol: "_ZStlsIcSt11char_traitsIcEERSt13basic_ostreamIT_T0_ES6_PKc", want: "std::basic_ostream<char, std::char_traits<char> >& std::operator<< <char, std::char_traits<char> >(std::basic_ostream<char, std::char_traits<char> >&, char const*)"},
+		"function pointer":     {symbol: "_Z1fPFPFivEvE", want: "f(int (*(*)())())"},
+		"array reference":      {symbol: "_Z1fRKA3_i", want: "f(int const (&) [3])"},
+		"pointer to member":    {symbol: "_ZNK1A1fEMS_FivE", want: "A::f(int (A::*)()) const"},
+		"anonymous namespace":  {symbol: "_ZN12_GLOBAL__N_14anonEi", want: "(anonymous namespace)::anon(int)"},
+		"abi tag":              {symbol: "_ZN1AB5cxx111fEv", want: "A[abi:cxx11]::f()"},
+		"local name":           {symbol: "_ZZ3usevE7counter", want: "use()::counter"},
+		"lambda":               {symbol: "_ZZ4mainENKUlvE_clEv", want: "main::{lambda()#1}::operator()() const"},
+		"vtable":               {symbol: "_ZTVSt9exception", want: "vtable for std::exception"},
+		"thunk":                {symbol: "_ZTv0_n24_N1A1fEv", want: "virtual thunk to A::f()"},
+		"guard variable":       {symbol: "_ZGVZ4mainE1x", want: "guard variable for main::x"},
+		"clones":               {symbol: "_Z3barPFviE.isra.0.part.1", want: "bar(void (*)(int)) [clone .isra.0] [clone .part.1]"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := Symbol(c.symbol)
+			if err != nil {
+				t.Fatalf(`Symbol(%q): unexpected error: %s`, c.symbol, err)
+			}
+			if got != c.want {
+				t.Errorf(`Symbol(%q): wanted %q, got %q`, c.symbol, c.want, got)
+			}
+		})
+	}
+}
+
+func TestSymbol_Error(t *testing.T) {
+	for n, c := range map[string]struct {
+		symbol string
+		want   error
+	}{
+		"not mangled":       {symbol: "main", want: ErrNotMangled},
+		"truncated":         {symbol: "_ZN3foo3ba", want: ErrInvalid},
+		"empty nested name": {symbol: "_ZNEv", want: ErrInvalid},
+		"bad substitution":  {symbol: "_Z1fS0_", want: ErrInvalid},
+		"expression":        {symbol: "_Z1fIXadL_Z1gvEEEvv", want: ErrInvalid},
+	} {
+		t.Run(n, func(t *testing.T) {
+			_, err := Symbol(c.symbol)
+			if !errors.Is(err, c.want) {
+				t.Errorf(`Symbol(%q): wanted error %v, got %v`, c.symbol, c.want, err)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	in := "#0  0x0000555555555131 in _ZN3foo3barEi ()\n" +
+		"#1  0x0000555555555140 in _ZN3foo3baz ()\n" +
+		"#2  0x0000555555555150 in main () at main.cpp:12\n" +
+		"see _Z3foov.\n"
+	want := "#0  0x0000555555555131 in foo::bar(int) ()\n" +
+		"#1  0x0000555555555140 in _ZN3foo3baz ()\n" +
+		"#2  0x0000555555555150 in main () at main.cpp:12\n" +
+		"see foo().\n"
+
+	got := Text(in)
+	if got != want {
+		t.Errorf(`Text(): wanted %q, got %q`, want, got)
+	}
+}
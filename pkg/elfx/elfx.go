@@ -3,10 +3,17 @@ package elfx
 import (
 	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/elwinar/rcoredump/pkg/corenote"
 )
 
 func init() {
@@ -59,6 +66,10 @@ func SetLibraryPath(path string) {
 // File wraps an elf.File to add additional utility methods on it.
 type File struct {
 	Path string
+	// Platform is the platform string the process was given by the kernel
+	// (see getauxval(3)), used to expand $PLATFORM. If empty, it is
+	// guessed from the class of the file.
+	Platform string
 	*elf.File
 }
 
@@ -97,6 +108,7 @@ func (f File) ResolveImportedLibrary(library string) (path string, ok bool, err
 			return library, false, err
 		}
 	}
+	runpath, rpath = splitDirs(runpath), splitDirs(rpath)
 
 	// We check first if the library is a path, then in the configured and
 	// standard directories.
@@ -127,12 +139,36 @@ func (f File) ResolveImportedLibrary(library string) (path string, ok bool, err
 		return path, true, nil
 	}
 
-	for _, dirs := range [][]string{
-		rpath,
-		LibraryPathDirs,
-		runpath,
-		DefaultDirs,
-	} {
+	path, ok, err = f.searchDirs(library, rpath, LibraryPathDirs, runpath)
+	if ok || err != nil {
+		return path, ok, err
+	}
+
+	// The dynamic linker looks in its cache before the default
+	// directories. The cache may be outdated, so the file must still be
+	// checked.
+	if path, ok := f.lookupCache(library); ok {
+		_, err = os.Stat(path)
+		if err == nil {
+			return path, true, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return path, false, err
+		}
+	}
+
+	path, ok, err = f.searchDirs(library, f.multiarchDirs(), DefaultDirs)
+	if ok || err != nil {
+		return path, ok, err
+	}
+
+	return library, false, nil
+}
+
+// searchDirs looks for the library in each of the given lists of directories,
+// in order, and returns the path of the first existing file.
+func (f File) searchDirs(library string, lists ...[]string) (path string, ok bool, err error) {
+	for _, dirs := range lists {
 		for _, dir := range dirs {
 			path = filepath.Join(f.Expand(dir), library)
 			_, err = os.Stat(path)
@@ -146,7 +182,272 @@ func (f File) ResolveImportedLibrary(library string) (path string, ok bool, err
 		}
 	}
 
-	return library, false, nil
+	return "", false, nil
+}
+
+// splitDirs splits the values of DT_RPATH or DT_RUNPATH entries, which are
+// colon-separated lists of directories. Empty directories are ignored.
+func splitDirs(values []string) []string {
+	var dirs []string
+	for _, v := range values {
+		for _, d := range strings.Split(v, ":") {
+			if len(d) != 0 {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs
+}
+
+// MultiarchTriplet returns the multiarch tuple of the file's architecture, as
+// used by Debian-based distributions to name the library directories (e.g:
+// /usr/lib/x86_64-linux-gnu), or an empty string if the architecture isn't
+// known. See https://wiki.debian.org/Multiarch/Tuples.
+func (f File) MultiarchTriplet() string {
+	switch f.Machine {
+	case elf.EM_X86_64:
+		if f.Class == elf.ELFCLASS32 {
+			return "x86_64-linux-gnux32"
+		}
+		return "x86_64-linux-gnu"
+	case elf.EM_386:
+		return "i386-linux-gnu"
+	case elf.EM_AARCH64:
+		return "aarch64-linux-gnu"
+	case elf.EM_ARM:
+		// The soft-float ABI (arm-linux-gnueabi) can't be distinguished
+		// without reading the header flags, and is mostly a thing of the
+		// past anyway.
+		return "arm-linux-gnueabihf"
+	case elf.EM_PPC64:
+		if f.ByteOrder == binary.LittleEndian {
+			return "powerpc64le-linux-gnu"
+		}
+		return "powerpc64-linux-gnu"
+	case elf.EM_S390:
+		return "s390x-linux-gnu"
+	case elf.EM_RISCV:
+		return "riscv64-linux-gnu"
+	default:
+		return ""
+	}
+}
+
+// multiarchDirs returns the multiarch variants of the default directories.
+func (f File) multiarchDirs() []string {
+	triplet := f.MultiarchTriplet()
+	if len(triplet) == 0 {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(DefaultDirs))
+	for _, dir := range DefaultDirs {
+		dirs = append(dirs, filepath.Join(dir, triplet))
+	}
+	return dirs
+}
+
+// ExportedSymbols returns the sorted names of the dynamic symbols defined by
+// the file, i.e the functions and variables it exports for other objects to
+// use. Undefined symbols (the ones imported from other objects) and local
+// symbols are ignored.
+func (f File) ExportedSymbols() ([]string, error) {
+	symbols, err := f.DynamicSymbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range symbols {
+		if s.Section == elf.SHN_UNDEF || len(s.Name) == 0 {
+			continue
+		}
+
+		switch elf.ST_BIND(s.Info) {
+		case elf.STB_GLOBAL, elf.STB_WEAK:
+		default:
+			continue
+		}
+
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT, elf.STT_GNU_IFUNC:
+		default:
+			continue
+		}
+
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// BuildID returns the build-id of the file in hexadecimal, as written by the
+// linker in the NT_GNU_BUILD_ID note, or an empty string if it has none.
+func (f File) BuildID() (string, error) {
+	notes, err := f.notes()
+	if err != nil {
+		return "", err
+	}
+
+	for _, note := range notes {
+		if note.Name == "GNU" && note.Type == ntGNUBuildID {
+			return hex.EncodeToString(note.Desc), nil
+		}
+	}
+	return "", nil
+}
+
+// Types of the GNU notes.
+const (
+	ntGNUABITag  = 1
+	ntGNUBuildID = 3
+)
+
+// abiOSes are the names of the operating systems of the NT_GNU_ABI_TAG note.
+var abiOSes = map[uint32]string{
+	0: "Linux",
+	1: "Hurd",
+	2: "Solaris",
+	3: "FreeBSD",
+}
+
+// ABITag returns the operating system and minimal version of its kernel the
+// file requires (e.g: "Linux 3.2.0"), as written in the NT_GNU_ABI_TAG note by
+// the C library, or an empty string if it has none.
+func (f File) ABITag() (string, error) {
+	notes, err := f.notes()
+	if err != nil {
+		return "", err
+	}
+
+	for _, note := range notes {
+		if note.Name != "GNU" || note.Type != ntGNUABITag || len(note.Desc) < 16 {
+			continue
+		}
+
+		system, ok := abiOSes[f.ByteOrder.Uint32(note.Desc)]
+		if !ok {
+			system = fmt.Sprintf("OS %d", f.ByteOrder.Uint32(note.Desc))
+		}
+		return fmt.Sprintf("%s %d.%d.%d", system, f.ByteOrder.Uint32(note.Desc[4:]), f.ByteOrder.Uint32(note.Desc[8:]), f.ByteOrder.Uint32(note.Desc[12:])), nil
+	}
+	return "", nil
+}
+
+// Comments returns the distinct entries of the .comment section, in which the
+// compilers and linkers write their version (e.g: "GCC: (GNU) 10.1.0"). Each
+// object file linked adds its own, so there can be multiple ones.
+func (f File) Comments() ([]string, error) {
+	section := f.Section(".comment")
+	if section == nil {
+		return nil, nil
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []string
+	seen := make(map[string]bool)
+	for _, comment := range strings.Split(string(data), "\x00") {
+		comment = strings.TrimSpace(comment)
+		if len(comment) == 0 || seen[comment] {
+			continue
+		}
+		seen[comment] = true
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+// notes returns the notes of the PT_NOTE segments of the file.
+func (f File) notes() ([]corenote.Note, error) {
+	var notes []corenote.Note
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, corenote.Parse(data, f.Class, f.ByteOrder)...)
+	}
+	return notes, nil
+}
+
+// Type returns the kind of linkage of the file, i.e if it is statically
+// linked (it doesn't need the dynamic linker to run), and if it is a
+// position-independent executable. Static PIE executables are both, and
+// shared libraries are neither.
+func (f File) Type() (static bool, pie bool) {
+	var interp bool
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			interp = true
+			break
+		}
+	}
+
+	switch f.File.Type {
+	case elf.ET_EXEC:
+		return !interp, false
+
+	case elf.ET_DYN:
+		// Old toolchains don't set the PIE flag, but a shared object
+		// with an interpreter can't be anything else than an
+		// executable.
+		flags, _ := f.dynValue(elf.DT_FLAGS_1)
+		pie = interp || elf.DynFlag1(flags)&elf.DF_1_PIE != 0
+		return pie && !interp, pie
+
+	default:
+		return false, false
+	}
+}
+
+// dynValue returns the value of the first entry of the dynamic section with
+// the given tag, and a boolean indicating if the entry was found.
+func (f File) dynValue(tag elf.DynTag) (uint64, bool) {
+	ds := f.SectionByType(elf.SHT_DYNAMIC)
+	if ds == nil {
+		return 0, false
+	}
+
+	d, err := ds.Data()
+	if err != nil {
+		return 0, false
+	}
+
+	for {
+		var t elf.DynTag
+		var v uint64
+		switch {
+		case f.Class == elf.ELFCLASS32 && len(d) >= 8:
+			t = elf.DynTag(f.ByteOrder.Uint32(d[0:4]))
+			v = uint64(f.ByteOrder.Uint32(d[4:8]))
+			d = d[8:]
+		case f.Class == elf.ELFCLASS64 && len(d) >= 16:
+			t = elf.DynTag(f.ByteOrder.Uint64(d[0:8]))
+			v = f.ByteOrder.Uint64(d[8:16])
+			d = d[16:]
+		default:
+			return 0, false
+		}
+
+		if t == elf.DT_NULL {
+			return 0, false
+		}
+		if t == tag {
+			return v, true
+		}
+	}
 }
 
 // Expand a rpath specification for tokens like $ORIGIN, $LIB, $PLATFORM.
@@ -156,8 +457,8 @@ func (f File) ResolveImportedLibrary(library string) (path string, ok bool, err
 // or github.com/mvdan/sh because both of those have much more features than
 // necessary, and variable expansion is a very sensible subject.
 //
-// BUG The PLATFORM replacement is inherently wrong, and should probably not be
-// relied upon.
+// BUG The PLATFORM replacement is inherently wrong when the Platform field
+// isn't set, and should probably not be relied upon.
 func (f File) Expand(path string) string {
 	return expand(path, func(name string) (value string, ok bool) {
 		switch string(name) {
@@ -170,13 +471,14 @@ func (f File) Expand(path string) string {
 			}
 			return "lib", true
 
-		// This is a best attempt at something that is probably
-		// fundamentaly wrong or impossible in the context: the
-		// platform string is given by the kernel to the program in the
-		// auxilliary vector (see getauxval(3)), which we can't get
-		// afterwards. Something more complex could probably be done,
-		// but right now this will do.
+		// The platform string is given by the kernel to the program in
+		// the auxilliary vector (see getauxval(3)), which can be read
+		// from the core. Without it, this is a best attempt at
+		// something that is probably fundamentaly wrong.
 		case "PLATFORM":
+			if len(f.Platform) != 0 {
+				return f.Platform, true
+			}
 			switch f.Class {
 			case elf.ELFCLASS64:
 				return "x86_64", true
@@ -2,9 +2,13 @@ package elfx
 
 import (
 	"debug/elf"
+	"encoding/binary"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/elfx/elfxtest"
 )
 
 func TestSetLibraryPath(t *testing.T) {
@@ -96,6 +100,11 @@ func TestFile_ResolveImportedLibrary(t *testing.T) {
 			wantPath:    "testdata/lib64/library_in_lib64.so",
 			wantOK:      true,
 		},
+		"library in multiarch triplet": testcase{
+			input:    "library_in_triplet.so",
+			wantPath: AbsT(t, "./testdata/lib/x86_64-linux-gnu/library_in_triplet.so"),
+			wantOK:   true,
+		},
 		"library in rpath": testcase{
 			executable: "./testdata/executable_rpath",
 			input:      "library_in_rpath.so",
@@ -152,6 +161,125 @@ func TestFile_ResolveImportedLibrary(t *testing.T) {
 	}
 }
 
+func TestFile_ResolveImportedLibrary_Fake(t *testing.T) {
+	type testcase struct {
+		executable  elfxtest.File
+		libraries   []string
+		libraryDirs []string
+		defaultDirs []string
+		input       string
+		wantPath    string
+		wantOK      bool
+	}
+
+	for n, c := range map[string]testcase{
+		"rpath before ld_library_path": testcase{
+			executable:  elfxtest.File{RPath: []string{"$ROOT/rpath"}},
+			libraries:   []string{"rpath/lib.so", "ld_library_path/lib.so"},
+			libraryDirs: []string{"ld_library_path"},
+			input:       "lib.so",
+			wantPath:    "rpath/lib.so",
+			wantOK:      true,
+		},
+		"ld_library_path before runpath": testcase{
+			executable:  elfxtest.File{RunPath: []string{"$ROOT/runpath"}},
+			libraries:   []string{"runpath/lib.so", "ld_library_path/lib.so"},
+			libraryDirs: []string{"ld_library_path"},
+			input:       "lib.so",
+			wantPath:    "ld_library_path/lib.so",
+			wantOK:      true,
+		},
+		"runpath ignores rpath": testcase{
+			executable: elfxtest.File{RPath: []string{"$ROOT/rpath"}, RunPath: []string{"$ROOT/runpath"}},
+			libraries:  []string{"rpath/lib.so"},
+			input:      "lib.so",
+			wantPath:   "lib.so",
+			wantOK:     false,
+		},
+		"runpath with several directories": testcase{
+			executable: elfxtest.File{RunPath: []string{"$ROOT/first:$ROOT/second"}},
+			libraries:  []string{"second/lib.so"},
+			input:      "lib.so",
+			wantPath:   "second/lib.so",
+			wantOK:     true,
+		},
+		"runpath with origin": testcase{
+			executable: elfxtest.File{RunPath: []string{"$ORIGIN/../lib"}},
+			libraries:  []string{"lib/lib.so"},
+			input:      "lib.so",
+			wantPath:   "lib/lib.so",
+			wantOK:     true,
+		},
+		"default dirs before lib": testcase{
+			executable:  elfxtest.File{Class: elf.ELFCLASS32, Machine: elf.EM_386},
+			libraries:   []string{"lib/lib.so", "lib64/lib.so"},
+			defaultDirs: []string{"$LIB"},
+			input:       "lib.so",
+			wantPath:    "lib/lib.so",
+			wantOK:      true,
+		},
+		"multiarch triplet of the file": testcase{
+			executable:  elfxtest.File{Machine: elf.EM_AARCH64},
+			libraries:   []string{"lib/x86_64-linux-gnu/lib.so", "lib/aarch64-linux-gnu/lib.so"},
+			defaultDirs: []string{"lib"},
+			input:       "lib.so",
+			wantPath:    "lib/aarch64-linux-gnu/lib.so",
+			wantOK:      true,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			root := tempDirT(t)
+
+			// The executable is in a subdirectory so $ORIGIN/.. is
+			// the root. $ROOT is a placeholder for the latter in the
+			// rpath and runpath.
+			for _, dirs := range [][]string{c.executable.RPath, c.executable.RunPath} {
+				for i, d := range dirs {
+					dirs[i] = strings.ReplaceAll(d, "$ROOT", root)
+				}
+			}
+			path := elfxtest.Write(t, root, "bin/executable", c.executable)
+			for _, l := range c.libraries {
+				elfxtest.Write(t, root, l, elfxtest.File{})
+			}
+
+			// Directories are relative to the root.
+			prefix := func(dirs []string) []string {
+				var prefixed []string
+				for _, d := range dirs {
+					prefixed = append(prefixed, filepath.Join(root, d))
+				}
+				return prefixed
+			}
+			LibraryPathDirs = prefix(c.libraryDirs)
+			DefaultDirs = prefix(c.defaultDirs)
+			LdSoCache = nil
+
+			file, err := Open(path)
+			if err != nil {
+				t.Fatalf(`ResolveImportedLibrary(%q): opening executable: %s`, c.input, err)
+			}
+			defer file.Close()
+
+			gotPath, ok, err := file.ResolveImportedLibrary(c.input)
+			if err != nil {
+				t.Fatalf(`ResolveImportedLibrary(%q): unexpected error: %s`, c.input, err)
+			}
+
+			if ok {
+				gotPath, err = filepath.Rel(root, gotPath)
+				if err != nil {
+					t.Fatalf(`ResolveImportedLibrary(%q): unexpected path %q`, c.input, gotPath)
+				}
+			}
+
+			if gotPath != c.wantPath || ok != c.wantOK {
+				t.Errorf(`ResolveImportedLibrary(%q): wanted %q, %t, got %q, %t`, c.input, c.wantPath, c.wantOK, gotPath, ok)
+			}
+		})
+	}
+}
+
 // AbsT returns an absolute path equivalent to the given path, and fail the
 // test in case of error.
 func AbsT(t *testing.T, path string) string {
@@ -163,10 +291,230 @@ func AbsT(t *testing.T, path string) string {
 	return p
 }
 
-func TestFile_Expand(t *testing.T) {
+func TestFile_MultiarchTriplet(t *testing.T) {
+	type testcase struct {
+		machine elf.Machine
+		class   elf.Class
+		order   binary.ByteOrder
+		want    string
+	}
+
+	for n, c := range map[string]testcase{
+		"amd64": testcase{
+			machine: elf.EM_X86_64,
+			class:   elf.ELFCLASS64,
+			order:   binary.LittleEndian,
+			want:    "x86_64-linux-gnu",
+		},
+		"x32": testcase{
+			machine: elf.EM_X86_64,
+			class:   elf.ELFCLASS32,
+			order:   binary.LittleEndian,
+			want:    "x86_64-linux-gnux32",
+		},
+		"i386": testcase{
+			machine: elf.EM_386,
+			class:   elf.ELFCLASS32,
+			order:   binary.LittleEndian,
+			want:    "i386-linux-gnu",
+		},
+		"arm64": testcase{
+			machine: elf.EM_AARCH64,
+			class:   elf.ELFCLASS64,
+			order:   binary.LittleEndian,
+			want:    "aarch64-linux-gnu",
+		},
+		"ppc64le": testcase{
+			machine: elf.EM_PPC64,
+			class:   elf.ELFCLASS64,
+			order:   binary.LittleEndian,
+			want:    "powerpc64le-linux-gnu",
+		},
+		"ppc64": testcase{
+			machine: elf.EM_PPC64,
+			class:   elf.ELFCLASS64,
+			order:   binary.BigEndian,
+			want:    "powerpc64-linux-gnu",
+		},
+		"unknown": testcase{
+			machine: elf.EM_VAX,
+			class:   elf.ELFCLASS32,
+			order:   binary.LittleEndian,
+			want:    "",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			file := File{
+				File: &elf.File{
+					FileHeader: elf.FileHeader{
+						Class:     c.class,
+						Machine:   c.machine,
+						ByteOrder: c.order,
+					},
+				},
+			}
+
+			got := file.MultiarchTriplet()
+			if got != c.want {
+				t.Errorf(`MultiarchTriplet(%s, %s): wanted %q, got %q`, c.machine, c.class, c.want, got)
+			}
+		})
+	}
+}
+
+func TestFile_ExportedSymbols(t *testing.T) {
+	type testcase struct {
+		input string
+		want  []string
+	}
+
+	for n, c := range map[string]testcase{
+		"executable": testcase{
+			input: "./testdata/executable",
+			want:  nil,
+		},
+		"library": testcase{
+			input: "./testdata/libtester.so",
+			want:  []string{"exported_function", "exported_variable"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			file, err := Open(c.input)
+			if err != nil {
+				t.Fatalf(`ExportedSymbols(%q): opening file: %s`, c.input, err)
+			}
+
+			got, err := file.ExportedSymbols()
+			if err != nil {
+				t.Fatalf(`ExportedSymbols(%q): unexpected error: %s`, c.input, err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`ExportedSymbols(%q): wanted %#v, got %#v`, c.input, c.want, got)
+			}
+		})
+	}
+}
+
+func TestFile_Type(t *testing.T) {
 	type testcase struct {
+		input      string
+		wantStatic bool
+		wantPIE    bool
+	}
+
+	for n, c := range map[string]testcase{
+		"pie": testcase{
+			input:      "./testdata/executable",
+			wantStatic: false,
+			wantPIE:    true,
+		},
+		"dynamic": testcase{
+			input:      "./testdata/executable_nopie",
+			wantStatic: false,
+			wantPIE:    false,
+		},
+		"static": testcase{
+			input:      "./testdata/executable_static",
+			wantStatic: true,
+			wantPIE:    false,
+		},
+		"static pie": testcase{
+			input:      "./testdata/executable_static_pie",
+			wantStatic: true,
+			wantPIE:    true,
+		},
+		"library": testcase{
+			input:      "./testdata/libtester.so",
+			wantStatic: false,
+			wantPIE:    false,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			file, err := Open(c.input)
+			if err != nil {
+				t.Fatalf(`Type(%q): opening file: %s`, c.input, err)
+			}
+
+			static, pie := file.Type()
+			if static != c.wantStatic || pie != c.wantPIE {
+				t.Errorf(`Type(%q): wanted %t, %t, got %t, %t`, c.input, c.wantStatic, c.wantPIE, static, pie)
+			}
+		})
+	}
+}
+
+func TestFile_BuildID(t *testing.T) {
+	for n, c := range map[string]struct {
+		input string
+		want  string
+	}{
+		"executable": {input: "./testdata/executable", want: "258d26029b11a329d070daff443b29ccd52f363e"},
+		"library":    {input: "./testdata/libtester.so", want: "bd3e7fc0513d4d6edd36d44d65766fba28bbe97e"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			file, err := Open(c.input)
+			if err != nil {
+				t.Fatalf(`BuildID(%q): opening file: %s`, c.input, err)
+			}
+
+			got, err := file.BuildID()
+			if err != nil {
+				t.Fatalf(`BuildID(%q): unexpected error: %s`, c.input, err)
+			}
+			if got != c.want {
+				t.Errorf(`BuildID(%q): wanted %q, got %q`, c.input, c.want, got)
+			}
+		})
+	}
+}
+
+func TestFile_ABITag(t *testing.T) {
+	for n, c := range map[string]struct {
 		input string
 		want  string
+	}{
+		"executable": {input: "./testdata/executable", want: "Linux 3.2.0"},
+		"static":     {input: "./testdata/executable_static", want: ""},
+	} {
+		t.Run(n, func(t *testing.T) {
+			file, err := Open(c.input)
+			if err != nil {
+				t.Fatalf(`ABITag(%q): opening file: %s`, c.input, err)
+			}
+
+			got, err := file.ABITag()
+			if err != nil {
+				t.Fatalf(`ABITag(%q): unexpected error: %s`, c.input, err)
+			}
+			if got != c.want {
+				t.Errorf(`ABITag(%q): wanted %q, got %q`, c.input, c.want, got)
+			}
+		})
+	}
+}
+
+func TestFile_Comments(t *testing.T) {
+	file, err := Open("./testdata/executable")
+	if err != nil {
+		t.Fatalf(`Comments(): opening file: %s`, err)
+	}
+
+	got, err := file.Comments()
+	if err != nil {
+		t.Fatalf(`Comments(): unexpected error: %s`, err)
+	}
+	want := []string{"GCC: (GNU) 10.1.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`Comments(): wanted %#v, got %#v`, want, got)
+	}
+}
+
+func TestFile_Expand(t *testing.T) {
+	type testcase struct {
+		input    string
+		platform string
+		want     string
 	}
 
 	for n, c := range map[string]testcase{
@@ -190,10 +538,20 @@ func TestFile_Expand(t *testing.T) {
 			input: "foo/${LIB}",
 			want:  "foo/lib64",
 		},
+		"platform": testcase{
+			input:    "foo/$PLATFORM/bar",
+			platform: "haswell",
+			want:     "foo/haswell/bar",
+		},
+		"guessed_platform": testcase{
+			input: "foo/$PLATFORM/bar",
+			want:  "foo/x86_64/bar",
+		},
 	} {
 		t.Run(n, func(t *testing.T) {
 			file := File{
-				Path: "./testdata/executable",
+				Path:     "./testdata/executable",
+				Platform: c.platform,
 				File: &elf.File{
 					FileHeader: elf.FileHeader{
 						Class: elf.ELFCLASS64,
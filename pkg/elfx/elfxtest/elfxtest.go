@@ -0,0 +1,246 @@
+// Package elfxtest generates fake ELF files, to test the resolution of the
+// libraries without depending on the ones of the system.
+package elfxtest
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// File describes a minimal ELF file to be generated by Write. It
+// only contains what is necessary for the resolution logic: the header, an
+// optional PT_INTERP program header, and a dynamic section with its string
+// table.
+type File struct {
+	// Class of the file, ELFCLASS64 by default.
+	Class elf.Class
+	// Machine of the file, EM_X86_64 by default.
+	Machine elf.Machine
+	// Type of the file, ET_DYN by default.
+	Type elf.Type
+	// Interp is the path of the program interpreter. No PT_INTERP program
+	// header is written if empty.
+	Interp string
+	// Needed, RPath and RunPath are the values of the DT_NEEDED, DT_RPATH
+	// and DT_RUNPATH entries, one entry per value.
+	Needed  []string
+	RPath   []string
+	RunPath []string
+	// Flags1 is the value of the DT_FLAGS_1 entry, which is omitted if
+	// zero.
+	Flags1 elf.DynFlag1
+}
+
+// Write generates the ELF file described by spec at dir/name, creating the
+// parent directories, and returns its path.
+//
+// NOTE The standard library can only read ELF files, so the layout is written
+// by hand: header, program header, .interp, .dynstr, .dynamic, .shstrtab, then
+// the section headers. Everything is little-endian.
+func Write(t testing.TB, dir, name string, spec File) string {
+	t.Helper()
+
+	if spec.Class == elf.ELFCLASSNONE {
+		spec.Class = elf.ELFCLASS64
+	}
+	if spec.Machine == elf.EM_NONE {
+		spec.Machine = elf.EM_X86_64
+	}
+	if spec.Type == elf.ET_NONE {
+		spec.Type = elf.ET_DYN
+	}
+	is64 := spec.Class == elf.ELFCLASS64
+
+	// Sizes of the structures depending on the class.
+	ehsize, phentsize, shentsize, dynsize := 52, 32, 40, 8
+	if is64 {
+		ehsize, phentsize, shentsize, dynsize = 64, 56, 64, 16
+	}
+
+	// Build the string tables and the dynamic entries.
+	var dynstr strtab
+	dynstr.add("")
+	var dyns []elf.Dyn64
+	for _, e := range []struct {
+		tag    elf.DynTag
+		values []string
+	}{
+		{elf.DT_NEEDED, spec.Needed},
+		{elf.DT_RPATH, spec.RPath},
+		{elf.DT_RUNPATH, spec.RunPath},
+	} {
+		for _, v := range e.values {
+			dyns = append(dyns, elf.Dyn64{Tag: int64(e.tag), Val: uint64(dynstr.add(v))})
+		}
+	}
+	if spec.Flags1 != 0 {
+		dyns = append(dyns, elf.Dyn64{Tag: int64(elf.DT_FLAGS_1), Val: uint64(spec.Flags1)})
+	}
+	dyns = append(dyns, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+	var shstrtab strtab
+	shstrtab.add("")
+	interpName := shstrtab.add(".interp")
+	dynstrName := shstrtab.add(".dynstr")
+	dynamicName := shstrtab.add(".dynamic")
+	shstrtabName := shstrtab.add(".shstrtab")
+
+	// Compute the layout of the file.
+	var phnum int
+	var interp []byte
+	if len(spec.Interp) != 0 {
+		phnum = 1
+		interp = append([]byte(spec.Interp), 0)
+	}
+	interpOff := ehsize + phnum*phentsize
+	dynstrOff := interpOff + len(interp)
+	dynamicOff := dynstrOff + dynstr.Len()
+	shstrtabOff := dynamicOff + len(dyns)*dynsize
+	shoff := shstrtabOff + shstrtab.Len()
+
+	// The sections are: null, .interp (if any), .dynstr, .dynamic,
+	// .shstrtab.
+	type section struct {
+		name, typ, link, off, size, entsize int
+	}
+	sections := []section{{}}
+	if phnum != 0 {
+		sections = append(sections, section{interpName, int(elf.SHT_PROGBITS), 0, interpOff, len(interp), 0})
+	}
+	dynstrIndex := len(sections)
+	sections = append(sections,
+		section{dynstrName, int(elf.SHT_STRTAB), 0, dynstrOff, dynstr.Len(), 0},
+		section{dynamicName, int(elf.SHT_DYNAMIC), dynstrIndex, dynamicOff, len(dyns) * dynsize, dynsize},
+		section{shstrtabName, int(elf.SHT_STRTAB), 0, shstrtabOff, shstrtab.Len(), 0},
+	)
+
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS] = byte(spec.Class)
+	ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		err := binary.Write(&buf, binary.LittleEndian, v)
+		if err != nil {
+			t.Fatalf(`writing fake file %q: %s`, name, err)
+		}
+	}
+
+	if is64 {
+		write(elf.Header64{
+			Ident:     ident,
+			Type:      uint16(spec.Type),
+			Machine:   uint16(spec.Machine),
+			Version:   uint32(elf.EV_CURRENT),
+			Phoff:     uint64(ehsize),
+			Shoff:     uint64(shoff),
+			Ehsize:    uint16(ehsize),
+			Phentsize: uint16(phentsize),
+			Phnum:     uint16(phnum),
+			Shentsize: uint16(shentsize),
+			Shnum:     uint16(len(sections)),
+			Shstrndx:  uint16(len(sections) - 1),
+		})
+		if phnum != 0 {
+			write(elf.Prog64{
+				Type:   uint32(elf.PT_INTERP),
+				Flags:  uint32(elf.PF_R),
+				Off:    uint64(interpOff),
+				Filesz: uint64(len(interp)),
+				Memsz:  uint64(len(interp)),
+				Align:  1,
+			})
+		}
+	} else {
+		write(elf.Header32{
+			Ident:     ident,
+			Type:      uint16(spec.Type),
+			Machine:   uint16(spec.Machine),
+			Version:   uint32(elf.EV_CURRENT),
+			Phoff:     uint32(ehsize),
+			Shoff:     uint32(shoff),
+			Ehsize:    uint16(ehsize),
+			Phentsize: uint16(phentsize),
+			Phnum:     uint16(phnum),
+			Shentsize: uint16(shentsize),
+			Shnum:     uint16(len(sections)),
+			Shstrndx:  uint16(len(sections) - 1),
+		})
+		if phnum != 0 {
+			write(elf.Prog32{
+				Type:   uint32(elf.PT_INTERP),
+				Flags:  uint32(elf.PF_R),
+				Off:    uint32(interpOff),
+				Filesz: uint32(len(interp)),
+				Memsz:  uint32(len(interp)),
+				Align:  1,
+			})
+		}
+	}
+
+	buf.Write(interp)
+	buf.Write(dynstr.Bytes())
+	for _, d := range dyns {
+		if is64 {
+			write(d)
+		} else {
+			write(elf.Dyn32{Tag: int32(d.Tag), Val: uint32(d.Val)})
+		}
+	}
+	buf.Write(shstrtab.Bytes())
+
+	for _, s := range sections {
+		if is64 {
+			write(elf.Section64{
+				Name:      uint32(s.name),
+				Type:      uint32(s.typ),
+				Off:       uint64(s.off),
+				Size:      uint64(s.size),
+				Link:      uint32(s.link),
+				Addralign: 1,
+				Entsize:   uint64(s.entsize),
+			})
+		} else {
+			write(elf.Section32{
+				Name:      uint32(s.name),
+				Type:      uint32(s.typ),
+				Off:       uint32(s.off),
+				Size:      uint32(s.size),
+				Link:      uint32(s.link),
+				Addralign: 1,
+				Entsize:   uint32(s.entsize),
+			})
+		}
+	}
+
+	path := filepath.Join(dir, name)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatalf(`creating directory for fake file %q: %s`, name, err)
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), os.ModePerm)
+	if err != nil {
+		t.Fatalf(`writing fake file %q: %s`, name, err)
+	}
+	return path
+}
+
+// strtab is an ELF string table being built.
+type strtab struct {
+	bytes.Buffer
+}
+
+// add appends the string to the table and returns its offset.
+func (s *strtab) add(str string) int {
+	off := s.Len()
+	s.WriteString(str)
+	s.WriteByte(0)
+	return off
+}
@@ -0,0 +1,85 @@
+package elfx
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/elfx/elfxtest"
+)
+
+// tempDirT creates a temporary directory removed during the test cleanup.
+func tempDirT(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "elfx")
+	if err != nil {
+		t.Fatalf(`creating temporary directory: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestWriteFakeFile(t *testing.T) {
+	for n, spec := range map[string]elfxtest.File{
+		"64 bits": elfxtest.File{
+			Class:   elf.ELFCLASS64,
+			Interp:  "/lib64/ld-linux-x86-64.so.2",
+			Needed:  []string{"libc.so.6", "libm.so.6"},
+			RunPath: []string{"$ORIGIN/lib"},
+			Flags1:  elf.DF_1_PIE,
+		},
+		"32 bits": elfxtest.File{
+			Class:   elf.ELFCLASS32,
+			Machine: elf.EM_386,
+			Type:    elf.ET_EXEC,
+			Needed:  []string{"libc.so.6"},
+			RPath:   []string{"/opt/lib"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			path := elfxtest.Write(t, tempDirT(t), "fake", spec)
+
+			file, err := Open(path)
+			if err != nil {
+				t.Fatalf(`opening fake file: %s`, err)
+			}
+			defer file.Close()
+
+			if file.Class != spec.Class {
+				t.Errorf(`Class: wanted %s, got %s`, spec.Class, file.Class)
+			}
+
+			for _, c := range []struct {
+				tag  elf.DynTag
+				want []string
+			}{
+				{elf.DT_NEEDED, spec.Needed},
+				{elf.DT_RPATH, spec.RPath},
+				{elf.DT_RUNPATH, spec.RunPath},
+			} {
+				got, err := file.DynString(c.tag)
+				if err != nil {
+					t.Fatalf(`DynString(%s): unexpected error: %s`, c.tag, err)
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf(`DynString(%s): wanted %#v, got %#v`, c.tag, c.want, got)
+				}
+			}
+
+			var interp bool
+			for _, p := range file.Progs {
+				interp = interp || p.Type == elf.PT_INTERP
+			}
+			if interp != (len(spec.Interp) != 0) {
+				t.Errorf(`PT_INTERP: wanted %t, got %t`, !interp, interp)
+			}
+
+			flags, _ := file.dynValue(elf.DT_FLAGS_1)
+			if elf.DynFlag1(flags) != spec.Flags1 {
+				t.Errorf(`DT_FLAGS_1: wanted %s, got %s`, spec.Flags1, elf.DynFlag1(flags))
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package elfx
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// LdSoCache contains the libraries listed in the dynamic linker cache. It is
+// empty by default, in which case the cache isn't used for resolution. See
+// LoadLdSoCache.
+var LdSoCache Cache
+
+// Cache is the content of a dynamic linker cache (usually /etc/ld.so.cache),
+// i.e the list of libraries found by ldconfig(8) in the configured
+// directories.
+type Cache []CacheEntry
+
+// CacheEntry is a library of the dynamic linker cache.
+type CacheEntry struct {
+	// Name of the library (e.g: libc.so.6).
+	Name string
+	// Path of the library file.
+	Path string
+	// Flags of the library, indicating the type and architecture of the
+	// library.
+	Flags int32
+}
+
+// Format identifiers of the cache files. The old format is only produced by
+// very old versions of ldconfig, but the new format can be hidden after an
+// old format table for compatibility.
+const (
+	cacheMagicOld = "ld.so-1.7.0"
+	cacheMagicNew = "glibc-ld.so.cache1.1"
+)
+
+// Flags of the cache entries. The low byte is the type of library, the
+// second one the architecture. See sysdeps/generic/ldconfig.h in the glibc
+// sources.
+const (
+	cacheFlagELFLibc6 = 0x0003
+
+	cacheFlagSparcLib64   = 0x0100
+	cacheFlagX8664Lib64   = 0x0300
+	cacheFlagS390Lib64    = 0x0400
+	cacheFlagPowerPCLib64 = 0x0500
+	cacheFlagX8664LibX32  = 0x0800
+	cacheFlagARMLibHF     = 0x0900
+	cacheFlagAArch64Lib64 = 0x0a00
+	cacheFlagRISCVDouble  = 0x1000
+)
+
+// LoadLdSoCache parses the dynamic linker cache at path and sets the
+// LdSoCache variable, so the cache is looked up during resolution.
+//
+// NOTE The cache format is undocumented and only stable in practice, so this
+// is opt-in: on error, LdSoCache is left untouched.
+func LoadLdSoCache(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cache, err := ParseCache(raw)
+	if err != nil {
+		return fmt.Errorf(`parsing %q: %w`, path, err)
+	}
+
+	LdSoCache = cache
+	return nil
+}
+
+// ParseCache parses the content of a dynamic linker cache file. Both the new
+// format and the old one (with or without the new format appended) are
+// handled.
+func ParseCache(raw []byte) (Cache, error) {
+	if bytes.HasPrefix(raw, []byte(cacheMagicNew)) {
+		return parseNewCache(raw)
+	}
+
+	if !bytes.HasPrefix(raw, []byte(cacheMagicOld)) {
+		return nil, errors.New(`unknown cache format`)
+	}
+
+	// Old format: magic (padded to 12 bytes), number of entries, then
+	// entries of 3 words (flags, key, value). The strings table comes
+	// right after the entries.
+	if len(raw) < 16 {
+		return nil, errors.New(`truncated header`)
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	nlibs := int(order.Uint32(raw[12:16]))
+	end := 16 + nlibs*12
+	if nlibs < 0 || end > len(raw) {
+		return nil, errors.New(`truncated entries`)
+	}
+
+	// If the new format is present, it is aligned on the next 8 bytes
+	// boundary after the old entries, and is much more complete.
+	aligned := (end + 7) &^ 7
+	if aligned < len(raw) && bytes.HasPrefix(raw[aligned:], []byte(cacheMagicNew)) {
+		return parseNewCache(raw[aligned:])
+	}
+
+	cache := make(Cache, 0, nlibs)
+	for i := 0; i < nlibs; i++ {
+		entry := raw[16+i*12:]
+		name, err := cacheString(raw[end:], order.Uint32(entry[4:8]))
+		if err != nil {
+			return nil, fmt.Errorf(`reading entry %d: %w`, i, err)
+		}
+		path, err := cacheString(raw[end:], order.Uint32(entry[8:12]))
+		if err != nil {
+			return nil, fmt.Errorf(`reading entry %d: %w`, i, err)
+		}
+		cache = append(cache, CacheEntry{
+			Name:  name,
+			Path:  path,
+			Flags: int32(order.Uint32(entry[0:4])),
+		})
+	}
+	return cache, nil
+}
+
+// parseNewCache parses a cache in the new format, starting at the beginning of
+// raw. The strings offsets are relative to the beginning of the header.
+func parseNewCache(raw []byte) (Cache, error) {
+	// Header: magic and version (20 bytes), number of entries, size of
+	// the strings table, flags (1 byte, padded to 4), extensions offset,
+	// and 3 unused words.
+	const headerSize = 48
+	const entrySize = 24
+	if len(raw) < headerSize {
+		return nil, errors.New(`truncated header`)
+	}
+
+	// Newer versions of ldconfig indicate the endianness of the file.
+	// Older ones only generate files for the host, which are little
+	// endian in most cases.
+	var order binary.ByteOrder = binary.LittleEndian
+	if raw[28] == 3 {
+		order = binary.BigEndian
+	}
+
+	nlibs := int(order.Uint32(raw[20:24]))
+	if nlibs < 0 || headerSize+nlibs*entrySize > len(raw) {
+		return nil, errors.New(`truncated entries`)
+	}
+
+	// Entries: flags, key, value, OS version, and hardware capabilities.
+	cache := make(Cache, 0, nlibs)
+	for i := 0; i < nlibs; i++ {
+		entry := raw[headerSize+i*entrySize:]
+		name, err := cacheString(raw, order.Uint32(entry[4:8]))
+		if err != nil {
+			return nil, fmt.Errorf(`reading entry %d: %w`, i, err)
+		}
+		path, err := cacheString(raw, order.Uint32(entry[8:12]))
+		if err != nil {
+			return nil, fmt.Errorf(`reading entry %d: %w`, i, err)
+		}
+		cache = append(cache, CacheEntry{
+			Name:  name,
+			Path:  path,
+			Flags: int32(order.Uint32(entry[0:4])),
+		})
+	}
+	return cache, nil
+}
+
+// cacheString reads the null-terminated string at the given offset of raw.
+func cacheString(raw []byte, offset uint32) (string, error) {
+	if int(offset) >= len(raw) {
+		return "", fmt.Errorf(`string offset %d out of bounds`, offset)
+	}
+	end := bytes.IndexByte(raw[offset:], 0)
+	if end == -1 {
+		return "", fmt.Errorf(`unterminated string at offset %d`, offset)
+	}
+	return string(raw[offset : int(offset)+end]), nil
+}
+
+// Lookup returns the path of the first library of the cache with the given
+// name and flags, and a boolean indicating if it was found.
+func (c Cache) Lookup(name string, flags int32) (string, bool) {
+	for _, e := range c {
+		if e.Name == name && e.Flags == flags {
+			return e.Path, true
+		}
+	}
+	return "", false
+}
+
+// cacheFlags returns the flags of the cache entries that are compatible with
+// the file, and a boolean indicating if the architecture is handled.
+func (f File) cacheFlags() (int32, bool) {
+	switch f.Machine {
+	case elf.EM_X86_64:
+		if f.Class == elf.ELFCLASS32 {
+			return cacheFlagELFLibc6 | cacheFlagX8664LibX32, true
+		}
+		return cacheFlagELFLibc6 | cacheFlagX8664Lib64, true
+	case elf.EM_386:
+		return cacheFlagELFLibc6, true
+	case elf.EM_AARCH64:
+		return cacheFlagELFLibc6 | cacheFlagAArch64Lib64, true
+	case elf.EM_ARM:
+		return cacheFlagELFLibc6 | cacheFlagARMLibHF, true
+	case elf.EM_PPC64:
+		return cacheFlagELFLibc6 | cacheFlagPowerPCLib64, true
+	case elf.EM_S390:
+		return cacheFlagELFLibc6 | cacheFlagS390Lib64, true
+	case elf.EM_SPARCV9:
+		return cacheFlagELFLibc6 | cacheFlagSparcLib64, true
+	case elf.EM_RISCV:
+		return cacheFlagELFLibc6 | cacheFlagRISCVDouble, true
+	default:
+		return 0, false
+	}
+}
+
+// lookupCache returns the path of the library in the dynamic linker cache, if
+// it exists there for the file's architecture.
+func (f File) lookupCache(library string) (string, bool) {
+	if len(LdSoCache) == 0 {
+		return "", false
+	}
+
+	flags, ok := f.cacheFlags()
+	if !ok {
+		return "", false
+	}
+
+	return LdSoCache.Lookup(library, flags)
+}
@@ -0,0 +1,80 @@
+package elfx
+
+import (
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/testingx"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCache(t *testing.T) {
+	got, err := ParseCache(testingx.ReadFile(t, `ld.so.cache`))
+	if err != nil {
+		t.Fatalf(`ParseCache(%q): unexpected error: %s`, `testdata/ld.so.cache`, err)
+	}
+
+	want := Cache{
+		{Name: "library_in_cache.so", Path: "testdata/cache/library_in_cache.so", Flags: 0x0303},
+		{Name: "library_in_cache.so", Path: "testdata/cache32/library_in_cache.so", Flags: 0x0003},
+		{Name: "stale_library.so", Path: "testdata/cache/stale_library.so", Flags: 0x0303},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf(`ParseCache(%q): unexpected result`, `testdata/ld.so.cache`)
+		t.Log(cmp.Diff(got, want))
+	}
+}
+
+func TestParseCache_Invalid(t *testing.T) {
+	for n, raw := range map[string][]byte{
+		"unknown format":   []byte("not a cache"),
+		"truncated header": []byte(cacheMagicNew),
+		"truncated entries": append([]byte(cacheMagicNew),
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		),
+	} {
+		t.Run(n, func(t *testing.T) {
+			_, err := ParseCache(raw)
+			if err == nil {
+				t.Errorf(`ParseCache(%q): expected error, got nil`, raw)
+			}
+		})
+	}
+}
+
+func TestFile_ResolveImportedLibrary_Cache(t *testing.T) {
+	LibraryPathDirs = nil
+	DefaultDirs = []string{AbsT(t, "./testdata/lib")}
+
+	err := LoadLdSoCache("testdata/ld.so.cache")
+	if err != nil {
+		t.Fatalf(`LoadLdSoCache(%q): unexpected error: %s`, "testdata/ld.so.cache", err)
+	}
+	defer func() { LdSoCache = nil }()
+
+	file, err := Open("./testdata/executable")
+	if err != nil {
+		t.Fatalf(`opening executable: %s`, err)
+	}
+
+	for input, want := range map[string]struct {
+		path string
+		ok   bool
+	}{
+		"library_in_cache.so": {path: "testdata/cache/library_in_cache.so", ok: true},
+		"stale_library.so":    {path: "stale_library.so", ok: false},
+		"library_in_lib.so":   {path: AbsT(t, "./testdata/lib/library_in_lib.so"), ok: true},
+	} {
+		path, ok, err := file.ResolveImportedLibrary(input)
+		if err != nil {
+			t.Errorf(`ResolveImportedLibrary(%q): unexpected error: %s`, input, err)
+			continue
+		}
+
+		if path != want.path || ok != want.ok {
+			t.Errorf(`ResolveImportedLibrary(%q): wanted %q, %t, got %q, %t`, input, want.path, want.ok, path, ok)
+		}
+	}
+}
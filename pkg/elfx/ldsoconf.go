@@ -0,0 +1,128 @@
+package elfx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadLdSoConf parses the dynamic linker configuration file at path (usually
+// /etc/ld.so.conf) and prepends the directories it lists to DefaultDirs, so
+// the resolution matches what the dynamic linker of the host would find.
+//
+// NOTE The dynamic linker doesn't actually read this file, but the cache
+// generated from it by ldconfig(8). The cache may be outdated, but this is the
+// best we can do without parsing it.
+func LoadLdSoConf(path string) error {
+	dirs, err := parseLdSoConf(path, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	// The trusted directories are searched after the configured ones, and
+	// there is no point in searching a directory twice.
+	met := make(map[string]bool, len(dirs)+len(DefaultDirs))
+	all := make([]string, 0, len(dirs)+len(DefaultDirs))
+	for _, dir := range append(dirs, DefaultDirs...) {
+		if met[dir] {
+			continue
+		}
+		met[dir] = true
+		all = append(all, dir)
+	}
+	DefaultDirs = all
+
+	return nil
+}
+
+// parseLdSoConf returns the directories listed in the configuration file at
+// path, following the include directives. Already visited files are ignored
+// to avoid include loops.
+func parseLdSoConf(path string, visited map[string]bool) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if visited[path] {
+		return nil, nil
+	}
+	visited[path] = true
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dirs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Comments can start anywhere on the line.
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "include":
+			// Included patterns are relative to the directory of
+			// the including file.
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(path), pattern)
+				}
+
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return nil, fmt.Errorf(`expanding %q in %q: %w`, pattern, path, err)
+				}
+
+				for _, match := range matches {
+					included, err := parseLdSoConf(match, visited)
+					if err != nil {
+						return nil, fmt.Errorf(`including %q in %q: %w`, match, path, err)
+					}
+					dirs = append(dirs, included...)
+				}
+			}
+
+		case "hwcap":
+			// Hardware capabilities directives are obsolete and
+			// don't define directories.
+			continue
+
+		default:
+			// A line can list several directories, separated by
+			// spaces, tabs, commas or colons. Directories can be
+			// followed by a library type (e.g: /usr/lib=libc5),
+			// which is irrelevant here.
+			for _, dir := range strings.FieldsFunc(line, isLdSoConfSeparator) {
+				dir = strings.SplitN(dir, "=", 2)[0]
+				if len(dir) != 0 {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf(`reading %q: %w`, path, err)
+	}
+
+	return dirs, nil
+}
+
+// isLdSoConfSeparator reports whether r separates the directories of a line of
+// the dynamic linker configuration.
+func isLdSoConfSeparator(r rune) bool {
+	return r == ' ' || r == '\t' || r == ',' || r == ':'
+}
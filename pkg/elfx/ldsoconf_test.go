@@ -0,0 +1,45 @@
+package elfx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadLdSoConf(t *testing.T) {
+	DefaultDirs = []string{"/lib", "/usr/lib"}
+
+	err := LoadLdSoConf("testdata/ld.so.conf")
+	if err != nil {
+		t.Fatalf(`LoadLdSoConf(%q): unexpected error: %s`, "testdata/ld.so.conf", err)
+	}
+
+	want := []string{
+		"/opt/a/lib",
+		"/lib/x86_64-linux-gnu",
+		"/usr/lib/x86_64-linux-gnu",
+		"/opt/custom/lib",
+		"/opt/first/lib",
+		"/opt/second/lib",
+		"/opt/third/lib",
+		"/usr/local/lib",
+		"/usr/lib",
+		"/lib",
+	}
+	if !reflect.DeepEqual(DefaultDirs, want) {
+		t.Errorf(`LoadLdSoConf(%q): wanted %#v, got %#v`, "testdata/ld.so.conf", want, DefaultDirs)
+	}
+}
+
+func TestLoadLdSoConf_Missing(t *testing.T) {
+	DefaultDirs = []string{"/lib", "/usr/lib"}
+
+	err := LoadLdSoConf("testdata/missing.conf")
+	if err == nil {
+		t.Errorf(`LoadLdSoConf(%q): expected error, got nil`, "testdata/missing.conf")
+	}
+
+	want := []string{"/lib", "/usr/lib"}
+	if !reflect.DeepEqual(DefaultDirs, want) {
+		t.Errorf(`LoadLdSoConf(%q): wanted %#v, got %#v`, "testdata/missing.conf", want, DefaultDirs)
+	}
+}
@@ -0,0 +1,184 @@
+// Package k8s identifies the kubernetes pods and containers processes run in,
+// from their cgroup and the pods known to the kubelet of the node.
+package k8s
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned when the process doesn't run in a kubernetes
+// container, or the kubelet doesn't know its pod.
+var ErrNotFound = errors.New("not found")
+
+// Container of a process, as identified by its cgroup.
+type Container struct {
+	// UID of the pod.
+	PodUID string
+	// ID of the container, as given by the container runtime.
+	ID string
+}
+
+// Info about the container of a process, as known by the kubelet.
+type Info struct {
+	Namespace string
+	Pod       string
+	Container string
+	Node      string
+}
+
+var (
+	// podRegexp matches the pod part of a cgroup path, either
+	// "pod<uid>" with the cgroupfs driver, or
+	// "kubepods-<qos>-pod<uid>.slice" with the systemd driver, in which
+	// case the dashes of the UID are replaced by underscores.
+	podRegexp = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	// containerRegexp matches the container part of a cgroup path, either
+	// its ID with the cgroupfs driver, or "<runtime>-<id>.scope" with the
+	// systemd driver.
+	containerRegexp = regexp.MustCompile(`(?:^|-)([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// ReadCgroup identifies the container of the process from /proc.
+func ReadCgroup(pid int) (Container, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return Container{}, err
+	}
+	defer f.Close()
+
+	return ParseCgroup(f)
+}
+
+// ParseCgroup identifies the container from the content of a cgroup file
+// (see cgroups(7)), made of lines of the form
+// "hierarchy-ID:controller-list:cgroup-path".
+func ParseCgroup(r io.Reader) (Container, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 || !strings.Contains(fields[2], "kubepods") {
+			continue
+		}
+
+		var c Container
+		for _, segment := range strings.Split(fields[2], "/") {
+			if match := podRegexp.FindStringSubmatch(segment); match != nil {
+				c.PodUID = strings.Replace(match[1], "_", "-", -1)
+			} else if match := containerRegexp.FindStringSubmatch(segment); match != nil {
+				c.ID = match[1]
+			}
+		}
+		if len(c.PodUID) != 0 {
+			return c, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Container{}, err
+	}
+	return Container{}, ErrNotFound
+}
+
+// Kubelet is a client of the API of the kubelet of a node.
+type Kubelet struct {
+	// URL of the kubelet (e.g: https://localhost:10250).
+	URL string
+	// Token to authenticate with, if not empty.
+	Token string
+	// HTTP client used for the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+}
+
+// podList is the subset of the kubernetes PodList used to identify the
+// containers.
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			UID       string `json:"uid"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			ContainerStatuses          []containerStatus `json:"containerStatuses"`
+			InitContainerStatuses      []containerStatus `json:"initContainerStatuses"`
+			EphemeralContainerStatuses []containerStatus `json:"ephemeralContainerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type containerStatus struct {
+	Name string `json:"name"`
+	// ContainerID is prefixed by the runtime (e.g:
+	// containerd://<id>).
+	ContainerID string `json:"containerID"`
+}
+
+func (k Kubelet) http() *http.Client {
+	if k.HTTP == nil {
+		return http.DefaultClient
+	}
+	return k.HTTP
+}
+
+// Lookup the pod of the container in the pods running on the node. The name
+// of the container is left empty if the kubelet doesn't know its ID yet.
+func (k Kubelet) Lookup(c Container) (Info, error) {
+	req, err := http.NewRequest(http.MethodGet, k.URL+"/pods", nil)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(k.Token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	res, err := k.http().Do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	var pods podList
+	err = json.NewDecoder(res.Body).Decode(&pods)
+	if err != nil {
+		return Info{}, fmt.Errorf("reading response: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Metadata.UID != c.PodUID {
+			continue
+		}
+
+		info := Info{
+			Namespace: pod.Metadata.Namespace,
+			Pod:       pod.Metadata.Name,
+			Node:      pod.Spec.NodeName,
+		}
+		for _, statuses := range [][]containerStatus{
+			pod.Status.ContainerStatuses,
+			pod.Status.InitContainerStatuses,
+			pod.Status.EphemeralContainerStatuses,
+		} {
+			for _, status := range statuses {
+				if len(c.ID) != 0 && strings.HasSuffix(status.ContainerID, "://"+c.ID) {
+					info.Container = status.Name
+				}
+			}
+		}
+		return info, nil
+	}
+	return Info{}, ErrNotFound
+}
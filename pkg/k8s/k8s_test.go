@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const containerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseCgroup(t *testing.T) {
+	want := Container{PodUID: "5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c", ID: containerID}
+
+	for n, c := range map[string]string{
+		"cgroupfs v1": "12:memory:/kubepods/burstable/pod5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c/" + containerID + "\n" +
+			"1:name=systemd:/kubepods/burstable/pod5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c/" + containerID + "\n",
+		"systemd v2": "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod5f2b6c1e_8a3d_4e7f_9b0c_1d2e3f4a5b6c.slice/cri-containerd-" + containerID + ".scope\n",
+		"guaranteed": "0::/kubepods.slice/kubepods-pod5f2b6c1e_8a3d_4e7f_9b0c_1d2e3f4a5b6c.slice/crio-" + containerID + ".scope\n",
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := ParseCgroup(strings.NewReader(c))
+			if err != nil {
+				t.Fatalf(`ParseCgroup(): unexpected error: %s`, err)
+			}
+			if got != want {
+				t.Errorf(`ParseCgroup(): wanted %#v, got %#v`, want, got)
+			}
+		})
+	}
+
+	_, err := ParseCgroup(strings.NewReader("0::/user.slice/user-1000.slice/session-2.scope\n"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf(`ParseCgroup(): wanted ErrNotFound, got %v`, err)
+	}
+}
+
+func TestKubelet_Lookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"items": [
+			{"metadata": {"name": "other", "namespace": "default", "uid": "11111111-2222-3333-4444-555555555555"}},
+			{
+				"metadata": {"name": "api-7d9f", "namespace": "shop", "uid": "5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c"},
+				"spec": {"nodeName": "node-3"},
+				"status": {"containerStatuses": [
+					{"name": "sidecar", "containerID": "containerd://ffff"},
+					{"name": "api", "containerID": "containerd://` + containerID + `"}
+				]}
+			}
+		]}`))
+	}))
+	defer server.Close()
+
+	kubelet := Kubelet{URL: server.URL, Token: "token"}
+
+	got, err := kubelet.Lookup(Container{PodUID: "5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c", ID: containerID})
+	if err != nil {
+		t.Fatalf(`Lookup(): unexpected error: %s`, err)
+	}
+	want := Info{Namespace: "shop", Pod: "api-7d9f", Container: "api", Node: "node-3"}
+	if got != want {
+		t.Errorf(`Lookup(): wanted %#v, got %#v`, want, got)
+	}
+
+	_, err = kubelet.Lookup(Container{PodUID: "66666666-7777-8888-9999-000000000000"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf(`Lookup(): wanted ErrNotFound, got %v`, err)
+	}
+
+	_, err = Kubelet{URL: server.URL}.Lookup(Container{PodUID: "5f2b6c1e-8a3d-4e7f-9b0c-1d2e3f4a5b6c"})
+	if err == nil {
+		t.Errorf(`Lookup(): wanted an error without token`)
+	}
+}
@@ -0,0 +1,110 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// message received by the fake server.
+type message struct {
+	subject string
+	data    string
+}
+
+// serve a fake NATS server on l, sending the received messages on the
+// channel. The connections are closed after maxMessages messages, to test the
+// reconnection, unless it is zero.
+func serve(l net.Listener, messages chan<- message, maxMessages int) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "INFO {\"max_payload\":64}\r\n")
+
+			received := 0
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				switch fields[0] {
+				case "CONNECT":
+					if !strings.Contains(line, `"auth_token":"token"`) {
+						fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+						return
+					}
+				case "PING":
+					fmt.Fprint(conn, "PONG\r\n")
+				case "PUB":
+					size, _ := strconv.Atoi(fields[2])
+					data := make([]byte, size+2)
+					_, err := io.ReadFull(r, data)
+					if err != nil {
+						return
+					}
+					messages <- message{subject: fields[1], data: string(data[:size])}
+					received++
+					if received == maxMessages {
+						// Answer the PING before closing.
+						r.ReadString('\n')
+						fmt.Fprint(conn, "PONG\r\n")
+						return
+					}
+				}
+			}
+		}()
+	}
+}
+
+func TestClient_Publish(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf(`listening: %s`, err)
+	}
+	defer l.Close()
+
+	messages := make(chan message, 10)
+	go serve(l, messages, 1)
+
+	c := &Client{URL: "nats://token@" + l.Addr().String(), Timeout: time.Second}
+	defer c.Close()
+
+	// The server closes the connection after each message, so the second
+	// one is sent on a new connection.
+	for _, data := range []string{"first", "second"} {
+		err = c.Publish("cores", []byte(data))
+		if err != nil {
+			t.Fatalf(`Publish(): unexpected error: %s`, err)
+		}
+		got := <-messages
+		if got != (message{subject: "cores", data: data}) {
+			t.Errorf(`Publish(): wanted message %q on cores, got %#v`, data, got)
+		}
+	}
+
+	err = c.Publish("cores", []byte(strings.Repeat("x", 65)))
+	if err == nil {
+		t.Errorf(`Publish(): wanted an error for a message above the maximum payload`)
+	}
+
+	err = c.Publish("invalid subject", []byte("data"))
+	if err == nil {
+		t.Errorf(`Publish(): wanted an error for an invalid subject`)
+	}
+
+	err = (&Client{URL: "nats://" + l.Addr().String(), Timeout: time.Second}).Publish("cores", []byte("data"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf(`Publish(): wanted an authorization error, got %v`, err)
+	}
+}
@@ -0,0 +1,309 @@
+// Package protocol implements the encoding of the body of the requests sent to
+// the index endpoint of the server.
+//
+// The body is a sequence of gzip members: the first one is the JSON-encoded
+// IndexRequest, followed by one member for the coredump, then if
+// IncludeExecutable is set one for the executable and one for each of the
+// Links that are Sent, in order. Using a member per file allows to stream the
+// files one after the other without knowing their size in advance.
+//
+// The Decoder also reads uncompressed bodies, for the clients that can't
+// compress: the JSON-encoded IndexRequest on a single line, followed by the
+// coredump up to the end of the body. The executable can't be sent that way,
+// as nothing delimits the coredump. The compression is detected from the first
+// bytes of the body, regardless of its Content-Encoding.
+//
+// The header carries the version of the protocol the request is encoded with,
+// so forwarders and servers of different versions can interoperate:
+//
+//   - the version is incremented whenever the encoding or the sequence of
+//     members changes in a way older servers can't read;
+//   - servers handle every version up to theirs, and reject the newer ones
+//     with a 400 status and the unsupported_version error code, instead of
+//     misreading them;
+//   - requests without version come from forwarders predating it, and are
+//     encoded as version 1.
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+// Versions of the protocol handled by this package: Version is the one used
+// to encode, and every version from MinVersion to Version can be decoded.
+const (
+	MinVersion = 0
+	Version    = 1
+)
+
+// Compression of the members of the body written by the Encoder, as
+// advertised by the servers.
+const Compression = "gzip"
+
+// Uncompressed is advertised by the servers reading uncompressed bodies.
+const Uncompressed = "none"
+
+// Magic numbers of the compression formats.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ErrUnsupportedVersion is returned when decoding a request encoded with a
+// version of the protocol newer than Version.
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// ErrUnsupportedCompression is returned when decoding a body compressed with a
+// known format other than gzip.
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+// Supported reports whether a request encoded with the given version of the
+// protocol can be decoded.
+func Supported(version int) bool {
+	return version >= MinVersion && version <= Version
+}
+
+// Encode writes a whole request body. The executable is only written if
+// IncludeExecutable is set, in which case links must contain the readers of
+// the Links that are Sent, in order.
+func Encode(w io.Writer, req IndexRequest, core, executable io.Reader, links []io.Reader) error {
+	enc := NewEncoder(w)
+
+	err := enc.WriteHeader(req)
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.WriteMember(core)
+	if err != nil {
+		return wrap(err, "writing core")
+	}
+
+	if !req.IncludeExecutable {
+		return nil
+	}
+
+	_, err = enc.WriteMember(executable)
+	if err != nil {
+		return wrap(err, "writing executable")
+	}
+
+	for i, link := range links {
+		_, err = enc.WriteMember(link)
+		if err != nil {
+			return wrap(err, "writing link %d", i)
+		}
+	}
+
+	return nil
+}
+
+// Encoder writes the members of a request body one after the other, so the
+// files can be opened only when needed.
+type Encoder struct {
+	w  io.Writer
+	gz *gzip.Writer
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// WriteHeader writes the header member. It must be called first. The
+// ProtocolVersion is set to the version implemented by the encoder.
+func (e *Encoder) WriteHeader(req IndexRequest) error {
+	req.ProtocolVersion = Version
+	return e.write(func(w io.Writer) (err error) {
+		err = json.NewEncoder(w).Encode(req)
+		if err != nil {
+			return wrap(err, "writing header")
+		}
+		return nil
+	})
+}
+
+// WriteMember writes the content of r as the next member, and returns the
+// number of bytes read from r.
+//
+// NOTE On error, the member is left unfinished on purpose, so the reader
+// doesn't mistake it for a complete file.
+func (e *Encoder) WriteMember(r io.Reader) (written int64, err error) {
+	err = e.write(func(w io.Writer) error {
+		written, err = io.Copy(w, r)
+		return err
+	})
+	return written, err
+}
+
+func (e *Encoder) write(fn func(io.Writer) error) error {
+	if e.gz == nil {
+		e.gz = gzip.NewWriter(e.w)
+	} else {
+		e.gz.Reset(e.w)
+	}
+
+	err := fn(e.gz)
+	if err != nil {
+		return err
+	}
+
+	// Closing the writer flushes the member, without closing the
+	// underlying writer.
+	err = e.gz.Close()
+	if err != nil {
+		return wrap(err, "closing member")
+	}
+
+	return nil
+}
+
+// Decoder reads the members of a request body one after the other.
+type Decoder struct {
+	r  *bufio.Reader
+	gz *gzip.Reader
+	// raw indicates that the body isn't compressed, in which case members
+	// counts the members read.
+	raw     bool
+	members int
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// NewDecoderSize returns a decoder reading from r with a buffer of the given
+// size, the default one if it isn't positive. Large buffers reduce the number
+// of reads of the large bodies.
+func NewDecoderSize(r io.Reader, size int) *Decoder {
+	if size <= 0 {
+		return NewDecoder(r)
+	}
+	return &Decoder{r: bufio.NewReaderSize(r, size)}
+}
+
+// ReadHeader reads the header member. It must be called first. It returns
+// ErrUnsupportedVersion if the request is encoded with a newer version of the
+// protocol, along with the header.
+func (d *Decoder) ReadHeader() (req IndexRequest, err error) {
+	member, err := d.NextMember()
+	if err != nil {
+		return req, err
+	}
+
+	err = json.NewDecoder(member).Decode(&req)
+	if err != nil && d.raw {
+		return req, wrap(err, "parsing header of uncompressed body")
+	}
+	if err != nil {
+		return req, wrap(err, "parsing header")
+	}
+
+	if d.raw && req.IncludeExecutable {
+		return req, errors.New("uncompressed bodies can't include the executable")
+	}
+
+	if !Supported(req.ProtocolVersion) {
+		return req, fmt.Errorf(`%w %d, the latest supported is %d`, ErrUnsupportedVersion, req.ProtocolVersion, Version)
+	}
+
+	return req, nil
+}
+
+// NextMember returns a reader over the next member. The rest of the previous
+// member is skipped. It returns io.EOF if there is no more members.
+func (d *Decoder) NextMember() (io.Reader, error) {
+	if d.gz == nil && !d.raw {
+		err := d.detect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.raw {
+		return d.nextRawMember()
+	}
+
+	var err error
+	if d.gz == nil {
+		d.gz, err = gzip.NewReader(d.r)
+	} else {
+		_, err = io.Copy(ioutil.Discard, d.gz)
+		if err != nil {
+			return nil, wrap(err, "skipping member")
+		}
+		err = d.gz.Reset(d.r)
+	}
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, wrap(err, "preparing gzip reader")
+	}
+
+	// Each member is read separately, otherwise the reader would go on
+	// with the following ones.
+	d.gz.Multistream(false)
+	return d.gz, nil
+}
+
+// detect the compression of the body from its first bytes. The bodies starting
+// with an unknown magic number are assumed to be uncompressed.
+func (d *Decoder) detect() error {
+	magic, err := d.r.Peek(len(zstdMagic))
+	if len(magic) == 0 && err == io.EOF {
+		return err
+	}
+	if len(magic) == 0 && err != nil {
+		return wrap(err, "reading body")
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+	case bytes.HasPrefix(magic, zstdMagic):
+		return fmt.Errorf(`%w zstd`, ErrUnsupportedCompression)
+	default:
+		d.raw = true
+	}
+	return nil
+}
+
+// nextRawMember returns the next member of an uncompressed body: its first
+// line, then the rest of it.
+func (d *Decoder) nextRawMember() (io.Reader, error) {
+	d.members++
+	switch d.members {
+	case 1:
+		line, err := d.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, wrap(err, "reading header")
+		}
+		return bytes.NewReader(line), nil
+	case 2:
+		return d.r, nil
+	default:
+		return nil, io.EOF
+	}
+}
+
+// Close the decoder, without closing the underlying reader.
+func (d *Decoder) Close() error {
+	if d.gz == nil {
+		return nil
+	}
+	return d.gz.Close()
+}
+
+// wrap an error using the provided message and arguments.
+func wrap(err error, msg string, args ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(msg, args...), err)
+}
@@ -0,0 +1,218 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/elwinar/rcoredump/pkg/rcoredump"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for n, c := range map[string]struct {
+		req        IndexRequest
+		links      []string
+		bufferSize int
+		want       []string
+	}{
+		"core only": {
+			req:  IndexRequest{Hostname: "localhost"},
+			want: []string{"core"},
+		},
+		"with executable": {
+			req: IndexRequest{
+				Hostname:          "localhost",
+				IncludeExecutable: true,
+				Links:             []Link{{Name: "libc.so.6", Path: "/lib/libc.so.6", Found: true}},
+			},
+			links: []string{"libc"},
+			want:  []string{"core", "executable", "libc"},
+		},
+		"small buffer": {
+			req:        IndexRequest{Hostname: "localhost", IncludeExecutable: true},
+			bufferSize: 16,
+			want:       []string{"core", "executable"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var links []io.Reader
+			for _, l := range c.links {
+				links = append(links, strings.NewReader(l))
+			}
+
+			var buf bytes.Buffer
+			err := Encode(&buf, c.req, strings.NewReader("core"), strings.NewReader("executable"), links)
+			if err != nil {
+				t.Fatalf(`Encode(): unexpected error: %s`, err)
+			}
+
+			dec := NewDecoderSize(&buf, c.bufferSize)
+			defer dec.Close()
+
+			req, err := dec.ReadHeader()
+			if err != nil {
+				t.Fatalf(`ReadHeader(): unexpected error: %s`, err)
+			}
+			c.req.ProtocolVersion = Version
+			if !reflect.DeepEqual(req, c.req) {
+				t.Errorf(`ReadHeader(): wanted %#v, got %#v`, c.req, req)
+			}
+
+			var got []string
+			for {
+				member, err := dec.NextMember()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf(`NextMember(): unexpected error: %s`, err)
+				}
+				raw, err := ioutil.ReadAll(member)
+				if err != nil {
+					t.Fatalf(`reading member: %s`, err)
+				}
+				got = append(got, string(raw))
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf(`NextMember(): wanted %#v, got %#v`, c.want, got)
+			}
+		})
+	}
+}
+
+func TestDecoder_NextMember_Skip(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, IndexRequest{IncludeExecutable: true}, strings.NewReader("core"), strings.NewReader("executable"), nil)
+	if err != nil {
+		t.Fatalf(`Encode(): unexpected error: %s`, err)
+	}
+
+	// The core member isn't read, it must be skipped when asking for the
+	// next one.
+	dec := NewDecoder(&buf)
+	defer dec.Close()
+	_, err = dec.ReadHeader()
+	if err != nil {
+		t.Fatalf(`ReadHeader(): unexpected error: %s`, err)
+	}
+	_, err = dec.NextMember()
+	if err != nil {
+		t.Fatalf(`NextMember(): unexpected error: %s`, err)
+	}
+	member, err := dec.NextMember()
+	if err != nil {
+		t.Fatalf(`NextMember(): unexpected error: %s`, err)
+	}
+	raw, _ := ioutil.ReadAll(member)
+	if string(raw) != "executable" {
+		t.Errorf(`NextMember(): wanted %q, got %q`, "executable", raw)
+	}
+}
+
+func TestDecoder_ReadHeader_Version(t *testing.T) {
+	for n, c := range map[string]struct {
+		header string
+		err    error
+	}{
+		"unversioned": {header: `{"hostname":"localhost"}`, err: nil},
+		"current":     {header: fmt.Sprintf(`{"protocol_version":%d}`, Version), err: nil},
+		"newer":       {header: fmt.Sprintf(`{"protocol_version":%d}`, Version+1), err: ErrUnsupportedVersion},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write([]byte(c.header))
+			w.Close()
+
+			dec := NewDecoder(&buf)
+			defer dec.Close()
+			_, err := dec.ReadHeader()
+			if !errors.Is(err, c.err) {
+				t.Errorf(`ReadHeader(): wanted error %v, got %v`, c.err, err)
+			}
+		})
+	}
+}
+
+func TestDecoder_Compression(t *testing.T) {
+	var gzipped bytes.Buffer
+	err := Encode(&gzipped, IndexRequest{Hostname: "localhost"}, strings.NewReader("core"), nil, nil)
+	if err != nil {
+		t.Fatalf(`Encode(): unexpected error: %s`, err)
+	}
+
+	for n, c := range map[string]struct {
+		body     string
+		hostname string
+		core     string
+		err      bool
+	}{
+		"gzip": {
+			body:     gzipped.String(),
+			hostname: "localhost",
+			core:     "core",
+		},
+		"raw": {
+			body:     "{\"hostname\":\"localhost\"}\ncore\nwith lines",
+			hostname: "localhost",
+			core:     "core\nwith lines",
+		},
+		"raw with executable": {
+			body: "{\"hostname\":\"localhost\",\"include_executable\":true}\ncore",
+			err:  true,
+		},
+		"zstd": {
+			body: "\x28\xb5\x2f\xfd\x00\x58",
+			err:  true,
+		},
+		"unknown": {
+			body: "\x00\x01garbage",
+			err:  true,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(c.body))
+			defer dec.Close()
+
+			req, err := dec.ReadHeader()
+			if c.err {
+				if err == nil {
+					t.Errorf(`ReadHeader(): wanted an error`)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf(`ReadHeader(): unexpected error: %s`, err)
+			}
+			if req.Hostname != c.hostname {
+				t.Errorf(`ReadHeader(): wanted hostname %q, got %q`, c.hostname, req.Hostname)
+			}
+
+			member, err := dec.NextMember()
+			if err != nil {
+				t.Fatalf(`NextMember(): unexpected error: %s`, err)
+			}
+			raw, _ := ioutil.ReadAll(member)
+			if string(raw) != c.core {
+				t.Errorf(`NextMember(): wanted %q, got %q`, c.core, raw)
+			}
+
+			_, err = dec.NextMember()
+			if err != io.EOF {
+				t.Errorf(`NextMember(): wanted io.EOF after the core, got %v`, err)
+			}
+		})
+	}
+
+	// The zstd bodies are recognized, rather than misread as uncompressed.
+	_, err = NewDecoder(strings.NewReader("\x28\xb5\x2f\xfd\x00\x58")).ReadHeader()
+	if !errors.Is(err, ErrUnsupportedCompression) {
+		t.Errorf(`ReadHeader(): wanted ErrUnsupportedCompression, got %v`, err)
+	}
+}
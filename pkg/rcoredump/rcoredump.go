@@ -1,11 +1,22 @@
 package rcoredump
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"time"
 )
 
+// SizeHeader is the HTTP header used by the forwarder to tell the size of the
+// files sent to the index endpoint, before compression. The body being
+// streamed, the Content-Length isn't known.
+const SizeHeader = "X-Rcoredump-Size"
+
 // IndexRequest is the struct expected by the index endpoint.
 type IndexRequest struct {
+	// Version of the protocol used to encode the request, which determines
+	// the files sent after the header. See the protocol package.
+	ProtocolVersion int `json:"protocol_version,omitempty"`
 	// Date the core dump was generated.
 	DumpedAt time.Time `json:"dumped_at"`
 	// Hostname of the origin host.
@@ -14,48 +25,418 @@ type IndexRequest struct {
 	IncludeExecutable bool `json:"include_executable,omitempty"`
 	// Hash of the executable that generated the core dump.
 	ExecutableHash string `json:"executable_hash,omitempty"`
+	// Build-id of the executable, if any, which allows the server to fetch
+	// it from debuginfod instead of receiving it.
+	ExecutableBuildID string `json:"executable_build_id,omitempty"`
 	// Path to the executable on the origin host.
 	ExecutablePath string `json:"executable_path"`
+	// Format of the executable, as detected by the forwarder. Only ELF
+	// executables can be analyzed.
+	ExecutableFormat string `json:"executable_format,omitempty"`
 	// Metadata set by the forwarder configuration.
 	Metadata map[string]string `json:"metadata"`
 	// Version of the forwarder that sent the coredump.
 	ForwarderVersion string `json:"forwarder_version"`
+	// Shared libraries the executable depends on. If the executable is
+	// included, the libraries found are sent after it, in order.
+	Links []Link `json:"links,omitempty"`
+	// LinksTruncated is set if the resolution of the libraries was stopped
+	// before the end, in which case some of them are missing from Links.
+	LinksTruncated bool `json:"links_truncated,omitempty"`
+	// Size of the core dump sent, if known before sending it, so the
+	// server can tell a truncated upload from a small core dump.
+	ExpectedCoreSize int64 `json:"expected_core_size,omitempty"`
+	// Format of the file sent in place of the core dump, empty for a core
+	// dump. See the CoreFormat constants.
+	Format string `json:"format,omitempty"`
+}
+
+// Link is a shared library an executable depends on, as resolved on the
+// origin host.
+type Link struct {
+	// Name of the library, as required by the executable or one of its
+	// libraries.
+	Name string `json:"name"`
+	// Path of the library on the origin host, with every symbolic link
+	// resolved.
+	Path string `json:"path"`
+	// Paths leading to the library through symbolic links, in the order
+	// they were followed.
+	Symlinks []string `json:"symlinks,omitempty"`
+	// Was the library found on the origin host?
+	Found bool `json:"found"`
+	// Error encountered while resolving the library, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Sent reports whether the library's file is sent alongside the executable.
+func (l Link) Sent() bool {
+	return l.Found && len(l.Error) == 0
 }
 
 // SearchResult as returned by the server.
 type SearchResult struct {
-	Results []Coredump `json:"results"`
-	Total   uint64     `json:"total"`
+	Results []Hit  `json:"results"`
+	Total   uint64 `json:"total"`
+	// Size of the page actually applied, which can be lower than the
+	// requested one.
+	Size int `json:"size"`
+}
+
+// Hit is a coredump matching a search.
+type Hit struct {
+	Coredump
+	// Fragments of the fields matching the query, by field, with the
+	// matches enclosed in <mark> tags. Only filled if requested.
+	Highlights map[string][]string `json:"highlights,omitempty"`
 }
 
 // Coredump as indexed by the server.
 type Coredump struct {
 	// Those fields are filled by indexing.
-	DumpedAt         time.Time         `json:"dumped_at"`
-	Executable       string            `json:"executable"`
-	ExecutableHash   string            `json:"executable_hash"`
-	ExecutablePath   string            `json:"executable_path"`
-	ExecutableSize   int64             `json:"executable_size"`
-	ForwarderVersion string            `json:"forwarder_version"`
-	Hostname         string            `json:"hostname"`
-	IndexerVersion   string            `json:"indexer_version"`
-	Metadata         map[string]string `json:"metadata"`
-	Size             int64             `json:"size"`
-	UID              string            `json:"uid"`
+	DumpedAt          time.Time         `json:"dumped_at"`
+	Executable        string            `json:"executable"`
+	ExecutableBuildID string            `json:"executable_build_id,omitempty"`
+	ExecutableHash    string            `json:"executable_hash"`
+	ExecutablePath    string            `json:"executable_path"`
+	ExecutableSize    int64             `json:"executable_size"`
+	ForwarderVersion  string            `json:"forwarder_version"`
+	Hostname          string            `json:"hostname"`
+	IndexerVersion    string            `json:"indexer_version"`
+	LinksTruncated    bool              `json:"links_truncated,omitempty"`
+	Metadata          map[string]string `json:"metadata"`
+	RejectedMetadata  string            `json:"rejected_metadata,omitempty"`
+	Size              int64             `json:"size"`
+	UID               string            `json:"uid"`
+	// Links are the shared libraries of the executable, as resolved by
+	// the forwarder when it sent the executable, including the ones that
+	// weren't found or failed to be resolved. They are stored, but not
+	// indexed.
+	Links []Link `json:"links,omitempty"`
+	// LinksComplete indicates that the forwarder sent every shared library
+	// of the executable, i.e they were all resolved without error and the
+	// resolution wasn't truncated. It isn't set if the executable wasn't
+	// sent along the core.
+	LinksComplete bool `json:"links_complete"`
+	// Format of the file received in place of the core dump, empty for a
+	// core dump. See the CoreFormat constants.
+	Format string `json:"format,omitempty"`
 
 	// Those fields are filled by analysis.
-	Analyzed   bool      `json:"analyzed"`
-	AnalyzedAt time.Time `json:"analyzed_at"`
-	Lang       string    `json:"lang"`
-	Trace      string    `json:"trace"`
+	ABI              string            `json:"abi,omitempty"`
+	Analyzed         bool              `json:"analyzed"`
+	AnalyzedAt       time.Time         `json:"analyzed_at"`
+	AnalysisError    string            `json:"analysis_error,omitempty"`
+	AnalysisSkipped  bool              `json:"analysis_skipped,omitempty"`
+	AnalyzerCPU      float64           `json:"analyzer_cpu,omitempty"`
+	AnalyzerMaxRSS   int64             `json:"analyzer_max_rss,omitempty"`
+	Args             []string          `json:"args,omitempty"`
+	Command          string            `json:"command,omitempty"`
+	Compilers        []string          `json:"compilers,omitempty"`
+	ExecutableFormat string            `json:"executable_format"`
+	ExecutableType   string            `json:"executable_type"`
+	Functions        []string          `json:"functions,omitempty"`
+	GoModule         string            `json:"go_module,omitempty"`
+	GoModuleVersion  string            `json:"go_module_version,omitempty"`
+	GoVersion        string            `json:"go_version,omitempty"`
+	Lang             string            `json:"lang"`
+	MappedFiles      []string          `json:"mapped_files,omitempty"`
+	MissingLibraries []string          `json:"missing_libraries,omitempty"`
+	Registers        map[string]uint64 `json:"registers,omitempty"`
+	Signal           int               `json:"signal,omitempty"`
+	SignalName       string            `json:"signal_name,omitempty"`
+	SkipReason       string            `json:"skip_reason,omitempty"`
+	Symbols          []string          `json:"symbols,omitempty"`
+	Trace            string            `json:"trace,omitempty"`
+	// TraceTruncated indicates that the trace is only an excerpt, the
+	// full trace being available at /cores/:uid/trace.
+	TraceTruncated bool `json:"trace_truncated,omitempty"`
+	// PartiallyAnalyzed indicates that the analysis failed, and only the
+	// notes of the core were read. See AnalysisError.
+	PartiallyAnalyzed bool `json:"partially_analyzed,omitempty"`
+	// AnalysisPath tells how the core was analyzed. See the AnalysisPath
+	// constants.
+	AnalysisPath string `json:"analysis_path,omitempty"`
+	// Those fields are read from the sanitizer reports. See the sanitizer
+	// package.
+	Sanitizer        string `json:"sanitizer,omitempty"`
+	SanitizerError   string `json:"sanitizer_error,omitempty"`
+	SanitizerAccess  string `json:"sanitizer_access,omitempty"`
+	SanitizerSummary string `json:"sanitizer_summary,omitempty"`
+
+	// Those fields are filled by the cleanup, when the core is kept during
+	// the deletion grace period.
+	Deleted   bool      `json:"deleted"`
+	DeletedAt time.Time `json:"deleted_at"`
+
+	// Events are the steps of the lifecycle of the core in the server, in
+	// order. They are stored, but only their names are indexed, as the
+	// event field (e.g: -event:analysis_finished).
+	Events []Event `json:"events,omitempty"`
+}
+
+// Event is a step of the lifecycle of a coredump in the server, recorded so
+// it is obvious where a coredump got stuck. See the Event constants.
+type Event struct {
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+}
+
+// VersionInfo as returned by the server, for the forwarder to check it is
+// compatible before sending a coredump.
+type VersionInfo struct {
+	// Version of the server.
+	Version string `json:"version"`
+	// Range of the protocol versions the server can read.
+	MinProtocolVersion int `json:"min_protocol_version"`
+	MaxProtocolVersion int `json:"max_protocol_version"`
+}
+
+// Capabilities of the server, for the forwarder to adapt what it sends.
+type Capabilities struct {
+	VersionInfo
+	// Compression algorithms the server can read the requests with.
+	Compression []string `json:"compression"`
+	// Debuginfod reports whether the server can fetch the executables
+	// from debuginfod by build-id, in which case they don't need to be
+	// sent.
+	Debuginfod bool `json:"debuginfod"`
+	// ReadOnly reports whether the server refuses the coredumps.
+	ReadOnly bool `json:"read_only"`
+	// Maximum sizes of the coredumps and executables the server accepts,
+	// in bytes. Zero means no limit.
+	MaxCoreSize       int64 `json:"max_core_size"`
+	MaxExecutableSize int64 `json:"max_executable_size"`
+	// MinForwarderVersion is the oldest version of the forwarder the
+	// server accepts the coredumps of, empty if there is none.
+	MinForwarderVersion string `json:"min_forwarder_version,omitempty"`
+	// SanitizerReports reports whether the server reads the sanitizer
+	// reports sent in place of the core dumps.
+	SanitizerReports bool `json:"sanitizer_reports"`
+}
+
+// AuditEntry is an action recorded in the audit log of the server.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Action done (e.g: delete, analyze).
+	Action string `json:"action"`
+	// Actor of the action, either the user making the request or the
+	// server itself.
+	Actor string `json:"actor"`
+	// Target of the action, usually the UID of a core.
+	Target string `json:"target,omitempty"`
+	// Detail of the action, like the reason of a cleanup or the path of
+	// an uploaded file.
+	Detail string `json:"detail,omitempty"`
+}
+
+// SavedSearch is a named query stored by the server, to be run again by the
+// users.
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Default sort of the results, see the sort and order parameters of
+	// the search.
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+	// UpdatedAt is the last time the search was saved.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AlertRule fires an alert when the number of coredumps matching the query
+// over the window reaches the threshold.
+type AlertRule struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Threshold of the number of coredumps, over the sliding window of
+	// time (e.g: 5m).
+	Threshold uint64 `json:"threshold"`
+	Window    string `json:"window"`
+	// Cooldown is the minimum duration between two alerts of the rule
+	// while the threshold is still reached (e.g: 1h).
+	Cooldown string `json:"cooldown"`
+	// Webhook is the URL the alerts are sent to.
+	Webhook string `json:"webhook"`
+}
+
+// AlertState is the state of an alerting rule, as of its last evaluation.
+type AlertState struct {
+	AlertRule
+	// Count of the coredumps over the window, and whether it reaches the
+	// threshold.
+	Count  uint64 `json:"count"`
+	Firing bool   `json:"firing"`
+	// EvaluatedAt and FiredAt are the times of the last evaluation, and of
+	// the last alert sent.
+	EvaluatedAt time.Time `json:"evaluated_at"`
+	FiredAt     time.Time `json:"fired_at"`
+	// Error of the last evaluation or alert, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Alert sent to the webhook of an alerting rule.
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Query     string    `json:"query"`
+	Threshold uint64    `json:"threshold"`
+	Window    string    `json:"window"`
+	Count     uint64    `json:"count"`
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+// Histogram of the coredumps over time.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	// Series of the most frequent values of the field the coredumps are
+	// grouped by, if any.
+	Series []HistogramSeries `json:"series,omitempty"`
+}
+
+// HistogramBucket is the number of coredumps dumped in an interval of time.
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count uint64    `json:"count"`
+}
+
+// HistogramSeries is the histogram of the coredumps with a given value of a
+// field.
+type HistogramSeries struct {
+	Term    string            `json:"term"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// ArtifactInfo describes an artifact of the analysis of a coredump, i.e the
+// output of debugger commands configured on the server.
+type ArtifactInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
 }
 
 // Error type for API return values.
 type Error struct {
+	// Code identifying the kind of error, to be checked by clients. See
+	// the ErrCode constants.
+	Code string `json:"code"`
+	// Message describing the error, for humans.
 	Err string `json:"error"`
 }
 
+// Codes of the API errors. Those are stable, unlike the messages.
+const (
+	// The request is invalid, and shouldn't be retried as is.
+	ErrCodeInvalidRequest = "invalid_request"
+	// The endpoint or the resource doesn't exist.
+	ErrCodeNotFound = "not_found"
+	// The method isn't allowed on the endpoint, either at all or because
+	// the server is read-only.
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeReadOnly         = "read_only"
+	// The feature isn't configured on the server.
+	ErrCodeNotConfigured = "not_configured"
+	// The request lacks valid credentials for the endpoint.
+	ErrCodeUnauthorized = "unauthorized"
+	// The request is encoded with a version of the protocol the server
+	// doesn't handle.
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	// The forwarder is older than the minimum version the server
+	// accepts, and must be upgraded.
+	ErrCodeOutdatedForwarder = "outdated_forwarder"
+	// The server has no space left to store the request's files.
+	ErrCodeStorageFull = "storage_full"
+	// The coredump or the executable is larger than the server accepts.
+	ErrCodeTooLarge = "too_large"
+	// The server is too busy to handle the request, which should be
+	// retried later.
+	ErrCodeUnavailable = "unavailable"
+	// Any other error on the server side, the request may be retried.
+	ErrCodeInternal = "internal"
+)
+
+const (
+	LangC   = "C"
+	LangCPP = "C++"
+	LangGo  = "Go"
+)
+
+// Kinds of linkage of the executables.
+const (
+	ExecutableTypeDynamic   = "dynamic"
+	ExecutableTypePIE       = "pie"
+	ExecutableTypeStatic    = "static"
+	ExecutableTypeStaticPIE = "static-pie"
+)
+
+// Paths of the analysis of a core: the stack trace is extracted by the
+// debugger, or the analysis falls back to the notes of the core when the
+// debugger fails. The sanitizer reports are only read.
+const (
+	AnalysisPathDebugger  = "debugger"
+	AnalysisPathNotes     = "notes"
+	AnalysisPathSanitizer = "sanitizer"
+)
+
+// Formats of the files sent in place of the core dumps. The sanitizer reports
+// are the text printed by the sanitizers (e.g: AddressSanitizer) of the
+// programs that exit instead of dumping a core, which is indexed as the trace
+// without running a debugger.
+const (
+	CoreFormatCoredump  = ""
+	CoreFormatSanitizer = "sanitizer"
+)
+
+// Names of the events of the lifecycle of a coredump. Each attempt at
+// analyzing it starts with an analysis_started event, followed by either an
+// analysis_finished or an analysis_failed event.
+const (
+	EventReceived         = "received"
+	EventStored           = "stored"
+	EventAnalysisStarted  = "analysis_started"
+	EventAnalysisFinished = "analysis_finished"
+	EventAnalysisFailed   = "analysis_failed"
+)
+
+// Reasons of the analysis of a core being skipped. The cores awaiting their
+// executable are analyzed once it is uploaded.
+const (
+	SkipReasonTooLarge           = "too_large"
+	SkipReasonAwaitingExecutable = "awaiting_executable"
+)
+
+// Formats of the executables.
 const (
-	LangC  = "C"
-	LangGo = "Go"
+	FormatELF     = "elf"
+	FormatMachO   = "mach-o"
+	FormatScript  = "script"
+	FormatUnknown = "unknown"
 )
+
+// DetectFormat reads the magic bytes at the beginning of an executable file to
+// find out its format. Files too small to have magic bytes are of unknown
+// format.
+func DetectFormat(r io.Reader) (string, error) {
+	magic := make([]byte, 4)
+	_, err := io.ReadFull(r, magic)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return FormatUnknown, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("\x7fELF")):
+		return FormatELF, nil
+	case bytes.HasPrefix(magic, []byte("#!")):
+		return FormatScript, nil
+	// Mach-O magic numbers, 32 and 64 bits in both byte orders, and
+	// universal binaries.
+	case bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xce}),
+		bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}),
+		bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}),
+		bytes.Equal(magic, []byte{0xca, 0xfe, 0xba, 0xbe}):
+		return FormatMachO, nil
+	default:
+		return FormatUnknown, nil
+	}
+}
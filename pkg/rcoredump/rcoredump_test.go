@@ -0,0 +1,32 @@
+package rcoredump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for n, c := range map[string]struct {
+		input string
+		want  string
+	}{
+		"elf":           {input: "\x7fELF\x02\x01\x01", want: FormatELF},
+		"script":        {input: "#!/bin/sh\nexit 1\n", want: FormatScript},
+		"mach-o 64":     {input: "\xcf\xfa\xed\xfe\x07\x00\x00\x01", want: FormatMachO},
+		"mach-o 32 big": {input: "\xfe\xed\xfa\xce\x00\x00\x00\x07", want: FormatMachO},
+		"universal":     {input: "\xca\xfe\xba\xbe\x00\x00\x00\x02", want: FormatMachO},
+		"unknown":       {input: "MZ\x90\x00\x03\x00", want: FormatUnknown},
+		"short":         {input: "\x7fEL", want: FormatUnknown},
+		"empty":         {input: "", want: FormatUnknown},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := DetectFormat(strings.NewReader(c.input))
+			if err != nil {
+				t.Fatalf(`DetectFormat(%q): unexpected error: %s`, c.input, err)
+			}
+			if got != c.want {
+				t.Errorf(`DetectFormat(%q): wanted %q, got %q`, c.input, c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// sanitizer contains a parser for the reports printed by the sanitizers of
+// clang and gcc (AddressSanitizer, LeakSanitizer, ThreadSanitizer,
+// MemorySanitizer and UndefinedBehaviorSanitizer) when they detect an error in
+// an instrumented program, on stderr or in the files given by their log_path
+// option. The programs usually exit instead of dumping a core, so the report is
+// all there is to know about the crash.
+package sanitizer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ErrNotReport is returned when no sanitizer error is found in the report.
+var ErrNotReport = errors.New(`not a sanitizer report`)
+
+// UndefinedBehaviorSanitizer is the name of the sanitizer whose errors don't
+// name it, but start with "runtime error".
+const UndefinedBehaviorSanitizer = "UndefinedBehaviorSanitizer"
+
+// Report is the structured content of a sanitizer report. Only the first
+// error of the report is described, the following ones usually being
+// consequences of it.
+type Report struct {
+	// Sanitizer that detected the error (e.g: AddressSanitizer).
+	Sanitizer string
+	// Error detected, without the specifics of the occurrence (e.g:
+	// heap-buffer-overflow, data race, signed integer overflow).
+	Error string
+	// Access of the memory that caused the error, if any (e.g: READ of
+	// size 4).
+	Access string
+	// Summary of the report, as printed on its SUMMARY line without the
+	// name of the sanitizer.
+	Summary string
+	// Functions of the first stack of the report, innermost first.
+	Functions []string
+}
+
+var (
+	// ==1234==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000014 at pc ...
+	errorHeader = regexp.MustCompile(`^==\d+==ERROR: (\w+Sanitizer): (.+)$`)
+	// WARNING: ThreadSanitizer: data race (pid=1234)
+	warningHeader = regexp.MustCompile(`^(?:==\d+==)?WARNING: (\w+Sanitizer): (.+)$`)
+	// main.c:4:5: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'
+	runtimeError = regexp.MustCompile(`^\S+: runtime error: (.+)$`)
+	// SUMMARY: AddressSanitizer: heap-buffer-overflow main.c:4 in main
+	summary = regexp.MustCompile(`^SUMMARY: (\w+Sanitizer): (.+)$`)
+	// READ of size 4 at 0x602000000014 thread T0
+	access = regexp.MustCompile(`^\s*((?:READ|WRITE|Read|Write|Atomic read|Atomic write) of size \d+) at `)
+	// #0 0x4f5b3c in main /tmp/main.c:4:3 (ASan)
+	// #0 main /tmp/main.c:4:3 (a.out+0x4f5b3c) (TSan)
+	frame = regexp.MustCompile(`^\s*#(\d+) (?:0x[0-9a-fA-F]+ )?(?:in )?(\S+)`)
+)
+
+// Parse reads a sanitizer report from r. It returns ErrNotReport if there is no
+// sanitizer error in it.
+func Parse(r io.Reader) (Report, error) {
+	var report Report
+
+	// Lines can be very long for the demangled C++ functions, so we don't
+	// use a bufio.Scanner that would impose a maximum size.
+	reader := bufio.NewReader(r)
+	var stacks int
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return Report{}, fmt.Errorf(`reading line %d: %w`, n, err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		line = strings.TrimRight(line, " \t\r\n")
+
+		if m := errorHeader.FindStringSubmatch(line); m != nil && len(report.Sanitizer) == 0 {
+			report.Sanitizer, report.Error = m[1], errorName(m[2])
+			continue
+		}
+		if m := warningHeader.FindStringSubmatch(line); m != nil && len(report.Sanitizer) == 0 {
+			report.Sanitizer, report.Error = m[1], errorName(m[2])
+			continue
+		}
+		if m := runtimeError.FindStringSubmatch(line); m != nil && len(report.Sanitizer) == 0 {
+			report.Sanitizer, report.Error = UndefinedBehaviorSanitizer, errorName(m[1])
+			continue
+		}
+		if m := summary.FindStringSubmatch(line); m != nil && len(report.Summary) == 0 {
+			report.Summary = m[2]
+			if len(report.Sanitizer) == 0 {
+				report.Sanitizer, report.Error = m[1], strings.Fields(m[2])[0]
+			}
+			continue
+		}
+		if m := access.FindStringSubmatch(line); m != nil && len(report.Access) == 0 {
+			report.Access = m[1]
+			continue
+		}
+		if m := frame.FindStringSubmatch(line); m != nil {
+			// Each stack starts over at frame #0, and only the first
+			// one is kept.
+			if m[1] == "0" {
+				stacks++
+			}
+			if stacks == 1 && !strings.HasPrefix(m[2], "(") && !strings.HasPrefix(m[2], "0x") && !strings.HasPrefix(m[2], "<") {
+				report.Functions = append(report.Functions, m[2])
+			}
+		}
+	}
+
+	if len(report.Sanitizer) == 0 {
+		return Report{}, ErrNotReport
+	}
+	return report, nil
+}
+
+// errorName returns the name of the error from the description of the header,
+// without the address, pid or values involved (e.g: "heap-buffer-overflow on
+// address 0x602000000014 at pc ..." is a heap-buffer-overflow).
+func errorName(description string) string {
+	for _, sep := range []string{" on ", " (", ": "} {
+		if i := strings.Index(description, sep); i > 0 {
+			description = description[:i]
+		}
+	}
+	return strings.TrimSpace(description)
+}
@@ -0,0 +1,71 @@
+package sanitizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elwinar/rcoredump/pkg/testingx"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	for path, expected := range map[string]Report{
+		"asan.txt": {
+			Sanitizer: "AddressSanitizer",
+			Error:     "heap-buffer-overflow",
+			Access:    "READ of size 4",
+			Summary:   "heap-buffer-overflow /src/crasher/main.c:6:9 in read_value",
+			Functions: []string{"read_value", "main", "__libc_start_main", "_start"},
+		},
+		"tsan.txt": {
+			Sanitizer: "ThreadSanitizer",
+			Error:     "data race",
+			Access:    "Write of size 4",
+			Summary:   "data race /src/counter/main.c:8:10 in increment",
+			Functions: []string{"increment", "worker"},
+		},
+		"ubsan.txt": {
+			Sanitizer: "UndefinedBehaviorSanitizer",
+			Error:     "signed integer overflow",
+			Summary:   "undefined-behavior /src/overflow/main.c:5:11 in",
+			Functions: []string{"add", "main", "__libc_start_main"},
+		},
+		"lsan.txt": {
+			Sanitizer: "LeakSanitizer",
+			Error:     "detected memory leaks",
+			Summary:   "64 byte(s) leaked in 1 allocation(s).",
+			Functions: []string{"malloc", "make_buffer", "main"},
+		},
+	} {
+		t.Run(path, func(t *testing.T) {
+			report, err := Parse(testingx.Open(t, path))
+			if err != nil {
+				t.Fatalf(`Parse(%q): unexpected error: %s`, path, err)
+			}
+			if diff := cmp.Diff(expected, report); diff != "" {
+				t.Errorf(`Parse(%q): unexpected report (-want +got):\n%s`, path, diff)
+			}
+		})
+	}
+}
+
+func TestParse_NotReport(t *testing.T) {
+	_, err := Parse(strings.NewReader("Segmentation fault (core dumped)\n"))
+	if err != ErrNotReport {
+		t.Errorf(`Parse(): wanted ErrNotReport, got %v`, err)
+	}
+}
+
+func TestErrorName(t *testing.T) {
+	for description, expected := range map[string]string{
+		"heap-buffer-overflow on address 0x602000000014 at pc 0x0000004f5b3d":         "heap-buffer-overflow",
+		"SEGV on unknown address 0x000000000000 (pc 0x4f5b3c bp 0x7ffd sp 0x7ffd T0)": "SEGV",
+		"data race (pid=9921)": "data race",
+		"signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'": "signed integer overflow",
+		"detected memory leaks": "detected memory leaks",
+	} {
+		if got := errorName(description); got != expected {
+			t.Errorf(`errorName(%q): wanted %q, got %q`, description, expected, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package semver parses and compares semantic versions (see
+// https://semver.org), as used by the releases of rcoredump.
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is returned when a string isn't a semantic version.
+var ErrInvalid = errors.New("invalid semantic version")
+
+// Version is a semantic version. The build metadata is ignored, as it doesn't
+// take part in the precedence of the versions.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	// PreRelease is the part following the dash (e.g: "rc.1"), empty for
+	// a release.
+	PreRelease string
+}
+
+// Parse a version of the form MAJOR.MINOR.PATCH, optionally prefixed by "v"
+// and followed by a pre-release and build metadata (e.g: v1.2.3-rc.1+abc).
+func Parse(s string) (Version, error) {
+	raw := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(raw, '+'); i >= 0 {
+		raw = raw[:i]
+	}
+
+	var v Version
+	if i := strings.IndexByte(raw, '-'); i >= 0 {
+		raw, v.PreRelease = raw[:i], raw[i+1:]
+		if len(v.PreRelease) == 0 {
+			return Version{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		*dst = n
+	}
+	return v, nil
+}
+
+// String returns the version without prefix.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) != 0 {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if the version is respectively lower, equal or
+// greater than o. A pre-release is lower than the release it precedes.
+func (v Version) Compare(o Version) int {
+	for _, c := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c := compareInts(c[0], c[1]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case v.PreRelease == o.PreRelease:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(o.PreRelease) == 0:
+		return -1
+	}
+
+	// The identifiers of the pre-releases are compared one by one,
+	// numerically if they are both numbers, the numeric ones being lower
+	// than the others.
+	a, b := strings.Split(v.PreRelease, "."), strings.Split(o.PreRelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, errA := strconv.Atoi(a[i])
+		nb, errB := strconv.Atoi(b[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = compareInts(na, nb)
+		case errA == nil:
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// Less reports whether the version is lower than o.
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
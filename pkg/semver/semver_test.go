@@ -0,0 +1,60 @@
+package semver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]Version{
+		"1.2.3":            {Major: 1, Minor: 2, Patch: 3},
+		"v0.10.0":          {Minor: 10},
+		"v1.0.0-rc.1":      {Major: 1, PreRelease: "rc.1"},
+		"1.0.0-beta+exp.1": {Major: 1, PreRelease: "beta"},
+		"2.0.0+20200101":   {Major: 2},
+	} {
+		got, err := Parse(in)
+		if err != nil {
+			t.Errorf(`Parse(%q): unexpected error: %s`, in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf(`Parse(%q): wanted %#v, got %#v`, in, want, got)
+		}
+	}
+
+	for _, in := range []string{"", "N/C", "1.2", "1.2.3.4", "1.a.3", "1.2.-3", "1.2.3-"} {
+		_, err := Parse(in)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf(`Parse(%q): wanted ErrInvalid, got %v`, in, err)
+		}
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	// Ordered by precedence, as in the examples of the specification.
+	ordered := []string{
+		"0.9.0",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := Parse(ordered[i])
+			b, _ := Parse(ordered[j])
+			want := compareInts(i, j)
+			if got := a.Compare(b); got != want {
+				t.Errorf(`Compare(%s, %s): wanted %d, got %d`, a, b, want, got)
+			}
+		}
+	}
+}
@@ -143,7 +143,12 @@ export function App() {
 	// When the query change, we want to run the search query and update
 	// the cores.
 	React.useEffect(function() {
-		api.search(state.query)
+		// Highlighting is only useful when there is something to match.
+		let query = state.query;
+		if (query.q !== '*') {
+			query = {...query, highlight: true};
+		}
+		api.search(query)
 			.then(function(res) {
 				return res.json();
 			})
@@ -274,6 +279,60 @@ function Searchbar(props) {
 		setState(query);
 	}
 
+	// The saved searches are loaded once, and again each time one is
+	// saved or deleted.
+	const [searches, setSearches] = React.useState([]);
+	function loadSearches() {
+		api.listSearches()
+			.then(res => res.json())
+			.then(res => setSearches(res.searches || []))
+			.catch(() => setSearches([]));
+	}
+	React.useEffect(loadSearches, []);
+
+	// pick is used by the saved searches dropdown to run the selected
+	// search.
+	function pick(ev) {
+		const search = searches.find(s => s.name === ev.target.value);
+		if (search === undefined) {
+			return;
+		}
+		dispatch({type: 'set_query', query: {...state, q: search.query, sort: search.sort, order: search.order}});
+	}
+
+	// save is used by the save button to save the current search under a
+	// name given by the user.
+	function save(e) {
+		e.preventDefault();
+		const name = window.prompt('name of the saved search');
+		if (!name) {
+			return;
+		}
+		api.saveSearch({name: name, query: state.q, sort: state.sort, order: state.order})
+			.then(res => res.json())
+			.then(function(res) {
+				if (res.error) {
+					dispatch({type: 'set_error', err: res.error});
+					return;
+				}
+				loadSearches();
+			})
+			.catch(err => dispatch({type: 'set_error', err: err.message}));
+	}
+
+	// forget is used by the delete button to delete the saved search
+	// matching the current query.
+	const current = searches.find(s => s.query === query.q);
+	function forget(e) {
+		e.preventDefault();
+		if (current === undefined || !window.confirm(`delete the saved search ${current.name}?`)) {
+			return;
+		}
+		api.deleteSearch(current.name)
+			.then(loadSearches)
+			.catch(err => dispatch({type: 'set_error', err: err.message}));
+	}
+
 	return (
 		<React.Fragment>
 			<form className={styles.Searchbar} onSubmit={submit}>
@@ -310,6 +369,14 @@ function Searchbar(props) {
 					<button type="submit" disabled={!dirty}>apply</button>
 					<button onClick={reset} disabled={!dirty}>reset</button>
 				</div>
+				<div>
+					<select value={current === undefined ? '' : current.name} onChange={pick}>
+						<option value="">saved searches</option>
+						{searches.map(s => <option key={s.name} value={s.name}>{s.name}</option>)}
+					</select>
+					<button onClick={save}>save</button>
+					<button onClick={forget} disabled={current === undefined}>delete</button>
+				</div>
 				<div>
 					<p><a href="https://blevesearch.com/docs/Query-String-Query/" target="_blank">query string reference</a></p>
 				</div>
@@ -423,6 +490,39 @@ function Core(props) {
 		return bytes.toFixed(1) + ' ' + units[u];
 	}
 
+	// The trace isn't part of the search results, so it is loaded when the
+	// core is displayed. Large traces are only partially indexed, so the
+	// full one is fetched from its own endpoint.
+	const [trace, setTrace] = React.useState(undefined);
+	React.useEffect(function() {
+		api.getTrace(core.uid)
+			.then(function(res) {
+				if (res.ok) {
+					return res.text().then(setTrace);
+				}
+				return res.json().then(function(res) {
+					if (res.code === 'not_found') {
+						setTrace(undefined);
+						return;
+					}
+					dispatch({type: 'set_error', err: res.error});
+				});
+			})
+			.catch(function(err) {
+				dispatch({type: 'set_error', err: err.message});
+			});
+	}, [core.uid]);
+
+	// The artifacts of the analysis are listed with the core, to be viewed
+	// from their own endpoint.
+	const [artifacts, setArtifacts] = React.useState([]);
+	React.useEffect(function() {
+		api.listArtifacts(core.uid)
+			.then(res => res.json())
+			.then(res => setArtifacts(res.artifacts || []))
+			.catch(() => setArtifacts([]));
+	}, [core.uid]);
+
 	// We use a ref so we can have a simpler copy routine.
 	const downloadAndDebug = React.useRef();
 	function copy() {
@@ -463,14 +563,32 @@ function Core(props) {
 				<li><a className={styles.Button} href={api.route(`/executables/${core.executable_hash}`)}>download executable ({formatSize(core.executable_size, true)})</a></li>
 				<li><button onClick={deleteCore}>delete core</button></li>
 			</ul>
+			{artifacts.length !== 0 && (
+				<ul>
+					{artifacts.map(a => <li key={a.name}><a className={styles.Button} href={api.route(`/cores/${core.uid}/artifacts/${a.name}`)} target="_blank">{a.name} ({formatSize(a.size)})</a></li>)}
+				</ul>
+			)}
+			{core.deleted && <p>deleted at {formatDate(core.deleted_at)}</p>}
 			<h2>executable</h2>
 			<dl>
 				<dt>executable_hash</dt><dd><QueryLink query={`executable_hash:"${core.executable_hash}"`}>{core.executable_hash}</QueryLink></dd>
 				<dt>executable_path</dt><dd>{core.executable_path}</dd>
+				{core.executable_build_id && <React.Fragment><dt>executable_build_id</dt><dd><QueryLink query={`executable_build_id:"${core.executable_build_id}"`}>{core.executable_build_id}</QueryLink></dd></React.Fragment>}
+				{core.executable_format && <React.Fragment><dt>executable_format</dt><dd>{core.executable_format}</dd></React.Fragment>}
+				{core.go_version && <React.Fragment><dt>go_version</dt><dd><QueryLink query={`go_version:"${core.go_version}"`}>{core.go_version}</QueryLink></dd></React.Fragment>}
+				{core.go_module && <React.Fragment><dt>go_module</dt><dd><QueryLink query={`go_module:"${core.go_module}"`}>{core.go_module}</QueryLink> {core.go_module_version}</dd></React.Fragment>}
+				{core.compilers && <React.Fragment><dt>compilers</dt><dd>{core.compilers.map(x => <div key={x}>{x}</div>)}</dd></React.Fragment>}
+				{core.abi && <React.Fragment><dt>abi</dt><dd><QueryLink query={`abi:"${core.abi}"`}>{core.abi}</QueryLink></dd></React.Fragment>}
+				{core.missing_libraries && <React.Fragment><dt>missing_libraries</dt><dd>{core.missing_libraries.map(x => <div key={x}><QueryLink query={`missing_libraries:"${x}"`}>{x}</QueryLink></div>)}</dd></React.Fragment>}
+				{core.links_truncated && <React.Fragment><dt>links_truncated</dt><dd>the forwarder stopped resolving the libraries before the end</dd></React.Fragment>}
 			</dl>
 			<h2>coredump</h2>
 			<dl>
 				<dt>uid</dt><dd><QueryLink query={`uid:"${core.uid}"`}>{core.uid}</QueryLink></dd>
+				{core.signal_name && <React.Fragment><dt>signal_name</dt><dd><QueryLink query={`signal_name:${core.signal_name}`}>{core.signal_name}</QueryLink> ({core.signal})</dd></React.Fragment>}
+				{core.command && <React.Fragment><dt>command</dt><dd><QueryLink query={`command:"${core.command}"`}>{core.command}</QueryLink></dd></React.Fragment>}
+				{core.args && <React.Fragment><dt>args</dt><dd>{core.args.join(' ')}</dd></React.Fragment>}
+				{core.format && <React.Fragment><dt>format</dt><dd><QueryLink query={`format:"${core.format}"`}>{core.format}</QueryLink></dd></React.Fragment>}
 				{Object.keys(core.metadata).map(x => {
 					return (
 						<React.Fragment key={x}>
@@ -479,23 +597,72 @@ function Core(props) {
 						</React.Fragment>
 					);
 				})}
+				{core.rejected_metadata && <React.Fragment><dt>rejected_metadata</dt><dd>{core.rejected_metadata} (not indexed)</dd></React.Fragment>}
 			</dl>
 			<h3>download & debug <button onClick={copy}>copy</button></h3>
 			<pre ref={downloadAndDebug}>
 				curl -s "{api.route(`/cores/${core.uid}`)}" --output {core.executable}.{core.uid}<br/>
 				curl -s "{api.route(`/executables/${core.executable_hash}`)}" --output {core.executable}<br/>
-				{core.lang == "C" && `gdb ${core.executable} ${core.executable}.${core.uid}`}
+				{(core.lang == "C" || core.lang == "C++") && `gdb ${core.executable} ${core.executable}.${core.uid}`}
 				{core.lang == "Go" && `dlv core ${core.executable} ${core.executable}.${core.uid}`}
 			</pre>
+			{core.highlights && (
+				<React.Fragment>
+					<h2>matches</h2>
+					<dl>
+						{Object.keys(core.highlights).map(x => {
+							return (
+								<React.Fragment key={x}>
+									<dt>{x}</dt>
+									{core.highlights[x].map((f, i) => <dd key={i}><pre><Highlight fragment={f} /></pre></dd>)}
+								</React.Fragment>
+							);
+						})}
+					</dl>
+				</React.Fragment>
+			)}
+			{core.sanitizer && (
+				<React.Fragment>
+					<h2>sanitizer report</h2>
+					<dl>
+						<dt>sanitizer</dt><dd><QueryLink query={`sanitizer:"${core.sanitizer}"`}>{core.sanitizer}</QueryLink></dd>
+						<dt>sanitizer_error</dt><dd><QueryLink query={`sanitizer_error:"${core.sanitizer_error}"`}>{core.sanitizer_error}</QueryLink></dd>
+						{core.sanitizer_access && <React.Fragment><dt>sanitizer_access</dt><dd>{core.sanitizer_access}</dd></React.Fragment>}
+						{core.sanitizer_summary && <React.Fragment><dt>sanitizer_summary</dt><dd>{core.sanitizer_summary}</dd></React.Fragment>}
+					</dl>
+				</React.Fragment>
+			)}
+			{core.events && (
+				<React.Fragment>
+					<h2>events</h2>
+					<dl>
+						{core.events.map((e, i) => <React.Fragment key={i}><dt>{e.name}</dt><dd>{formatDate(e.at)}</dd></React.Fragment>)}
+					</dl>
+				</React.Fragment>
+			)}
 			<h2>stack trace</h2>
 			<dl>
 				<dt>analyzed_at</dt><dd>{formatDate(core.analyzed_at)}</dd>
+				{core.analysis_path && <React.Fragment><dt>analysis_path</dt><dd>{core.analysis_path}</dd></React.Fragment>}
+				{core.analyzer_cpu && <React.Fragment><dt>analyzer_cpu</dt><dd>{core.analyzer_cpu.toFixed(2)}s</dd></React.Fragment>}
+				{core.analyzer_max_rss && <React.Fragment><dt>analyzer_max_rss</dt><dd>{formatSize(core.analyzer_max_rss, true)}</dd></React.Fragment>}
 			</dl>
-			{core.trace !== undefined ? <pre>{core.trace}</pre> : <p>No trace</p>}
+			{core.partially_analyzed && <p>analysis failed, only the notes of the core were read:</p>}
+			{core.analysis_error && <p>{core.analysis_error}</p>}
+			{core.analysis_skipped && <p>analysis skipped: {core.skip_reason}</p>}
+			{trace !== undefined ? <pre>{trace}</pre> : <p>No trace</p>}
 		</React.Fragment>
 	);
 }
 
+// Highlight displays a fragment returned by the search, with the matches
+// enclosed in mark tags. The fragment isn't escaped by the server, so the
+// tags are interpreted here instead of injecting it as HTML.
+function Highlight(props) {
+	const parts = props.fragment.split(/<\/?mark>/);
+	return parts.map((p, i) => i % 2 == 1 ? <mark key={i}>{p}</mark> : <React.Fragment key={i}>{p}</React.Fragment>);
+}
+
 // QueryLink can be used to make a direct link to a query search. The link is a
 // standard HTML link with a valid href, but the navigation is intercepted to
 // be handled by the app. This allow the user to copy-paste the link via his
@@ -14,8 +14,36 @@ export function search(query) {
 	return call(`/cores?${params.join('&')}`);
 }
 
+export function getCore(uid) {
+	return call(`/cores/${uid}`, {headers: {'Accept': 'application/json'}});
+}
+
+export function getTrace(uid) {
+	return call(`/cores/${uid}/trace`);
+}
+
+export function listArtifacts(uid) {
+	return call(`/cores/${uid}/artifacts`);
+}
+
 export function deleteCore(uid) {
 	return call(`/cores/${uid}`, {method: 'delete'});
 }
 
-export default { route, call, search, deleteCore };
+export function listSearches() {
+	return call('/searches');
+}
+
+export function saveSearch(search) {
+	return call('/searches', {
+		method: 'post',
+		headers: {'Content-Type': 'application/json'},
+		body: JSON.stringify(search),
+	});
+}
+
+export function deleteSearch(name) {
+	return call(`/searches/${encodeURIComponent(name)}`, {method: 'delete'});
+}
+
+export default { route, call, search, getCore, getTrace, listArtifacts, deleteCore, listSearches, saveSearch, deleteSearch };